@@ -0,0 +1,108 @@
+// Modified by Giacomo Tartari
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkt
+
+import (
+	"strconv"
+	"strings"
+)
+
+// AckStatus is the status of an "ACK" line of a protocol v1 upload-pack
+// response, as sent with multi_ack and multi_ack_detailed.
+type AckStatus int
+
+const (
+	// AckNone is a plain "ACK <oid>": the final acknowledgement, or the
+	// acknowledgement of a server without multi_ack.
+	AckNone AckStatus = iota
+	// AckContinue is the status of multi_ack, and of multi_ack_detailed
+	// for a common object when the server is not ready.
+	AckContinue
+	// AckCommon is the status of multi_ack_detailed for a common object.
+	AckCommon
+	// AckReady is the status of multi_ack_detailed telling that the server
+	// can send the pack.
+	AckReady
+)
+
+var ackStatuses = [...]string{
+	AckNone:     "",
+	AckContinue: "continue",
+	AckCommon:   "common",
+	AckReady:    "ready",
+}
+
+// ParseAckStatus parses the status of an "ACK" line, empty for AckNone.
+func ParseAckStatus(s string) (AckStatus, error) {
+	for st, name := range ackStatuses {
+		if s == name {
+			return AckStatus(st), nil
+		}
+	}
+	return AckNone, &FieldError{
+		Field: "ACK status",
+		Value: s,
+		Err:   SyntaxError("expected one of " + strings.Join(ackStatuses[1:], ", ")),
+	}
+}
+
+// String returns the status as sent on the "ACK" line.
+func (s AckStatus) String() string {
+	if s < 0 || int(s) >= len(ackStatuses) {
+		return "AckStatus(" + strconv.Itoa(int(s)) + ")"
+	}
+	return ackStatuses[s]
+}
+
+// NewAckStatusChunk returns a chunk for an "ACK" line with the status.
+func NewAckStatusChunk(oid string, status AckStatus) *UploadResponseChunk {
+	return NewAckChunk(oid, status.String())
+}
+
+// AckStatus returns the parsed status of an "ACK" line. It returns an error
+// for an unknown status, which the parser only accepts outside of strict
+// mode.
+func (c *UploadResponseChunk) AckStatus() (AckStatus, error) {
+	_, detail := c.Ack()
+	return ParseAckStatus(detail)
+}
+
+// Status returns the parsed status of the acknowledgement.
+func (e AckEvent) Status() (AckStatus, error) {
+	return ParseAckStatus(e.Detail)
+}
+
+// AckWithStatus writes an "ACK" line with the status.
+func (w *UploadResponseWriter) AckWithStatus(oid string, status AckStatus) error {
+	if err := w.acknowledgement("AckWithStatus"); err != nil {
+		return err
+	}
+	return w.write(NewAckStatusChunk(oid, status))
+}
+
+// checkAckStatus rejects, in strict mode, the status of an "ACK" line that
+// is not one of the multi_ack statuses.
+func (c *Config) checkAckStatus(s string) error {
+	if !c.StrictMode {
+		return nil
+	}
+	if st, err := ParseAckStatus(s); err != nil {
+		return err
+	} else if st == AckNone {
+		return &FieldError{Field: "ACK status", Value: s, Err: SyntaxError("empty status")}
+	}
+	return nil
+}
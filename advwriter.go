@@ -0,0 +1,169 @@
+// Modified by Giacomo Tartari
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkt
+
+import (
+	"fmt"
+	"io"
+)
+
+// AdvertisementWriter writes a protocol v0/v1 ref advertisement one ref at a
+// time, e.g. while iterating over the refs of a large repository. It is the
+// streaming counterpart of Advertisement: the capabilities are sent after a
+// NUL on the first ref, or on the "capabilities^{}" placeholder of an empty
+// repository, the peeled object IDs follow their tags, and End writes the
+// flush. After the first error, all the methods return it.
+type AdvertisementWriter struct {
+	w       io.Writer
+	caps    []string
+	format  ObjectFormat
+	symrefs map[string]string
+	started bool
+	ended   bool
+	err     error
+}
+
+// NewAdvertisementWriter returns a new AdvertisementWriter writing to w and
+// advertising caps. The object IDs are checked against the object-format
+// capability, SHA1 if absent.
+func NewAdvertisementWriter(w io.Writer, caps []string) *AdvertisementWriter {
+	return &AdvertisementWriter{
+		w:       w,
+		caps:    append([]string(nil), caps...),
+		format:  Capabilities(caps).ObjectFormat(),
+		symrefs: map[string]string{},
+	}
+}
+
+// Err returns the first error that was encountered by the
+// AdvertisementWriter.
+func (w *AdvertisementWriter) Err() error {
+	return w.err
+}
+
+// Symref advertises that the symbolic ref name points to target, with a
+// symref capability. It must be called before the first ref is written;
+// WriteRef does it for the refs with a SymrefTarget until then.
+func (w *AdvertisementWriter) Symref(name, target string) error {
+	if w.err != nil {
+		return w.err
+	}
+	if w.started {
+		return w.fail("Symref")
+	}
+	if _, ok := w.symrefs[name]; !ok {
+		w.caps = append(w.caps, "symref="+name+":"+target)
+		w.symrefs[name] = target
+	}
+	return nil
+}
+
+// WriteRef writes r, followed by its peeled object ID if any. The first ref
+// carries the capabilities, so the target of a symbolic ref written later
+// must have been advertised with Symref.
+func (w *AdvertisementWriter) WriteRef(r Ref) error {
+	if w.err != nil {
+		return w.err
+	}
+	if w.ended {
+		return w.fail("WriteRef")
+	}
+	if r.SymrefTarget != "" {
+		if !w.started {
+			w.Symref(r.Name, r.SymrefTarget)
+		} else if w.symrefs[r.Name] != r.SymrefTarget {
+			w.err = fmt.Errorf("AdvertisementWriter: symref %s not advertised before the first ref", r.Name)
+			return w.err
+		}
+	}
+	if err := w.validate(r.ObjectID); err != nil {
+		return err
+	}
+	c := &InfoRefsResponseChunk{ObjectID: string(r.ObjectID), Ref: r.Name}
+	if !w.started {
+		c.Capabilities = w.capabilities()
+		w.started = true
+	}
+	if err := w.write(c); err != nil {
+		return err
+	}
+	if r.Peeled == "" {
+		return nil
+	}
+	if err := w.validate(r.Peeled); err != nil {
+		return err
+	}
+	return w.write(&InfoRefsResponseChunk{ObjectID: string(r.Peeled), Ref: r.Name + "^{}"})
+}
+
+// End writes the "capabilities^{}" placeholder if no ref was written, and
+// the flush packet ending the advertisement.
+func (w *AdvertisementWriter) End() error {
+	if w.err != nil {
+		return w.err
+	}
+	if w.ended {
+		return w.fail("End")
+	}
+	if !w.started {
+		w.started = true
+		if err := w.write(&InfoRefsResponseChunk{
+			ObjectID:     string(w.format.ZeroID()),
+			Ref:          "capabilities^{}",
+			Capabilities: w.capabilities(),
+		}); err != nil {
+			return err
+		}
+	}
+	w.ended = true
+	return w.write(&InfoRefsResponseChunk{EndOfRequest: true})
+}
+
+// capabilities returns the capabilities of the first line. Without any, the
+// NUL separator is kept, as git does.
+func (w *AdvertisementWriter) capabilities() []string {
+	if len(w.caps) == 0 {
+		return []string{""}
+	}
+	return w.caps
+}
+
+func (w *AdvertisementWriter) validate(id ObjectID) error {
+	if w.format.HexSize() == 0 {
+		return nil
+	}
+	w.err = w.format.ValidateObjectID(string(id))
+	return w.err
+}
+
+func (w *AdvertisementWriter) fail(method string) error {
+	if w.err == nil {
+		after := "the first ref"
+		if w.ended {
+			after = "End"
+		}
+		w.err = fmt.Errorf("AdvertisementWriter: unexpected %s after %s", method, after)
+	}
+	return w.err
+}
+
+func (w *AdvertisementWriter) write(c *InfoRefsResponseChunk) error {
+	if w.err = c.Validate(); w.err != nil {
+		return w.err
+	}
+	_, w.err = w.w.Write(c.EncodeToPktLine())
+	return w.err
+}
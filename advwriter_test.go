@@ -0,0 +1,232 @@
+// Modified by Giacomo Tartari
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkt
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestAdvertisementWriter(t *testing.T) {
+	tag := strings.Repeat("b", 40)
+	refs := Refs{
+		{Name: "HEAD", ObjectID: ObjectID(oid), SymrefTarget: "refs/heads/main"},
+		{Name: "refs/heads/main", ObjectID: ObjectID(oid)},
+		{Name: "refs/tags/v1", ObjectID: ObjectID(tag), Peeled: ObjectID(oid)},
+		{Name: "refs/tags/v2", ObjectID: ObjectID(tag)},
+	}
+	tests := []struct {
+		name string
+		caps []string
+		refs Refs
+		want string
+	}{
+		{
+			name: "refs",
+			caps: []string{"multi_ack", "ofs-delta"},
+			refs: refs,
+			want: pktLines(
+				oid+" HEAD\x00multi_ack ofs-delta symref=HEAD:refs/heads/main\n",
+				oid+" refs/heads/main\n",
+				tag+" refs/tags/v1\n",
+				oid+" refs/tags/v1^{}\n",
+				tag+" refs/tags/v2\n",
+				"0000"),
+		},
+		{
+			name: "peeled first ref",
+			caps: []string{"ofs-delta"},
+			refs: refs[2:3],
+			want: pktLines(tag+" refs/tags/v1\x00ofs-delta\n", oid+" refs/tags/v1^{}\n", "0000"),
+		},
+		{
+			name: "no capabilities",
+			refs: refs[1:2],
+			want: pktLines(oid+" refs/heads/main\x00\n", "0000"),
+		},
+		{
+			name: "empty repository",
+			caps: []string{"multi_ack", "ofs-delta"},
+			want: pktLines(strings.Repeat("0", 40)+" capabilities^{}\x00multi_ack ofs-delta\n", "0000"),
+		},
+		{
+			name: "empty repository without capabilities",
+			want: pktLines(strings.Repeat("0", 40)+" capabilities^{}\x00\n", "0000"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var b bytes.Buffer
+			w := NewAdvertisementWriter(&b, tt.caps)
+			for _, r := range tt.refs {
+				if err := w.WriteRef(r); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := w.End(); err != nil {
+				t.Fatal(err)
+			}
+			if b.String() != tt.want {
+				t.Errorf("got %q, want %q", b.String(), tt.want)
+			}
+			// The streamed advertisement is the one of Advertisement.
+			var a bytes.Buffer
+			if _, err := (&Advertisement{Refs: tt.refs, Capabilities: tt.caps}).WriteTo(&a); err != nil {
+				t.Fatal(err)
+			}
+			if b.String() != a.String() {
+				t.Errorf("got %q, Advertisement writes %q", b.String(), a.String())
+			}
+		})
+	}
+}
+
+func TestAdvertisementWriter_Symref(t *testing.T) {
+	var b bytes.Buffer
+	w := NewAdvertisementWriter(&b, nil)
+	if err := w.Symref("HEAD", "refs/heads/main"); err != nil {
+		t.Fatal(err)
+	}
+	// HEAD is written after the first ref, with its advertised target.
+	for _, r := range []Ref{
+		{Name: "refs/heads/main", ObjectID: ObjectID(oid)},
+		{Name: "HEAD", ObjectID: ObjectID(oid), SymrefTarget: "refs/heads/main"},
+	} {
+		if err := w.WriteRef(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.End(); err != nil {
+		t.Fatal(err)
+	}
+	want := pktLines(oid+" refs/heads/main\x00symref=HEAD:refs/heads/main\n", oid+" HEAD\n", "0000")
+	if b.String() != want {
+		t.Errorf("got %q, want %q", b.String(), want)
+	}
+}
+
+func TestAdvertisementWriter_sha256(t *testing.T) {
+	var b bytes.Buffer
+	w := NewAdvertisementWriter(&b, []string{"object-format=sha256"})
+	if err := w.End(); err != nil {
+		t.Fatal(err)
+	}
+	want := pktLines(strings.Repeat("0", 64)+" capabilities^{}\x00object-format=sha256\n", "0000")
+	if b.String() != want {
+		t.Errorf("got %q, want %q", b.String(), want)
+	}
+	if err := NewAdvertisementWriter(&b, []string{"object-format=sha256"}).WriteRef(Ref{Name: "HEAD", ObjectID: ObjectID(oid)}); err == nil {
+		t.Error("got no error for a SHA1 object ID in a SHA256 advertisement")
+	}
+}
+
+type failingWriter struct{ err error }
+
+func (w failingWriter) Write([]byte) (int, error) { return 0, w.err }
+
+func TestAdvertisementWriter_errors(t *testing.T) {
+	main := Ref{Name: "refs/heads/main", ObjectID: ObjectID(oid)}
+	tests := []struct {
+		name string
+		// run calls the methods, the last one failing with err.
+		run func(w *AdvertisementWriter) error
+		err string
+	}{
+		{
+			name: "Symref after the first ref",
+			run: func(w *AdvertisementWriter) error {
+				w.WriteRef(main)
+				return w.Symref("HEAD", "refs/heads/main")
+			},
+			err: "AdvertisementWriter: unexpected Symref after the first ref",
+		},
+		{
+			name: "symref not advertised",
+			run: func(w *AdvertisementWriter) error {
+				w.WriteRef(main)
+				return w.WriteRef(Ref{Name: "HEAD", ObjectID: ObjectID(oid), SymrefTarget: "refs/heads/main"})
+			},
+			err: "AdvertisementWriter: symref HEAD not advertised before the first ref",
+		},
+		{
+			name: "WriteRef after End",
+			run: func(w *AdvertisementWriter) error {
+				w.End()
+				return w.WriteRef(main)
+			},
+			err: "AdvertisementWriter: unexpected WriteRef after End",
+		},
+		{
+			name: "End after End",
+			run: func(w *AdvertisementWriter) error {
+				w.End()
+				return w.End()
+			},
+			err: "AdvertisementWriter: unexpected End after End",
+		},
+		{
+			name: "invalid object ID",
+			run: func(w *AdvertisementWriter) error {
+				return w.WriteRef(Ref{Name: "refs/heads/main", ObjectID: "xyz"})
+			},
+		},
+		{
+			name: "invalid peeled object ID",
+			run: func(w *AdvertisementWriter) error {
+				return w.WriteRef(Ref{Name: "refs/tags/v1", ObjectID: ObjectID(oid), Peeled: "xyz"})
+			},
+		},
+		{
+			name: "invalid ref name",
+			run: func(w *AdvertisementWriter) error {
+				return w.WriteRef(Ref{Name: "refs/heads/a b\n", ObjectID: ObjectID(oid)})
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var b bytes.Buffer
+			w := NewAdvertisementWriter(&b, nil)
+			err := tt.run(w)
+			if err == nil || tt.err != "" && err.Error() != tt.err {
+				t.Fatalf("got error %v, want %q", err, tt.err)
+			}
+			// The error sticks.
+			if w.Err() != err {
+				t.Errorf("Err() = %v, want %v", w.Err(), err)
+			}
+			if err2 := w.WriteRef(main); err2 != err {
+				t.Errorf("WriteRef() = %v after the error, want %v", err2, err)
+			}
+			if err2 := w.End(); err2 != err {
+				t.Errorf("End() = %v after the error, want %v", err2, err)
+			}
+		})
+	}
+
+	t.Run("write error", func(t *testing.T) {
+		werr := errors.New("broken pipe")
+		w := NewAdvertisementWriter(failingWriter{werr}, nil)
+		if err := w.End(); err != werr {
+			t.Errorf("End() = %v, want %v", err, werr)
+		}
+		if err := w.Err(); err != werr {
+			t.Errorf("Err() = %v, want %v", err, werr)
+		}
+	})
+}
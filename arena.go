@@ -0,0 +1,179 @@
+// Modified by Giacomo Tartari
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkt
+
+import (
+	"sync"
+	"unsafe"
+)
+
+const (
+	// arenaBlockLen is the number of chunks allocated at once.
+	arenaBlockLen = 64
+	// arenaStringBlockSize is the size of the blocks strings are copied to.
+	// Larger strings are allocated on the heap.
+	arenaStringBlockSize = 16 * 1024
+)
+
+// Arena allocates chunks and their string fields in blocks that are recycled
+// when the arena is released, cutting the allocations of servers and proxies
+// handling many short sessions.
+//
+// Use one arena per session, pass it to the parsers with WithArena and call
+// Release when the session ends. The chunks returned by the parsers and the
+// strings they contain must not be used after Release. An Arena is not safe
+// for concurrent use. The zero Arena is ready to use, like the one returned
+// by NewArena. The methods of a nil *Arena allocate on the heap.
+type Arena struct {
+	uploadRequests   arenaSlab[UploadRequestChunk]
+	uploadResponses  arenaSlab[UploadResponseChunk]
+	receiveRequests  arenaSlab[ReceiveRequestChunk]
+	receiveResponses arenaSlab[ReceiveResponseChunk]
+
+	str       []byte
+	strBlocks []*[arenaStringBlockSize]byte
+}
+
+// NewArena returns a new Arena.
+func NewArena() *Arena {
+	return &Arena{
+		uploadRequests:   arenaSlab[UploadRequestChunk]{pool: &uploadRequestBlocks},
+		uploadResponses:  arenaSlab[UploadResponseChunk]{pool: &uploadResponseBlocks},
+		receiveRequests:  arenaSlab[ReceiveRequestChunk]{pool: &receiveRequestBlocks},
+		receiveResponses: arenaSlab[ReceiveResponseChunk]{pool: &receiveResponseBlocks},
+	}
+}
+
+var (
+	uploadRequestBlocks   = sync.Pool{New: func() any { return new([arenaBlockLen]UploadRequestChunk) }}
+	uploadResponseBlocks  = sync.Pool{New: func() any { return new([arenaBlockLen]UploadResponseChunk) }}
+	receiveRequestBlocks  = sync.Pool{New: func() any { return new([arenaBlockLen]ReceiveRequestChunk) }}
+	receiveResponseBlocks = sync.Pool{New: func() any { return new([arenaBlockLen]ReceiveResponseChunk) }}
+	stringBlocks          = sync.Pool{New: func() any { return new([arenaStringBlockSize]byte) }}
+)
+
+// NewUploadRequestChunk returns a copy of c allocated in the arena.
+func (a *Arena) NewUploadRequestChunk(c UploadRequestChunk) *UploadRequestChunk {
+	if a == nil {
+		return &c
+	}
+	return a.uploadRequests.alloc(c)
+}
+
+// NewUploadResponseChunk returns a copy of c allocated in the arena.
+func (a *Arena) NewUploadResponseChunk(c UploadResponseChunk) *UploadResponseChunk {
+	if a == nil {
+		return &c
+	}
+	return a.uploadResponses.alloc(c)
+}
+
+// NewReceiveRequestChunk returns a copy of c allocated in the arena.
+func (a *Arena) NewReceiveRequestChunk(c ReceiveRequestChunk) *ReceiveRequestChunk {
+	if a == nil {
+		return &c
+	}
+	return a.receiveRequests.alloc(c)
+}
+
+// NewReceiveResponseChunk returns a copy of c allocated in the arena.
+func (a *Arena) NewReceiveResponseChunk(c ReceiveResponseChunk) *ReceiveResponseChunk {
+	if a == nil {
+		return &c
+	}
+	return a.receiveResponses.alloc(c)
+}
+
+// String returns a string with the contents of b, copied to the arena.
+func (a *Arena) String(b []byte) string {
+	if a == nil || len(b) > arenaStringBlockSize/4 {
+		return string(b)
+	}
+	if len(b) == 0 {
+		return ""
+	}
+	if cap(a.str)-len(a.str) < len(b) {
+		blk := stringBlocks.Get().(*[arenaStringBlockSize]byte)
+		a.strBlocks = append(a.strBlocks, blk)
+		a.str = blk[:0]
+	}
+	off := len(a.str)
+	a.str = append(a.str, b...)
+	return unsafe.String(&a.str[off], len(b))
+}
+
+// Release recycles the memory of the arena, which can then be reused.
+func (a *Arena) Release() {
+	if a == nil {
+		return
+	}
+	a.uploadRequests.release()
+	a.uploadResponses.release()
+	a.receiveRequests.release()
+	a.receiveResponses.release()
+	for _, blk := range a.strBlocks {
+		stringBlocks.Put(blk)
+	}
+	a.strBlocks = nil
+	a.str = nil
+}
+
+type arenaSlab[T any] struct {
+	pool   *sync.Pool
+	free   []T
+	blocks []*[arenaBlockLen]T
+}
+
+// arenaBlocks returns the pool of the blocks of T.
+func arenaBlocks[T any]() *sync.Pool {
+	switch any((*T)(nil)).(type) {
+	case *UploadRequestChunk:
+		return &uploadRequestBlocks
+	case *UploadResponseChunk:
+		return &uploadResponseBlocks
+	case *ReceiveRequestChunk:
+		return &receiveRequestBlocks
+	case *ReceiveResponseChunk:
+		return &receiveResponseBlocks
+	}
+	panic("no arena blocks")
+}
+
+func (s *arenaSlab[T]) alloc(v T) *T {
+	if len(s.free) == 0 {
+		// The slabs of a zero Arena have no pool yet.
+		if s.pool == nil {
+			s.pool = arenaBlocks[T]()
+		}
+		blk := s.pool.Get().(*[arenaBlockLen]T)
+		s.blocks = append(s.blocks, blk)
+		s.free = blk[:]
+	}
+	p := &s.free[0]
+	*p = v
+	s.free = s.free[1:]
+	return p
+}
+
+func (s *arenaSlab[T]) release() {
+	for _, blk := range s.blocks {
+		// Drop the references to the strings and the packet data.
+		*blk = [arenaBlockLen]T{}
+		s.pool.Put(blk)
+	}
+	s.blocks = nil
+	s.free = nil
+}
@@ -0,0 +1,92 @@
+// Modified by Giacomo Tartari
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkt
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+func TestArena(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		arena func() *Arena
+	}{
+		{"nil", func() *Arena { return nil }},
+		{"zero", func() *Arena { return &Arena{} }},
+		{"new", func() *Arena { return new(Arena) }},
+		{"NewArena", NewArena},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			a := tc.arena()
+			// Twice, to reuse the released blocks.
+			for round := 0; round < 2; round++ {
+				const n = 2*arenaBlockLen + 1
+				var (
+					urs  []*UploadRequestChunk
+					uss  []*UploadResponseChunk
+					rrs  []*ReceiveRequestChunk
+					rss  []*ReceiveResponseChunk
+					strs []string
+				)
+				for i := 0; i < n; i++ {
+					s := fmt.Sprint(i)
+					urs = append(urs, a.NewUploadRequestChunk(UploadRequestChunk{WantObjectID: s}))
+					uss = append(uss, a.NewUploadResponseChunk(UploadResponseChunk{AckObjectID: s}))
+					rrs = append(rrs, a.NewReceiveRequestChunk(ReceiveRequestChunk{RefName: s}))
+					rss = append(rss, a.NewReceiveResponseChunk(ReceiveResponseChunk{RefName: s}))
+					strs = append(strs, a.String([]byte(strings.Repeat(s, 100))))
+				}
+				for i := 0; i < n; i++ {
+					s := fmt.Sprint(i)
+					if urs[i].WantObjectID != s || uss[i].AckObjectID != s || rrs[i].RefName != s || rss[i].RefName != s {
+						t.Fatalf("round %d: chunks %d were overwritten", round, i)
+					}
+					if strs[i] != strings.Repeat(s, 100) {
+						t.Fatalf("round %d: string %d was overwritten", round, i)
+					}
+				}
+				a.Release()
+			}
+		})
+	}
+}
+
+func TestWithArena(t *testing.T) {
+	in := pktLines("want "+oid+" ofs-delta\n", "0000", "have "+oid+"\n", "done\n")
+	parse := func(opts ...Option) []*UploadRequestChunk {
+		r := NewUploadRequest(strings.NewReader(in), opts...)
+		var cs []*UploadRequestChunk
+		for r.Scan() {
+			cs = append(cs, r.Chunk())
+		}
+		if err := r.Err(); err != nil {
+			t.Fatal(err)
+		}
+		return cs
+	}
+	want := parse()
+	for _, a := range []*Arena{{}, NewArena()} {
+		if diff := cmp.Diff(want, parse(WithArena(a)), cmpopts.IgnoreUnexported(UploadRequestChunk{})); diff != "" {
+			t.Errorf("chunks mismatch (-heap +arena):\n%s", diff)
+		}
+		a.Release()
+	}
+}
@@ -0,0 +1,75 @@
+// Modified by Giacomo Tartari
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkt
+
+import "context"
+
+// ChunkOrError is a chunk read by a parser, or the error ending its scan.
+type ChunkOrError[C any] struct {
+	Chunk C
+	Err   error
+}
+
+// ScanChunks scans s in a goroutine and sends its chunks on the returned
+// channel, followed by the error ending the scan, if any. The channel is
+// closed at the end of the scan, or when ctx is done; a read blocked in
+// Scan is only interrupted if the parser reads with WithContext too. The
+// goroutine scans the next chunk as soon as one is received, so the parser
+// must not use WithReuseBuffer or an Arena.
+func ScanChunks[C any](ctx context.Context, s ChunkScanner[C]) <-chan ChunkOrError[C] {
+	ch := make(chan ChunkOrError[C])
+	go func() {
+		defer close(ch)
+		for ctx.Err() == nil && s.Scan() {
+			select {
+			case ch <- ChunkOrError[C]{Chunk: s.Chunk()}:
+			case <-ctx.Done():
+				return
+			}
+		}
+		err := s.Err()
+		if err == nil {
+			err = ctx.Err()
+		}
+		if err != nil {
+			select {
+			case ch <- ChunkOrError[C]{Err: err}:
+			case <-ctx.Done():
+			}
+		}
+	}()
+	return ch
+}
+
+// Chunks returns the chunks of r on a channel, see ScanChunks.
+func (r *UploadRequest) Chunks(ctx context.Context) <-chan ChunkOrError[*UploadRequestChunk] {
+	return ScanChunks[*UploadRequestChunk](ctx, r)
+}
+
+// Chunks returns the chunks of r on a channel, see ScanChunks.
+func (r *UploadResponse) Chunks(ctx context.Context) <-chan ChunkOrError[*UploadResponseChunk] {
+	return ScanChunks[*UploadResponseChunk](ctx, r)
+}
+
+// Chunks returns the chunks of r on a channel, see ScanChunks.
+func (r *ReceiveRequest) Chunks(ctx context.Context) <-chan ChunkOrError[*ReceiveRequestChunk] {
+	return ScanChunks[*ReceiveRequestChunk](ctx, r)
+}
+
+// Chunks returns the chunks of r on a channel, see ScanChunks.
+func (r *ReceiveResponse) Chunks(ctx context.Context) <-chan ChunkOrError[*ReceiveResponseChunk] {
+	return ScanChunks[*ReceiveResponseChunk](ctx, r)
+}
@@ -0,0 +1,164 @@
+// Modified by Giacomo Tartari
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkt_test
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/cycloidio/pkt-line"
+	"github.com/cycloidio/pkt-line/pkttest"
+)
+
+// readTestdata returns a stream captured from git.
+func readTestdata(b *testing.B, name string) []byte {
+	in, err := os.ReadFile("testdata/" + name)
+	if err != nil {
+		b.Fatal(err)
+	}
+	return in
+}
+
+// scanAll reads in with a PacketScanner, b.N times.
+func scanAll(b *testing.B, in []byte, opts ...pkt.Option) {
+	b.SetBytes(int64(len(in)))
+	b.ReportAllocs()
+	r := bytes.NewReader(in)
+	for i := 0; i < b.N; i++ {
+		r.Reset(in)
+		s := pkt.NewPacketScanner(r, opts...)
+		for s.Scan() {
+		}
+		if err := s.Err(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkScanSmallPackets(b *testing.B) {
+	b.Run("advertisement", func(b *testing.B) {
+		scanAll(b, readTestdata(b, "upload-pack-advertisement.pkt"))
+	})
+	for _, size := range []int{10, 50, 1000} {
+		in := pkttest.SmallPackets(10000, size)
+		b.Run(fmt.Sprintf("size=%d", size), func(b *testing.B) {
+			scanAll(b, in)
+		})
+		b.Run(fmt.Sprintf("size=%d/reuse", size), func(b *testing.B) {
+			scanAll(b, in, pkt.WithReuseBuffer(true))
+		})
+	}
+}
+
+func BenchmarkScanPackStream(b *testing.B) {
+	const size = 8 << 20
+	for _, sideBand := range []bool{false, true} {
+		in := pkttest.UploadResponse(0, size, sideBand)
+		b.Run(fmt.Sprintf("sideband=%v", sideBand), func(b *testing.B) {
+			scanAll(b, in, pkt.WithReuseBuffer(true))
+		})
+		b.Run(fmt.Sprintf("sideband=%v/PackReader", sideBand), func(b *testing.B) {
+			b.SetBytes(int64(len(in)))
+			b.ReportAllocs()
+			r := bytes.NewReader(in)
+			for i := 0; i < b.N; i++ {
+				r.Reset(in)
+				u := pkt.NewUploadResponse(r)
+				u.Scan()
+				n, err := io.Copy(io.Discard, u.PackReader())
+				if err != nil || n != size {
+					b.Fatal(n, err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkUploadResponse(b *testing.B) {
+	streams := map[string][]byte{
+		"captured":       readTestdata(b, "upload-pack-response.pkt"),
+		"acks=100":       pkttest.UploadResponse(100, 1<<20, true),
+		"acks=100/raw":   pkttest.UploadResponse(100, 1<<20, false),
+		"acks=10000/nop": pkttest.UploadResponse(10000, 0, true),
+	}
+	for _, name := range []string{"captured", "acks=100", "acks=100/raw", "acks=10000/nop"} {
+		in := streams[name]
+		for _, lazy := range []bool{false, true} {
+			opts := []pkt.Option{pkt.WithReuseBuffer(true)}
+			if lazy {
+				opts = append(opts, pkt.WithLazyFields())
+			}
+			b.Run(fmt.Sprintf("%s/lazy=%v", name, lazy), func(b *testing.B) {
+				b.SetBytes(int64(len(in)))
+				b.ReportAllocs()
+				r := bytes.NewReader(in)
+				for i := 0; i < b.N; i++ {
+					r.Reset(in)
+					u := pkt.NewUploadResponse(r, opts...)
+					for u.Scan() {
+					}
+					if err := u.Err(); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
+
+func BenchmarkEncode(b *testing.B) {
+	p := pkt.BytesPacket(fmt.Sprintf("have %040x\n", 0))
+	b.Run("EncodeToPktLine", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			p.EncodeToPktLine()
+		}
+	})
+	b.Run("AppendPktLine", func(b *testing.B) {
+		b.ReportAllocs()
+		buf := make([]byte, 0, 128)
+		for i := 0; i < b.N; i++ {
+			buf = p.AppendPktLine(buf[:0])
+		}
+	})
+	b.Run("chunk", func(b *testing.B) {
+		c := pkt.NewHaveChunk(fmt.Sprintf("%040x", 0))
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			c.EncodeToPktLine()
+		}
+	})
+	b.Run("PacketWriter", func(b *testing.B) {
+		w := pkt.NewPacketWriter(io.Discard)
+		b.SetBytes(int64(len(p) + 4))
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			w.WritePacket(p)
+		}
+	})
+	b.Run("SideBandMuxer", func(b *testing.B) {
+		data := pkttest.PackData(1 << 20)
+		m := pkt.NewSideBandMuxer(io.Discard, true)
+		b.SetBytes(int64(len(data)))
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			m.Main().Write(data)
+		}
+	})
+}
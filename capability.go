@@ -0,0 +1,232 @@
+// Modified by Giacomo Tartari
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkt
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// InvalidCapabilityError is returned when a capability cannot be advertised.
+type InvalidCapabilityError struct {
+	Capability string
+	Reason     string
+}
+
+func (e *InvalidCapabilityError) Error() string {
+	return fmt.Sprintf("invalid capability %q: %s", e.Capability, e.Reason)
+}
+
+// ValidateCapability checks that name and value form a legal capability, as
+// defined by the protocol v2 documentation: the name is made of letters,
+// digits, "-" and "_", and the optional value of letters, digits, spaces and
+// the characters in -_.,?\/{}[]()<>!@#$%^&*+=:;. Protocol v1 capabilities are
+// separated by spaces, so their values must not contain any; see
+// Advertisement.AddCapability.
+func ValidateCapability(name, value string) error {
+	c := name
+	if value != "" {
+		c += "=" + value
+	}
+	if name == "" {
+		return &InvalidCapabilityError{c, "empty name"}
+	}
+	for _, r := range name {
+		if !isAlnum(r) && r != '-' && r != '_' {
+			return &InvalidCapabilityError{c, fmt.Sprintf("illegal character %q in name", r)}
+		}
+	}
+	for _, r := range value {
+		if !isAlnum(r) && !strings.ContainsRune(" -_.,?\\/{}[]()<>!@#$%^&*+=:;", r) {
+			return &InvalidCapabilityError{c, fmt.Sprintf("illegal character %q in value", r)}
+		}
+	}
+	return nil
+}
+
+func isAlnum(r rune) bool {
+	return 'a' <= r && r <= 'z' || 'A' <= r && r <= 'Z' || '0' <= r && r <= '9'
+}
+
+// Capabilities is a list of capabilities of the form name[=value], as sent
+// after the NUL of the first line of a protocol v1 advertisement and on the
+// first want or command line. Some capabilities, like symref, can appear
+// several times.
+type Capabilities []string
+
+// ParseCapabilities parses a space separated list of capabilities. A
+// leading NUL and a trailing LF are ignored.
+func ParseCapabilities(s string) Capabilities {
+	s = strings.TrimSuffix(strings.TrimPrefix(s, "\x00"), "\n")
+	return Capabilities(strings.Fields(s))
+}
+
+// String returns the space separated list of the capabilities.
+func (c Capabilities) String() string {
+	return strings.Join(c, " ")
+}
+
+// Has reports whether the named capability is present.
+func (c Capabilities) Has(name string) bool {
+	_, ok := c.Get(name)
+	return ok
+}
+
+// Get returns the value of the first capability with the name, and whether
+// it is present.
+func (c Capabilities) Get(name string) (string, bool) {
+	for _, cp := range c {
+		if n, v, _ := strings.Cut(cp, "="); n == name {
+			return v, true
+		}
+	}
+	return "", false
+}
+
+// Values returns the values of all the capabilities with the name.
+func (c Capabilities) Values(name string) []string {
+	var vs []string
+	for _, cp := range c {
+		if n, v, _ := strings.Cut(cp, "="); n == name {
+			vs = append(vs, v)
+		}
+	}
+	return vs
+}
+
+// Agent returns the value of the agent capability, e.g. "git/2.40.0".
+func (c Capabilities) Agent() string {
+	v, _ := c.Get("agent")
+	return v
+}
+
+// ObjectFormat returns the value of the object-format capability, or SHA1,
+// the format of the peers that do not send it.
+func (c Capabilities) ObjectFormat() ObjectFormat {
+	if v, ok := c.Get("object-format"); ok {
+		return ObjectFormat(v)
+	}
+	return SHA1
+}
+
+// Symrefs returns the targets of the symref capabilities, keyed by the name
+// of the symbolic ref, e.g. {"HEAD": "refs/heads/main"}.
+func (c Capabilities) Symrefs() map[string]string {
+	m := map[string]string{}
+	for _, v := range c.Values("symref") {
+		if ref, target, ok := strings.Cut(v, ":"); ok {
+			m[ref] = target
+		}
+	}
+	return m
+}
+
+// Set replaces the capabilities with the name by a single one with value,
+// or adds it. An empty value sets a capability without value.
+func (c *Capabilities) Set(name, value string) {
+	c.Delete(name)
+	c.Add(name, value)
+}
+
+// Add appends a capability, even if another one has the same name.
+func (c *Capabilities) Add(name, value string) {
+	if value != "" {
+		name += "=" + value
+	}
+	*c = append(*c, name)
+}
+
+// Delete removes the capabilities with the name.
+func (c *Capabilities) Delete(name string) {
+	out := (*c)[:0]
+	for _, cp := range *c {
+		if n, _, _ := strings.Cut(cp, "="); n != name {
+			out = append(out, cp)
+		}
+	}
+	*c = out
+}
+
+// Advertisement is a protocol v0/v1 ref advertisement, as sent by a server
+// at the beginning of a session.
+type Advertisement struct {
+	Refs         Refs
+	Capabilities []string
+}
+
+// AddCapability appends a capability to the advertisement. It is meant for
+// private extensions; the name must be legal, not already advertised, and
+// the value must not contain spaces.
+func (a *Advertisement) AddCapability(name, value string) error {
+	if err := ValidateCapability(name, value); err != nil {
+		return err
+	}
+	c := name
+	if value != "" {
+		c += "=" + value
+	}
+	if strings.Contains(value, " ") {
+		return &InvalidCapabilityError{c, "protocol v1 values cannot contain spaces"}
+	}
+	if Capabilities(a.Capabilities).Has(name) {
+		return &InvalidCapabilityError{c, "already advertised"}
+	}
+	a.Capabilities = append(a.Capabilities, c)
+	return nil
+}
+
+// Chunks returns the chunks of the advertisement, ending with a flush. The
+// capabilities are sent on the first ref, or on the "capabilities^{}"
+// placeholder if there are no refs, and the peeled object IDs follow their
+// tags. The symref targets are advertised as symref capabilities.
+func (a *Advertisement) Chunks() []*InfoRefsResponseChunk {
+	caps := append([]string(nil), a.Capabilities...)
+	for _, r := range a.Refs {
+		if r.SymrefTarget != "" {
+			caps = append(caps, "symref="+r.Name+":"+r.SymrefTarget)
+		}
+	}
+	if len(caps) == 0 {
+		// Keep the NUL separator, as git does.
+		caps = []string{""}
+	}
+
+	var chunks []*InfoRefsResponseChunk
+	if len(a.Refs) == 0 {
+		chunks = append(chunks, &InfoRefsResponseChunk{
+			ObjectID:     strings.Repeat("0", 40),
+			Ref:          "capabilities^{}",
+			Capabilities: caps,
+		})
+	}
+	for i, r := range a.Refs {
+		c := &InfoRefsResponseChunk{ObjectID: string(r.ObjectID), Ref: r.Name}
+		if i == 0 {
+			c.Capabilities = caps
+		}
+		chunks = append(chunks, c)
+		if r.Peeled != "" {
+			chunks = append(chunks, &InfoRefsResponseChunk{ObjectID: string(r.Peeled), Ref: r.Name + "^{}"})
+		}
+	}
+	return append(chunks, &InfoRefsResponseChunk{EndOfRequest: true})
+}
+
+// WriteTo writes the advertisement to w.
+func (a *Advertisement) WriteTo(w io.Writer) (int64, error) {
+	return writeChunks(w, a.Chunks())
+}
@@ -0,0 +1,128 @@
+// Modified by Giacomo Tartari
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkt
+
+import (
+	"slices"
+	"strings"
+)
+
+// CapabilityFilter rewrites the capabilities of an advertisement, e.g. to
+// hide "filter" from the clients of a proxy or to add "session-id". It
+// applies to the capabilities sent after the NUL of the first ref of a
+// protocol v1 advertisement and to the capability lines of a protocol v2
+// one.
+type CapabilityFilter struct {
+	// Strip are the names of the capabilities to remove.
+	Strip []string
+	// StripFeatures are the protocol v2 command features to remove from
+	// the values of the commands, e.g. "filter" from "fetch=shallow filter".
+	// The protocol v1 equivalents are capabilities, removed by Strip.
+	StripFeatures []string
+	// Inject are the capabilities to add, of the form name[=value]. An
+	// injected capability replaces the advertised ones with its name.
+	Inject []string
+}
+
+// Apply returns caps rewritten by f. caps is not modified.
+func (f *CapabilityFilter) Apply(caps Capabilities) Capabilities {
+	out := make(Capabilities, 0, len(caps)+len(f.Inject))
+	for _, c := range caps {
+		name := capabilityName(c)
+		if _, ok := f.injection(name); !ok && !slices.Contains(f.Strip, name) {
+			out = append(out, c)
+		}
+	}
+	return append(out, f.Inject...)
+}
+
+// Transformer returns a ChunkTransformer applying f to the chunks of an
+// InfoRefsResponse, to be used with TransformChunks. In a protocol v2
+// advertisement, the injected capabilities replace the advertised ones in
+// place, and the others are added before the flush. The transformer keeps
+// the state of one advertisement.
+func (f *CapabilityFilter) Transformer() ChunkTransformer[*InfoRefsResponseChunk] {
+	v2 := false
+	firstRef := true
+	injected := map[string]bool{}
+	return func(c *InfoRefsResponseChunk) ([]*InfoRefsResponseChunk, error) {
+		switch {
+		case c.ProtocolVersion == 2:
+			v2 = true
+		case v2 && len(c.Capabilities) == 1:
+			name := capabilityName(c.Capabilities[0])
+			if slices.Contains(f.Strip, name) {
+				return nil, nil
+			}
+			if cp, ok := f.injection(name); ok {
+				if injected[name] {
+					return nil, nil
+				}
+				injected[name] = true
+				return []*InfoRefsResponseChunk{{Capabilities: []string{cp}}}, nil
+			}
+			if cp := f.stripFeatures(c.Capabilities[0]); cp != c.Capabilities[0] {
+				return []*InfoRefsResponseChunk{{Capabilities: []string{cp}}}, nil
+			}
+		case v2 && c.EndOfRequest:
+			var chunks []*InfoRefsResponseChunk
+			for _, cp := range f.Inject {
+				if !injected[capabilityName(cp)] {
+					chunks = append(chunks, &InfoRefsResponseChunk{Capabilities: []string{cp}})
+				}
+			}
+			return append(chunks, c), nil
+		case !v2 && firstRef && c.ObjectID != "":
+			firstRef = false
+			rc := *c
+			rc.Capabilities = f.Apply(c.Capabilities)
+			return []*InfoRefsResponseChunk{&rc}, nil
+		}
+		return []*InfoRefsResponseChunk{c}, nil
+	}
+}
+
+// stripFeatures removes the StripFeatures from the value of the command
+// capability c.
+func (f *CapabilityFilter) stripFeatures(c string) string {
+	name, value, ok := strings.Cut(c, "=")
+	if !ok || len(f.StripFeatures) == 0 {
+		return c
+	}
+	features := slices.DeleteFunc(strings.Fields(value), func(ft string) bool {
+		return slices.Contains(f.StripFeatures, ft)
+	})
+	if len(features) == 0 {
+		return name
+	}
+	return name + "=" + strings.Join(features, " ")
+}
+
+// injection returns the injected capability with the name.
+func (f *CapabilityFilter) injection(name string) (string, bool) {
+	for _, cp := range f.Inject {
+		if capabilityName(cp) == name {
+			return cp, true
+		}
+	}
+	return "", false
+}
+
+// capabilityName returns the name of a capability of the form name[=value].
+func capabilityName(c string) string {
+	name, _, _ := strings.Cut(c, "=")
+	return name
+}
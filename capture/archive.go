@@ -0,0 +1,324 @@
+// Modified by Giacomo Tartari
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package capture records and replays Git protocol sessions.
+package capture
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// Direction is the direction of the traffic in a session.
+type Direction byte
+
+const (
+	// ClientToServer is the traffic sent by the client.
+	ClientToServer Direction = '>'
+	// ServerToClient is the traffic sent by the server.
+	ServerToClient Direction = '<'
+)
+
+// Opposite returns the other direction.
+func (d Direction) Opposite() Direction {
+	if d == ClientToServer {
+		return ServerToClient
+	}
+	return ClientToServer
+}
+
+func (d Direction) String() string {
+	switch d {
+	case ClientToServer:
+		return "client"
+	case ServerToClient:
+		return "server"
+	}
+	return fmt.Sprintf("Direction(%d)", byte(d))
+}
+
+func (d Direction) valid() bool {
+	return d == ClientToServer || d == ServerToClient
+}
+
+var archiveMagic = []byte("PKTARCH\x01")
+
+// ErrBadArchive is returned when the input is not an archive written by
+// ArchiveWriter.
+var ErrBadArchive = errors.New("capture: not a session archive")
+
+// ArchiveWriter streams both directions of a session into a gzip compressed
+// archive. Each write is stored as a record with its direction so that the
+// two byte streams can be separated again by ArchiveReader. It is safe for
+// concurrent use.
+type ArchiveWriter struct {
+	mu     sync.Mutex
+	zw     *gzip.Writer
+	closer io.Closer
+	err    error
+}
+
+// NewArchiveWriter returns a new ArchiveWriter writing to w.
+func NewArchiveWriter(w io.Writer) (*ArchiveWriter, error) {
+	zw := gzip.NewWriter(w)
+	if _, err := zw.Write(archiveMagic); err != nil {
+		return nil, err
+	}
+	return &ArchiveWriter{zw: zw}, nil
+}
+
+// CreateArchive creates the named archive file. Close closes the file.
+func CreateArchive(name string) (*ArchiveWriter, error) {
+	f, err := os.Create(name)
+	if err != nil {
+		return nil, err
+	}
+	a, err := NewArchiveWriter(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	a.closer = f
+	return a, nil
+}
+
+// Write appends p to the archive as traffic sent in the direction dir.
+func (a *ArchiveWriter) Write(dir Direction, p []byte) error {
+	if !dir.valid() {
+		return fmt.Errorf("capture: invalid direction %d", byte(dir))
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.err != nil {
+		return a.err
+	}
+	for len(p) > 0 {
+		n := len(p)
+		if n > maxRecordSize {
+			n = maxRecordSize
+		}
+		var hdr [5]byte
+		hdr[0] = byte(dir)
+		binary.BigEndian.PutUint32(hdr[1:], uint32(n))
+		if _, a.err = a.zw.Write(hdr[:]); a.err != nil {
+			return a.err
+		}
+		if _, a.err = a.zw.Write(p[:n]); a.err != nil {
+			return a.err
+		}
+		p = p[n:]
+	}
+	return nil
+}
+
+const maxRecordSize = 1 << 30
+
+// readRecord reads the n bytes of a record. The length comes from the
+// input, so it is bounded by maxRecordSize and the buffer grows as the data
+// is read rather than being allocated upfront.
+func readRecord(r io.Reader, n uint32) ([]byte, error) {
+	if n > maxRecordSize {
+		return nil, fmt.Errorf("capture: record of %d bytes exceeds %d", n, maxRecordSize)
+	}
+	bs, err := io.ReadAll(io.LimitReader(r, int64(n)))
+	if err != nil {
+		return nil, err
+	}
+	if len(bs) != int(n) {
+		return nil, io.ErrUnexpectedEOF
+	}
+	return bs, nil
+}
+
+// Writer returns an io.Writer that archives everything written to it as
+// traffic in the direction dir. It can be combined with io.TeeReader or
+// io.MultiWriter to tap an existing stream.
+func (a *ArchiveWriter) Writer(dir Direction) io.Writer {
+	return directionWriter{a, dir}
+}
+
+// Wrap returns an io.ReadWriter that archives the data read from rw as
+// traffic in the direction read and the data written to rw as traffic in the
+// opposite direction. A client wraps its connection with ServerToClient, a
+// server with ClientToServer.
+func (a *ArchiveWriter) Wrap(rw io.ReadWriter, read Direction) io.ReadWriter {
+	return &tappedReadWriter{rw: rw, a: a, read: read}
+}
+
+// Close flushes the compressed stream. If the archive was created by
+// CreateArchive, the file is closed as well.
+func (a *ArchiveWriter) Close() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	err := a.zw.Close()
+	if a.closer != nil {
+		if cerr := a.closer.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if a.err == nil {
+		a.err = errors.New("capture: archive closed")
+	}
+	return err
+}
+
+type directionWriter struct {
+	a   *ArchiveWriter
+	dir Direction
+}
+
+func (w directionWriter) Write(p []byte) (int, error) {
+	if err := w.a.Write(w.dir, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+type tappedReadWriter struct {
+	rw   io.ReadWriter
+	a    *ArchiveWriter
+	read Direction
+}
+
+func (t *tappedReadWriter) Read(p []byte) (int, error) {
+	n, err := t.rw.Read(p)
+	if n > 0 {
+		if aerr := t.a.Write(t.read, p[:n]); aerr != nil && err == nil {
+			err = aerr
+		}
+	}
+	return n, err
+}
+
+func (t *tappedReadWriter) Write(p []byte) (int, error) {
+	n, err := t.rw.Write(p)
+	if n > 0 {
+		if aerr := t.a.Write(t.read.Opposite(), p[:n]); aerr != nil && err == nil {
+			err = aerr
+		}
+	}
+	return n, err
+}
+
+// ArchiveReader reads the records of an archive written by ArchiveWriter.
+type ArchiveReader struct {
+	zr     *gzip.Reader
+	br     *bufio.Reader
+	closer io.Closer
+}
+
+// NewArchiveReader returns a new ArchiveReader reading from r.
+func NewArchiveReader(r io.Reader) (*ArchiveReader, error) {
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	br := bufio.NewReader(zr)
+	magic := make([]byte, len(archiveMagic))
+	if _, err := io.ReadFull(br, magic); err != nil || !bytes.Equal(magic, archiveMagic) {
+		return nil, ErrBadArchive
+	}
+	return &ArchiveReader{zr: zr, br: br}, nil
+}
+
+// OpenArchive opens the named archive file. Close closes the file.
+func OpenArchive(name string) (*ArchiveReader, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	r, err := NewArchiveReader(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	r.closer = f
+	return r, nil
+}
+
+// Next returns the next record of the archive. It returns io.EOF when there
+// are no more records.
+func (r *ArchiveReader) Next() (Direction, []byte, error) {
+	var hdr [5]byte
+	if _, err := io.ReadFull(r.br, hdr[:]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			err = ErrBadArchive
+		}
+		return 0, nil, err
+	}
+	dir := Direction(hdr[0])
+	if !dir.valid() {
+		return 0, nil, ErrBadArchive
+	}
+	bs, err := readRecord(r.br, binary.BigEndian.Uint32(hdr[1:]))
+	if err != nil {
+		return 0, nil, ErrBadArchive
+	}
+	return dir, bs, nil
+}
+
+// Close closes the archive.
+func (r *ArchiveReader) Close() error {
+	err := r.zr.Close()
+	if r.closer != nil {
+		if cerr := r.closer.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// Session is an archived session split by direction.
+type Session struct {
+	Client []byte
+	Server []byte
+}
+
+// ReadSession reads all the records of r and returns the reassembled
+// traffic of both directions.
+func ReadSession(r *ArchiveReader) (*Session, error) {
+	s := &Session{}
+	for {
+		dir, bs, err := r.Next()
+		if err == io.EOF {
+			return s, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if dir == ClientToServer {
+			s.Client = append(s.Client, bs...)
+		} else {
+			s.Server = append(s.Server, bs...)
+		}
+	}
+}
+
+// Reader returns the traffic sent in the direction dir. It can be passed to
+// the parsers of this module, e.g. pkt.NewUploadRequest for the client side
+// of a fetch.
+func (s *Session) Reader(dir Direction) io.Reader {
+	if dir == ClientToServer {
+		return bytes.NewReader(s.Client)
+	}
+	return bytes.NewReader(s.Server)
+}
@@ -0,0 +1,154 @@
+// Modified by Giacomo Tartari
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package capture
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"io"
+	"runtime"
+	"testing"
+)
+
+func TestArchiveRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	a, err := NewArchiveWriter(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writes := []struct {
+		dir  Direction
+		data string
+	}{
+		{ClientToServer, "0014command=ls-refs\n0000"},
+		{ServerToClient, "003fref\n"},
+		{ClientToServer, "0009done\n"},
+		{ServerToClient, "0000"},
+	}
+	for _, w := range writes {
+		if err := a.Write(w.dir, []byte(w.data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := a.Writer(ServerToClient).Write([]byte("0002")); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Write(ClientToServer, []byte("0000")); err == nil {
+		t.Error("Write after Close succeeded")
+	}
+
+	r, err := NewArchiveReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	s, err := ReadSession(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(s.Client), "0014command=ls-refs\n00000009done\n"; got != want {
+		t.Errorf("client traffic = %q, want %q", got, want)
+	}
+	if got, want := string(s.Server), "003fref\n00000002"; got != want {
+		t.Errorf("server traffic = %q, want %q", got, want)
+	}
+}
+
+func TestArchiveWrap(t *testing.T) {
+	var buf bytes.Buffer
+	a, err := NewArchiveWriter(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sent bytes.Buffer
+	rw := a.Wrap(struct {
+		io.Reader
+		io.Writer
+	}{bytes.NewBufferString("0008NAK\n"), &sent}, ServerToClient)
+	if _, err := rw.Write([]byte("0009done\n")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadAll(rw); err != nil {
+		t.Fatal(err)
+	}
+	a.Close()
+
+	r, err := NewArchiveReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := ReadSession(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(s.Client) != "0009done\n" || string(s.Server) != "0008NAK\n" {
+		t.Errorf("ReadSession() = %q, %q", s.Client, s.Server)
+	}
+}
+
+// forgeArchive returns a compressed archive made of body after the magic.
+func forgeArchive(magic, body []byte) *bytes.Buffer {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Write(magic)
+	zw.Write(body)
+	zw.Close()
+	return &buf
+}
+
+func archiveRecord(dir Direction, n uint32, data string) []byte {
+	hdr := []byte{byte(dir), 0, 0, 0, 0}
+	binary.BigEndian.PutUint32(hdr[1:], n)
+	return append(hdr, data...)
+}
+
+func TestArchiveReader_corrupt(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		body []byte
+	}{
+		{"oversized record", archiveRecord(ClientToServer, 0xFFFFFFFF, "0000")},
+		{"above maxRecordSize", archiveRecord(ClientToServer, maxRecordSize+1, "0000")},
+		{"truncated record", archiveRecord(ServerToClient, 8, "0008")},
+		{"truncated header", []byte{byte(ClientToServer), 0, 0}},
+		{"invalid direction", archiveRecord('?', 4, "0000")},
+	} {
+		r, err := NewArchiveReader(forgeArchive(archiveMagic, tc.body))
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		var before, after runtime.MemStats
+		runtime.ReadMemStats(&before)
+		if _, _, err := r.Next(); err != ErrBadArchive {
+			t.Errorf("%s: Next() = %v, want ErrBadArchive", tc.name, err)
+		}
+		runtime.ReadMemStats(&after)
+		if n := after.TotalAlloc - before.TotalAlloc; n > 1<<20 {
+			t.Errorf("%s: Next() allocated %d bytes", tc.name, n)
+		}
+	}
+
+	if _, err := NewArchiveReader(forgeArchive([]byte("PKTARCH\x02"), nil)); err != ErrBadArchive {
+		t.Errorf("NewArchiveReader(bad magic) = %v, want ErrBadArchive", err)
+	}
+	if _, err := NewArchiveReader(bytes.NewBufferString("not gzip")); err == nil {
+		t.Error("NewArchiveReader(not gzip) succeeded")
+	}
+}
@@ -0,0 +1,273 @@
+// Modified by Giacomo Tartari
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package capture
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/cycloidio/pkt-line"
+)
+
+// The capture container format stores one record per packet:
+//
+//	magic   "PKTCAP\x00\x01"
+//	record  direction (1 byte) | flags (1 byte) | time (8 bytes, UNIX nanoseconds)
+//	        | length (4 bytes) | packet as sent on the wire
+//
+// All integers are big endian.
+var captureMagic = []byte("PKTCAP\x00\x01")
+
+const (
+	recordFlagPackData = 1 << iota
+)
+
+// ErrBadCapture is returned when the input is not a capture written by
+// CaptureWriter.
+var ErrBadCapture = errors.New("capture: not a packet capture")
+
+// Record is a single packet of a capture.
+type Record struct {
+	Direction Direction
+	Time      time.Time
+	// Data is the packet as it was sent on the wire, including the length
+	// header.
+	Data []byte
+	// PackData is true if Data is a chunk of a pack file rather than a
+	// pkt-line.
+	PackData bool
+}
+
+// Packet decodes the packet of the record.
+func (r *Record) Packet() (pkt.Packet, error) {
+	if r.PackData {
+		return pkt.PackFilePacket(r.Data), nil
+	}
+	sc := pkt.NewPacketScanner(bytes.NewReader(r.Data), pkt.WithErrorPackets())
+	if !sc.Scan() {
+		if sc.Err() != nil {
+			return nil, sc.Err()
+		}
+		return nil, ErrBadCapture
+	}
+	return sc.Packet(), nil
+}
+
+// CaptureWriter writes packets with their timestamp and direction. It is
+// safe for concurrent use.
+type CaptureWriter struct {
+	mu  sync.Mutex
+	w   io.Writer
+	now func() time.Time
+}
+
+// NewCaptureWriter returns a new CaptureWriter writing to w.
+func NewCaptureWriter(w io.Writer) (*CaptureWriter, error) {
+	if _, err := w.Write(captureMagic); err != nil {
+		return nil, err
+	}
+	return &CaptureWriter{w: w, now: time.Now}, nil
+}
+
+// WriteRecord writes a record.
+func (c *CaptureWriter) WriteRecord(r *Record) error {
+	if !r.Direction.valid() {
+		return fmt.Errorf("capture: invalid direction %d", byte(r.Direction))
+	}
+	if len(r.Data) > maxRecordSize {
+		return fmt.Errorf("capture: record too large: %d", len(r.Data))
+	}
+	var hdr [14]byte
+	hdr[0] = byte(r.Direction)
+	if r.PackData {
+		hdr[1] |= recordFlagPackData
+	}
+	binary.BigEndian.PutUint64(hdr[2:], uint64(r.Time.UnixNano()))
+	binary.BigEndian.PutUint32(hdr[10:], uint32(len(r.Data)))
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, err := c.w.Write(hdr[:]); err != nil {
+		return err
+	}
+	_, err := c.w.Write(r.Data)
+	return err
+}
+
+// WritePacket records p as sent in the direction dir now.
+func (c *CaptureWriter) WritePacket(dir Direction, p pkt.Packet) error {
+	_, pack := p.(pkt.PackFilePacket)
+	return c.WriteRecord(&Record{
+		Direction: dir,
+		Time:      c.now(),
+		Data:      p.EncodeToPktLine(),
+		PackData:  pack,
+	})
+}
+
+// Writer returns an io.Writer that splits the pkt-line stream written to it
+// into packets and records each of them, timestamped when its last byte is
+// written, as sent in the direction dir. Once the pack file starts, every
+// write is recorded as one pack data record. It can be combined with
+// io.TeeReader or io.MultiWriter to tap an existing stream.
+func (c *CaptureWriter) Writer(dir Direction) io.Writer {
+	return &packetSplitter{c: c, dir: dir}
+}
+
+type packetSplitter struct {
+	c        *CaptureWriter
+	dir      Direction
+	buf      []byte
+	packMode bool
+}
+
+func (s *packetSplitter) Write(p []byte) (int, error) {
+	now := s.c.now()
+	if s.packMode {
+		if err := s.emit(now, p, true); err != nil {
+			return 0, err
+		}
+		return len(p), nil
+	}
+	s.buf = append(s.buf, p...)
+	for len(s.buf) >= 4 {
+		if bytes.HasPrefix(s.buf, []byte("PACK")) {
+			if err := s.emit(now, s.buf[:4], false); err != nil {
+				return 0, err
+			}
+			s.packMode = true
+			if rest := s.buf[4:]; len(rest) > 0 {
+				if err := s.emit(now, rest, true); err != nil {
+					return 0, err
+				}
+			}
+			s.buf = nil
+			break
+		}
+		sz, ok := pkt.ParseLength(s.buf[:4])
+		if !ok {
+			return 0, pkt.SyntaxError("cannot parse the packet length: " + string(s.buf[:4]))
+		}
+		if sz < 4 {
+			sz = 4
+		}
+		if len(s.buf) < sz {
+			break
+		}
+		if err := s.emit(now, s.buf[:sz], false); err != nil {
+			return 0, err
+		}
+		s.buf = s.buf[sz:]
+	}
+	return len(p), nil
+}
+
+func (s *packetSplitter) emit(now time.Time, bs []byte, pack bool) error {
+	return s.c.WriteRecord(&Record{
+		Direction: s.dir,
+		Time:      now,
+		Data:      append([]byte(nil), bs...),
+		PackData:  pack,
+	})
+}
+
+// CaptureReader reads the records of a capture written by CaptureWriter.
+type CaptureReader struct {
+	br *bufio.Reader
+}
+
+// NewCaptureReader returns a new CaptureReader reading from r.
+func NewCaptureReader(r io.Reader) (*CaptureReader, error) {
+	br := bufio.NewReader(r)
+	magic := make([]byte, len(captureMagic))
+	if _, err := io.ReadFull(br, magic); err != nil || !bytes.Equal(magic, captureMagic) {
+		return nil, ErrBadCapture
+	}
+	return &CaptureReader{br: br}, nil
+}
+
+// Next returns the next record of the capture. It returns io.EOF when there
+// are no more records.
+func (r *CaptureReader) Next() (*Record, error) {
+	var hdr [14]byte
+	if _, err := io.ReadFull(r.br, hdr[:]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			err = ErrBadCapture
+		}
+		return nil, err
+	}
+	rec := &Record{
+		Direction: Direction(hdr[0]),
+		PackData:  hdr[1]&recordFlagPackData != 0,
+		Time:      time.Unix(0, int64(binary.BigEndian.Uint64(hdr[2:]))),
+	}
+	if !rec.Direction.valid() {
+		return nil, ErrBadCapture
+	}
+	data, err := readRecord(r.br, binary.BigEndian.Uint32(hdr[10:]))
+	if err != nil {
+		return nil, ErrBadCapture
+	}
+	rec.Data = data
+	return rec, nil
+}
+
+// ReadAll reads all the remaining records.
+func (r *CaptureReader) ReadAll() ([]*Record, error) {
+	var recs []*Record
+	for {
+		rec, err := r.Next()
+		if err == io.EOF {
+			return recs, nil
+		}
+		if err != nil {
+			return recs, err
+		}
+		recs = append(recs, rec)
+	}
+}
+
+// Latency is the delay between a packet and the previous packet of the
+// capture.
+type Latency struct {
+	Record *Record
+	// Delay is the time elapsed since the previous record, zero for the
+	// first one.
+	Delay time.Duration
+	// Turnaround is true if the previous record was sent in the other
+	// direction, i.e. Delay is the time the peer took to respond.
+	Turnaround bool
+}
+
+// Latencies returns the inter-packet delays of recs.
+func Latencies(recs []*Record) []Latency {
+	ls := make([]Latency, len(recs))
+	for i, rec := range recs {
+		ls[i].Record = rec
+		if i == 0 {
+			continue
+		}
+		ls[i].Delay = rec.Time.Sub(recs[i-1].Time)
+		ls[i].Turnaround = rec.Direction != recs[i-1].Direction
+	}
+	return ls
+}
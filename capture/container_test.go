@@ -0,0 +1,164 @@
+// Modified by Giacomo Tartari
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package capture
+
+import (
+	"bytes"
+	"encoding/binary"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/cycloidio/pkt-line"
+)
+
+// fakeClock returns a clock starting at start and advancing by a second at
+// each call.
+func fakeClock(start time.Time) func() time.Time {
+	t := start.Add(-time.Second)
+	return func() time.Time {
+		t = t.Add(time.Second)
+		return t
+	}
+}
+
+func TestCaptureRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	c, err := NewCaptureWriter(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Unix(1700000000, 0)
+	c.now = fakeClock(start)
+
+	if err := c.WritePacket(ClientToServer, pkt.BytesPacket("want\n")); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.WritePacket(ClientToServer, pkt.FlushPacket{}); err != nil {
+		t.Fatal(err)
+	}
+	// The server response arrives in pieces, with the pack file after NAK.
+	w := c.Writer(ServerToClient)
+	for _, s := range []string{"00", "08NAK\n", "PACK", "\x00\x00\x00\x02", "rest"} {
+		if _, err := w.Write([]byte(s)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r, err := NewCaptureReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recs, err := r.ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []struct {
+		dir  Direction
+		data string
+		pack bool
+	}{
+		{ClientToServer, "0009want\n", false},
+		{ClientToServer, "0000", false},
+		{ServerToClient, "0008NAK\n", false},
+		{ServerToClient, "PACK", false},
+		{ServerToClient, "\x00\x00\x00\x02", true},
+		{ServerToClient, "rest", true},
+	}
+	if len(recs) != len(want) {
+		t.Fatalf("got %d records, want %d", len(recs), len(want))
+	}
+	for i, w := range want {
+		rec := recs[i]
+		if rec.Direction != w.dir || string(rec.Data) != w.data || rec.PackData != w.pack {
+			t.Errorf("record %d = %v %q %v, want %v %q %v", i, rec.Direction, rec.Data, rec.PackData, w.dir, w.data, w.pack)
+		}
+	}
+	// The NAK is timestamped at its last write, the third call of the clock.
+	if got, want := recs[2].Time, start.Add(3*time.Second); !got.Equal(want) {
+		t.Errorf("NAK time = %v, want %v", got, want)
+	}
+
+	if p, err := recs[0].Packet(); err != nil || string(p.(pkt.BytesPacket)) != "want\n" {
+		t.Errorf("Packet() = %#v, %v", p, err)
+	}
+	if p, err := recs[4].Packet(); err != nil || string(p.(pkt.PackFilePacket)) != "\x00\x00\x00\x02" {
+		t.Errorf("Packet() = %#v, %v", p, err)
+	}
+
+	ls := Latencies(recs)
+	if ls[0].Delay != 0 || ls[1].Turnaround || !ls[2].Turnaround || ls[2].Delay != 2*time.Second {
+		t.Errorf("Latencies() = %+v", ls[:3])
+	}
+}
+
+func TestCaptureWriter_invalid(t *testing.T) {
+	c, err := NewCaptureWriter(&bytes.Buffer{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.WriteRecord(&Record{Direction: '?', Data: []byte("0000")}); err == nil {
+		t.Error("WriteRecord(invalid direction) succeeded")
+	}
+	if _, err := c.Writer(ClientToServer).Write([]byte("zzzz")); err == nil {
+		t.Error("Write(invalid length) succeeded")
+	}
+}
+
+func captureRecord(dir Direction, n uint32, data string) []byte {
+	hdr := make([]byte, 14)
+	hdr[0] = byte(dir)
+	binary.BigEndian.PutUint32(hdr[10:], n)
+	return append(hdr, data...)
+}
+
+func TestCaptureReader_corrupt(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		body []byte
+	}{
+		{"oversized record", captureRecord(ClientToServer, 0xFFFFFFFF, "0000")},
+		{"above maxRecordSize", captureRecord(ClientToServer, maxRecordSize+1, "0000")},
+		{"truncated record", captureRecord(ServerToClient, 8, "0008")},
+		{"truncated header", []byte{byte(ClientToServer), 0, 0}},
+		{"invalid direction", captureRecord('?', 4, "0000")},
+	} {
+		r, err := NewCaptureReader(bytes.NewReader(append(append([]byte(nil), captureMagic...), tc.body...)))
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		var before, after runtime.MemStats
+		runtime.ReadMemStats(&before)
+		if _, err := r.Next(); err != ErrBadCapture {
+			t.Errorf("%s: Next() = %v, want ErrBadCapture", tc.name, err)
+		}
+		runtime.ReadMemStats(&after)
+		if n := after.TotalAlloc - before.TotalAlloc; n > 1<<20 {
+			t.Errorf("%s: Next() allocated %d bytes", tc.name, n)
+		}
+	}
+
+	if _, err := NewCaptureReader(bytes.NewBufferString("PKTCAP\x00\x02")); err != ErrBadCapture {
+		t.Errorf("NewCaptureReader(bad magic) = %v, want ErrBadCapture", err)
+	}
+	r, err := NewCaptureReader(bytes.NewReader(captureMagic))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if recs, err := r.ReadAll(); err != nil || len(recs) != 0 {
+		t.Errorf("ReadAll(empty) = %v, %v", recs, err)
+	}
+}
@@ -0,0 +1,104 @@
+// Modified by Giacomo Tartari
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package capture
+
+import (
+	"context"
+	"io"
+	"time"
+)
+
+// Replayer writes the packets a capture recorded in one direction, honoring
+// the recorded pacing.
+type Replayer struct {
+	// Direction selects the records to replay.
+	Direction Direction
+	// Scale multiplies the recorded delays: 1 replays at the recorded pace,
+	// 2 twice as slow, 0.5 twice as fast. Zero replays without any delay.
+	Scale float64
+	// MaxDelay caps a single wait when non-zero.
+	MaxDelay time.Duration
+}
+
+// Replay reads the records from r and writes the data of those sent in
+// rp.Direction to w. Each record is written at the offset from the first
+// replayed record recorded in the capture, scaled by rp.Scale, so that the
+// delays do not drift with the cost of writing. It returns ctx.Err() if the
+// context is done while waiting.
+func (rp *Replayer) Replay(ctx context.Context, w io.Writer, r *CaptureReader) error {
+	var (
+		start    time.Time
+		origin   time.Time
+		skew     time.Duration
+		replayed bool
+		timer    *time.Timer
+	)
+	defer func() {
+		if timer != nil {
+			timer.Stop()
+		}
+	}()
+	for {
+		rec, err := r.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if rec.Direction != rp.Direction {
+			continue
+		}
+		if !replayed {
+			replayed = true
+			start = time.Now()
+			origin = rec.Time
+		} else if d := rp.delay(start, origin, rec.Time, &skew); d > 0 {
+			if timer == nil {
+				timer = time.NewTimer(d)
+			} else {
+				timer.Reset(d)
+			}
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-timer.C:
+			}
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if _, err := w.Write(rec.Data); err != nil {
+			return err
+		}
+	}
+}
+
+// delay returns how long to wait before writing a record recorded at t. The
+// time cut by MaxDelay is accumulated in skew so that the following records
+// keep their recorded spacing instead of being written in a burst.
+func (rp *Replayer) delay(start, origin, t time.Time, skew *time.Duration) time.Duration {
+	if rp.Scale <= 0 {
+		return 0
+	}
+	due := start.Add(time.Duration(float64(t.Sub(origin))*rp.Scale) - *skew)
+	d := time.Until(due)
+	if rp.MaxDelay > 0 && d > rp.MaxDelay {
+		*skew += d - rp.MaxDelay
+		d = rp.MaxDelay
+	}
+	return d
+}
@@ -0,0 +1,120 @@
+// Modified by Giacomo Tartari
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package capture
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+)
+
+// newCapture returns a capture of records sent every step, alternating the
+// directions and starting with the client.
+func newCapture(t *testing.T, step time.Duration, data ...string) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	c, err := NewCaptureWriter(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Unix(1700000000, 0)
+	for i, d := range data {
+		dir := ClientToServer
+		if i%2 == 1 {
+			dir = ServerToClient
+		}
+		rec := &Record{Direction: dir, Time: start.Add(time.Duration(i) * step), Data: []byte(d)}
+		if err := c.WriteRecord(rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return &buf
+}
+
+func TestReplayer(t *testing.T) {
+	data := []string{"0009want\n", "0008NAK\n", "0009done\n", "0000", "0000"}
+	for _, tc := range []struct {
+		name    string
+		rp      Replayer
+		want    string
+		minTime time.Duration
+		maxTime time.Duration
+	}{
+		{
+			name:    "client at recorded pace",
+			rp:      Replayer{Direction: ClientToServer, Scale: 1},
+			want:    "0009want\n0009done\n0000",
+			minTime: 80 * time.Millisecond,
+			maxTime: time.Second,
+		},
+		{
+			name:    "server twice as fast",
+			rp:      Replayer{Direction: ServerToClient, Scale: 0.5},
+			want:    "0008NAK\n0000",
+			minTime: 20 * time.Millisecond,
+			maxTime: time.Second,
+		},
+		{
+			name:    "no delay",
+			rp:      Replayer{Direction: ClientToServer},
+			want:    "0009want\n0009done\n0000",
+			maxTime: 50 * time.Millisecond,
+		},
+		{
+			name:    "capped delays",
+			rp:      Replayer{Direction: ClientToServer, Scale: 100, MaxDelay: 5 * time.Millisecond},
+			want:    "0009want\n0009done\n0000",
+			minTime: 10 * time.Millisecond,
+			maxTime: time.Second,
+		},
+	} {
+		r, err := NewCaptureReader(newCapture(t, 20*time.Millisecond, data...))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out bytes.Buffer
+		start := time.Now()
+		if err := tc.rp.Replay(context.Background(), &out, r); err != nil {
+			t.Errorf("%s: Replay() = %v", tc.name, err)
+			continue
+		}
+		elapsed := time.Since(start)
+		if out.String() != tc.want {
+			t.Errorf("%s: replayed %q, want %q", tc.name, out.String(), tc.want)
+		}
+		if elapsed < tc.minTime || elapsed > tc.maxTime {
+			t.Errorf("%s: replay took %v, want between %v and %v", tc.name, elapsed, tc.minTime, tc.maxTime)
+		}
+	}
+}
+
+func TestReplayer_canceled(t *testing.T) {
+	r, err := NewCaptureReader(newCapture(t, time.Hour, "0009want\n", "0008NAK\n", "0009done\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	var out bytes.Buffer
+	rp := &Replayer{Direction: ClientToServer, Scale: 1}
+	if err := rp.Replay(ctx, &out, r); err != context.DeadlineExceeded {
+		t.Errorf("Replay() = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if out.String() != "0009want\n" {
+		t.Errorf("replayed %q before the cancellation", out.String())
+	}
+}
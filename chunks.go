@@ -0,0 +1,120 @@
+// Modified by Giacomo Tartari
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkt
+
+import (
+	"bufio"
+	"io"
+)
+
+// UploadRequestChunks is a whole protocol v1 git-upload-pack request.
+type UploadRequestChunks []*UploadRequestChunk
+
+// WriteTo writes the chunks to w through a single buffer. It returns an
+// InvalidChunkError, before writing it, for a chunk that cannot be encoded.
+func (cs UploadRequestChunks) WriteTo(w io.Writer) (int64, error) {
+	return writeChunks(w, cs)
+}
+
+// ReadAllUploadRequest reads a whole protocol v1 git-upload-pack request
+// from rd. WithReuseBuffer is ignored, so that the chunks stay valid.
+func ReadAllUploadRequest(rd io.Reader, opts ...Option) (UploadRequestChunks, error) {
+	return readAll[*UploadRequestChunk](NewUploadRequest(rd, noReuse(opts)...))
+}
+
+// UploadResponseChunks is a whole protocol v1 git-upload-pack response.
+type UploadResponseChunks []*UploadResponseChunk
+
+// WriteTo writes the chunks to w through a single buffer. It returns an
+// InvalidChunkError, before writing it, for a chunk that cannot be encoded.
+func (cs UploadResponseChunks) WriteTo(w io.Writer) (int64, error) {
+	return writeChunks(w, cs)
+}
+
+// ReadAllUploadResponse reads a whole protocol v1 git-upload-pack response
+// from rd, pack included. WithReuseBuffer is ignored, so that the chunks
+// stay valid.
+func ReadAllUploadResponse(rd io.Reader, opts ...Option) (UploadResponseChunks, error) {
+	return readAll[*UploadResponseChunk](NewUploadResponse(rd, noReuse(opts)...))
+}
+
+// ReceiveRequestChunks is a whole protocol v1 git-receive-pack request,
+// without the pack.
+type ReceiveRequestChunks []*ReceiveRequestChunk
+
+// WriteTo writes the chunks to w through a single buffer. It returns an
+// InvalidChunkError, before writing it, for a chunk that cannot be encoded.
+func (cs ReceiveRequestChunks) WriteTo(w io.Writer) (int64, error) {
+	return writeChunks(w, cs)
+}
+
+// ReadAllReceiveRequest reads a whole protocol v1 git-receive-pack request
+// from rd, up to the pack. WithReuseBuffer is ignored, so that the chunks
+// stay valid.
+func ReadAllReceiveRequest(rd io.Reader, opts ...Option) (ReceiveRequestChunks, error) {
+	return readAll[*ReceiveRequestChunk](NewReceiveRequest(rd, noReuse(opts)...))
+}
+
+// ReceiveResponseChunks is a whole protocol v1 git-receive-pack response.
+type ReceiveResponseChunks []*ReceiveResponseChunk
+
+// WriteTo writes the chunks to w through a single buffer. It returns an
+// InvalidChunkError, before writing it, for a chunk that cannot be encoded.
+func (cs ReceiveResponseChunks) WriteTo(w io.Writer) (int64, error) {
+	return writeChunks(w, cs)
+}
+
+// ReadAllReceiveResponse reads a whole protocol v1 git-receive-pack
+// response from rd. WithReuseBuffer is ignored, so that the chunks stay
+// valid.
+func ReadAllReceiveResponse(rd io.Reader, opts ...Option) (ReceiveResponseChunks, error) {
+	return readAll[*ReceiveResponseChunk](NewReceiveResponse(rd, noReuse(opts)...))
+}
+
+// writeChunks encodes cs to w through a bufio.Writer. The count excludes
+// the bytes left in the buffer by a failed write.
+func writeChunks[C Packet](w io.Writer, cs []C) (int64, error) {
+	bw := bufio.NewWriter(w)
+	var n int64
+	for _, c := range cs {
+		b, err := Encode(c)
+		if err == nil {
+			_, err = bw.Write(b)
+		}
+		if err != nil {
+			return n - int64(bw.Buffered()), err
+		}
+		n += int64(len(b))
+	}
+	if err := bw.Flush(); err != nil {
+		return n - int64(bw.Buffered()), err
+	}
+	return n, nil
+}
+
+// readAll returns the chunks of s.
+func readAll[C any](s ChunkScanner[C]) ([]C, error) {
+	var cs []C
+	for s.Scan() {
+		cs = append(cs, s.Chunk())
+	}
+	return cs, s.Err()
+}
+
+// noReuse returns opts disabling WithReuseBuffer.
+func noReuse(opts []Option) []Option {
+	return append(opts[:len(opts):len(opts)], WithReuseBuffer(false))
+}
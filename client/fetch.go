@@ -0,0 +1,337 @@
+// Modified by Giacomo Tartari
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/cycloidio/pkt-line"
+	pktv2 "github.com/cycloidio/pkt-line/v2"
+)
+
+// FetchOptions are the options of Fetch.
+type FetchOptions struct {
+	// Wants are the object IDs to fetch.
+	Wants []string
+	// WantRefs are the refs to fetch, resolved by the server. They need
+	// protocol v2 and the ref-in-want feature.
+	WantRefs []string
+	// Haves are the object IDs the client has, ordered by preference.
+	Haves []string
+	// Shallows are the shallow commits of the client.
+	Shallows []pkt.ObjectID
+	// Depth, if not zero, limits the history to that many commits from
+	// the wants.
+	Depth int
+	// Filter is the filter spec of a partial clone, e.g. "blob:none".
+	Filter string
+	// Agent is sent in the agent capability, e.g. "git/2.40.0".
+	Agent string
+	// Protocol is the protocol version asked for. It defaults to 2.
+	Protocol int
+	// Limits bound the negotiation.
+	Limits pkt.NegotiationLimits
+}
+
+// FetchResult is the result of Fetch. It must be closed once the pack is
+// read.
+type FetchResult struct {
+	// Protocol is the protocol version of the server, 0, 1 or 2.
+	Protocol int
+	// Pack is the pack file, read from the connection.
+	Pack io.Reader
+	// Shallow is the update of the shallow commits of the client, to be
+	// applied once the pack is stored.
+	Shallow ShallowUpdate
+	// WantedRefs are the object IDs of the WantRefs.
+	WantedRefs []pktv2.WantedRef
+
+	conn Conn
+}
+
+// Close closes the connection.
+func (r *FetchResult) Close() error {
+	return r.conn.Close()
+}
+
+// Fetch fetches the pack of the wants from the git-upload-pack of t. It
+// negotiates with protocol v2 when the server has it, sending the haves in
+// rounds until the server is ready, and falls back to protocol v0/v1
+// otherwise, where the haves are sent in a single round.
+func Fetch(ctx context.Context, t Transport, opts FetchOptions) (*FetchResult, error) {
+	if len(opts.Wants) == 0 && len(opts.WantRefs) == 0 {
+		return nil, errors.New("nothing to fetch")
+	}
+	f := &fetcher{opts: opts}
+	if opts.Filter != "" {
+		spec, err := pkt.ParseFilterSpec(opts.Filter)
+		if err != nil {
+			return nil, err
+		}
+		f.filter = &spec
+	}
+	protocol := opts.Protocol
+	if protocol == 0 {
+		protocol = 2
+	}
+	conn, err := t.OpenUploadPack(ctx, protocol)
+	if err != nil {
+		return nil, err
+	}
+	f.conn = conn
+	res := &FetchResult{conn: conn}
+
+	adv := pkt.NewInfoRefsResponse(conn)
+	for adv.Scan() {
+		c := adv.Chunk()
+		switch {
+		case c.ProtocolVersion != 0:
+			res.Protocol = int(c.ProtocolVersion)
+		case res.Protocol == 2:
+			f.caps = append(f.caps, c.Capabilities...)
+		case c.Capabilities != nil:
+			f.caps = c.Capabilities
+		}
+	}
+	if err := adv.Err(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	f.rd = adv.Rest()
+
+	if res.Protocol == 2 {
+		err = f.fetchV2(res)
+	} else {
+		err = f.fetchV1(res)
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return res, nil
+}
+
+// fetcher is the state of Fetch.
+type fetcher struct {
+	opts   FetchOptions
+	filter *pkt.FilterSpec
+	conn   Conn
+	// rd reads the next response, after what the previous parsers
+	// buffered.
+	rd   io.Reader
+	caps pkt.Capabilities
+}
+
+// need checks that the server has the named feature when the option using
+// it is set.
+func need(features pkt.Capabilities, name string, used bool) error {
+	if used && !features.Has(name) {
+		return fmt.Errorf("the server does not support %s", name)
+	}
+	return nil
+}
+
+func (f *fetcher) fetchV2(res *FetchResult) error {
+	v, ok := f.caps.Get("fetch")
+	if !ok {
+		return errors.New("the server does not support the fetch command")
+	}
+	features := pkt.ParseCapabilities(v)
+	if err := errors.Join(
+		need(features, "shallow", f.opts.Depth != 0 || len(f.opts.Shallows) != 0),
+		need(features, "filter", f.filter != nil),
+		need(features, "ref-in-want", len(f.opts.WantRefs) != 0),
+	); err != nil {
+		return err
+	}
+
+	var caps, args []string
+	if a := f.opts.Agent; a != "" && f.caps.Has("agent") {
+		caps = append(caps, "agent="+a)
+	}
+	if of, ok := f.caps.Get("object-format"); ok {
+		caps = append(caps, "object-format="+of)
+	}
+	for _, oid := range f.opts.Wants {
+		args = append(args, "want "+oid)
+	}
+	for _, name := range f.opts.WantRefs {
+		args = append(args, pktv2.WantRefArgument(name))
+	}
+	for _, oid := range f.opts.Shallows {
+		args = append(args, "shallow "+string(oid))
+	}
+	deepen := pkt.Deepen{Depth: f.opts.Depth}
+	args = append(args, deepen.Arguments()...)
+	if f.filter != nil {
+		args = append(args, f.filter.Argument())
+	}
+	args = append(args, "ofs-delta", "no-progress")
+
+	neg := NewNegotiator(f.opts.Haves, f.opts.Limits)
+	var common []string
+	isCommon := map[string]bool{}
+	for {
+		b := pktv2.NewRequestBuilder(f.conn)
+		b.Command("fetch")
+		for _, c := range caps {
+			b.Capability(c)
+		}
+		for _, arg := range args {
+			b.Argument(arg)
+		}
+		for _, oid := range common {
+			b.Argument("have " + oid)
+		}
+		for _, c := range neg.NextRound() {
+			switch {
+			case c.HaveObjectID != "":
+				b.Argument("have " + c.HaveObjectID)
+			case c.NoMoreNegotiation:
+				b.Argument("done")
+			}
+		}
+		// Done flushes the connection, which sends the request.
+		if err := b.Done(); err != nil {
+			return err
+		}
+
+		r := pktv2.NewFetchResponse(f.rd)
+		for r.Scan() {
+			c := r.Chunk()
+			if oid := c.AckObjectID; oid != "" && !isCommon[oid] {
+				isCommon[oid] = true
+				common = append(common, oid)
+			}
+			if c.ShallowObjectID != "" {
+				res.Shallow.Shallow = append(res.Shallow.Shallow, pkt.ObjectID(c.ShallowObjectID))
+			}
+			if c.UnshallowObjectID != "" {
+				res.Shallow.Unshallow = append(res.Shallow.Unshallow, pkt.ObjectID(c.UnshallowObjectID))
+			}
+			if wr, ok := r.WantedRef(); ok {
+				res.WantedRefs = append(res.WantedRefs, wr)
+			}
+			if c.Section == pktv2.SectionPackfile && c.SectionHeader {
+				res.Pack = r.PackReader()
+				return nil
+			}
+		}
+		if err := r.Err(); err != nil {
+			return err
+		}
+		if neg.Done() {
+			return pkt.SyntaxError("the fetch response has no packfile section")
+		}
+		f.rd = r.Rest()
+	}
+}
+
+func (f *fetcher) fetchV1(res *FetchResult) error {
+	if len(f.opts.WantRefs) != 0 {
+		return errors.New("want-ref needs protocol v2")
+	}
+	if err := errors.Join(
+		need(f.caps, "shallow", f.opts.Depth != 0 || len(f.opts.Shallows) != 0),
+		need(f.caps, "filter", f.filter != nil),
+	); err != nil {
+		return err
+	}
+
+	var caps []string
+	switch {
+	case f.caps.Has("side-band-64k"):
+		caps = append(caps, "side-band-64k")
+	case f.caps.Has("side-band"):
+		caps = append(caps, "side-band")
+	}
+	for _, c := range []string{"ofs-delta", "no-progress"} {
+		if f.caps.Has(c) {
+			caps = append(caps, c)
+		}
+	}
+	if f.opts.Depth != 0 || len(f.opts.Shallows) != 0 {
+		caps = append(caps, "shallow")
+	}
+	if f.filter != nil {
+		caps = append(caps, "filter")
+	}
+	if a := f.opts.Agent; a != "" && f.caps.Has("agent") {
+		caps = append(caps, "agent="+a)
+	}
+	if of, ok := f.caps.Get("object-format"); ok {
+		caps = append(caps, "object-format="+of)
+	}
+
+	var chunks []*pkt.UploadRequestChunk
+	for i, oid := range f.opts.Wants {
+		if i == 0 {
+			chunks = append(chunks, pkt.NewWantChunk(oid, caps...))
+		} else {
+			chunks = append(chunks, pkt.NewWantChunk(oid))
+		}
+	}
+	for _, oid := range f.opts.Shallows {
+		chunks = append(chunks, pkt.NewClientShallowChunk(string(oid)))
+	}
+	deepen := pkt.Deepen{Depth: f.opts.Depth}
+	chunks = append(chunks, deepen.Chunks()...)
+	if f.filter != nil {
+		chunks = append(chunks, pkt.NewFilterChunk(f.filter.String()))
+	}
+	chunks = append(chunks, pkt.NewEndOfRoundChunk())
+	haves := f.opts.Haves
+	if max := f.opts.Limits.MaxHaves; max > 0 && len(haves) > max {
+		haves = haves[:max]
+	}
+	for _, oid := range haves {
+		chunks = append(chunks, pkt.NewHaveChunk(oid))
+	}
+	chunks = append(chunks, pkt.NewDoneChunk())
+	// The request has flush packets, so it is written as is and sent at
+	// once.
+	for _, c := range chunks {
+		b, err := pkt.Encode(c)
+		if err != nil {
+			return err
+		}
+		if _, err := f.conn.Write(b); err != nil {
+			return err
+		}
+	}
+	if err := f.conn.Flush(); err != nil {
+		return err
+	}
+
+	// Without multi_ack, the server answers the haves with a single ACK,
+	// for the first common one, or a NAK, and sends the pack.
+	r := pkt.NewUploadResponse(f.rd)
+	for r.Scan() {
+		c := r.Chunk()
+		res.Shallow.Observe(c)
+		if oid, _ := c.Ack(); oid != "" || c.Nak {
+			res.Pack = r.PackReader()
+			return nil
+		}
+	}
+	if err := r.Err(); err != nil {
+		return err
+	}
+	return pkt.SyntaxError("the upload-pack response has no pack")
+}
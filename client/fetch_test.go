@@ -0,0 +1,168 @@
+// Modified by Giacomo Tartari
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+	"testing"
+
+	"github.com/cycloidio/pkt-line"
+	pktv2 "github.com/cycloidio/pkt-line/v2"
+	"github.com/google/go-cmp/cmp"
+)
+
+// git runs git in dir and returns its trimmed output.
+func git(t *testing.T, dir string, args ...string) string {
+	t.Helper()
+	cmd := exec.Command("git", append([]string{"-C", dir}, args...)...)
+	cmd.Env = append(cmd.Environ(),
+		"GIT_AUTHOR_NAME=test", "GIT_AUTHOR_EMAIL=test@example.com",
+		"GIT_COMMITTER_NAME=test", "GIT_COMMITTER_EMAIL=test@example.com",
+		"GIT_CONFIG_NOSYSTEM=1", "HOME="+dir)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %s: %v\n%s", strings.Join(args, " "), err, out)
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// newRepo returns the path of a new repository with a commit for each
+// message on main.
+func newRepo(t *testing.T, msgs ...string) string {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	dir := t.TempDir()
+	git(t, dir, "init", "-q", "-b", "main")
+	for _, m := range msgs {
+		git(t, dir, "commit", "-q", "--allow-empty", "-m", m)
+	}
+	return dir
+}
+
+// packObjects returns the number of objects of the pack read from r.
+func packObjects(t *testing.T, r io.Reader) int {
+	t.Helper()
+	b, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(b) < 12+20 || string(b[:4]) != "PACK" {
+		t.Fatalf("not a pack: %q", b)
+	}
+	return int(binary.BigEndian.Uint32(b[8:12]))
+}
+
+func TestFetch(t *testing.T) {
+	src := newRepo(t, "c1", "c2", "c3")
+	git(t, src, "config", "uploadpack.allowRefInWant", "true")
+	c1, c3 := git(t, src, "rev-parse", "main~2"), git(t, src, "rev-parse", "main")
+	unknown := strings.Repeat("e", 40)
+
+	tests := []struct {
+		name string
+		opts FetchOptions
+		// objects is the number of objects of the pack: the commits and
+		// their empty tree.
+		objects int
+		shallow []pkt.ObjectID
+		err     string
+	}{
+		{
+			name:    "clone",
+			opts:    FetchOptions{Wants: []string{c3}},
+			objects: 4,
+		},
+		{
+			name:    "haves",
+			opts:    FetchOptions{Wants: []string{c3}, Haves: []string{unknown, c1}},
+			objects: 2,
+		},
+		{
+			name:    "depth",
+			opts:    FetchOptions{Wants: []string{c3}, Depth: 1},
+			objects: 2,
+			shallow: []pkt.ObjectID{pkt.ObjectID(c3)},
+		},
+		{
+			name: "nothing",
+			err:  "nothing to fetch",
+		},
+		{
+			name: "filter",
+			opts: FetchOptions{Wants: []string{c3}, Filter: "blob:none"},
+			err:  "the server does not support filter",
+		},
+	}
+	for _, protocol := range []int{1, 2} {
+		for _, tt := range tests {
+			t.Run(fmt.Sprintf("%s/v%d", tt.name, protocol), func(t *testing.T) {
+				opts := tt.opts
+				opts.Protocol = protocol
+				res, err := Fetch(context.Background(), &ExecTransport{Path: src}, opts)
+				if tt.err != "" {
+					if err == nil || err.Error() != tt.err {
+						t.Fatalf("got error %v, want %q", err, tt.err)
+					}
+					return
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer res.Close()
+				if res.Protocol != protocol {
+					t.Errorf("got protocol %d, want %d", res.Protocol, protocol)
+				}
+				if n := packObjects(t, res.Pack); n != tt.objects {
+					t.Errorf("got %d objects, want %d", n, tt.objects)
+				}
+				if diff := cmp.Diff(tt.shallow, res.Shallow.Shallow); diff != "" {
+					t.Errorf("shallow mismatch (-want +got):\n%s", diff)
+				}
+			})
+		}
+	}
+}
+
+func TestFetch_wantRefs(t *testing.T) {
+	src := newRepo(t, "c1", "c2")
+	git(t, src, "config", "uploadpack.allowRefInWant", "true")
+	c2 := git(t, src, "rev-parse", "main")
+
+	res, err := Fetch(context.Background(), &ExecTransport{Path: src}, FetchOptions{WantRefs: []string{"refs/heads/main"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Close()
+	want := []pktv2.WantedRef{{Name: "refs/heads/main", ObjectID: pkt.ObjectID(c2)}}
+	if diff := cmp.Diff(want, res.WantedRefs); diff != "" {
+		t.Errorf("wanted refs mismatch (-want +got):\n%s", diff)
+	}
+	if n := packObjects(t, res.Pack); n != 3 {
+		t.Errorf("got %d objects, want 3", n)
+	}
+
+	_, err = Fetch(context.Background(), &ExecTransport{Path: src}, FetchOptions{WantRefs: []string{"refs/heads/main"}, Protocol: 1})
+	if err == nil || err.Error() != "want-ref needs protocol v2" {
+		t.Errorf("got error %v with protocol 1, want %q", err, "want-ref needs protocol v2")
+	}
+}
@@ -0,0 +1,262 @@
+// Modified by Giacomo Tartari
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package client provides higher-level helpers for talking to Git servers.
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"crypto/sha256"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/cycloidio/pkt-line"
+)
+
+// RefUpdate is a single ref update command of a push.
+type RefUpdate struct {
+	RefName     string
+	OldObjectID string
+	NewObjectID string
+}
+
+// IsCreate returns true if the command creates the ref.
+func (u RefUpdate) IsCreate() bool {
+	return pkt.ObjectID(u.OldObjectID).IsZero()
+}
+
+// IsDelete returns true if the command deletes the ref.
+func (u RefUpdate) IsDelete() bool {
+	return pkt.ObjectID(u.NewObjectID).IsZero()
+}
+
+// MirrorPlan is the work needed to make a destination repository mirror a
+// source repository.
+type MirrorPlan struct {
+	// Wants are the object IDs to fetch from the source.
+	Wants []string
+	// Haves are the object IDs the destination already has. They are sent
+	// to the source to reduce the size of the fetched pack.
+	Haves []string
+	// Updates are the ref update commands to send to the destination.
+	Updates []RefUpdate
+}
+
+// PlanMirror compares the ref listings of src and dst, both mapping a ref
+// name to an object ID like Refs.Map, and returns the fetch and push plans that make dst
+// a mirror of src. Refs that exist only in dst are deleted when prune is
+// true. Peeled entries ("refs/tags/v1^{}") are ignored.
+func PlanMirror(src, dst map[string]pkt.ObjectID, prune bool) *MirrorPlan {
+	p := &MirrorPlan{}
+	have := map[pkt.ObjectID]bool{}
+	for name, oid := range dst {
+		if isPeeled(name) || have[oid] {
+			continue
+		}
+		have[oid] = true
+		p.Haves = append(p.Haves, string(oid))
+	}
+	wanted := map[pkt.ObjectID]bool{}
+	for name, oid := range src {
+		if isPeeled(name) {
+			continue
+		}
+		old, ok := dst[name]
+		if ok && old == oid {
+			continue
+		}
+		if !ok {
+			old = pkt.ObjectFormatOf(oid).ZeroID()
+		}
+		p.Updates = append(p.Updates, RefUpdate{
+			RefName:     name,
+			OldObjectID: string(old),
+			NewObjectID: string(oid),
+		})
+		if !have[oid] && !wanted[oid] {
+			wanted[oid] = true
+			p.Wants = append(p.Wants, string(oid))
+		}
+	}
+	if prune {
+		for name, oid := range dst {
+			if isPeeled(name) {
+				continue
+			}
+			if _, ok := src[name]; ok {
+				continue
+			}
+			p.Updates = append(p.Updates, RefUpdate{
+				RefName:     name,
+				OldObjectID: string(oid),
+				NewObjectID: string(pkt.ObjectFormatOf(oid).ZeroID()),
+			})
+		}
+	}
+	sort.Strings(p.Wants)
+	sort.Strings(p.Haves)
+	sort.Slice(p.Updates, func(i, j int) bool {
+		return p.Updates[i].RefName < p.Updates[j].RefName
+	})
+	return p
+}
+
+// UpToDate returns true if dst already mirrors src.
+func (p *MirrorPlan) UpToDate() bool {
+	return len(p.Updates) == 0
+}
+
+// UploadRequestChunks returns the protocol v1 git-upload-pack request that
+// fetches the wanted objects from the source. The capabilities are sent with
+// the first want. It returns nil if nothing needs to be fetched.
+func (p *MirrorPlan) UploadRequestChunks(caps []string) []*pkt.UploadRequestChunk {
+	if len(p.Wants) == 0 {
+		return nil
+	}
+	var chunks []*pkt.UploadRequestChunk
+	for i, oid := range p.Wants {
+		c := pkt.NewWantChunk(oid)
+		if i == 0 {
+			c.Capabilities = caps
+		}
+		chunks = append(chunks, c)
+	}
+	chunks = append(chunks, pkt.NewEndOfRoundChunk())
+	for _, oid := range p.Haves {
+		chunks = append(chunks, pkt.NewHaveChunk(oid))
+	}
+	chunks = append(chunks, pkt.NewDoneChunk())
+	return chunks
+}
+
+// ReceiveRequestChunks returns the ref update commands of the protocol v1
+// git-receive-pack request that updates the destination. The capabilities
+// are sent with the first command. The pack must be written after these
+// chunks. It returns nil if the destination is up to date.
+func (p *MirrorPlan) ReceiveRequestChunks(caps []string) []*pkt.ReceiveRequestChunk {
+	if len(p.Updates) == 0 {
+		return nil
+	}
+	var chunks []*pkt.ReceiveRequestChunk
+	for i, u := range p.Updates {
+		c := pkt.NewCommandChunk(u.OldObjectID, u.NewObjectID, u.RefName)
+		if i == 0 {
+			c.Capabilities = caps
+		}
+		chunks = append(chunks, c)
+	}
+	chunks = append(chunks, pkt.NewEndOfCommandsChunk())
+	return chunks
+}
+
+// MirrorOptions are the options of Mirror.
+type MirrorOptions struct {
+	// Prune deletes the refs of the destination that the source does not
+	// have.
+	Prune bool
+	// Fetch are the options of the fetch from the source. The wants and
+	// the haves are set by the plan.
+	Fetch FetchOptions
+	// Push are the options of the push to the destination.
+	Push PushOptions
+}
+
+// MirrorResult is the result of Mirror.
+type MirrorResult struct {
+	Plan *MirrorPlan
+	// Push is the status of the push, nil if the destination was up to
+	// date.
+	Push *PushResult
+}
+
+// Mirror makes the repository of dst a mirror of the repository of src: it
+// lists the refs of both, plans the updates with PlanMirror, fetches the
+// missing objects from src and pushes them with the ref updates to dst. The
+// pack is streamed from one connection to the other. Only the refs under
+// "refs/" are mirrored, as HEAD cannot be pushed.
+func Mirror(ctx context.Context, src, dst Transport, opts MirrorOptions) (*MirrorResult, error) {
+	srcRefs, _, err := listRefs(ctx, src.OpenUploadPack)
+	if err != nil {
+		return nil, err
+	}
+	dstRefs, dstCaps, err := listRefs(ctx, dst.OpenReceivePack)
+	if err != nil {
+		return nil, err
+	}
+	plan := PlanMirror(srcRefs.WithPrefix("refs/").Map(), dstRefs.WithPrefix("refs/").Map(), opts.Prune)
+	res := &MirrorResult{Plan: plan}
+	if plan.UpToDate() {
+		return res, nil
+	}
+
+	var pack io.Reader
+	if len(plan.Wants) != 0 {
+		fopts := opts.Fetch
+		fopts.Wants = plan.Wants
+		fopts.Haves = plan.Haves
+		fr, err := Fetch(ctx, src, fopts)
+		if err != nil {
+			return nil, err
+		}
+		defer fr.Close()
+		pack = fr.Pack
+	} else {
+		// The destination has the objects already, but a push that is
+		// not only deletions still carries a pack.
+		pack = bytes.NewReader(emptyPack(dstCaps.ObjectFormat()))
+	}
+	res.Push, err = Push(ctx, dst, plan.Updates, pack, opts.Push)
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// listRefs reads the ref advertisement of the connection opened by open
+// with protocol v0, and ends the session with a flush packet, as a client
+// without anything to fetch or push does.
+func listRefs(ctx context.Context, open func(context.Context, int) (Conn, error)) (pkt.Refs, pkt.Capabilities, error) {
+	conn, err := open(ctx, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer conn.Close()
+	refs, caps, err := pkt.ReadInfoRefs(pkt.NewInfoRefsResponse(conn))
+	if err != nil {
+		return nil, nil, err
+	}
+	if _, err := conn.Write(pkt.FlushPacket{}.EncodeToPktLine()); err != nil {
+		return nil, nil, err
+	}
+	return refs, caps, nil
+}
+
+// emptyPack returns a pack file without objects.
+func emptyPack(f pkt.ObjectFormat) []byte {
+	p := []byte("PACK\x00\x00\x00\x02\x00\x00\x00\x00")
+	if f == pkt.SHA256 {
+		sum := sha256.Sum256(p)
+		return append(p, sum[:]...)
+	}
+	sum := sha1.Sum(p)
+	return append(p, sum[:]...)
+}
+
+func isPeeled(name string) bool {
+	return strings.HasSuffix(name, "^{}")
+}
@@ -0,0 +1,161 @@
+// Modified by Giacomo Tartari
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/cycloidio/pkt-line"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestPlanMirror(t *testing.T) {
+	a := pkt.ObjectID(strings.Repeat("a", 40))
+	b := pkt.ObjectID(strings.Repeat("b", 40))
+	c := pkt.ObjectID(strings.Repeat("c", 40))
+	zero := strings.Repeat("0", 40)
+	for _, tc := range []struct {
+		name     string
+		src, dst map[string]pkt.ObjectID
+		prune    bool
+		want     *MirrorPlan
+	}{
+		{
+			name: "up to date",
+			src:  map[string]pkt.ObjectID{"refs/heads/main": a},
+			dst:  map[string]pkt.ObjectID{"refs/heads/main": a},
+			want: &MirrorPlan{Haves: []string{string(a)}},
+		},
+		{
+			name: "create and update",
+			src:  map[string]pkt.ObjectID{"refs/heads/main": b, "refs/tags/v1": c, "refs/tags/v1^{}": a},
+			dst:  map[string]pkt.ObjectID{"refs/heads/main": a},
+			want: &MirrorPlan{
+				Wants: []string{string(b), string(c)},
+				Haves: []string{string(a)},
+				Updates: []RefUpdate{
+					{RefName: "refs/heads/main", OldObjectID: string(a), NewObjectID: string(b)},
+					{RefName: "refs/tags/v1", OldObjectID: zero, NewObjectID: string(c)},
+				},
+			},
+		},
+		{
+			name: "object already in the destination",
+			src:  map[string]pkt.ObjectID{"refs/heads/main": a, "refs/heads/topic": a},
+			dst:  map[string]pkt.ObjectID{"refs/heads/main": a},
+			want: &MirrorPlan{
+				Haves:   []string{string(a)},
+				Updates: []RefUpdate{{RefName: "refs/heads/topic", OldObjectID: zero, NewObjectID: string(a)}},
+			},
+		},
+		{
+			name: "kept without prune",
+			src:  map[string]pkt.ObjectID{},
+			dst:  map[string]pkt.ObjectID{"refs/heads/old": a},
+			want: &MirrorPlan{Haves: []string{string(a)}},
+		},
+		{
+			name:  "pruned",
+			src:   map[string]pkt.ObjectID{},
+			dst:   map[string]pkt.ObjectID{"refs/heads/old": a},
+			prune: true,
+			want: &MirrorPlan{
+				Haves:   []string{string(a)},
+				Updates: []RefUpdate{{RefName: "refs/heads/old", OldObjectID: string(a), NewObjectID: zero}},
+			},
+		},
+		{
+			name: "sha256",
+			src:  map[string]pkt.ObjectID{"refs/heads/main": pkt.ObjectID(strings.Repeat("a", 64))},
+			dst:  map[string]pkt.ObjectID{},
+			want: &MirrorPlan{
+				Wants:   []string{strings.Repeat("a", 64)},
+				Updates: []RefUpdate{{RefName: "refs/heads/main", OldObjectID: strings.Repeat("0", 64), NewObjectID: strings.Repeat("a", 64)}},
+			},
+		},
+	} {
+		got := PlanMirror(tc.src, tc.dst, tc.prune)
+		if diff := cmp.Diff(tc.want, got); diff != "" {
+			t.Errorf("%s: plan differs (-want +got):\n%s", tc.name, diff)
+		}
+		if got.UpToDate() != (len(tc.want.Updates) == 0) {
+			t.Errorf("%s: UpToDate() = %v", tc.name, got.UpToDate())
+		}
+		for _, u := range got.Updates {
+			if _, ok := tc.dst[u.RefName]; u.IsCreate() == ok {
+				t.Errorf("%s: IsCreate() = %v for %s", tc.name, u.IsCreate(), u.RefName)
+			}
+			if _, ok := tc.src[u.RefName]; u.IsDelete() == ok {
+				t.Errorf("%s: IsDelete() = %v for %s", tc.name, u.IsDelete(), u.RefName)
+			}
+		}
+	}
+}
+
+func TestMirror(t *testing.T) {
+	srcDir := newRepo(t, "one", "two")
+	git(t, srcDir, "tag", "-a", "-m", "v1", "v1")
+	git(t, srcDir, "branch", "topic", "HEAD~1")
+	dstDir := newBareRepo(t)
+	git(t, dstDir, "fetch", "-q", srcDir, "main:refs/heads/stale")
+
+	src := &ExecTransport{Path: srcDir}
+	dst := &ExecTransport{Path: dstDir}
+	refs := func(dir string) string {
+		return git(t, dir, "for-each-ref", "--format=%(objectname) %(refname)", "refs/")
+	}
+	ctx := context.Background()
+
+	for _, step := range []struct {
+		name    string
+		prepare func()
+		prune   bool
+		wantUp  bool
+	}{
+		{name: "initial", prune: true},
+		{name: "up to date", wantUp: true},
+		{
+			// The new target of topic is in the destination already, so the
+			// push carries an empty pack.
+			name:    "ref moved to a known commit",
+			prepare: func() { git(t, srcDir, "branch", "-f", "topic", "main") },
+		},
+		{
+			name:    "new commit",
+			prepare: func() { git(t, srcDir, "commit", "-q", "--allow-empty", "-m", "three") },
+		},
+	} {
+		if step.prepare != nil {
+			step.prepare()
+		}
+		res, err := Mirror(ctx, src, dst, MirrorOptions{Prune: step.prune})
+		if err != nil {
+			t.Fatalf("%s: %v", step.name, err)
+		}
+		if res.Plan.UpToDate() != step.wantUp {
+			t.Errorf("%s: UpToDate() = %v, want %v", step.name, res.Plan.UpToDate(), step.wantUp)
+		}
+		if res.Push != nil && !res.Push.OK() {
+			t.Errorf("%s: push failed: %+v", step.name, res.Push)
+		}
+		if got, want := refs(dstDir), refs(srcDir); got != want {
+			t.Errorf("%s: destination refs:\n%s\nwant:\n%s", step.name, got, want)
+		}
+	}
+	git(t, dstDir, "fsck", "--no-progress")
+}
@@ -0,0 +1,79 @@
+// Modified by Giacomo Tartari
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import "github.com/cycloidio/pkt-line"
+
+// defaultRoundSize is the number of haves sent per round, as git does
+// initially.
+const defaultRoundSize = 32
+
+// Negotiator splits the haves of a protocol v1 fetch into rounds, giving up
+// with "done" when the haves are exhausted or the limits are reached.
+type Negotiator struct {
+	// RoundSize is the number of haves per round. It defaults to 32.
+	RoundSize int
+
+	tracker pkt.NegotiationTracker
+	haves   []string
+	done    bool
+}
+
+// NewNegotiator returns a Negotiator sending haves, ordered by preference,
+// within limits.
+func NewNegotiator(haves []string, limits pkt.NegotiationLimits) *Negotiator {
+	return &Negotiator{
+		tracker: pkt.NegotiationTracker{Limits: limits},
+		haves:   haves,
+	}
+}
+
+// Done reports whether the last round has been returned.
+func (n *Negotiator) Done() bool {
+	return n.done
+}
+
+// NextRound returns the chunks of the next round: the haves followed by a
+// flush, or by "done" for the last round. It returns nil after the last
+// round. A round with a flush must be followed by reading the server
+// acknowledgements.
+func (n *Negotiator) NextRound() []*pkt.UploadRequestChunk {
+	if n.done {
+		return nil
+	}
+	size := n.RoundSize
+	if size <= 0 {
+		size = defaultRoundSize
+	}
+	if max := n.tracker.Limits.MaxHaves; max > 0 {
+		size = min(size, max-n.tracker.Haves)
+	}
+	size = min(size, len(n.haves))
+
+	var chunks []*pkt.UploadRequestChunk
+	for _, h := range n.haves[:size] {
+		chunks = append(chunks, pkt.NewHaveChunk(h))
+	}
+	n.haves = n.haves[size:]
+	_ = n.tracker.AddHaves(size)
+	_ = n.tracker.AddRound()
+
+	if len(n.haves) == 0 || n.tracker.Exhausted() {
+		n.done = true
+		return append(chunks, pkt.NewDoneChunk())
+	}
+	return append(chunks, pkt.NewEndOfRoundChunk())
+}
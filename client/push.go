@@ -0,0 +1,235 @@
+// Modified by Giacomo Tartari
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"context"
+	"errors"
+	"io"
+
+	"github.com/cycloidio/pkt-line"
+)
+
+// PushOptions are the options of Push.
+type PushOptions struct {
+	// PushOptions are sent to the hooks of the server, which must
+	// advertise push-options.
+	PushOptions []string
+	// Atomic makes the server update all the refs or none.
+	Atomic bool
+	// Agent is sent in the agent capability, e.g. "git/2.40.0".
+	Agent string
+	// Sign, if set, makes a signed push: the commands are sent in a push
+	// certificate whose payload is signed by Sign, e.g. with
+	// "gpg --detach-sign --armor". The server must advertise push-cert.
+	Sign func(payload []byte) ([]byte, error)
+	// Pusher and Pushee are the fields of the push certificate.
+	Pusher string
+	Pushee string
+	// Progress receives the progress messages of the server. It may be
+	// nil.
+	Progress func([]byte)
+}
+
+// RefResult is the result of a ref update command of a push.
+type RefResult struct {
+	RefName string
+	// Error is the reason of the server for rejecting the update, empty
+	// if the ref was updated.
+	Error string
+	// Options are the options of report-status-v2, e.g. "new-oid" when a
+	// hook changed the update. The value of "forced-update" is empty.
+	Options map[string]string
+}
+
+// OK reports whether the ref was updated.
+func (r RefResult) OK() bool {
+	return r.Error == ""
+}
+
+// PushResult is the result of Push.
+type PushResult struct {
+	// Unpack is the status of the pack, "ok" or an error message. It is
+	// empty, like Refs, if the server does not report the status.
+	Unpack string
+	Refs   []RefResult
+}
+
+// OK reports whether the pack was stored and all the refs updated.
+func (r *PushResult) OK() bool {
+	if r.Unpack != "" && r.Unpack != "ok" {
+		return false
+	}
+	for _, ref := range r.Refs {
+		if !ref.OK() {
+			return false
+		}
+	}
+	return true
+}
+
+// Push sends the ref updates and the pack to the git-receive-pack of t, and
+// returns the status reported by the server. The pack is not sent when all
+// the updates are deletions, and may be nil then.
+func Push(ctx context.Context, t Transport, updates []RefUpdate, pack io.Reader, opts PushOptions) (*PushResult, error) {
+	if len(updates) == 0 {
+		return nil, errors.New("nothing to push")
+	}
+	conn, err := t.OpenReceivePack(ctx, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	adv := pkt.NewInfoRefsResponse(conn)
+	var advCaps pkt.Capabilities
+	for adv.Scan() {
+		if c := adv.Chunk(); c.Capabilities != nil {
+			advCaps = c.Capabilities
+		}
+	}
+	if err := adv.Err(); err != nil {
+		return nil, err
+	}
+
+	deleteOnly := true
+	for _, u := range updates {
+		if !u.IsDelete() {
+			deleteOnly = false
+		} else if !advCaps.Has("delete-refs") {
+			return nil, errors.New("the server does not support delete-refs")
+		}
+	}
+	if err := errors.Join(
+		need(advCaps, "push-options", len(opts.PushOptions) != 0),
+		need(advCaps, "atomic", opts.Atomic),
+		need(advCaps, "push-cert", opts.Sign != nil),
+	); err != nil {
+		return nil, err
+	}
+
+	var caps []string
+	report := true
+	switch {
+	case advCaps.Has("report-status-v2"):
+		caps = append(caps, "report-status-v2")
+	case advCaps.Has("report-status"):
+		caps = append(caps, "report-status")
+	default:
+		report = false
+	}
+	sideBand := report && advCaps.Has("side-band-64k")
+	if sideBand {
+		caps = append(caps, "side-band-64k")
+	}
+	if opts.Atomic {
+		caps = append(caps, "atomic")
+	}
+	if len(opts.PushOptions) != 0 {
+		caps = append(caps, "push-options")
+	}
+	if a := opts.Agent; a != "" && advCaps.Has("agent") {
+		caps = append(caps, "agent="+a)
+	}
+	if of, ok := advCaps.Get("object-format"); ok {
+		caps = append(caps, "object-format="+of)
+	}
+
+	var chunks []*pkt.ReceiveRequestChunk
+	if opts.Sign != nil {
+		nonce, _ := advCaps.Get("push-cert")
+		cert := &pkt.PushCert{
+			Pusher:      opts.Pusher,
+			Pushee:      opts.Pushee,
+			Nonce:       nonce,
+			PushOptions: opts.PushOptions,
+		}
+		for _, u := range updates {
+			cert.Commands = append(cert.Commands, pkt.PushCertCommand{
+				OldObjectID: u.OldObjectID,
+				NewObjectID: u.NewObjectID,
+				RefName:     u.RefName,
+			})
+		}
+		if err := cert.Sign(opts.Sign); err != nil {
+			return nil, err
+		}
+		chunks = cert.Chunks(caps)
+	} else {
+		for i, u := range updates {
+			c := pkt.NewCommandChunk(u.OldObjectID, u.NewObjectID, u.RefName)
+			if i == 0 {
+				c.Capabilities = caps
+			}
+			chunks = append(chunks, c)
+		}
+	}
+	chunks = append(chunks, pkt.NewEndOfCommandsChunk())
+	if len(opts.PushOptions) != 0 {
+		chunks = append(chunks, pkt.NewPushOptionsChunks(opts.PushOptions...)...)
+	}
+	for _, c := range chunks {
+		b, err := pkt.Encode(c)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := conn.Write(b); err != nil {
+			return nil, err
+		}
+	}
+	if !deleteOnly {
+		if pack == nil {
+			return nil, errors.New("the updates need a pack")
+		}
+		if _, err := io.Copy(conn, pack); err != nil {
+			return nil, err
+		}
+	}
+	if err := conn.Flush(); err != nil {
+		return nil, err
+	}
+
+	res := &PushResult{}
+	if !report {
+		return res, nil
+	}
+	rd := adv.Rest()
+	if sideBand {
+		d := pkt.NewSideBandDemuxer(pkt.NewPacketScanner(rd))
+		d.Progress = opts.Progress
+		rd = d
+	}
+	r := pkt.NewReceiveResponse(rd)
+	for r.Scan() {
+		c := r.Chunk()
+		switch {
+		case c.UnpackStatus != "":
+			res.Unpack = c.UnpackStatus
+		case c.RefName != "":
+			res.Refs = append(res.Refs, RefResult{RefName: c.RefName, Error: c.RefUpdateFailMessage})
+		case c.RefOption != "" && len(res.Refs) != 0:
+			ref := &res.Refs[len(res.Refs)-1]
+			if ref.Options == nil {
+				ref.Options = map[string]string{}
+			}
+			ref.Options[c.RefOption] = c.RefOptionValue
+		}
+	}
+	if err := r.Err(); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
@@ -0,0 +1,167 @@
+// Modified by Giacomo Tartari
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+// newBareRepo returns the path of a new bare repository.
+func newBareRepo(t *testing.T) string {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	dir := t.TempDir()
+	git(t, dir, "init", "-q", "--bare", "-b", "main")
+	return dir
+}
+
+// packOf returns the pack of git pack-objects --revs for revs in dir.
+func packOf(t *testing.T, dir string, revs ...string) []byte {
+	t.Helper()
+	cmd := exec.Command("git", "-C", dir, "pack-objects", "--revs", "--stdout", "-q")
+	cmd.Stdin = strings.NewReader(strings.Join(revs, "\n") + "\n")
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("git pack-objects: %v", err)
+	}
+	return out
+}
+
+func TestPush(t *testing.T) {
+	src := newRepo(t, "c1", "c2", "c3")
+	dst := newBareRepo(t)
+	c1, c2, c3 := git(t, src, "rev-parse", "main~2"), git(t, src, "rev-parse", "main~1"), git(t, src, "rev-parse", "main")
+	zero := strings.Repeat("0", 40)
+
+	steps := []struct {
+		name    string
+		config  []string
+		updates []RefUpdate
+		pack    []byte
+		opts    PushOptions
+		// rejected are the refs the server refuses to update.
+		rejected []string
+		err      string
+		// refs are the refs of dst after the push.
+		refs string
+	}{
+		{
+			name:    "create",
+			updates: []RefUpdate{{"refs/heads/main", zero, c2}},
+			pack:    packOf(t, src, c2),
+			refs:    c2 + " refs/heads/main",
+		},
+		{
+			name: "update and create",
+			updates: []RefUpdate{
+				{"refs/heads/main", c2, c3},
+				{"refs/heads/topic", zero, c1},
+			},
+			pack: packOf(t, src, c3, "^"+c2),
+			refs: c3 + " refs/heads/main\n" + c1 + " refs/heads/topic",
+		},
+		{
+			name: "atomic with a stale update",
+			updates: []RefUpdate{
+				{"refs/heads/main", c1, c2},
+				{"refs/heads/topic", c1, zero},
+			},
+			pack:     packOf(t, src, c2, "^"+c3),
+			opts:     PushOptions{Atomic: true},
+			rejected: []string{"refs/heads/main", "refs/heads/topic"},
+			refs:     c3 + " refs/heads/main\n" + c1 + " refs/heads/topic",
+		},
+		{
+			name:    "delete",
+			updates: []RefUpdate{{"refs/heads/topic", c1, zero}},
+			refs:    c3 + " refs/heads/main",
+		},
+		{
+			name:    "push options not advertised",
+			updates: []RefUpdate{{"refs/heads/main", c3, c2}},
+			pack:    packOf(t, src, c2, "^"+c3),
+			opts:    PushOptions{PushOptions: []string{"ci.skip"}},
+			err:     "the server does not support push-options",
+			refs:    c3 + " refs/heads/main",
+		},
+		{
+			name:    "push options",
+			config:  []string{"receive.advertisePushOptions", "true"},
+			updates: []RefUpdate{{"refs/heads/main", c3, c2}},
+			pack:    packOf(t, src, c2, "^"+c3),
+			opts:    PushOptions{PushOptions: []string{"ci.skip"}, Agent: "test/1.0"},
+			refs:    c2 + " refs/heads/main",
+		},
+		{
+			name:    "missing pack",
+			updates: []RefUpdate{{"refs/heads/main", c2, c3}},
+			err:     "the updates need a pack",
+			refs:    c2 + " refs/heads/main",
+		},
+		{
+			name: "nothing",
+			err:  "nothing to push",
+			refs: c2 + " refs/heads/main",
+		},
+	}
+	for _, step := range steps {
+		if step.config != nil {
+			git(t, dst, append([]string{"config"}, step.config...)...)
+		}
+		var pack io.Reader
+		if step.pack != nil {
+			pack = bytes.NewReader(step.pack)
+		}
+		res, err := Push(context.Background(), &ExecTransport{Path: dst}, step.updates, pack, step.opts)
+		switch {
+		case step.err != "":
+			if err == nil || err.Error() != step.err {
+				t.Errorf("%s: got error %v, want %q", step.name, err, step.err)
+			}
+		case err != nil:
+			t.Errorf("%s: %v", step.name, err)
+		default:
+			if res.Unpack != "ok" {
+				t.Errorf("%s: got unpack status %q", step.name, res.Unpack)
+			}
+			var rejected []string
+			for _, r := range res.Refs {
+				if !r.OK() {
+					rejected = append(rejected, r.RefName)
+				}
+			}
+			if strings.Join(rejected, " ") != strings.Join(step.rejected, " ") {
+				t.Errorf("%s: got rejected refs %v, want %v", step.name, rejected, step.rejected)
+			}
+			if res.OK() != (len(step.rejected) == 0) {
+				t.Errorf("%s: OK() = %v", step.name, res.OK())
+			}
+			if len(res.Refs) != len(step.updates) {
+				t.Errorf("%s: got %d ref results, want %d", step.name, len(res.Refs), len(step.updates))
+			}
+		}
+		if got := git(t, dst, "for-each-ref", "--format=%(objectname) %(refname)"); got != step.refs {
+			t.Errorf("%s: got refs\n%s\nwant\n%s", step.name, got, step.refs)
+		}
+	}
+}
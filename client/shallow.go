@@ -0,0 +1,143 @@
+// Modified by Giacomo Tartari
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/cycloidio/pkt-line"
+)
+
+// ReadShallowFile reads the shallow commits listed in a .git/shallow file.
+// A missing file means that the repository is not shallow.
+func ReadShallowFile(path string) ([]pkt.ObjectID, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var oids []pkt.ObjectID
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		if line := strings.TrimSpace(sc.Text()); line != "" {
+			oids = append(oids, pkt.ObjectID(line))
+		}
+	}
+	return oids, sc.Err()
+}
+
+// WriteShallowFile replaces the .git/shallow file with oids, sorted. Like
+// git, it takes the lock file path + ".lock" and renames it over path, so
+// that readers never see a partial file, and it removes the file when oids
+// is empty.
+func WriteShallowFile(path string, oids []pkt.ObjectID) error {
+	return updateShallowFile(path, func([]pkt.ObjectID) []pkt.ObjectID { return oids })
+}
+
+// updateShallowFile replaces the shallow commits of path by the result of
+// update, holding the lock while the file is read.
+func updateShallowFile(path string, update func([]pkt.ObjectID) []pkt.ObjectID) error {
+	lock := path + ".lock"
+	f, err := os.OpenFile(lock, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return fmt.Errorf("cannot lock %s: %w", path, err)
+	}
+	defer os.Remove(lock)
+
+	current, err := ReadShallowFile(path)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	oids := update(current)
+	if len(oids) == 0 {
+		f.Close()
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+
+	sorted := slices.Clone(oids)
+	slices.Sort(sorted)
+	w := bufio.NewWriter(f)
+	for _, oid := range slices.Compact(sorted) {
+		w.WriteString(string(oid) + "\n")
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(lock, path)
+}
+
+// ShallowUpdate collects the shallow and unshallow lines of a fetch
+// response.
+type ShallowUpdate struct {
+	Shallow   []pkt.ObjectID
+	Unshallow []pkt.ObjectID
+}
+
+// Observe records the chunk if it is a shallow or unshallow line.
+func (u *ShallowUpdate) Observe(c *pkt.UploadResponseChunk) {
+	if oid := c.Shallow(); oid != "" {
+		u.Shallow = append(u.Shallow, pkt.ObjectID(oid))
+	}
+	if oid := c.Unshallow(); oid != "" {
+		u.Unshallow = append(u.Unshallow, pkt.ObjectID(oid))
+	}
+}
+
+// Empty reports whether the update changes nothing.
+func (u *ShallowUpdate) Empty() bool {
+	return len(u.Shallow) == 0 && len(u.Unshallow) == 0
+}
+
+// Apply returns the shallow commits after the update of current.
+func (u *ShallowUpdate) Apply(current []pkt.ObjectID) []pkt.ObjectID {
+	ret := slices.DeleteFunc(slices.Clone(current), func(oid pkt.ObjectID) bool {
+		return slices.Contains(u.Unshallow, oid)
+	})
+	ret = append(ret, u.Shallow...)
+	slices.Sort(ret)
+	return slices.Compact(ret)
+}
+
+// ApplyToFile applies the update to a .git/shallow file. It should be called
+// once the pack is stored, so that the repository never lists shallow
+// commits it does not have.
+func (u *ShallowUpdate) ApplyToFile(path string) error {
+	if u.Empty() {
+		return nil
+	}
+	return updateShallowFile(path, u.Apply)
+}
@@ -0,0 +1,294 @@
+// Modified by Giacomo Tartari
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"os/exec"
+
+	"github.com/cycloidio/pkt-line"
+	pkthttp "github.com/cycloidio/pkt-line/http"
+	"github.com/cycloidio/pkt-line/ssh"
+)
+
+// Transport opens connections to the services of a Git repository.
+type Transport interface {
+	// OpenUploadPack starts git-upload-pack, asking for the protocol
+	// version protocol. The server may answer with a lower version.
+	OpenUploadPack(ctx context.Context, protocol int) (Conn, error)
+	// OpenReceivePack starts git-receive-pack, asking for the protocol
+	// version protocol.
+	OpenReceivePack(ctx context.Context, protocol int) (Conn, error)
+}
+
+// Conn is a connection to a Git service. Reads return the advertisement of
+// the server, then its responses, and writes are the requests. Flush ends a
+// request: over a stateless transport, like smart HTTP, it sends what was
+// written as a new request, and the reads that follow return its response.
+type Conn interface {
+	io.Reader
+	io.Writer
+	// Flush sends the request written so far.
+	Flush() error
+	io.Closer
+}
+
+// HTTPTransport is the smart HTTP transport. Each request is buffered and
+// posted on Flush.
+type HTTPTransport struct {
+	// Client performs the requests. Its Protocol is replaced by the
+	// protocol asked for.
+	Client pkthttp.Client
+}
+
+// OpenUploadPack gets the advertisement of git-upload-pack.
+func (t *HTTPTransport) OpenUploadPack(ctx context.Context, protocol int) (Conn, error) {
+	return t.open(ctx, pkthttp.UploadPack, protocol)
+}
+
+// OpenReceivePack gets the advertisement of git-receive-pack.
+func (t *HTTPTransport) OpenReceivePack(ctx context.Context, protocol int) (Conn, error) {
+	return t.open(ctx, pkthttp.ReceivePack, protocol)
+}
+
+func (t *HTTPTransport) open(ctx context.Context, service string, protocol int) (Conn, error) {
+	c := t.Client
+	c.Protocol = protocol
+	resp, err := c.InfoRefs(ctx, service)
+	if err != nil {
+		return nil, err
+	}
+	return &httpConn{ctx: ctx, client: &c, service: service, resp: resp, rd: resp.Rest()}, nil
+}
+
+// httpConn reads the current response, the advertisement and then the
+// response of the last request.
+type httpConn struct {
+	ctx     context.Context
+	client  *pkthttp.Client
+	service string
+	resp    *pkthttp.Response
+	rd      io.Reader
+	req     bytes.Buffer
+}
+
+func (c *httpConn) Read(b []byte) (int, error) {
+	return c.rd.Read(b)
+}
+
+func (c *httpConn) Write(b []byte) (int, error) {
+	return c.req.Write(b)
+}
+
+func (c *httpConn) Flush() error {
+	if c.req.Len() == 0 {
+		return nil
+	}
+	body := c.req
+	c.req = bytes.Buffer{}
+	c.resp.Close()
+	resp, err := c.client.Post(c.ctx, c.service, &body)
+	if err != nil {
+		c.rd = eofReader{}
+		return err
+	}
+	c.resp, c.rd = resp, resp.Rest()
+	return nil
+}
+
+func (c *httpConn) Close() error {
+	return c.resp.Close()
+}
+
+// eofReader is the reader of a failed request.
+type eofReader struct{}
+
+func (eofReader) Read([]byte) (int, error) { return 0, io.EOF }
+
+// SSHTransport runs the services over SSH, with a Session if NewSession is
+// set, and with the ssh command otherwise.
+type SSHTransport struct {
+	// Dest is the destination of the ssh command, e.g. "git@example.com".
+	Dest string
+	// Args are options of the ssh command, e.g. "-p", "2222".
+	Args []string
+	// NewSession returns a new session of an SSH client, e.g. of
+	// golang.org/x/crypto/ssh.
+	NewSession func() (ssh.Session, error)
+	// Path is the path of the repository on the server.
+	Path string
+}
+
+// OpenUploadPack runs git-upload-pack.
+func (t *SSHTransport) OpenUploadPack(ctx context.Context, protocol int) (Conn, error) {
+	return t.open(ctx, ssh.UploadPack, protocol)
+}
+
+// OpenReceivePack runs git-receive-pack.
+func (t *SSHTransport) OpenReceivePack(ctx context.Context, protocol int) (Conn, error) {
+	return t.open(ctx, ssh.ReceivePack, protocol)
+}
+
+func (t *SSHTransport) open(ctx context.Context, service string, protocol int) (Conn, error) {
+	if t.NewSession != nil {
+		s, err := t.NewSession()
+		if err != nil {
+			return nil, err
+		}
+		c, err := ssh.Start(s, service, t.Path, protocol)
+		if err != nil {
+			s.Close()
+			return nil, err
+		}
+		return &cmdConn{c}, nil
+	}
+	cmd := ssh.Cmd(ctx, t.Dest, service, t.Path, protocol)
+	// The options go before the destination.
+	cmd.Args = append(append(cmd.Args[:1:1], t.Args...), cmd.Args[1:]...)
+	c, err := ssh.StartCmd(cmd)
+	if err != nil {
+		return nil, err
+	}
+	return &cmdConn{c}, nil
+}
+
+// ExecTransport runs the services as local commands, as git does for a
+// repository given by its path.
+type ExecTransport struct {
+	// Path is the path of the repository.
+	Path string
+	// Git is the git command, "git" if empty.
+	Git string
+	// Env are added to the environment of the commands.
+	Env []string
+}
+
+// OpenUploadPack runs git upload-pack.
+func (t *ExecTransport) OpenUploadPack(ctx context.Context, protocol int) (Conn, error) {
+	return t.open(ctx, "upload-pack", protocol)
+}
+
+// OpenReceivePack runs git receive-pack.
+func (t *ExecTransport) OpenReceivePack(ctx context.Context, protocol int) (Conn, error) {
+	return t.open(ctx, "receive-pack", protocol)
+}
+
+func (t *ExecTransport) open(ctx context.Context, service string, protocol int) (Conn, error) {
+	git := t.Git
+	if git == "" {
+		git = "git"
+	}
+	cmd := exec.CommandContext(ctx, git, service, t.Path)
+	cmd.Env = append(cmd.Environ(), t.Env...)
+	if env := ssh.ProtocolEnv(protocol); env != "" {
+		cmd.Env = append(cmd.Env, "GIT_PROTOCOL="+env)
+	}
+	c, err := ssh.StartCmd(cmd)
+	if err != nil {
+		return nil, err
+	}
+	return &cmdConn{c}, nil
+}
+
+// cmdConn is a service running as a command or in an SSH session.
+type cmdConn struct {
+	c *ssh.Conn
+}
+
+func (c *cmdConn) Read(b []byte) (int, error) {
+	return c.c.Stdout().Read(b)
+}
+
+func (c *cmdConn) Write(b []byte) (int, error) {
+	return c.c.Stdin().Write(b)
+}
+
+func (c *cmdConn) Flush() error {
+	return nil
+}
+
+// Close ends the session and waits for the service to exit, e.g. after
+// the hooks of a push. What the service still sends is discarded.
+func (c *cmdConn) Close() error {
+	c.c.CloseWrite()
+	io.Copy(io.Discard, c.c.Stdout())
+	return c.c.Wait()
+}
+
+// GitTransport is the git:// transport of git daemon.
+type GitTransport struct {
+	// Addr is the address of the daemon, e.g. "example.com:9418".
+	Addr string
+	// Host is sent in the host parameter, e.g. "example.com", if not
+	// empty.
+	Host string
+	// Path is the path of the repository on the server.
+	Path string
+	// Dialer dials Addr. The zero Dialer is used if nil.
+	Dialer *net.Dialer
+}
+
+// OpenUploadPack requests git-upload-pack.
+func (t *GitTransport) OpenUploadPack(ctx context.Context, protocol int) (Conn, error) {
+	return t.open(ctx, "git-upload-pack", protocol)
+}
+
+// OpenReceivePack requests git-receive-pack.
+func (t *GitTransport) OpenReceivePack(ctx context.Context, protocol int) (Conn, error) {
+	return t.open(ctx, "git-receive-pack", protocol)
+}
+
+func (t *GitTransport) open(ctx context.Context, service string, protocol int) (Conn, error) {
+	d := t.Dialer
+	if d == nil {
+		d = &net.Dialer{}
+	}
+	nc, err := d.DialContext(ctx, "tcp", t.Addr)
+	if err != nil {
+		return nil, err
+	}
+	req := &pkt.DaemonRequest{Service: service, Path: t.Path, Host: t.Host}
+	if env := ssh.ProtocolEnv(protocol); env != "" {
+		req.ExtraParameters = []string{env}
+	}
+	if _, err := nc.Write(req.EncodeToPktLine()); err != nil {
+		nc.Close()
+		return nil, err
+	}
+	return &netConn{nc}, nil
+}
+
+// netConn is a git daemon connection.
+type netConn struct {
+	net.Conn
+}
+
+func (c *netConn) Flush() error {
+	return nil
+}
+
+// Close ends the session and closes the connection once the daemon closes
+// it. What the daemon still sends is discarded.
+func (c *netConn) Close() error {
+	if cw, ok := c.Conn.(interface{ CloseWrite() error }); ok {
+		cw.CloseWrite()
+		io.Copy(io.Discard, c.Conn)
+	}
+	return c.Conn.Close()
+}
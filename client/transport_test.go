@@ -0,0 +1,181 @@
+// Modified by Giacomo Tartari
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http/cgi"
+	"net/http/httptest"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/cycloidio/pkt-line"
+	pkthttp "github.com/cycloidio/pkt-line/http"
+	"github.com/cycloidio/pkt-line/server"
+	"github.com/cycloidio/pkt-line/ssh"
+)
+
+// localSession is an ssh.Session running the commands locally.
+type localSession struct {
+	dir    string
+	env    []string
+	cmd    *exec.Cmd
+	stdin  *io.PipeReader
+	stdout *io.PipeWriter
+	done   chan struct{}
+	err    error
+}
+
+func (s *localSession) Setenv(name, value string) error {
+	s.env = append(s.env, name+"="+value)
+	return nil
+}
+
+func (s *localSession) StdinPipe() (io.WriteCloser, error) {
+	r, w := io.Pipe()
+	s.stdin = r
+	return w, nil
+}
+
+func (s *localSession) StdoutPipe() (io.Reader, error) {
+	r, w := io.Pipe()
+	s.stdout = w
+	return r, nil
+}
+
+func (s *localSession) Start(cmd string) error {
+	service, path, err := ssh.ParseCommand(cmd)
+	if err != nil {
+		return err
+	}
+	s.cmd = exec.Command("git", strings.TrimPrefix(service, "git-"), filepath.Join(s.dir, path))
+	s.cmd.Env = append(s.cmd.Environ(), s.env...)
+	s.cmd.Stdin, s.cmd.Stdout = s.stdin, s.stdout
+	if err := s.cmd.Start(); err != nil {
+		return err
+	}
+	// Like an SSH session, the output ends when the command exits.
+	s.done = make(chan struct{})
+	go func() {
+		s.err = s.cmd.Wait()
+		s.stdout.Close()
+		close(s.done)
+	}()
+	return nil
+}
+
+func (s *localSession) Wait() error {
+	<-s.done
+	return s.err
+}
+
+func (s *localSession) Close() error {
+	return s.cmd.Process.Kill()
+}
+
+// serveDaemon serves the git daemon connections of l with the repositories
+// of dir.
+func serveDaemon(l net.Listener, dir string) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer conn.Close()
+			s, req, err := server.NewDaemonSession(context.Background(), conn, nil)
+			if err != nil {
+				return
+			}
+			cmd := exec.Command("git", strings.TrimPrefix(s.Service, "git-"), filepath.Join(dir, req.Path))
+			cmd.Env = append(cmd.Environ(), "GIT_PROTOCOL="+ssh.ProtocolEnv(s.Protocol))
+			cmd.Stdin, cmd.Stdout = s.Reader, s.Writer
+			cmd.Run()
+		}()
+	}
+}
+
+func TestTransports(t *testing.T) {
+	src := newRepo(t, "c1", "c2")
+	c2 := git(t, src, "rev-parse", "main")
+	root := t.TempDir()
+	git(t, root, "clone", "-q", "--bare", src, "repo.git")
+	repo := filepath.Join(root, "repo.git")
+	git(t, repo, "config", "http.receivepack", "true")
+
+	backend := filepath.Join(git(t, root, "--exec-path"), "git-http-backend")
+	hs := httptest.NewServer(&cgi.Handler{
+		Path: backend,
+		Env:  []string{"GIT_PROJECT_ROOT=" + root, "GIT_HTTP_EXPORT_ALL=1"},
+	})
+	defer hs.Close()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go serveDaemon(l, root)
+
+	transports := []struct {
+		name string
+		t    Transport
+	}{
+		{"http", &HTTPTransport{Client: pkthttp.Client{URL: hs.URL + "/repo.git"}}},
+		{"ssh", &SSHTransport{NewSession: func() (ssh.Session, error) { return &localSession{dir: root}, nil }, Path: "/repo.git"}},
+		{"git", &GitTransport{Addr: l.Addr().String(), Host: "localhost", Path: "/repo.git"}},
+		{"exec", &ExecTransport{Path: repo}},
+	}
+	zero := strings.Repeat("0", 40)
+	for _, tt := range transports {
+		for _, protocol := range []int{1, 2} {
+			t.Run(fmt.Sprintf("%s/v%d", tt.name, protocol), func(t *testing.T) {
+				res, err := Fetch(context.Background(), tt.t, FetchOptions{Wants: []string{c2}, Protocol: protocol})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if res.Protocol != protocol {
+					t.Errorf("got protocol %d, want %d", res.Protocol, protocol)
+				}
+				if n := packObjects(t, res.Pack); n != 3 {
+					t.Errorf("got %d objects, want 3", n)
+				}
+				if err := res.Close(); err != nil {
+					t.Errorf("Close() = %v", err)
+				}
+			})
+		}
+		t.Run(tt.name+"/push", func(t *testing.T) {
+			ref := "refs/heads/" + tt.name
+			pr, err := Push(context.Background(), tt.t, []RefUpdate{{ref, zero, c2}}, bytes.NewReader(emptyPack(pkt.SHA1)), PushOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !pr.OK() {
+				t.Errorf("push failed: %+v", pr)
+			}
+			if got := git(t, repo, "rev-parse", ref); got != c2 {
+				t.Errorf("got %s = %s, want %s", ref, got, c2)
+			}
+		})
+	}
+}
@@ -0,0 +1,61 @@
+// Modified by Giacomo Tartari
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkt
+
+import "bytes"
+
+// Clone returns a copy of the packet that does not share its payload.
+func (b BytesPacket) Clone() BytesPacket {
+	return bytes.Clone(b)
+}
+
+// Clone returns a copy of the packet that does not share its payload.
+func (p PackFilePacket) Clone() PackFilePacket {
+	return bytes.Clone(p)
+}
+
+// Clone returns a copy of the packet that does not share its payload.
+func (p SideBandMainPacket) Clone() SideBandMainPacket {
+	return bytes.Clone(p)
+}
+
+// Clone returns a copy of the packet that does not share its payload.
+func (p SideBandReportPacket) Clone() SideBandReportPacket {
+	return bytes.Clone(p)
+}
+
+// Clone returns a copy of the packet that does not share its payload.
+func (p SideBandErrorPacket) Clone() SideBandErrorPacket {
+	return bytes.Clone(p)
+}
+
+// ClonePacket returns a copy of p that can be kept across calls to Scan.
+// The packets without a []byte payload are returned as is.
+func ClonePacket(p Packet) Packet {
+	switch p := p.(type) {
+	case BytesPacket:
+		return p.Clone()
+	case PackFilePacket:
+		return p.Clone()
+	case SideBandMainPacket:
+		return p.Clone()
+	case SideBandReportPacket:
+		return p.Clone()
+	case SideBandErrorPacket:
+		return p.Clone()
+	}
+	return p
+}
@@ -0,0 +1,60 @@
+// Modified by Giacomo Tartari
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkt
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestClonePacket(t *testing.T) {
+	for _, p := range []Packet{
+		BytesPacket("abc"),
+		PackFilePacket("abc"),
+		SideBandMainPacket("abc"),
+		SideBandReportPacket("abc"),
+		SideBandErrorPacket("abc"),
+	} {
+		t.Run(fmt.Sprintf("%T", p), func(t *testing.T) {
+			c := ClonePacket(p)
+			if got, want := string(c.EncodeToPktLine()), string(p.EncodeToPktLine()); got != want {
+				t.Fatalf("got %q, want %q", got, want)
+			}
+			// The clone does not see the changes of the scanner buffer.
+			want := string(c.EncodeToPktLine())
+			switch p := p.(type) {
+			case BytesPacket:
+				p[0] = 'x'
+			case PackFilePacket:
+				p[0] = 'x'
+			case SideBandMainPacket:
+				p[0] = 'x'
+			case SideBandReportPacket:
+				p[0] = 'x'
+			case SideBandErrorPacket:
+				p[0] = 'x'
+			}
+			if got := string(c.EncodeToPktLine()); got != want {
+				t.Errorf("got %q after changing the original, want %q", got, want)
+			}
+		})
+	}
+	for _, p := range []Packet{FlushPacket{}, DelimPacket{}, StringPacket("abc"), ErrorPacket("abc")} {
+		if c := ClonePacket(p); c != p {
+			t.Errorf("ClonePacket(%#v) = %#v, want it as is", p, c)
+		}
+	}
+}
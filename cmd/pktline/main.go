@@ -0,0 +1,178 @@
+// Modified by Giacomo Tartari
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// pktline dumps and composes pkt-line streams.
+//
+//	pktline dump [-sideband] [-trace] [-hex n] [file]
+//	pktline compose [file]
+//
+// dump prints the packets read from file, or stdin, one per line with their
+// offset and length header; the special packets are shown by name and the
+// pack file as a hexdump. With -trace, it prints the textual trace format of
+// the pkttest package instead, which compose reads: compose writes the
+// stream of the trace read from file, or stdin, to stdout.
+package main
+
+import (
+	"bufio"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/cycloidio/pkt-line"
+	"github.com/cycloidio/pkt-line/pkttest"
+)
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("pktline: ")
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() < 1 {
+		usage()
+		os.Exit(2)
+	}
+	var err error
+	switch cmd, args := flag.Arg(0), flag.Args()[1:]; cmd {
+	case "dump":
+		err = dump(args)
+	case "compose":
+		err = compose(args)
+	default:
+		usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: pktline dump [-sideband] [-trace] [-hex n] [file]\n")
+	fmt.Fprintf(os.Stderr, "       pktline compose [file]\n")
+}
+
+// open returns the file named by the only argument, or stdin.
+func open(fs *flag.FlagSet) (io.ReadCloser, error) {
+	switch fs.NArg() {
+	case 0:
+		return io.NopCloser(os.Stdin), nil
+	case 1:
+		return os.Open(fs.Arg(0))
+	}
+	fs.Usage()
+	os.Exit(2)
+	return nil, nil
+}
+
+func dump(args []string) error {
+	fs := flag.NewFlagSet("dump", flag.ExitOnError)
+	sideband := fs.Bool("sideband", false, "decode the side-band of the data packets")
+	trace := fs.Bool("trace", false, "print the pkttest trace format")
+	hexMax := fs.Int("hex", 256, "the maximum number of bytes of each pack chunk to hexdump, -1 for all")
+	fs.Parse(args)
+	rd, err := open(fs)
+	if err != nil {
+		return err
+	}
+	defer rd.Close()
+
+	w := bufio.NewWriter(os.Stdout)
+	defer w.Flush()
+	if *trace {
+		rec := pkttest.NewPacketRecorder(w)
+		if err := rec.RecordStream(rd); err != nil {
+			return err
+		}
+		return w.Flush()
+	}
+
+	s := pkt.NewPacketScanner(rd, pkt.WithErrorPackets())
+	var offset int64
+	for s.Scan() {
+		raw := s.RawBytes()
+		hdr := string(raw[:min(4, len(raw))])
+		switch p := s.Packet().(type) {
+		case pkt.PackFilePacket:
+			fmt.Fprintf(w, "%08x        pack %d bytes\n", offset, len(p))
+			hexdump(w, p, *hexMax)
+		case pkt.BytesPacket:
+			fmt.Fprintf(w, "%08x  %s  %s\n", offset, hdr, formatData(p, *sideband))
+		default:
+			fmt.Fprintf(w, "%08x  %s  %s\n", offset, hdr, pkttest.FormatPacket(p))
+		}
+		offset += int64(len(raw))
+	}
+	if err := s.Err(); err != nil {
+		w.Flush()
+		return err
+	}
+	return w.Flush()
+}
+
+// formatData formats the payload of a data packet, with its side-band if
+// sideband is set.
+func formatData(p pkt.BytesPacket, sideband bool) string {
+	if !sideband || len(p) == 0 {
+		return "data " + strconv.Quote(string(p))
+	}
+	switch sp := pkt.ParseSideBandPacket(p).(type) {
+	case pkt.SideBandMainPacket:
+		return fmt.Sprintf("band 1, %d bytes", len(sp))
+	case pkt.SideBandReportPacket:
+		return "band 2 " + strconv.Quote(string(sp))
+	case pkt.SideBandErrorPacket:
+		return "band 3 " + strconv.Quote(string(sp))
+	}
+	return "data " + strconv.Quote(string(p))
+}
+
+// hexdump writes the first max bytes of b, or all of them if max is
+// negative, indented.
+func hexdump(w io.Writer, b []byte, max int) {
+	trunc := max >= 0 && len(b) > max
+	if trunc {
+		b = b[:max]
+	}
+	for _, line := range strings.SplitAfter(hex.Dump(b), "\n") {
+		if line != "" {
+			io.WriteString(w, "          "+line)
+		}
+	}
+	if trunc {
+		io.WriteString(w, "          ...\n")
+	}
+}
+
+func compose(args []string) error {
+	fs := flag.NewFlagSet("compose", flag.ExitOnError)
+	fs.Parse(args)
+	rd, err := open(fs)
+	if err != nil {
+		return err
+	}
+	defer rd.Close()
+	rp, err := pkttest.NewReplayer(rd)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(os.Stdout, rp)
+	return err
+}
@@ -0,0 +1,156 @@
+// Modified by Giacomo Tartari
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkt
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"time"
+)
+
+// PacketConn reads and writes packets on a net.Conn, as a git-daemon or a
+// service tunneled over SSH does. The packets written are buffered until a
+// flush or a response-end packet, which sends them to the peer, and every
+// packet read or written can be given a deadline.
+//
+// After a timeout, the PacketConn is in an undefined state and must be
+// closed.
+type PacketConn struct {
+	// ReadTimeout, if not zero, limits the time ReadPacket waits for a
+	// packet.
+	ReadTimeout time.Duration
+	// WriteTimeout, if not zero, limits the time a write to the connection
+	// takes.
+	WriteTimeout time.Duration
+
+	conn    net.Conn
+	scanner *PacketScanner
+	bw      *bufio.Writer
+	pw      *PacketWriter
+}
+
+// NewPacketConn returns a PacketConn on conn. The options configure the
+// PacketScanner reading from conn, and the PacketWriter writing to it for
+// WithTrace.
+func NewPacketConn(conn net.Conn, opts ...Option) *PacketConn {
+	c := &PacketConn{conn: conn}
+	c.scanner = NewPacketScanner(conn, opts...)
+	c.bw = bufio.NewWriterSize(deadlineWriter{c}, scannerBufferSize)
+	c.pw = NewPacketWriter(c.bw, opts...)
+	return c
+}
+
+// Conn returns the underlying connection.
+func (c *PacketConn) Conn() net.Conn {
+	return c.conn
+}
+
+// Scanner returns the PacketScanner reading from the connection, e.g. to
+// read the pack data with WritePackTo.
+func (c *PacketConn) Scanner() *PacketScanner {
+	return c.scanner
+}
+
+// ReadPacket reads the next packet. It returns io.EOF at the end of the
+// connection, and a net.Error whose Timeout method returns true if no packet
+// was read within ReadTimeout.
+func (c *PacketConn) ReadPacket() (Packet, error) {
+	if c.ReadTimeout > 0 {
+		if err := c.conn.SetReadDeadline(time.Now().Add(c.ReadTimeout)); err != nil {
+			return nil, err
+		}
+	}
+	if !c.scanner.Scan() {
+		if err := c.scanner.Err(); err != nil {
+			return nil, err
+		}
+		return nil, io.EOF
+	}
+	return c.scanner.Packet(), nil
+}
+
+// WritePacket writes p. A flush or a response-end packet sends the buffered
+// packets to the peer.
+func (c *PacketConn) WritePacket(p Packet) error {
+	switch p.(type) {
+	case FlushPacket:
+		return c.Flush()
+	case ResponseEndPacket:
+		if err := c.pw.WritePacket(p); err != nil {
+			return err
+		}
+		return c.send()
+	}
+	return c.pw.WritePacket(p)
+}
+
+// Write writes b as BytesPackets, like PacketWriter.Write.
+func (c *PacketConn) Write(b []byte) (int, error) {
+	return c.pw.Write(b)
+}
+
+// Flush writes a flush packet and sends the buffered packets to the peer.
+func (c *PacketConn) Flush() error {
+	// PacketWriter.Flush flushes the bufio.Writer.
+	return c.pw.Flush()
+}
+
+// Delim writes a delim packet.
+func (c *PacketConn) Delim() error {
+	return c.pw.Delim()
+}
+
+// WriteRaw writes b as is, e.g. pack data sent without side-band, and sends
+// it to the peer.
+func (c *PacketConn) WriteRaw(b []byte) error {
+	if err := c.pw.WritePacket(RawPacket(b)); err != nil {
+		return err
+	}
+	return c.send()
+}
+
+// Close sends the buffered packets and closes the connection.
+func (c *PacketConn) Close() error {
+	err := c.send()
+	if cerr := c.conn.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// send writes the buffered packets to the connection.
+func (c *PacketConn) send() error {
+	if c.pw.Err() != nil {
+		return c.pw.Err()
+	}
+	return c.bw.Flush()
+}
+
+// deadlineWriter writes to the connection of a PacketConn with its write
+// deadline.
+type deadlineWriter struct {
+	c *PacketConn
+}
+
+func (w deadlineWriter) Write(p []byte) (int, error) {
+	if w.c.WriteTimeout > 0 {
+		if err := w.c.conn.SetWriteDeadline(time.Now().Add(w.c.WriteTimeout)); err != nil {
+			return 0, err
+		}
+	}
+	return w.c.conn.Write(p)
+}
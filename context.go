@@ -0,0 +1,117 @@
+// Modified by Giacomo Tartari
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkt
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// WithContext makes the scanner, and the parsers built on top of it, stop
+// with ctx.Err() once ctx is done, e.g. to enforce a negotiation timeout.
+// The context is checked before each read of the underlying reader. A read
+// in progress is only interrupted if the reader has a SetReadDeadline
+// method, like net.Conn, in which case the deadline of ctx is set on it and
+// a past deadline is set when ctx is cancelled. The deadline is cleared once
+// the scan reaches the end of the input or an error, and when the input is
+// handed over with Rest or replaced with ResetInput.
+func WithContext(ctx context.Context) Option {
+	return func(c *Config) {
+		c.Context = ctx
+	}
+}
+
+// contextReader is a reader failing with the error of its context. When
+// the underlying reader has a SetReadDeadline method, the deadline of the
+// context is set on it until the reader is detached.
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+
+	// d is r if it has a SetReadDeadline method, and stop unregisters the
+	// function setting a past deadline on it once ctx is cancelled. mu
+	// guards detached against that function.
+	d        deadlineSetter
+	stop     func() bool
+	mu       sync.Mutex
+	detached bool
+}
+
+type deadlineSetter interface {
+	SetReadDeadline(time.Time) error
+}
+
+func newContextReader(ctx context.Context, r io.Reader) *contextReader {
+	cr := &contextReader{ctx: ctx, r: r}
+	if d, ok := r.(deadlineSetter); ok {
+		cr.d = d
+		if t, ok := ctx.Deadline(); ok {
+			d.SetReadDeadline(t)
+		}
+		cr.stop = context.AfterFunc(ctx, func() {
+			cr.mu.Lock()
+			defer cr.mu.Unlock()
+			if !cr.detached {
+				d.SetReadDeadline(time.Unix(1, 0))
+			}
+		})
+	}
+	return cr
+}
+
+// detach unregisters r from its context and clears the read deadline of
+// the underlying reader. The later reads are passed through.
+func (r *contextReader) detach() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.detached {
+		return
+	}
+	r.detached = true
+	if r.d != nil {
+		r.stop()
+		r.d.SetReadDeadline(time.Time{})
+	}
+}
+
+func (r *contextReader) Read(p []byte) (int, error) {
+	if r.detached {
+		return r.r.Read(p)
+	}
+	if err := r.ctx.Err(); err != nil {
+		r.detach()
+		return 0, err
+	}
+	n, err := r.r.Read(p)
+	if err != nil {
+		r.detach()
+	}
+	if err != nil && err != io.EOF {
+		// A deadline error caused by the context, that may expire right
+		// after the read deadline.
+		if cerr := r.ctx.Err(); cerr != nil {
+			return n, cerr
+		}
+		if _, ok := r.ctx.Deadline(); ok && errors.Is(err, os.ErrDeadlineExceeded) {
+			return n, context.DeadlineExceeded
+		}
+	}
+	return n, err
+}
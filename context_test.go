@@ -0,0 +1,132 @@
+// Modified by Giacomo Tartari
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkt
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestWithContext(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		ctx  func() (context.Context, context.CancelFunc)
+		err  error
+	}{
+		{
+			name: "cancel",
+			ctx: func() (context.Context, context.CancelFunc) {
+				ctx, cancel := context.WithCancel(context.Background())
+				time.AfterFunc(20*time.Millisecond, cancel)
+				return ctx, cancel
+			},
+			err: context.Canceled,
+		},
+		{
+			name: "deadline",
+			ctx: func() (context.Context, context.CancelFunc) {
+				return context.WithTimeout(context.Background(), 20*time.Millisecond)
+			},
+			err: context.DeadlineExceeded,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			client, conn := net.Pipe()
+			defer client.Close()
+			defer conn.Close()
+			ctx, cancel := tc.ctx()
+			defer cancel()
+
+			go client.Write([]byte(pktLines("a\n")))
+			s := NewPacketScanner(conn, WithContext(ctx))
+			if !s.Scan() {
+				t.Fatal(s.Err())
+			}
+			// Nothing follows, the read is interrupted.
+			if s.Scan() {
+				t.Fatalf("got packet %v, want an error", s.Packet())
+			}
+			if err := s.Err(); !errors.Is(err, tc.err) {
+				t.Fatalf("got error %v, want %v", err, tc.err)
+			}
+			// The deadline of conn was cleared.
+			go client.Write([]byte("more"))
+			bs := make([]byte, 4)
+			if _, err := io.ReadFull(conn, bs); err != nil || string(bs) != "more" {
+				t.Errorf("read %q, %v after the scan", bs, err)
+			}
+		})
+	}
+}
+
+func TestWithContext_rest(t *testing.T) {
+	client, conn := net.Pipe()
+	defer client.Close()
+	defer conn.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	go client.Write([]byte(pktLines("a\n")))
+	s := NewPacketScanner(conn, WithContext(ctx))
+	if !s.Scan() {
+		t.Fatal(s.Err())
+	}
+	rd := s.Rest()
+	// Neither the deadline nor the cancellation of ctx apply to the
+	// connection handed over.
+	cancel()
+	time.Sleep(40 * time.Millisecond)
+	go client.Write([]byte("more"))
+	bs := make([]byte, 4)
+	if _, err := io.ReadFull(rd, bs); err != nil || string(bs) != "more" {
+		t.Errorf("read %q, %v after Rest", bs, err)
+	}
+}
+
+func TestWithContext_resetInput(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	client1, conn1 := net.Pipe()
+	defer client1.Close()
+	defer conn1.Close()
+	client2, conn2 := net.Pipe()
+	defer client2.Close()
+	defer conn2.Close()
+
+	go client1.Write([]byte(pktLines("a\n")))
+	s := NewPacketScanner(conn1, WithContext(ctx))
+	if !s.Scan() {
+		t.Fatal(s.Err())
+	}
+	s.ResetInput(conn2)
+	cancel()
+	// The cancellation interrupts the scan of the new input only.
+	if s.Scan() {
+		t.Fatalf("got packet %v, want an error", s.Packet())
+	}
+	if err := s.Err(); !errors.Is(err, context.Canceled) {
+		t.Fatalf("got error %v, want %v", err, context.Canceled)
+	}
+	go client1.Write([]byte("more"))
+	bs := make([]byte, 4)
+	if _, err := io.ReadFull(conn1, bs); err != nil || string(bs) != "more" {
+		t.Errorf("read %q, %v from the previous input", bs, err)
+	}
+}
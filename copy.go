@@ -0,0 +1,107 @@
+// Modified by Giacomo Tartari
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkt
+
+import (
+	"context"
+	"time"
+)
+
+// CopyOption is an option of CopyPackets.
+type CopyOption func(*copier)
+
+// RateLimit limits CopyPackets to bytesPerSec bytes per second, on average
+// since the start of the copy.
+func RateLimit(bytesPerSec int64) CopyOption {
+	return func(c *copier) {
+		c.rate = bytesPerSec
+	}
+}
+
+// OnPacket makes CopyPackets call fn with each packet before it is
+// written. An error of fn stops the copy.
+func OnPacket(fn func(Packet) error) CopyOption {
+	return func(c *copier) {
+		c.onPacket = fn
+	}
+}
+
+// CopyContext makes CopyPackets stop with the error of ctx when it is done,
+// including while waiting for the rate limit.
+func CopyContext(ctx context.Context) CopyOption {
+	return func(c *copier) {
+		c.ctx = ctx
+	}
+}
+
+type copier struct {
+	rate     int64
+	onPacket func(Packet) error
+	ctx      context.Context
+}
+
+// CopyPackets copies the packets of src to dst, in their original encoding,
+// until the end of src, and returns the number of bytes written. A packet is
+// only read once the previous one is written, so that a slow dst slows the
+// reads of src down and the memory stays bounded by the buffer of src, as
+// proxies throttling large clones need. The flush packets flush dst, as
+// Transform does.
+func CopyPackets(dst *PacketWriter, src *PacketScanner, opts ...CopyOption) (int64, error) {
+	c := &copier{ctx: context.Background()}
+	for _, o := range opts {
+		o(c)
+	}
+	var n int64
+	start := time.Now()
+	for src.Scan() {
+		if err := c.ctx.Err(); err != nil {
+			return n, err
+		}
+		if c.onPacket != nil {
+			if err := c.onPacket(src.Packet()); err != nil {
+				return n, err
+			}
+		}
+		raw := src.RawBytes()
+		if err := forward(dst, RawPacket(raw)); err != nil {
+			return n, err
+		}
+		n += int64(len(raw))
+		if c.rate > 0 {
+			if err := c.wait(start, n); err != nil {
+				return n, err
+			}
+		}
+	}
+	return n, src.Err()
+}
+
+// wait sleeps until n bytes are within the rate since start.
+func (c *copier) wait(start time.Time, n int64) error {
+	due := start.Add(time.Duration(float64(n) / float64(c.rate) * float64(time.Second)))
+	d := time.Until(due)
+	if d <= 0 {
+		return nil
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-c.ctx.Done():
+		return c.ctx.Err()
+	}
+}
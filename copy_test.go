@@ -0,0 +1,128 @@
+// Modified by Giacomo Tartari
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkt
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// flushRecorder records the data written at each Flush.
+type flushRecorder struct {
+	bytes.Buffer
+	flushes []string
+}
+
+func (w *flushRecorder) Flush() error {
+	w.flushes = append(w.flushes, w.String())
+	return nil
+}
+
+func TestCopyPackets(t *testing.T) {
+	// The headers keep their case, and the flushes flush dst.
+	in := "000Aabcdef" + "0000" + pktLines("b\n") + "0001" + "0000"
+	var out flushRecorder
+	n, err := CopyPackets(NewPacketWriter(&out), NewPacketScanner(strings.NewReader(in)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != int64(len(in)) || out.String() != in {
+		t.Errorf("copied %d bytes %q, want %q", n, out.String(), in)
+	}
+	want := []string{"000Aabcdef0000", in}
+	if len(out.flushes) != 2 || out.flushes[0] != want[0] || out.flushes[1] != want[1] {
+		t.Errorf("got flushes %q, want %q", out.flushes, want)
+	}
+}
+
+func TestCopyPackets_onPacket(t *testing.T) {
+	in := pktLines("a\n", "b\n", "c\n")
+	var out bytes.Buffer
+	var seen []string
+	stop := errors.New("stop")
+	n, err := CopyPackets(NewPacketWriter(&out), NewPacketScanner(strings.NewReader(in)), OnPacket(func(p Packet) error {
+		bp := p.(BytesPacket)
+		seen = append(seen, string(bp))
+		if string(bp) == "b\n" {
+			return stop
+		}
+		return nil
+	}))
+	if err != stop {
+		t.Fatalf("got error %v, want %v", err, stop)
+	}
+	// The packet refused is not written.
+	if want := pktLines("a\n"); n != int64(len(want)) || out.String() != want {
+		t.Errorf("copied %d bytes %q, want %q", n, out.String(), want)
+	}
+	if len(seen) != 2 {
+		t.Errorf("saw packets %q, want 2", seen)
+	}
+}
+
+func TestCopyPackets_rateLimit(t *testing.T) {
+	// 10 packets of 100 bytes at 10000 bytes per second.
+	var in strings.Builder
+	for i := 0; i < 10; i++ {
+		in.Write(BytesPacket(strings.Repeat("a", 96)).EncodeToPktLine())
+	}
+	var out bytes.Buffer
+	start := time.Now()
+	n, err := CopyPackets(NewPacketWriter(&out), NewPacketScanner(strings.NewReader(in.String())), RateLimit(10000))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1000 || out.String() != in.String() {
+		t.Errorf("copied %d bytes, want 1000", n)
+	}
+	if d := time.Since(start); d < 90*time.Millisecond {
+		t.Errorf("copied in %v, want at least 100ms", d)
+	}
+}
+
+func TestCopyPackets_context(t *testing.T) {
+	in := pktLines("a\n", "b\n")
+	t.Run("cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		var out bytes.Buffer
+		n, err := CopyPackets(NewPacketWriter(&out), NewPacketScanner(strings.NewReader(in)), CopyContext(ctx))
+		if err != context.Canceled || n != 0 || out.Len() != 0 {
+			t.Errorf("copied %d bytes, error %v, want nothing and %v", n, err, context.Canceled)
+		}
+	})
+	t.Run("while waiting", func(t *testing.T) {
+		// The first packet is due in 6 seconds at 1 byte per second.
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+		var out bytes.Buffer
+		start := time.Now()
+		n, err := CopyPackets(NewPacketWriter(&out), NewPacketScanner(strings.NewReader(in)), CopyContext(ctx), RateLimit(1))
+		if err != context.DeadlineExceeded {
+			t.Fatalf("got error %v, want %v", err, context.DeadlineExceeded)
+		}
+		if want := pktLines("a\n"); n != int64(len(want)) || out.String() != want {
+			t.Errorf("copied %d bytes %q, want %q", n, out.String(), want)
+		}
+		if d := time.Since(start); d > time.Second {
+			t.Errorf("stopped after %v", d)
+		}
+	})
+}
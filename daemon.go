@@ -0,0 +1,120 @@
+// Modified by Giacomo Tartari
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkt
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// DaemonRequest is the first packet sent to a git daemon (git://):
+//
+//	git-proto-request = request-command SP pathname NUL
+//			    [ host-parameter NUL ] [ NUL extra-parameters ]
+//
+// e.g. "git-upload-pack /project.git\0host=example.com\0\0version=2\0".
+type DaemonRequest struct {
+	// Service is the requested service, e.g. "git-upload-pack".
+	Service string
+	Path    string
+	// Host is the value of the host parameter, with an optional port, or
+	// empty.
+	Host string
+	// ExtraParameters are the extra parameters, e.g. "version=2".
+	ExtraParameters []string
+}
+
+// ParseDaemonRequest parses the payload of the first packet of a git daemon
+// connection.
+func ParseDaemonRequest(b []byte) (*DaemonRequest, error) {
+	cmd, rest, ok := bytes.Cut(b, []byte{0})
+	if !ok {
+		return nil, SyntaxError(fmt.Sprintf("unexpected packet: %#v", string(b)))
+	}
+	service, path, ok := strings.Cut(string(cmd), " ")
+	if !ok || service == "" || path == "" {
+		return nil, SyntaxError("cannot split daemon request: " + string(cmd))
+	}
+	r := &DaemonRequest{Service: service, Path: path}
+	if host, ok := bytes.CutPrefix(rest, []byte("host=")); ok {
+		h, after, ok := bytes.Cut(host, []byte{0})
+		if !ok {
+			return nil, SyntaxError("host parameter without NUL: " + string(host))
+		}
+		r.Host = string(h)
+		rest = after
+	}
+	if len(rest) == 0 {
+		return r, nil
+	}
+	extra, ok := bytes.CutPrefix(rest, []byte{0})
+	if !ok {
+		return nil, SyntaxError(fmt.Sprintf("unexpected daemon request parameter: %q", rest))
+	}
+	for _, p := range bytes.Split(extra, []byte{0}) {
+		if len(p) != 0 {
+			r.ExtraParameters = append(r.ExtraParameters, string(p))
+		}
+	}
+	return r, nil
+}
+
+// ReadDaemonRequest reads the first packet of a git daemon connection.
+func ReadDaemonRequest(s *PacketScanner) (*DaemonRequest, error) {
+	if !s.Scan() {
+		if err := s.Err(); err != nil {
+			return nil, err
+		}
+		return nil, SyntaxError("early EOF")
+	}
+	bp, ok := s.Packet().(BytesPacket)
+	if !ok {
+		return nil, SyntaxError(fmt.Sprintf("unexpected packet: %#v", s.Packet()))
+	}
+	// Some clients end the packet with a LF.
+	return ParseDaemonRequest(bytes.TrimSuffix(bp, []byte("\n")))
+}
+
+// Version returns the protocol version requested by the "version" extra
+// parameter, or 0.
+func (r *DaemonRequest) Version() int {
+	for _, p := range r.ExtraParameters {
+		if v, ok := strings.CutPrefix(p, "version="); ok {
+			n, _ := strconv.Atoi(v)
+			return n
+		}
+	}
+	return 0
+}
+
+// EncodeToPktLine serializes the request.
+func (r *DaemonRequest) EncodeToPktLine() []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "%s %s\x00", r.Service, r.Path)
+	if r.Host != "" {
+		fmt.Fprintf(&b, "host=%s\x00", r.Host)
+	}
+	if len(r.ExtraParameters) != 0 {
+		b.WriteByte(0)
+		for _, p := range r.ExtraParameters {
+			b.WriteString(p)
+			b.WriteByte(0)
+		}
+	}
+	return BytesPacket(b.Bytes()).EncodeToPktLine()
+}
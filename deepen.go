@@ -0,0 +1,164 @@
+// Modified by Giacomo Tartari
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkt
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Deepen is the shallow part of a fetch request: the deepen, deepen-since,
+// deepen-not and deepen-relative lines of a protocol v1 request or
+// arguments of a protocol v2 fetch command.
+type Deepen struct {
+	// Depth is the number of commits to fetch from the wants, or from the
+	// shallow commits of the client if Relative is set.
+	Depth int
+	// Since cuts the history at the commits older than it, with a precision
+	// of a second.
+	Since time.Time
+	// Not cuts the history at the commits reachable from the refs.
+	Not []string
+	// Relative is the deepen-relative capability in protocol v1, sent with
+	// the first want, and argument in protocol v2.
+	Relative bool
+}
+
+// IsZero reports whether d requests no deepening.
+func (d *Deepen) IsZero() bool {
+	return d.Depth == 0 && d.Since.IsZero() && len(d.Not) == 0 && !d.Relative
+}
+
+// Validate checks that d is a request git accepts: a depth cannot be
+// combined with deepen-since or deepen-not, and deepen-relative needs a
+// depth.
+func (d *Deepen) Validate() error {
+	if d.Depth < 0 {
+		return SyntaxError(fmt.Sprintf("invalid depth: %d", d.Depth))
+	}
+	if d.Depth != 0 && (!d.Since.IsZero() || len(d.Not) != 0) {
+		return SyntaxError("deepen cannot be combined with deepen-since or deepen-not")
+	}
+	if d.Relative && d.Depth == 0 {
+		return SyntaxError("deepen-relative needs deepen")
+	}
+	return nil
+}
+
+// Chunks returns the lines of a protocol v1 request. The deepen-relative
+// capability must be added to the first want.
+func (d *Deepen) Chunks() []*UploadRequestChunk {
+	var cs []*UploadRequestChunk
+	if d.Depth != 0 {
+		cs = append(cs, NewDeepenChunk(d.Depth))
+	}
+	if !d.Since.IsZero() {
+		cs = append(cs, NewDeepenSinceChunk(d.Since))
+	}
+	for _, ref := range d.Not {
+		cs = append(cs, NewDeepenNotChunk(ref))
+	}
+	return cs
+}
+
+// Arguments returns the arguments of a protocol v2 fetch command.
+func (d *Deepen) Arguments() []string {
+	var args []string
+	if d.Depth != 0 {
+		args = append(args, "deepen "+strconv.Itoa(d.Depth))
+	}
+	if d.Relative {
+		args = append(args, "deepen-relative")
+	}
+	if !d.Since.IsZero() {
+		args = append(args, "deepen-since "+strconv.FormatInt(d.Since.Unix(), 10))
+	}
+	for _, ref := range d.Not {
+		args = append(args, "deepen-not "+ref)
+	}
+	return args
+}
+
+// AddChunk records the chunk of a protocol v1 request if it is a deepen,
+// deepen-since or deepen-not line, or a want with the deepen-relative
+// capability, and reports whether it did.
+func (d *Deepen) AddChunk(c *UploadRequestChunk) bool {
+	switch {
+	case c.DeepenDepth != 0:
+		d.Depth = c.DeepenDepth
+	case c.DeepenSince != 0:
+		d.Since = c.DeepenSinceTime()
+	case c.DeepenNotRef != "":
+		d.Not = append(d.Not, c.DeepenNotRef)
+	case c.WantObjectID != "" && c.HasCapability("deepen-relative"):
+		d.Relative = true
+	default:
+		return false
+	}
+	return true
+}
+
+// AddArgument records the argument of a protocol v2 fetch command, without
+// its trailing LF, if it is a deepen argument, and reports whether it is.
+func (d *Deepen) AddArgument(arg string) (bool, error) {
+	if arg == "deepen-relative" {
+		d.Relative = true
+		return true, nil
+	}
+	name, value, _ := strings.Cut(arg, " ")
+	switch name {
+	case "deepen":
+		depth, err := strconv.Atoi(value)
+		if err != nil || depth <= 0 {
+			return true, SyntaxError("cannot parse depth")
+		}
+		d.Depth = depth
+	case "deepen-since":
+		t, err := ParseDeepenSince(value)
+		if err != nil {
+			return true, err
+		}
+		d.Since = t
+	case "deepen-not":
+		if value == "" {
+			return true, SyntaxError("empty deepen-not")
+		}
+		d.Not = append(d.Not, value)
+	default:
+		return false, nil
+	}
+	return true, nil
+}
+
+// ParseDeepenSince parses the value of deepen-since, seconds since the UNIX
+// epoch.
+func ParseDeepenSince(s string) (time.Time, error) {
+	since, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return time.Time{}, SyntaxError("cannot parse deepen-since")
+	}
+	return time.Unix(since, 0), nil
+}
+
+// DeepenSinceTime returns the time of a deepen-since line, or the zero time.
+func (c *UploadRequestChunk) DeepenSinceTime() time.Time {
+	if c.DeepenSince == 0 {
+		return time.Time{}
+	}
+	return time.Unix(int64(c.DeepenSince), 0)
+}
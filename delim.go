@@ -0,0 +1,77 @@
+// Modified by Giacomo Tartari
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkt
+
+// DelimPolicy is the handling of the delim packets by the protocol v1
+// parsers, UploadResponse and ReceiveResponse. Protocol v1 does not define
+// delim packets, but some servers and middleboxes emit them.
+type DelimPolicy int
+
+const (
+	// DelimError rejects the delim packets with a SyntaxError. It is the
+	// default.
+	DelimError DelimPolicy = iota
+	// DelimSkip drops the delim packets.
+	DelimSkip
+	// DelimExpose returns the delim packets as chunks with Delim set,
+	// without changing the state of the parser.
+	DelimExpose
+)
+
+// WithDelimPolicy sets the handling of the delim packets by the protocol v1
+// parsers.
+func WithDelimPolicy(p DelimPolicy) Option {
+	return func(c *Config) {
+		c.DelimPolicy = p
+	}
+}
+
+// DelimEvent is a delim packet read by UploadResponse with DelimExpose.
+type DelimEvent struct{}
+
+// ReceiveDelimEvent is a delim packet read by ReceiveResponse with
+// DelimExpose.
+type ReceiveDelimEvent struct{}
+
+// Chunk returns the equivalent chunk.
+func (DelimEvent) Chunk() *UploadResponseChunk {
+	return &UploadResponseChunk{Delim: true}
+}
+
+// Chunk returns the equivalent chunk.
+func (ReceiveDelimEvent) Chunk() *ReceiveResponseChunk {
+	return &ReceiveResponseChunk{Delim: true}
+}
+
+// EncodeToPktLine serializes the event.
+func (DelimEvent) EncodeToPktLine() []byte { return DelimPacket{}.EncodeToPktLine() }
+
+// EncodeToPktLine serializes the event.
+func (ReceiveDelimEvent) EncodeToPktLine() []byte { return DelimPacket{}.EncodeToPktLine() }
+
+func (DelimEvent) uploadResponseEvent()         {}
+func (ReceiveDelimEvent) receiveResponseEvent() {}
+
+// scanPacket advances s to the next packet, skipping the delim packets if
+// the policy is DelimSkip.
+func (c *Config) scanPacket(s *PacketScanner) bool {
+	for s.Scan() {
+		if _, ok := s.Packet().(DelimPacket); !ok || c.DelimPolicy != DelimSkip {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,129 @@
+// Modified by Giacomo Tartari
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkt
+
+import (
+	"fmt"
+	"io"
+)
+
+// SideBandError is the message sent by the remote side on the error band
+// (0x03).
+type SideBandError string
+
+func (e SideBandError) Error() string { return "remote error: " + string(e) }
+
+// SideBandDemuxer splits the side-band or side-band-64k packets read by a
+// PacketScanner into their bands. The pack data of band 1 is read with Read,
+// the progress messages of band 2 are given to Progress and a message on
+// band 3 ends the stream with a SideBandError. A flush packet ends the
+// stream with io.EOF.
+type SideBandDemuxer struct {
+	// Progress receives the progress messages. It may be nil.
+	Progress func([]byte)
+	// Error receives the error message before Read returns it as a
+	// SideBandError. It may be nil.
+	Error func([]byte)
+
+	scanner  *PacketScanner
+	buf      []byte
+	err      error
+	packSize int64
+}
+
+// NewSideBandDemuxer returns a SideBandDemuxer reading from s.
+func NewSideBandDemuxer(s *PacketScanner) *SideBandDemuxer {
+	return &SideBandDemuxer{scanner: s}
+}
+
+// Read reads the pack data of band 1.
+func (d *SideBandDemuxer) Read(p []byte) (int, error) {
+	for len(d.buf) == 0 {
+		if d.err != nil {
+			return 0, d.err
+		}
+		d.next()
+	}
+	n := copy(p, d.buf)
+	d.buf = d.buf[n:]
+	return n, nil
+}
+
+// WriteTo writes the pack data of band 1 to w, without the intermediate
+// copy of Read.
+func (d *SideBandDemuxer) WriteTo(w io.Writer) (int64, error) {
+	var n int64
+	for {
+		if len(d.buf) > 0 {
+			m, err := w.Write(d.buf)
+			n += int64(m)
+			d.buf = d.buf[m:]
+			if err != nil {
+				return n, err
+			}
+		}
+		if d.err != nil {
+			if d.err == io.EOF {
+				return n, nil
+			}
+			return n, d.err
+		}
+		d.next()
+	}
+}
+
+// next reads the next packet into d.buf or d.err.
+func (d *SideBandDemuxer) next() {
+	if !d.scanner.Scan() {
+		d.err = d.scanner.Err()
+		if d.err == nil {
+			d.err = io.ErrUnexpectedEOF
+		}
+		return
+	}
+	d.handle(d.scanner.Packet())
+}
+
+// handle reads the packet p into d.buf or d.err.
+func (d *SideBandDemuxer) handle(p Packet) {
+	switch p := p.(type) {
+	case FlushPacket:
+		d.scanner.metrics.PackStream(d.packSize)
+		d.err = io.EOF
+	case BytesPacket:
+		if len(p) == 0 {
+			return
+		}
+		switch sp := ParseSideBandPacket(p).(type) {
+		case SideBandMainPacket:
+			d.buf = sp
+			d.packSize += int64(len(sp))
+		case SideBandReportPacket:
+			if d.Progress != nil {
+				d.Progress(sp)
+			}
+		case SideBandErrorPacket:
+			if d.Error != nil {
+				d.Error(sp)
+			}
+			d.err = SideBandError(sp)
+		default:
+			d.err = SyntaxError(fmt.Sprintf("unknown side-band: %#v", p))
+		}
+	default:
+		d.err = SyntaxError(fmt.Sprintf("unexpected packet: %#v", p))
+	}
+}
@@ -0,0 +1,83 @@
+// Modified by Giacomo Tartari
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkt
+
+import (
+	"bytes"
+	"io"
+	"strconv"
+)
+
+// DetectProtocol reads the beginning of a stream, a ref advertisement, a
+// request or a git daemon request, and returns its protocol version, and a
+// reader replaying the consumed bytes followed by the rest of rd. The
+// version is:
+//
+//   - N for a "version N" line, first or after the "# service=" header of a
+//     smart HTTP advertisement,
+//   - 2 for a "command=" line of a protocol v2 request,
+//   - the version extra parameter of a git daemon request,
+//   - 0 otherwise, e.g. for a protocol v0 ref advertisement or request.
+//
+// It returns io.EOF if rd is empty.
+func DetectProtocol(rd io.Reader) (int, io.Reader, error) {
+	var consumed bytes.Buffer
+	s := NewPacketScanner(io.TeeReader(rd, &consumed))
+	replay := io.MultiReader(&consumed, rd)
+	next := func() (BytesPacket, error) {
+		if !s.Scan() {
+			if err := s.Err(); err != nil {
+				return nil, err
+			}
+			return nil, io.EOF
+		}
+		bp, _ := s.Packet().(BytesPacket)
+		return bp, nil
+	}
+
+	bp, err := next()
+	if err != nil {
+		return 0, replay, err
+	}
+	if bytes.HasPrefix(bp, []byte("# service=")) {
+		// The flush ending the service header.
+		if _, err := next(); err != nil {
+			return 0, replay, err
+		}
+		if bp, err = next(); err == io.EOF {
+			return 0, replay, nil
+		} else if err != nil {
+			return 0, replay, err
+		}
+	}
+	switch {
+	case bytes.HasPrefix(bp, []byte("version ")):
+		v, err := strconv.Atoi(string(bytes.TrimSuffix(bp[len("version "):], []byte("\n"))))
+		if err != nil {
+			return 0, replay, SyntaxError("cannot parse the protocol version: " + string(bp))
+		}
+		return v, replay, nil
+	case bytes.HasPrefix(bp, []byte("command=")):
+		return 2, replay, nil
+	case bytes.HasPrefix(bp, []byte("git-")) && bytes.IndexByte(bp, 0) >= 0:
+		req, err := ParseDaemonRequest(bytes.TrimSuffix(bp, []byte("\n")))
+		if err != nil {
+			return 0, replay, err
+		}
+		return req.Version(), replay, nil
+	}
+	return 0, replay, nil
+}
@@ -0,0 +1,121 @@
+// Modified by Giacomo Tartari
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkt
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestDetectProtocol(t *testing.T) {
+	// The packets following the detected ones, larger than the buffer of
+	// the scanner.
+	tail := pktLines(strings.Repeat("x", 5000), strings.Repeat("y", 5000), "0000")
+	tests := []struct {
+		name    string
+		in      string
+		version int
+		err     error
+	}{
+		{
+			name:    "v2 advertisement",
+			in:      pktLines("version 2\n", "agent=git/2.40\n", "ls-refs\n", "0000"),
+			version: 2,
+		},
+		{
+			name:    "smart HTTP v2 advertisement",
+			in:      pktLines("# service=git-upload-pack\n", "0000", "version 2\n", "ls-refs\n", "0000"),
+			version: 2,
+		},
+		{
+			name:    "smart HTTP v1 advertisement",
+			in:      pktLines("# service=git-upload-pack\n", "0000", "version 1\n", oid+" HEAD\x00agent=x\n", "0000"),
+			version: 1,
+		},
+		{
+			name: "smart HTTP v0 advertisement",
+			in:   pktLines("# service=git-upload-pack\n", "0000", oid+" HEAD\x00agent=x\n", "0000"),
+		},
+		{
+			name: "smart HTTP empty advertisement",
+			in:   pktLines("# service=git-upload-pack\n", "0000"),
+		},
+		{
+			name: "v0 advertisement",
+			in:   pktLines(oid+" HEAD\x00multi_ack agent=x\n", oid+" refs/heads/main\n", "0000"),
+		},
+		{
+			name: "v0 request",
+			in:   pktLines("want "+oid+" ofs-delta\n", "0000", "done\n"),
+		},
+		{
+			name:    "v2 request",
+			in:      pktLines("command=ls-refs\n", "agent=git/2.40\n") + "0001" + pktLines("peel\n", "0000"),
+			version: 2,
+		},
+		{
+			name:    "daemon request v2",
+			in:      pktLines("git-upload-pack /repo.git\x00host=example.com\x00\x00version=2\x00"),
+			version: 2,
+		},
+		{
+			name: "daemon request v0",
+			in:   pktLines("git-upload-pack /repo.git\x00host=example.com\x00"),
+		},
+		{
+			name: "empty",
+			err:  io.EOF,
+		},
+		{
+			name: "bad version",
+			in:   pktLines("version two\n", "0000"),
+			err:  SyntaxError(""),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			in := tt.in
+			if tt.err == nil {
+				in += tail
+			}
+			for _, rd := range []io.Reader{strings.NewReader(in), iotest.OneByteReader(strings.NewReader(in))} {
+				v, replay, err := DetectProtocol(rd)
+				if tt.err != nil {
+					var se SyntaxError
+					if err != tt.err && !(errors.As(tt.err, &se) && errors.As(err, &se)) {
+						t.Errorf("got error %v, want %v", err, tt.err)
+					}
+				} else if err != nil {
+					t.Fatal(err)
+				}
+				if v != tt.version {
+					t.Errorf("got version %d, want %d", v, tt.version)
+				}
+				// The replay has the bytes read ahead by the scanner too.
+				bs, err := io.ReadAll(replay)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if string(bs) != in {
+					t.Errorf("replayed %d bytes, want the %d bytes of the input", len(bs), len(in))
+				}
+			}
+		})
+	}
+}
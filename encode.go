@@ -0,0 +1,192 @@
+// Modified by Giacomo Tartari
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkt
+
+import "sync"
+
+// PacketAppender is implemented by the packets that can be serialized into
+// a caller-provided buffer, like the packets of this file and the side-band
+// packets, saving the allocation of EncodeToPktLine.
+type PacketAppender interface {
+	// AppendPktLine appends the serialized packet to dst and returns the
+	// extended buffer. Like EncodeToPktLine, it panics with a
+	// PacketTooLargeError if the packet does not fit; AppendPacket returns
+	// the error instead.
+	AppendPktLine(dst []byte) []byte
+}
+
+// AppendPacket appends the serialized p to dst, with AppendPktLine if p is
+// a PacketAppender. A packet too large to be encoded is reported as a
+// PacketTooLargeError, and dst is returned unchanged.
+func AppendPacket(dst []byte, p Packet) ([]byte, error) {
+	if err := checkPacketSize(p); err != nil {
+		return dst, err
+	}
+	if a, ok := p.(PacketAppender); ok {
+		return a.AppendPktLine(dst), nil
+	}
+	return append(dst, p.EncodeToPktLine()...), nil
+}
+
+const hexDigits = "0123456789abcdef"
+
+// appendHeader appends the length header of a packet of n bytes.
+func appendHeader(dst []byte, n int) []byte {
+	return append(dst, hexDigits[n>>12&0xf], hexDigits[n>>8&0xf], hexDigits[n>>4&0xf], hexDigits[n&0xf])
+}
+
+// appendPayload appends a packet with the payload p, prefixed with the
+// side-band byte band if it is not 0.
+func appendPayload(dst []byte, band byte, p []byte) []byte {
+	hdr := 4
+	if band != 0 {
+		hdr++
+	}
+	if err := checkSize(len(p) + hdr); err != nil {
+		panic(err)
+	}
+	dst = appendHeader(dst, len(p)+hdr)
+	if band != 0 {
+		dst = append(dst, band)
+	}
+	return append(dst, p...)
+}
+
+// encodeBuffers are the buffers the writers encode the packets into.
+var encodeBuffers = sync.Pool{
+	New: func() any {
+		b := make([]byte, 0, 512)
+		return &b
+	},
+}
+
+// encodeTo writes the serialized p to w through a pooled buffer, and
+// returns the number of bytes of the serialized packet.
+func encodeTo(w interface{ Write([]byte) (int, error) }, p Packet) (int, error) {
+	bp := encodeBuffers.Get().(*[]byte)
+	b, err := AppendPacket((*bp)[:0], p)
+	if err != nil {
+		encodeBuffers.Put(bp)
+		return 0, err
+	}
+	_, err = w.Write(b)
+	if cap(b) <= maxPacketSize+1 {
+		*bp = b
+		encodeBuffers.Put(bp)
+	}
+	return len(b), err
+}
+
+// ParseLength decodes the 4 hex digits of the length header hdr, in either
+// case, without the allocation of strconv.ParseUint. ok is false if hdr is
+// not 4 hex digits long.
+func ParseLength(hdr []byte) (n int, ok bool) {
+	if len(hdr) != 4 {
+		return 0, false
+	}
+	for _, c := range hdr {
+		var d byte
+		switch {
+		case '0' <= c && c <= '9':
+			d = c - '0'
+		case 'a' <= c && c <= 'f':
+			d = c - 'a' + 10
+		case 'A' <= c && c <= 'F':
+			d = c - 'A' + 10
+		default:
+			return 0, false
+		}
+		n = n<<4 | int(d)
+	}
+	return n, true
+}
+
+// EncodeLargePayload serializes b as BytesPackets of at most
+// MaxPacketDataSize bytes, as PacketWriter.Write does, instead of panicking
+// like EncodeToPktLine for a payload that does not fit in a packet. An empty
+// b encodes to nothing.
+func EncodeLargePayload(b []byte) []byte {
+	dst := make([]byte, 0, len(b)+(len(b)/MaxPacketDataSize+1)*4)
+	for len(b) > 0 {
+		sz := min(len(b), MaxPacketDataSize)
+		dst = BytesPacket(b[:sz]).AppendPktLine(dst)
+		b = b[sz:]
+	}
+	return dst
+}
+
+// checkPacketSize returns a PacketTooLargeError if p does not fit in a
+// packet.
+func checkPacketSize(p Packet) error {
+	var n int
+	switch p := p.(type) {
+	case BytesPacket:
+		n = len(p) + 4
+	case StringPacket:
+		n = len(p) + 4
+	case ErrorPacket:
+		n = len(p) + 8
+	case SideBandMainPacket:
+		n = len(p) + 5
+	case SideBandReportPacket:
+		n = len(p) + 5
+	case SideBandErrorPacket:
+		n = len(p) + 5
+	}
+	return checkSize(n)
+}
+
+// checkSize returns a PacketTooLargeError if a packet of n bytes, header
+// included, does not fit.
+func checkSize(n int) error {
+	if n > maxPacketSize {
+		return &PacketTooLargeError{Length: n, Max: maxPacketSize}
+	}
+	return nil
+}
+
+// encodeErr serializes p, returning the error of checkPacketSize rather than
+// panicking.
+func encodeErr(p Packet) ([]byte, error) {
+	if err := checkPacketSize(p); err != nil {
+		return nil, err
+	}
+	return p.EncodeToPktLine(), nil
+}
+
+// EncodeToPktLineErr serializes the packet, or returns the
+// PacketTooLargeError EncodeToPktLine panics with.
+func (b BytesPacket) EncodeToPktLineErr() ([]byte, error) { return encodeErr(b) }
+
+// EncodeToPktLineErr serializes the packet, or returns the
+// PacketTooLargeError EncodeToPktLine panics with.
+func (b StringPacket) EncodeToPktLineErr() ([]byte, error) { return encodeErr(b) }
+
+// EncodeToPktLineErr serializes the packet, or returns the
+// PacketTooLargeError EncodeToPktLine panics with.
+func (e ErrorPacket) EncodeToPktLineErr() ([]byte, error) { return encodeErr(e) }
+
+// EncodeToPktLineErr serializes the packet, or returns the
+// PacketTooLargeError EncodeToPktLine panics with.
+func (p SideBandMainPacket) EncodeToPktLineErr() ([]byte, error) { return encodeErr(p) }
+
+// EncodeToPktLineErr serializes the packet, or returns the
+// PacketTooLargeError EncodeToPktLine panics with.
+func (p SideBandReportPacket) EncodeToPktLineErr() ([]byte, error) { return encodeErr(p) }
+
+// EncodeToPktLineErr serializes the packet, or returns the
+// PacketTooLargeError EncodeToPktLine panics with.
+func (p SideBandErrorPacket) EncodeToPktLineErr() ([]byte, error) { return encodeErr(p) }
@@ -0,0 +1,187 @@
+// Modified by Giacomo Tartari
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkt
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// errEncoder is implemented by the packets with EncodeToPktLineErr.
+type errEncoder interface {
+	Packet
+	EncodeToPktLineErr() ([]byte, error)
+}
+
+func TestEncodeToPktLineErr(t *testing.T) {
+	tests := []struct {
+		name string
+		// packet returns a packet whose payload is n bytes long.
+		packet func(n int) errEncoder
+		// max is the largest payload.
+		max int
+	}{
+		{"bytes", func(n int) errEncoder { return BytesPacket(strings.Repeat("a", n)) }, 65531},
+		{"string", func(n int) errEncoder { return StringPacket(strings.Repeat("a", n)) }, 65531},
+		{"error", func(n int) errEncoder { return ErrorPacket(strings.Repeat("a", n)) }, 65527},
+		{"side-band main", func(n int) errEncoder { return SideBandMainPacket(strings.Repeat("a", n)) }, 65530},
+		{"side-band report", func(n int) errEncoder { return SideBandReportPacket(strings.Repeat("a", n)) }, 65530},
+		{"side-band error", func(n int) errEncoder { return SideBandErrorPacket(strings.Repeat("a", n)) }, 65530},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := tt.packet(tt.max)
+			b, err := p.EncodeToPktLineErr()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(b) != maxPacketSize || string(b[:4]) != "ffff" {
+				t.Errorf("got a packet of %d bytes with header %q", len(b), b[:4])
+			}
+			if !bytes.Equal(b, p.EncodeToPktLine()) {
+				t.Error("EncodeToPktLineErr and EncodeToPktLine differ")
+			}
+			if a, err := AppendPacket([]byte("x"), p); err != nil || !bytes.Equal(a, append([]byte("x"), b...)) {
+				t.Errorf("AppendPacket = %d bytes, %v", len(a), err)
+			}
+
+			p = tt.packet(tt.max + 1)
+			want := &PacketTooLargeError{Length: maxPacketSize + 1, Max: maxPacketSize}
+			if _, err := p.EncodeToPktLineErr(); !cmp.Equal(err, error(want)) || !errors.Is(err, ErrPacketTooLarge) {
+				t.Errorf("EncodeToPktLineErr() = %v, want %v", err, want)
+			}
+			if _, err := Encode(p); !cmp.Equal(err, error(want)) {
+				t.Errorf("Encode() = %v, want %v", err, want)
+			}
+			if a, err := AppendPacket([]byte("x"), p); !cmp.Equal(err, error(want)) || string(a) != "x" {
+				t.Errorf("AppendPacket() = %q, %v, want %v", a, err, want)
+			}
+			func() {
+				defer func() {
+					if r := recover(); !cmp.Equal(r, any(want)) {
+						t.Errorf("EncodeToPktLine panicked with %v, want %v", r, want)
+					}
+				}()
+				p.EncodeToPktLine()
+			}()
+		})
+	}
+}
+
+func TestEncodeLargePayload(t *testing.T) {
+	for _, tc := range []struct {
+		n    int
+		want []int
+	}{
+		{0, nil},
+		{1, []int{1}},
+		{MaxPacketDataSize, []int{MaxPacketDataSize}},
+		{MaxPacketDataSize + 1, []int{MaxPacketDataSize, 1}},
+		{3*MaxPacketDataSize + 2, []int{MaxPacketDataSize, MaxPacketDataSize, MaxPacketDataSize, 2}},
+	} {
+		t.Run(fmt.Sprint(tc.n), func(t *testing.T) {
+			payload := payloadOf(tc.n)
+			lens, data := scanLengths(t, EncodeLargePayload(payload))
+			if diff := cmp.Diff(tc.want, lens); diff != "" {
+				t.Errorf("payload lengths mismatch (-want +got):\n%s", diff)
+			}
+			if !bytes.Equal(data, payload) {
+				t.Error("the payloads do not add up to the input")
+			}
+		})
+	}
+}
+
+func TestPacketWriter_split(t *testing.T) {
+	max := MaxPacketDataSize
+	sb := SideBand64kMaxData
+	tests := []struct {
+		name   string
+		packet Packet
+		// want are the payload lengths of the packets written, the side
+		// band included.
+		want []int
+		band byte
+	}{
+		{"bytes", BytesPacket(payloadOf(max)), []int{max}, 0},
+		{"bytes split", BytesPacket(payloadOf(max + 1)), []int{max, 1}, 0},
+		{"string", StringPacket(payloadOf(max)), []int{max}, 0},
+		{"string split", StringPacket(payloadOf(2*max + 1)), []int{max, max, 1}, 0},
+		{"side-band main", SideBandMainPacket(payloadOf(sb)), []int{sb + 1}, 1},
+		{"side-band main split", SideBandMainPacket(payloadOf(sb + 1)), []int{sb + 1, 2}, 1},
+		{"side-band report split", SideBandReportPacket(payloadOf(2*sb + 3)), []int{sb + 1, sb + 1, 4}, 2},
+		{"side-band error split", SideBandErrorPacket(payloadOf(sb + 1)), []int{sb + 1, 2}, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var b bytes.Buffer
+			if err := NewPacketWriter(&b).WritePacket(tt.packet); err != nil {
+				t.Fatal(err)
+			}
+			lens, data := scanLengths(t, b.Bytes())
+			if diff := cmp.Diff(tt.want, lens); diff != "" {
+				t.Errorf("payload lengths mismatch (-want +got):\n%s", diff)
+			}
+			// Each packet keeps the band, and the data adds up.
+			var joined []byte
+			for len(data) > 0 {
+				n := tt.want[0]
+				tt.want = tt.want[1:]
+				chunk := data[:n]
+				data = data[n:]
+				if tt.band != 0 {
+					if chunk[0] != tt.band {
+						t.Fatalf("got band %d, want %d", chunk[0], tt.band)
+					}
+					chunk = chunk[1:]
+				}
+				joined = append(joined, chunk...)
+			}
+			var want []byte
+			switch p := tt.packet.(type) {
+			case StringPacket:
+				want = []byte(p)
+			case BytePayloadPacket:
+				want = p.Bytes()
+			case BytesPacket:
+				want = p
+			}
+			if !bytes.Equal(joined, want) {
+				t.Error("the payloads do not add up to the packet")
+			}
+		})
+	}
+}
+
+func TestPacketWriter_tooLarge(t *testing.T) {
+	var b bytes.Buffer
+	w := NewPacketWriter(&b)
+	if err := w.WritePacket(ErrorPacket(payloadOf(65528))); !errors.Is(err, ErrPacketTooLarge) {
+		t.Fatalf("got error %v, want a PacketTooLargeError", err)
+	}
+	// Nothing was written, and the writer still works.
+	if err := w.WritePacket(ErrorPacket("no")); err != nil {
+		t.Fatal(err)
+	}
+	if got := b.String(); got != "000aERR no" {
+		t.Errorf("got %q, want %q", got, "000aERR no")
+	}
+}
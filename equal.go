@@ -0,0 +1,215 @@
+// Modified by Giacomo Tartari
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkt
+
+import (
+	"bytes"
+	"slices"
+)
+
+// PacketsEqual reports whether a and b encode the same packet. Text packets
+// are compared ignoring one trailing LF, as the pkt-line format makes it
+// optional; BytesPacket and StringPacket with the same payload are equal.
+func PacketsEqual(a, b Packet) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	type equaler interface {
+		Equal(Packet) bool
+	}
+	if e, ok := a.(equaler); ok {
+		return e.Equal(b)
+	}
+	return bytes.Equal(a.EncodeToPktLine(), b.EncodeToPktLine())
+}
+
+func trimLF(bs []byte) []byte {
+	return bytes.TrimSuffix(bs, []byte("\n"))
+}
+
+// Equal reports whether p is a FlushPacket.
+func (FlushPacket) Equal(p Packet) bool {
+	_, ok := p.(FlushPacket)
+	return ok
+}
+
+// Equal reports whether p is a ResponseEndPacket.
+func (ResponseEndPacket) Equal(p Packet) bool {
+	_, ok := p.(ResponseEndPacket)
+	return ok
+}
+
+// Equal reports whether p is a DelimPacket.
+func (DelimPacket) Equal(p Packet) bool {
+	_, ok := p.(DelimPacket)
+	return ok
+}
+
+// Equal reports whether p is a BytesPacket or a StringPacket with the same
+// payload, ignoring one trailing LF.
+func (b BytesPacket) Equal(p Packet) bool {
+	switch o := p.(type) {
+	case BytesPacket:
+		return bytes.Equal(trimLF(b), trimLF(o))
+	case StringPacket:
+		return bytes.Equal(trimLF(b), trimLF([]byte(o)))
+	}
+	return false
+}
+
+// Equal reports whether p is a BytesPacket or a StringPacket with the same
+// payload, ignoring one trailing LF.
+func (b StringPacket) Equal(p Packet) bool {
+	return BytesPacket(b).Equal(p)
+}
+
+// Equal reports whether p is an ErrorPacket with the same message.
+func (e ErrorPacket) Equal(p Packet) bool {
+	o, ok := p.(ErrorPacket)
+	return ok && e == o
+}
+
+// Equal reports whether p is a PackFileIndicatorPacket.
+func (PackFileIndicatorPacket) Equal(p Packet) bool {
+	_, ok := p.(PackFileIndicatorPacket)
+	return ok
+}
+
+// Equal reports whether p is a PackFilePacket with the same data.
+func (b PackFilePacket) Equal(p Packet) bool {
+	o, ok := p.(PackFilePacket)
+	return ok && bytes.Equal(b, o)
+}
+
+// Equal reports whether p is a SideBandMainPacket with the same data.
+func (b SideBandMainPacket) Equal(p Packet) bool {
+	o, ok := p.(SideBandMainPacket)
+	return ok && bytes.Equal(b, o)
+}
+
+// Equal reports whether p is a SideBandReportPacket with the same data.
+func (b SideBandReportPacket) Equal(p Packet) bool {
+	o, ok := p.(SideBandReportPacket)
+	return ok && bytes.Equal(b, o)
+}
+
+// Equal reports whether p is a SideBandErrorPacket with the same data.
+func (b SideBandErrorPacket) Equal(p Packet) bool {
+	o, ok := p.(SideBandErrorPacket)
+	return ok && bytes.Equal(b, o)
+}
+
+// Equal reports whether c and o have the same fields. A nil and an empty
+// Capabilities are equal.
+func (c *UploadRequestChunk) Equal(o *UploadRequestChunk) bool {
+	if c == nil || o == nil {
+		return c == o
+	}
+	c.Resolve()
+	o.Resolve()
+	return slices.Equal(c.Capabilities, o.Capabilities) &&
+		c.WantObjectID == o.WantObjectID &&
+		c.ShallowObjectID == o.ShallowObjectID &&
+		c.DeepenDepth == o.DeepenDepth &&
+		c.DeepenSince == o.DeepenSince &&
+		c.DeepenNotRef == o.DeepenNotRef &&
+		c.FilterSpec == o.FilterSpec &&
+		c.HaveObjectID == o.HaveObjectID &&
+		c.EndOneRound == o.EndOneRound &&
+		c.NoMoreNegotiation == o.NoMoreNegotiation
+}
+
+// Equal reports whether c and o have the same fields. PackRepo is ignored.
+func (c *UploadResponseChunk) Equal(o *UploadResponseChunk) bool {
+	if c == nil || o == nil {
+		return c == o
+	}
+	c.Resolve()
+	o.Resolve()
+	return c.ShallowObjectID == o.ShallowObjectID &&
+		c.UnshallowObjectID == o.UnshallowObjectID &&
+		c.EndOfShallows == o.EndOfShallows &&
+		c.AckObjectID == o.AckObjectID &&
+		c.AckDetail == o.AckDetail &&
+		c.Nak == o.Nak &&
+		bytes.Equal(c.PackStream, o.PackStream) &&
+		bytes.Equal(c.ProgressMessage, o.ProgressMessage) &&
+		c.Keepalive == o.Keepalive &&
+		c.EndOfRequest == o.EndOfRequest &&
+		c.Delim == o.Delim &&
+		c.RemoteError == o.RemoteError
+}
+
+// Equal reports whether c and o have the same fields. A nil and an empty
+// Capabilities are equal.
+func (c *ReceiveRequestChunk) Equal(o *ReceiveRequestChunk) bool {
+	if c == nil || o == nil {
+		return c == o
+	}
+	c.Resolve()
+	o.Resolve()
+	return c.ClientShallow == o.ClientShallow &&
+		slices.Equal(c.Capabilities, o.Capabilities) &&
+		c.OldObjectID == o.OldObjectID &&
+		c.NewObjectID == o.NewObjectID &&
+		c.RefName == o.RefName &&
+		c.EndOfCommands == o.EndOfCommands &&
+		c.StartOfPushCert == o.StartOfPushCert &&
+		c.PushCertHeader == o.PushCertHeader &&
+		c.Pusher == o.Pusher &&
+		c.Pushee == o.Pushee &&
+		c.Nonce == o.Nonce &&
+		c.CertPushOption == o.CertPushOption &&
+		c.EndOfCertPushOptions == o.EndOfCertPushOptions &&
+		bytes.Equal(c.GPGSignaturePart, o.GPGSignaturePart) &&
+		c.EndOfPushCert == o.EndOfPushCert &&
+		c.PushOption == o.PushOption &&
+		c.EndOfPushOptions == o.EndOfPushOptions &&
+		bytes.Equal(c.PackStream, o.PackStream)
+}
+
+// Equal reports whether c and o have the same fields.
+func (c *ReceiveResponseChunk) Equal(o *ReceiveResponseChunk) bool {
+	if c == nil || o == nil {
+		return c == o
+	}
+	c.Resolve()
+	o.Resolve()
+	return c.UnpackStatus == o.UnpackStatus &&
+		c.RefUpdateStatus == o.RefUpdateStatus &&
+		c.RefName == o.RefName &&
+		c.RefUpdateFailMessage == o.RefUpdateFailMessage &&
+		c.RefOption == o.RefOption &&
+		c.RefOptionValue == o.RefOptionValue &&
+		c.EndOfResponse == o.EndOfResponse &&
+		c.Delim == o.Delim &&
+		c.RemoteError == o.RemoteError
+}
+
+// Equal reports whether c and o have the same fields. A nil and an empty
+// Capabilities are equal.
+func (c *InfoRefsResponseChunk) Equal(o *InfoRefsResponseChunk) bool {
+	if c == nil || o == nil {
+		return c == o
+	}
+	return c.ServiceHeader == o.ServiceHeader &&
+		c.ServiceHeaderFlush == o.ServiceHeaderFlush &&
+		c.ProtocolVersion == o.ProtocolVersion &&
+		slices.Equal(c.Capabilities, o.Capabilities) &&
+		c.ObjectID == o.ObjectID &&
+		c.Ref == o.Ref &&
+		c.EndOfRequest == o.EndOfRequest
+}
@@ -0,0 +1,46 @@
+// Modified by Giacomo Tartari
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkt
+
+import "fmt"
+
+// ParseError is returned by the parsers for a malformed stream. It wraps a
+// SyntaxError, which errors.As still finds.
+type ParseError struct {
+	// Index is the index of the offending packet, counting from 0.
+	Index int
+	// Offset is the byte offset of the offending packet in the stream.
+	Offset int64
+	// Packet is the offending packet, encoded. It is nil when the stream
+	// ended early.
+	Packet []byte
+	// State is the state of the parser, e.g. an UploadRequestState, or nil
+	// for an error of the PacketScanner.
+	State any
+	Err   error
+}
+
+func (e *ParseError) Error() string {
+	if e.Packet == nil {
+		return fmt.Sprintf("packet %d at offset %d: %v", e.Index, e.Offset, e.Err)
+	}
+	return fmt.Sprintf("packet %d at offset %d (%q): %v", e.Index, e.Offset, e.Packet, e.Err)
+}
+
+// Unwrap returns the SyntaxError.
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,70 @@
+// Modified by Giacomo Tartari
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkt
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// The events of the two families have Chunk methods of different types, so
+// that no event belongs to both and a type switch on one family cannot match
+// the other's.
+
+func TestReceiveResponseChunk_Event(t *testing.T) {
+	for _, c := range []*ReceiveResponseChunk{
+		NewUnpackOK(),
+		NewUnpackError("index-pack failed"),
+		NewRefResultOK("refs/heads/main"),
+		NewRefResultNG("refs/heads/main", "non-fast-forward"),
+		NewRefOptionChunk("forced-update", ""),
+		NewReceiveResponseEndChunk(),
+		{Delim: true},
+		{RemoteError: "access denied"},
+	} {
+		e := c.Event()
+		t.Run(fmt.Sprintf("%T", e), func(t *testing.T) {
+			if diff := cmp.Diff(c, e.Chunk()); diff != "" {
+				t.Errorf("Chunk() mismatch (-want +got):\n%s", diff)
+			}
+			if got, want := string(e.EncodeToPktLine()), string(c.EncodeToPktLine()); got != want {
+				t.Errorf("EncodeToPktLine() = %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestUploadResponseChunk_Event(t *testing.T) {
+	for _, c := range []*UploadResponseChunk{
+		NewShallowChunk(oid),
+		NewNakChunk(),
+		NewUploadResponseEndChunk(),
+		{Delim: true},
+		{RemoteError: "access denied"},
+	} {
+		e := c.Event()
+		t.Run(fmt.Sprintf("%T", e), func(t *testing.T) {
+			if diff := cmp.Diff(c, e.Chunk()); diff != "" {
+				t.Errorf("Chunk() mismatch (-want +got):\n%s", diff)
+			}
+			if got, want := string(e.EncodeToPktLine()), string(c.EncodeToPktLine()); got != want {
+				t.Errorf("EncodeToPktLine() = %q, want %q", got, want)
+			}
+		})
+	}
+}
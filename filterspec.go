@@ -0,0 +1,243 @@
+// Modified by Giacomo Tartari
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkt
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// FilterKind is the kind of a filter-spec of a partial clone.
+type FilterKind string
+
+// The filter-spec kinds supported by git.
+const (
+	// FilterBlobNone omits all the blobs.
+	FilterBlobNone FilterKind = "blob:none"
+	// FilterBlobLimit omits the blobs of BlobLimit bytes or more.
+	FilterBlobLimit FilterKind = "blob:limit"
+	// FilterTree omits the blobs and trees deeper than TreeDepth from the
+	// root tree.
+	FilterTree FilterKind = "tree"
+	// FilterSparseOID omits the blobs not matched by the sparse-checkout
+	// specification in the blob SparseOID.
+	FilterSparseOID FilterKind = "sparse:oid"
+	// FilterObjectType omits the objects not of type ObjectType.
+	FilterObjectType FilterKind = "object:type"
+	// FilterCombine omits the objects omitted by any of Combine.
+	FilterCombine FilterKind = "combine"
+)
+
+// FilterSpec is a parsed filter-spec, the value of the "filter" line of a
+// protocol v1 request and of the "filter" argument of a protocol v2 fetch
+// command, e.g. "blob:none" or "combine:tree:0+blob:limit=1024". Only the
+// fields of Kind are set.
+type FilterSpec struct {
+	Kind FilterKind
+	// BlobLimit is the size limit of blob:limit, in bytes.
+	BlobLimit uint64
+	// TreeDepth is the depth of tree.
+	TreeDepth uint64
+	// SparseOID is the blob-ish of sparse:oid, e.g. an object ID or
+	// "main:.sparse".
+	SparseOID string
+	// ObjectType is the type of object:type: "blob", "tree", "commit" or
+	// "tag".
+	ObjectType string
+	// Combine are the filters of combine.
+	Combine []FilterSpec
+}
+
+// filterReserved are the characters that must be percent-encoded in the
+// filters of combine, in addition to the whitespace, '%' and '+'.
+const filterReserved = "~`!@#$^&*()[]{}\\;'\",<>?"
+
+// ParseFilterSpec parses a filter-spec. The sizes of blob:limit and the depth
+// of tree accept the k, m and g suffixes, and are expanded by String, as
+// git does before sending them.
+func ParseFilterSpec(s string) (FilterSpec, error) {
+	var f FilterSpec
+	invalid := func() (FilterSpec, error) {
+		return FilterSpec{}, SyntaxError(fmt.Sprintf("invalid filter-spec: %#v", s))
+	}
+	switch {
+	case s == string(FilterBlobNone):
+		f.Kind = FilterBlobNone
+	case strings.HasPrefix(s, string(FilterBlobLimit)+"="):
+		n, ok := parseFilterSize(s[len(FilterBlobLimit)+1:])
+		if !ok {
+			return invalid()
+		}
+		f.Kind, f.BlobLimit = FilterBlobLimit, n
+	case strings.HasPrefix(s, string(FilterTree)+":"):
+		n, ok := parseFilterSize(s[len(FilterTree)+1:])
+		if !ok {
+			return invalid()
+		}
+		f.Kind, f.TreeDepth = FilterTree, n
+	case strings.HasPrefix(s, string(FilterSparseOID)+"="):
+		f.Kind, f.SparseOID = FilterSparseOID, s[len(FilterSparseOID)+1:]
+		if f.SparseOID == "" {
+			return invalid()
+		}
+	case strings.HasPrefix(s, string(FilterObjectType)+"="):
+		f.Kind, f.ObjectType = FilterObjectType, s[len(FilterObjectType)+1:]
+		switch f.ObjectType {
+		case "blob", "tree", "commit", "tag":
+		default:
+			return invalid()
+		}
+	case strings.HasPrefix(s, string(FilterCombine)+":"):
+		f.Kind = FilterCombine
+		for _, sub := range strings.Split(s[len(FilterCombine)+1:], "+") {
+			if sub == "" || strings.ContainsAny(sub, filterReserved) || strings.IndexFunc(sub, unicode.IsSpace) >= 0 {
+				return invalid()
+			}
+			dec, err := decodeFilterSpec(sub)
+			if err != nil {
+				return invalid()
+			}
+			sf, err := ParseFilterSpec(dec)
+			if err != nil {
+				return FilterSpec{}, err
+			}
+			// Nested combines are flattened, as by git.
+			if sf.Kind == FilterCombine {
+				f.Combine = append(f.Combine, sf.Combine...)
+			} else {
+				f.Combine = append(f.Combine, sf)
+			}
+		}
+	default:
+		return invalid()
+	}
+	return f, nil
+}
+
+// String returns the filter-spec as sent on the wire.
+func (f FilterSpec) String() string {
+	switch f.Kind {
+	case FilterBlobNone:
+		return string(FilterBlobNone)
+	case FilterBlobLimit:
+		return string(FilterBlobLimit) + "=" + strconv.FormatUint(f.BlobLimit, 10)
+	case FilterTree:
+		return string(FilterTree) + ":" + strconv.FormatUint(f.TreeDepth, 10)
+	case FilterSparseOID:
+		return string(FilterSparseOID) + "=" + f.SparseOID
+	case FilterObjectType:
+		return string(FilterObjectType) + "=" + f.ObjectType
+	case FilterCombine:
+		subs := make([]string, len(f.Combine))
+		for i, sf := range f.Combine {
+			subs[i] = encodeFilterSpec(sf.String())
+		}
+		return string(FilterCombine) + ":" + strings.Join(subs, "+")
+	}
+	return string(f.Kind)
+}
+
+// Validate checks that the filter-spec can be encoded.
+func (f FilterSpec) Validate() error {
+	if f.Kind == FilterCombine && len(f.Combine) == 0 {
+		return SyntaxError("invalid filter-spec: empty combine")
+	}
+	_, err := ParseFilterSpec(f.String())
+	return err
+}
+
+// Argument returns the "filter" argument of a protocol v2 fetch command.
+func (f FilterSpec) Argument() string {
+	return "filter " + f.String()
+}
+
+// ParseFilterArgument parses the filter-spec of a "filter" argument of a
+// protocol v2 fetch command or a "filter" line of a protocol v1 request,
+// without its trailing LF. It returns false if arg is another argument.
+func ParseFilterArgument(arg string) (FilterSpec, bool, error) {
+	spec, ok := strings.CutPrefix(arg, "filter ")
+	if !ok {
+		return FilterSpec{}, false, nil
+	}
+	f, err := ParseFilterSpec(spec)
+	return f, true, err
+}
+
+// Filter parses the filter-spec of a "filter" line.
+func (c *UploadRequestChunk) Filter() (FilterSpec, error) {
+	return ParseFilterSpec(c.FilterSpec)
+}
+
+// parseFilterSize parses a number with an optional k, m or g suffix, as
+// git_parse_ulong.
+func parseFilterSize(s string) (uint64, bool) {
+	shift := 0
+	if s != "" {
+		switch s[len(s)-1] {
+		case 'k', 'K':
+			shift = 10
+		case 'm', 'M':
+			shift = 20
+		case 'g', 'G':
+			shift = 30
+		}
+		if shift != 0 {
+			s = s[:len(s)-1]
+		}
+	}
+	n, err := strconv.ParseUint(s, 10, 64)
+	if err != nil || n > (1<<64-1)>>shift {
+		return 0, false
+	}
+	return n << shift, true
+}
+
+// encodeFilterSpec percent-encodes a filter of combine.
+func encodeFilterSpec(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c <= ' ' || c >= 0x80 || c == '%' || c == '+' || strings.IndexByte(filterReserved, c) >= 0 {
+			fmt.Fprintf(&b, "%%%02x", c)
+		} else {
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// decodeFilterSpec decodes a percent-encoded filter of combine.
+func decodeFilterSpec(s string) (string, error) {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '%' {
+			b.WriteByte(s[i])
+			continue
+		}
+		if i+2 >= len(s) {
+			return "", SyntaxError("truncated percent-encoding")
+		}
+		n, err := strconv.ParseUint(s[i+1:i+3], 16, 8)
+		if err != nil {
+			return "", SyntaxError("invalid percent-encoding")
+		}
+		b.WriteByte(byte(n))
+		i += 2
+	}
+	return b.String(), nil
+}
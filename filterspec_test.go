@@ -0,0 +1,193 @@
+// Modified by Giacomo Tartari
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkt
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParseFilterSpec(t *testing.T) {
+	tests := []struct {
+		in   string
+		want FilterSpec
+		// out is the encoded filter-spec, if not in.
+		out string
+	}{
+		{in: "blob:none", want: FilterSpec{Kind: FilterBlobNone}},
+		{in: "blob:limit=0", want: FilterSpec{Kind: FilterBlobLimit}},
+		{in: "blob:limit=1024", want: FilterSpec{Kind: FilterBlobLimit, BlobLimit: 1024}},
+		{in: "blob:limit=1k", want: FilterSpec{Kind: FilterBlobLimit, BlobLimit: 1 << 10}, out: "blob:limit=1024"},
+		{in: "blob:limit=2M", want: FilterSpec{Kind: FilterBlobLimit, BlobLimit: 2 << 20}, out: "blob:limit=2097152"},
+		{in: "blob:limit=1g", want: FilterSpec{Kind: FilterBlobLimit, BlobLimit: 1 << 30}, out: "blob:limit=1073741824"},
+		{in: "tree:0", want: FilterSpec{Kind: FilterTree}},
+		{in: "tree:3", want: FilterSpec{Kind: FilterTree, TreeDepth: 3}},
+		{in: "sparse:oid=" + oid, want: FilterSpec{Kind: FilterSparseOID, SparseOID: oid}},
+		{in: "sparse:oid=main:.sparse", want: FilterSpec{Kind: FilterSparseOID, SparseOID: "main:.sparse"}},
+		{in: "object:type=commit", want: FilterSpec{Kind: FilterObjectType, ObjectType: "commit"}},
+		{
+			in: "combine:tree:0+blob:limit=1k",
+			want: FilterSpec{Kind: FilterCombine, Combine: []FilterSpec{
+				{Kind: FilterTree},
+				{Kind: FilterBlobLimit, BlobLimit: 1024},
+			}},
+			out: "combine:tree:0+blob:limit=1024",
+		},
+		{
+			in: "combine:blob:none+sparse:oid=main:dir%2bx%20y%25",
+			want: FilterSpec{Kind: FilterCombine, Combine: []FilterSpec{
+				{Kind: FilterBlobNone},
+				{Kind: FilterSparseOID, SparseOID: "main:dir+x y%"},
+			}},
+		},
+		{
+			in: "combine:sparse:oid=main%3A%7efile",
+			want: FilterSpec{Kind: FilterCombine, Combine: []FilterSpec{
+				{Kind: FilterSparseOID, SparseOID: "main:~file"},
+			}},
+			out: "combine:sparse:oid=main:%7efile",
+		},
+		{
+			in: "combine:combine:tree:1%2bblob:none+object:type=blob",
+			want: FilterSpec{Kind: FilterCombine, Combine: []FilterSpec{
+				{Kind: FilterTree, TreeDepth: 1},
+				{Kind: FilterBlobNone},
+				{Kind: FilterObjectType, ObjectType: "blob"},
+			}},
+			out: "combine:tree:1+blob:none+object:type=blob",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := ParseFilterSpec(tt.in)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("ParseFilterSpec() mismatch (-want +got):\n%s", diff)
+			}
+			out := tt.out
+			if out == "" {
+				out = tt.in
+			}
+			if s := got.String(); s != out {
+				t.Errorf("String() = %q, want %q", s, out)
+			}
+			if err := got.Validate(); err != nil {
+				t.Errorf("Validate() = %v", err)
+			}
+			again, err := ParseFilterSpec(got.String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(got, again); diff != "" {
+				t.Errorf("round trip mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestParseFilterSpec_invalid(t *testing.T) {
+	for _, s := range []string{
+		"",
+		"blob:nonee",
+		"blob:limit",
+		"blob:limit=",
+		"blob:limit=k",
+		"blob:limit=1x",
+		"blob:limit=-1",
+		"blob:limit=20000000000g",
+		"tree:",
+		"tree:-1",
+		"tree:1t",
+		"sparse:oid=",
+		"sparse:path=dir",
+		"object:type=file",
+		"combine:",
+		"combine:blob:none+",
+		"combine:+blob:none",
+		"combine:blob:none+sparse:oid=main:a;b",
+		"combine:blob:none+sparse:oid=main:a b",
+		"combine:sparse:oid=%zz",
+		"combine:sparse:oid=%2",
+		"combine:blob:none+tree:x",
+		"combine:bogus",
+	} {
+		t.Run(s, func(t *testing.T) {
+			_, err := ParseFilterSpec(s)
+			var serr SyntaxError
+			if !errors.As(err, &serr) || !strings.HasPrefix(err.Error(), "invalid filter-spec: ") {
+				t.Errorf("got error %v, want a filter-spec SyntaxError", err)
+			}
+		})
+	}
+}
+
+func TestFilterSpec_Validate(t *testing.T) {
+	for _, f := range []FilterSpec{
+		{},
+		{Kind: "blob:all"},
+		{Kind: FilterSparseOID},
+		{Kind: FilterObjectType, ObjectType: "file"},
+		{Kind: FilterCombine},
+		{Kind: FilterCombine, Combine: []FilterSpec{{Kind: FilterBlobNone}, {Kind: FilterTree, TreeDepth: 1}, {}}},
+	} {
+		if err := f.Validate(); err == nil {
+			t.Errorf("%#v.Validate() = nil, want an error", f)
+		}
+	}
+}
+
+func TestParseFilterArgument(t *testing.T) {
+	tests := []struct {
+		arg  string
+		want FilterSpec
+		ok   bool
+		err  bool
+	}{
+		{arg: "filter blob:none", want: FilterSpec{Kind: FilterBlobNone}, ok: true},
+		{arg: "filter tree:2", want: FilterSpec{Kind: FilterTree, TreeDepth: 2}, ok: true},
+		{arg: "filter blob:limit", ok: true, err: true},
+		{arg: "filter", ok: false},
+		{arg: "want " + oid, ok: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.arg, func(t *testing.T) {
+			got, ok, err := ParseFilterArgument(tt.arg)
+			if ok != tt.ok || (err != nil) != tt.err {
+				t.Fatalf("ParseFilterArgument() = _, %v, %v, want %v, error %v", ok, err, tt.ok, tt.err)
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("ParseFilterArgument() mismatch (-want +got):\n%s", diff)
+			}
+			if ok && !tt.err && got.Argument() != tt.arg {
+				t.Errorf("Argument() = %q, want %q", got.Argument(), tt.arg)
+			}
+		})
+	}
+
+	c := NewFilterChunk("combine:blob:none+tree:0")
+	f, err := c.Filter()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.String() != c.FilterSpec {
+		t.Errorf("Filter() = %q, want %q", f, c.FilterSpec)
+	}
+}
@@ -0,0 +1,123 @@
+// Modified by Giacomo Tartari
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkt
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// TraceWriter is a Trace writing the packets in the format of the
+// GIT_TRACE_PACKET output of git, e.g.
+//
+//	packet:  upload-pack< 0000
+//	packet:  upload-pack> NAK
+//
+// so that the traffic seen by this package can be diffed against a trace of
+// git, once the time and source location git prepends to each line are
+// stripped, e.g. with "cut -c41-". As git does, the LFs are dropped, the
+// other unprintable bytes are escaped in octal, and nothing is written after
+// the first packet of a pack file, which is abbreviated to "PACK ...". The
+// pack files sent without side-band are not pkt-lines and are not traced.
+//
+// TraceWriter can be shared by a scanner and a writer running concurrently.
+type TraceWriter struct {
+	mu       sync.Mutex
+	w        io.Writer
+	identity string
+	pack     bool
+	err      error
+}
+
+// NewTraceWriter returns a TraceWriter writing to w. identity is the name of
+// the program git prints, e.g. "upload-pack", "fetch" or "git".
+func NewTraceWriter(w io.Writer, identity string) *TraceWriter {
+	return &TraceWriter{w: w, identity: identity}
+}
+
+// Err returns the first error that was encountered by the TraceWriter.
+func (t *TraceWriter) Err() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.err
+}
+
+// OnPacketRead writes p as read.
+func (t *TraceWriter) OnPacketRead(p Packet, size int) {
+	t.trace(p, '<')
+}
+
+// OnPacketWritten writes p as written.
+func (t *TraceWriter) OnPacketWritten(p Packet, size int) {
+	t.trace(p, '>')
+}
+
+// OnStateTransition does nothing, git does not trace them.
+func (t *TraceWriter) OnStateTransition(from, to any) {}
+
+func (t *TraceWriter) trace(p Packet, dir byte) {
+	switch p.(type) {
+	case PackFileIndicatorPacket, PackFilePacket:
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	// A packet such as a chunk or a RawPacket can encode to several
+	// pkt-lines.
+	b := p.EncodeToPktLine()
+	for len(b) >= 4 && t.err == nil && !t.pack {
+		sz, ok := ParseLength(b[:4])
+		if !ok {
+			return
+		}
+		line := b[:4]
+		if sz > 3 {
+			if int(sz) > len(b) {
+				return
+			}
+			line = b[4:sz]
+		} else {
+			sz = 4
+		}
+		t.writeLine(line, dir)
+		b = b[sz:]
+	}
+}
+
+// writeLine writes a trace line for the payload of a packet, or the header
+// of a special packet, like packet_trace in git.
+func (t *TraceWriter) writeLine(payload []byte, dir byte) {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "packet: %12s%c ", t.identity, dir)
+	if bytes.HasPrefix(payload, []byte("PACK")) || len(payload) > 0 && bytes.HasPrefix(payload[1:], []byte("PACK")) {
+		buf.WriteString("PACK ...")
+		t.pack = true
+	} else {
+		for _, c := range payload {
+			switch {
+			case c == '\n':
+			case c >= 0x20 && c <= 0x7e:
+				buf.WriteByte(c)
+			default:
+				fmt.Fprintf(&buf, "\\%o", c)
+			}
+		}
+	}
+	buf.WriteByte('\n')
+	_, t.err = t.w.Write(buf.Bytes())
+}
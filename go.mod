@@ -2,3 +2,5 @@
 module github.com/cycloidio/pkt-line
 
 go 1.22
+
+require github.com/google/go-cmp v0.7.0
@@ -0,0 +1,82 @@
+// Modified by Giacomo Tartari
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package http
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/cycloidio/pkt-line/server"
+)
+
+// Authenticator authenticates the client of a request, e.g. BasicAuth, with
+// a principal of the AuthHTTP method. It returns a nil principal for an
+// anonymous request, and an error wrapping server.ErrUnauthenticated to ask
+// the client for credentials.
+type Authenticator func(r *http.Request) (*server.Principal, error)
+
+// Authenticate returns a handler attaching the principal returned by a to
+// the context of the requests served by h. The sessions of InfoRefsHandler
+// and RPCHandler carry it, so that the handlers and the repositories can
+// decide the visible refs and the allowed pushes by principal. A request
+// failing the authentication is answered with 401 Unauthorized, which makes
+// git prompt for credentials, or 403 Forbidden.
+func Authenticate(a Authenticator, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p, err := a(r)
+		if err != nil {
+			if errors.Is(err, server.ErrUnauthenticated) {
+				unauthorized(w, err)
+			} else {
+				http.Error(w, err.Error(), http.StatusForbidden)
+			}
+			return
+		}
+		if p != nil {
+			r = r.WithContext(server.WithPrincipal(r.Context(), p))
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// BasicAuth returns an Authenticator checking the credentials of HTTP basic
+// authentication with check. The principal is named after the user, with
+// the remote address in the "remote_addr" attribute. A request without
+// credentials is anonymous; the handlers requiring a principal return
+// server.ErrUnauthenticated, answered with 401 Unauthorized.
+func BasicAuth(check func(user, password string) bool) Authenticator {
+	return func(r *http.Request) (*server.Principal, error) {
+		user, password, ok := r.BasicAuth()
+		if !ok {
+			return nil, nil
+		}
+		if !check(user, password) {
+			return nil, server.ErrUnauthenticated
+		}
+		return &server.Principal{
+			Name:       user,
+			Method:     server.AuthHTTP,
+			Attributes: map[string]string{"remote_addr": r.RemoteAddr},
+		}, nil
+	}
+}
+
+// unauthorized answers with 401 Unauthorized, asking for basic
+// authentication.
+func unauthorized(w http.ResponseWriter, err error) {
+	w.Header().Set("WWW-Authenticate", `Basic realm="git"`)
+	http.Error(w, err.Error(), http.StatusUnauthorized)
+}
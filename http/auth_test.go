@@ -0,0 +1,100 @@
+// Modified by Giacomo Tartari
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package http
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/cycloidio/pkt-line"
+	"github.com/cycloidio/pkt-line/server"
+)
+
+func TestAuthenticate(t *testing.T) {
+	oid := pkt.ObjectID(strings.Repeat("a", 40))
+	refs := pkt.Refs{
+		{Name: "refs/heads/main", ObjectID: oid},
+		{Name: "refs/private/alice", ObjectID: oid},
+	}
+	// The private refs are only advertised to alice, and only the
+	// authenticated users can push.
+	adv := AdvertiserFunc(func(s *server.Session) error {
+		visible := refs
+		if p := s.Principal(); p == nil || p.Name != "alice" {
+			visible = refs.WithPrefix("refs/heads/")
+		}
+		_, err := (&pkt.Advertisement{Refs: visible}).WriteTo(s.Writer)
+		return err
+	})
+	push := server.SessionHandlerFunc(func(s *server.Session) error {
+		if s.Principal() == nil {
+			return server.ErrUnauthenticated
+		}
+		_, err := s.Writer.Write(pkt.FlushPacket{}.EncodeToPktLine())
+		return err
+	})
+	auth := BasicAuth(func(user, password string) bool {
+		return password == user+"-secret"
+	})
+	mux := http.NewServeMux()
+	mux.Handle("/info/refs", Authenticate(auth, InfoRefsHandler("git-upload-pack", adv)))
+	mux.Handle("/git-receive-pack", Authenticate(auth, RPCHandler("git-receive-pack", push)))
+
+	tests := []struct {
+		name     string
+		method   string
+		url      string
+		user     string
+		password string
+		status   int
+		private  bool
+	}{
+		{"anonymous fetch", "GET", "/info/refs?service=git-upload-pack", "", "", http.StatusOK, false},
+		{"bob fetch", "GET", "/info/refs?service=git-upload-pack", "bob", "bob-secret", http.StatusOK, false},
+		{"alice fetch", "GET", "/info/refs?service=git-upload-pack", "alice", "alice-secret", http.StatusOK, true},
+		{"bad password fetch", "GET", "/info/refs?service=git-upload-pack", "alice", "bob-secret", http.StatusUnauthorized, false},
+		{"anonymous push", "POST", "/git-receive-pack", "", "", http.StatusUnauthorized, false},
+		{"bad password push", "POST", "/git-receive-pack", "bob", "alice-secret", http.StatusUnauthorized, false},
+		{"bob push", "POST", "/git-receive-pack", "bob", "bob-secret", http.StatusOK, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, tt.url, strings.NewReader("0000"))
+			if tt.method == "POST" {
+				r.Header.Set("Content-Type", RequestContentType("git-receive-pack"))
+			}
+			if tt.user != "" {
+				r.SetBasicAuth(tt.user, tt.password)
+			}
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, r)
+			if w.Code != tt.status {
+				t.Fatalf("got status %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+			if tt.status == http.StatusUnauthorized {
+				if got := w.Header().Get("WWW-Authenticate"); got == "" {
+					t.Error("missing WWW-Authenticate header")
+				}
+				return
+			}
+			if got := strings.Contains(w.Body.String(), "refs/private/alice"); got != tt.private {
+				t.Errorf("private ref advertised: got %v, want %v", got, tt.private)
+			}
+		})
+	}
+}
@@ -0,0 +1,131 @@
+// Modified by Giacomo Tartari
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package http
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+)
+
+// UnsupportedEncodingError is returned for a Content-Encoding that is
+// neither gzip nor deflate.
+type UnsupportedEncodingError string
+
+func (e UnsupportedEncodingError) Error() string {
+	return fmt.Sprintf("unsupported Content-Encoding %q", string(e))
+}
+
+// DecodeBody returns the reader of body decompressed according to its
+// Content-Encoding, i.e. "gzip" (or "x-gzip") or "deflate". A body with no
+// encoding or the "identity" one is returned as is. Besides the zlib format
+// of the standard, a raw deflate stream is accepted for "deflate", as sent
+// by some clients. Closing the reader does not close body.
+func DecodeBody(body io.Reader, encoding string) (io.ReadCloser, error) {
+	switch encoding {
+	case "", "identity":
+		return io.NopCloser(body), nil
+	case "gzip", "x-gzip":
+		return gzip.NewReader(body)
+	case "deflate":
+		br := bufio.NewReader(body)
+		if hdr, err := br.Peek(2); err == nil && isZlibHeader(hdr) {
+			return zlib.NewReader(br)
+		}
+		return flate.NewReader(br), nil
+	}
+	return nil, UnsupportedEncodingError(encoding)
+}
+
+// isZlibHeader reports whether hdr is the header of a zlib stream, with the
+// deflate method and a valid check of the header bits.
+func isZlibHeader(hdr []byte) bool {
+	return hdr[0]&0x0f == 8 && (uint16(hdr[0])<<8|uint16(hdr[1]))%31 == 0
+}
+
+// EncodeBody returns the reader of body compressed with encoding, "gzip" or
+// "deflate", e.g. to post a large request. The compression runs as the
+// reader is read, without goroutine; closing the reader stops it, but does
+// not close body.
+func EncodeBody(body io.Reader, encoding string) (io.ReadCloser, error) {
+	r := &encodeReader{body: body}
+	switch encoding {
+	case "gzip", "x-gzip":
+		r.zw = gzip.NewWriter(&r.buf)
+	case "deflate":
+		r.zw = zlib.NewWriter(&r.buf)
+	default:
+		return nil, UnsupportedEncodingError(encoding)
+	}
+	return r, nil
+}
+
+// encodeReaderChunkSize is the size of the reads of the body of an
+// encodeReader.
+const encodeReaderChunkSize = 32 * 1024
+
+// encodeReader compresses the data of body with zw into buf as it is read.
+type encodeReader struct {
+	body  io.Reader
+	zw    io.WriteCloser
+	buf   bytes.Buffer
+	chunk []byte
+	// err is the error returned once buf is drained, io.EOF at the end of
+	// the compressed stream.
+	err error
+}
+
+func (r *encodeReader) Read(p []byte) (int, error) {
+	for r.buf.Len() == 0 && r.err == nil {
+		if r.chunk == nil {
+			r.chunk = make([]byte, encodeReaderChunkSize)
+		}
+		n, err := r.body.Read(r.chunk)
+		if n > 0 {
+			if _, werr := r.zw.Write(r.chunk[:n]); werr != nil {
+				r.err = werr
+				break
+			}
+		}
+		switch {
+		case err == io.EOF:
+			// The end of the compressed stream is written to buf.
+			r.err = r.zw.Close()
+			if r.err == nil {
+				r.err = io.EOF
+			}
+		case err != nil:
+			r.err = err
+		}
+	}
+	if r.buf.Len() > 0 {
+		return r.buf.Read(p)
+	}
+	return 0, r.err
+}
+
+func (r *encodeReader) Close() error {
+	if r.err == nil || r.buf.Len() > 0 {
+		r.err = io.ErrClosedPipe
+		r.buf.Reset()
+	}
+	r.chunk = nil
+	return nil
+}
@@ -0,0 +1,218 @@
+// Modified by Giacomo Tartari
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package http
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/cycloidio/pkt-line"
+	"github.com/cycloidio/pkt-line/server"
+)
+
+// testBody returns a compressible body larger than the chunks of
+// EncodeBody.
+func testBody() []byte {
+	var b bytes.Buffer
+	for i := 0; b.Len() < 100*1024; i++ {
+		b.Write(pkt.BytesPacket(strings.Repeat("have 0123456789abcdef\n", i%7+1)).EncodeToPktLine())
+	}
+	return b.Bytes()
+}
+
+func TestEncodeBody(t *testing.T) {
+	body := testBody()
+	tests := []struct {
+		encoding string
+		// decode decodes the body with the standard library.
+		decode func(io.Reader) (io.Reader, error)
+	}{
+		{"gzip", func(r io.Reader) (io.Reader, error) { return gzip.NewReader(r) }},
+		{"x-gzip", func(r io.Reader) (io.Reader, error) { return gzip.NewReader(r) }},
+		{"deflate", func(r io.Reader) (io.Reader, error) { return zlib.NewReader(r) }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.encoding, func(t *testing.T) {
+			zr, err := EncodeBody(iotest.HalfReader(bytes.NewReader(body)), tt.encoding)
+			if err != nil {
+				t.Fatal(err)
+			}
+			compressed, err := io.ReadAll(iotest.OneByteReader(zr))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := zr.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if len(compressed) >= len(body) {
+				t.Errorf("compressed %d bytes to %d", len(body), len(compressed))
+			}
+			for name, decode := range map[string]func(io.Reader) (io.Reader, error){
+				"standard":   tt.decode,
+				"DecodeBody": func(r io.Reader) (io.Reader, error) { return DecodeBody(r, tt.encoding) },
+			} {
+				dr, err := decode(bytes.NewReader(compressed))
+				if err != nil {
+					t.Fatalf("%s: %v", name, err)
+				}
+				got, err := io.ReadAll(dr)
+				if err != nil {
+					t.Fatalf("%s: %v", name, err)
+				}
+				if !bytes.Equal(got, body) {
+					t.Errorf("%s: decoded %d bytes, want the %d bytes of the body", name, len(got), len(body))
+				}
+			}
+		})
+	}
+}
+
+func TestEncodeBody_errors(t *testing.T) {
+	if _, err := EncodeBody(strings.NewReader(""), "br"); err != UnsupportedEncodingError("br") {
+		t.Errorf("got error %v for br", err)
+	}
+	// The error of the body ends the compressed stream.
+	zr, err := EncodeBody(iotest.TimeoutReader(iotest.OneByteReader(strings.NewReader("abc"))), "gzip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadAll(zr); err != iotest.ErrTimeout {
+		t.Errorf("got error %v, want %v", err, iotest.ErrTimeout)
+	}
+	// A closed reader stops.
+	zr, err = EncodeBody(bytes.NewReader(testBody()), "deflate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	zr.Read(make([]byte, 10))
+	zr.Close()
+	if n, err := zr.Read(make([]byte, 10)); n != 0 || err != io.ErrClosedPipe {
+		t.Errorf("Read() = %d, %v after Close", n, err)
+	}
+}
+
+func TestDecodeBody(t *testing.T) {
+	body := testBody()
+	var raw bytes.Buffer
+	fw, _ := flate.NewWriter(&raw, flate.DefaultCompression)
+	fw.Write(body)
+	fw.Close()
+	for _, tc := range []struct {
+		name, encoding string
+		in             []byte
+	}{
+		{"raw deflate", "deflate", raw.Bytes()},
+		{"identity", "identity", body},
+		{"none", "", body},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			dr, err := DecodeBody(bytes.NewReader(tc.in), tc.encoding)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := io.ReadAll(dr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, body) {
+				t.Errorf("decoded %d bytes, want the %d bytes of the body", len(got), len(body))
+			}
+		})
+	}
+	if _, err := DecodeBody(strings.NewReader(""), "br"); err != UnsupportedEncodingError("br") {
+		t.Errorf("got error %v for br", err)
+	}
+}
+
+// echoSession writes the request back to the client once read.
+var echoSession = server.SessionHandlerFunc(func(s *server.Session) error {
+	b, err := io.ReadAll(s.Reader)
+	if err != nil {
+		return err
+	}
+	_, err = s.Writer.Write(b)
+	return err
+})
+
+func TestClient_Post(t *testing.T) {
+	body := testBody()
+	mux := http.NewServeMux()
+	mux.Handle("/repo.git/git-upload-pack", RPCHandler(UploadPack, echoSession))
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+	for _, encoding := range []string{"", "gzip", "deflate"} {
+		t.Run(encoding, func(t *testing.T) {
+			c := &Client{HTTP: ts.Client(), URL: ts.URL + "/repo.git", ContentEncoding: encoding}
+			resp, err := c.Post(context.Background(), UploadPack, iotest.HalfReader(bytes.NewReader(body)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Close()
+			var got []byte
+			for resp.Scan() {
+				got = append(got, resp.RawBytes()...)
+			}
+			if err := resp.Err(); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, body) {
+				t.Errorf("got %d bytes back, want the %d bytes of the body", len(got), len(body))
+			}
+		})
+	}
+}
+
+func TestRPCHandler_encoding(t *testing.T) {
+	h := RPCHandler(UploadPack, echoSession)
+	tests := []struct {
+		name        string
+		contentType string
+		encoding    string
+		body        string
+		status      int
+	}{
+		{"identity", RequestContentType(UploadPack), "identity", "0000", http.StatusOK},
+		{"content type", "application/octet-stream", "", "0000", http.StatusUnsupportedMediaType},
+		{"unsupported encoding", RequestContentType(UploadPack), "br", "0000", http.StatusUnsupportedMediaType},
+		{"corrupt gzip", RequestContentType(UploadPack), "gzip", "0000", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/git-upload-pack", strings.NewReader(tt.body))
+			r.Header.Set("Content-Type", tt.contentType)
+			if tt.encoding != "" {
+				r.Header.Set("Content-Encoding", tt.encoding)
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+			if w.Code != tt.status {
+				t.Fatalf("got status %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+			if tt.status == http.StatusOK && w.Body.String() != tt.body {
+				t.Errorf("got body %q, want %q", w.Body, tt.body)
+			}
+		})
+	}
+}
@@ -0,0 +1,274 @@
+// Modified by Giacomo Tartari
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package http provides helpers for the smart HTTP transport of Git: the
+// GET of /info/refs and the stateless POST of the service endpoints.
+package http
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+
+	"github.com/cycloidio/pkt-line"
+)
+
+const (
+	// UploadPack is the service of fetches.
+	UploadPack = "git-upload-pack"
+	// ReceivePack is the service of pushes.
+	ReceivePack = "git-receive-pack"
+)
+
+// InfoRefsURL returns the URL of the ref advertisement of service for the
+// repository at base.
+func InfoRefsURL(base, service string) (string, error) {
+	u, err := url.Parse(base)
+	if err != nil {
+		return "", err
+	}
+	u.Path = path.Join(u.Path, "info/refs")
+	u.RawQuery = url.Values{"service": {service}}.Encode()
+	return u.String(), nil
+}
+
+// ServiceURL returns the URL the requests of service are posted to for the
+// repository at base.
+func ServiceURL(base, service string) (string, error) {
+	u, err := url.Parse(base)
+	if err != nil {
+		return "", err
+	}
+	u.Path = path.Join(u.Path, service)
+	return u.String(), nil
+}
+
+// AdvertisementContentType returns the Content-Type of the ref
+// advertisement of service, e.g.
+// "application/x-git-upload-pack-advertisement".
+func AdvertisementContentType(service string) string {
+	return "application/x-" + service + "-advertisement"
+}
+
+// RequestContentType returns the Content-Type of a request to service.
+func RequestContentType(service string) string {
+	return "application/x-" + service + "-request"
+}
+
+// ResultContentType returns the Content-Type of a response of service.
+func ResultContentType(service string) string {
+	return "application/x-" + service + "-result"
+}
+
+// NewInfoRefsRequest returns the request of the ref advertisement of
+// service. A protocol of 1 or 2 is sent in the Git-Protocol header.
+func NewInfoRefsRequest(ctx context.Context, base, service string, protocol int) (*http.Request, error) {
+	u, err := InfoRefsURL(base, service)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", AdvertisementContentType(service)+", */*")
+	setProtocol(req, protocol)
+	return req, nil
+}
+
+// NewServiceRequest returns the request posting body to service.
+func NewServiceRequest(ctx context.Context, base, service string, protocol int, body io.Reader) (*http.Request, error) {
+	u, err := ServiceURL(base, service)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", RequestContentType(service))
+	req.Header.Set("Accept", ResultContentType(service))
+	setProtocol(req, protocol)
+	return req, nil
+}
+
+func setProtocol(req *http.Request, protocol int) {
+	if protocol > 0 {
+		req.Header.Set("Git-Protocol", "version="+strconv.Itoa(protocol))
+	}
+}
+
+// CheckResponse returns an error if resp is not a successful response of
+// the given Content-Type. A server answering /info/refs with another
+// Content-Type only supports the dumb protocol.
+func CheckResponse(resp *http.Response, contentType string) error {
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s %s: %s", resp.Request.Method, resp.Request.URL.Redacted(), resp.Status)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != contentType {
+		return fmt.Errorf("%s %s: unexpected Content-Type %q, want %q", resp.Request.Method, resp.Request.URL.Redacted(), ct, contentType)
+	}
+	return nil
+}
+
+// StripServiceHeader reads the "# service=<service>" line and its flush
+// from the beginning of a ref advertisement and returns the reader of the
+// rest. A response without the header, e.g. of a protocol v2 server
+// answering a non-HTTP transport, is returned as is.
+func StripServiceHeader(r io.Reader, service string) (io.Reader, error) {
+	br := bufio.NewReader(r)
+	hdr, err := br.Peek(4)
+	if err == io.EOF {
+		return br, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	sz, ok := pkt.ParseLength(hdr)
+	if !ok || sz <= 4 {
+		return br, nil
+	}
+	line, err := br.Peek(int(sz))
+	if err != nil || !bytes.HasPrefix(line[4:], []byte("# service=")) {
+		return br, nil
+	}
+	got := string(bytes.TrimSuffix(line[4+len("# service="):], []byte("\n")))
+	if got != service {
+		return nil, pkt.SyntaxError(fmt.Sprintf("unexpected service %q, want %q", got, service))
+	}
+	br.Discard(int(sz))
+	flush, err := br.Peek(4)
+	if err != nil || string(flush) != "0000" {
+		return nil, pkt.SyntaxError("no flush after the service header")
+	}
+	br.Discard(4)
+	return br, nil
+}
+
+// Response is the body of a smart HTTP response, read packet by packet.
+type Response struct {
+	*pkt.PacketScanner
+	// Header is the header of the HTTP response.
+	Header http.Header
+
+	body io.Closer
+}
+
+// Close closes the body of the response.
+func (r *Response) Close() error {
+	return r.body.Close()
+}
+
+// Client performs smart HTTP requests to a repository.
+type Client struct {
+	// HTTP is the client sending the requests, http.DefaultClient if nil.
+	HTTP *http.Client
+	// URL is the URL of the repository, e.g.
+	// "https://example.com/repo.git".
+	URL string
+	// Protocol is the protocol version requested in the Git-Protocol
+	// header, or 0 to not send it.
+	Protocol int
+	// Header is added to the requests, e.g. for authorization.
+	Header http.Header
+	// Options are the options of the PacketScanner of the responses.
+	Options []pkt.Option
+	// ContentEncoding compresses the bodies of the posted requests, with
+	// "gzip" or "deflate", or sends them as is if empty. See EncodeBody.
+	ContentEncoding string
+}
+
+// InfoRefs gets the ref advertisement of service, without the service
+// header. The response must be closed.
+func (c *Client) InfoRefs(ctx context.Context, service string) (*Response, error) {
+	req, err := NewInfoRefsRequest(ctx, c.URL, service, c.Protocol)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.do(req, AdvertisementContentType(service))
+	if err != nil {
+		return nil, err
+	}
+	rd, err := StripServiceHeader(resp.Body, service)
+	if err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+	return &Response{
+		PacketScanner: pkt.NewPacketScanner(rd, c.Options...),
+		Header:        resp.Header,
+		body:          resp.Body,
+	}, nil
+}
+
+// Post posts the request read from body to service. The response must be
+// closed.
+func (c *Client) Post(ctx context.Context, service string, body io.Reader) (*Response, error) {
+	// The compressed body is closed by the transport once sent.
+	var zr io.ReadCloser
+	if c.ContentEncoding != "" {
+		var err error
+		if zr, err = EncodeBody(body, c.ContentEncoding); err != nil {
+			return nil, err
+		}
+		body = zr
+	}
+	req, err := NewServiceRequest(ctx, c.URL, service, c.Protocol, body)
+	if err != nil {
+		if zr != nil {
+			zr.Close()
+		}
+		return nil, err
+	}
+	if c.ContentEncoding != "" {
+		req.Header.Set("Content-Encoding", c.ContentEncoding)
+	}
+	resp, err := c.do(req, ResultContentType(service))
+	if err != nil {
+		return nil, err
+	}
+	return &Response{
+		PacketScanner: pkt.NewPacketScanner(resp.Body, c.Options...),
+		Header:        resp.Header,
+		body:          resp.Body,
+	}, nil
+}
+
+func (c *Client) do(req *http.Request, contentType string) (*http.Response, error) {
+	for k, vs := range c.Header {
+		for _, v := range vs {
+			req.Header.Add(k, v)
+		}
+	}
+	hc := c.HTTP
+	if hc == nil {
+		hc = http.DefaultClient
+	}
+	resp, err := hc.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if err := CheckResponse(resp, contentType); err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+	return resp, nil
+}
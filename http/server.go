@@ -0,0 +1,187 @@
+// Modified by Giacomo Tartari
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package http
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/cycloidio/pkt-line"
+	"github.com/cycloidio/pkt-line/server"
+)
+
+// Advertiser writes the ref advertisement of a service to s.Writer, e.g. a
+// pkt.Advertisement, or the CapabilityAdvertisement of a V2Server when
+// s.Protocol is 2.
+type Advertiser interface {
+	Advertise(s *server.Session) error
+}
+
+// AdvertiserFunc is an adapter to allow the use of ordinary functions as
+// Advertiser.
+type AdvertiserFunc func(s *server.Session) error
+
+// Advertise calls f(s).
+func (f AdvertiserFunc) Advertise(s *server.Session) error {
+	return f(s)
+}
+
+// InfoRefsHandler returns the handler of the GET of /info/refs for service.
+// It writes the "# service=" header and its flush, unless protocol v2 was
+// requested in the Git-Protocol header, and then the advertisement of
+// advertiser. Requests of the dumb protocol, without the service in the
+// query, are refused.
+func InfoRefsHandler(service string, advertiser Advertiser) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if got := r.URL.Query().Get("service"); got != service {
+			http.Error(w, fmt.Sprintf("unsupported service %q", got), http.StatusForbidden)
+			return
+		}
+		setNoCache(w.Header())
+		w.Header().Set("Content-Type", AdvertisementContentType(service))
+		if r.Method == http.MethodHead {
+			return
+		}
+
+		rw := &responseWriter{ResponseWriter: w}
+		s := server.NewSession(r.Context(), service, r.Body, rw)
+		s.Protocol = ParseProtocol(r.Header.Get("Git-Protocol"))
+		if s.Protocol != 2 {
+			hdr, err := pkt.Encode(&pkt.InfoRefsResponseChunk{ServiceHeader: service})
+			if err != nil {
+				rw.fail(err)
+				return
+			}
+			if _, err := rw.Write(hdr); err != nil {
+				return
+			}
+			if _, err := rw.Write(pkt.FlushPacket{}.EncodeToPktLine()); err != nil {
+				return
+			}
+		}
+		if err := advertiser.Advertise(s); err != nil {
+			rw.fail(err)
+			return
+		}
+		rw.Flush()
+	})
+}
+
+// RPCHandler returns the handler of the POST of service, e.g. of
+// "/git-upload-pack". It checks the Content-Type of the request, decodes a
+// compressed request body with DecodeBody, and serves the session with
+// handler, e.g. a stateless V2Server. The response is streamed in chunks,
+// flushed with the packet writers of the handler.
+func RPCHandler(service string, handler server.SessionHandler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", "POST")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if ct := r.Header.Get("Content-Type"); ct != RequestContentType(service) {
+			http.Error(w, fmt.Sprintf("unexpected Content-Type %q", ct), http.StatusUnsupportedMediaType)
+			return
+		}
+		body, err := DecodeBody(r.Body, r.Header.Get("Content-Encoding"))
+		if _, ok := err.(UnsupportedEncodingError); ok {
+			http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		defer body.Close()
+		setNoCache(w.Header())
+		w.Header().Set("Content-Type", ResultContentType(service))
+
+		rw := &responseWriter{ResponseWriter: w}
+		s := server.NewSession(r.Context(), service, body, rw)
+		s.Protocol = ParseProtocol(r.Header.Get("Git-Protocol"))
+		if err := handler.ServeSession(s); err != nil {
+			rw.fail(err)
+			return
+		}
+		rw.Flush()
+	})
+}
+
+// ParseProtocol returns the protocol version requested in a Git-Protocol
+// header, e.g. 2 for "version=2", or 0 if there is none. The header is a
+// colon separated list of parameters, the highest version wins.
+func ParseProtocol(h string) int {
+	v := 0
+	for _, p := range strings.Split(h, ":") {
+		s, ok := strings.CutPrefix(p, "version=")
+		if !ok {
+			continue
+		}
+		if n, err := strconv.Atoi(s); err == nil && n > v {
+			v = n
+		}
+	}
+	return v
+}
+
+// setNoCache sets the headers of git http-backend preventing the caching
+// of the responses.
+func setNoCache(h http.Header) {
+	h.Set("Expires", "Fri, 01 Jan 1980 00:00:00 GMT")
+	h.Set("Pragma", "no-cache")
+	h.Set("Cache-Control", "no-cache, max-age=0, must-revalidate")
+}
+
+// responseWriter records whether the response has started, so that an error
+// can still be reported with its status code, and flushes the response on
+// Flush, which the packet writers call at the flush packets.
+type responseWriter struct {
+	http.ResponseWriter
+	written bool
+}
+
+func (w *responseWriter) Write(p []byte) (int, error) {
+	w.written = true
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *responseWriter) Flush() error {
+	return http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+// fail reports err as an internal server error if nothing was written yet,
+// or as 401 Unauthorized for server.ErrUnauthenticated, e.g. returned by a
+// handler refusing an anonymous push. Afterwards the status is sent
+// already, and the handler is expected to have reported the error in the
+// response, e.g. as an error packet.
+func (w *responseWriter) fail(err error) {
+	if w.written {
+		return
+	}
+	if errors.Is(err, server.ErrUnauthenticated) {
+		unauthorized(w.ResponseWriter, err)
+		return
+	}
+	http.Error(w.ResponseWriter, err.Error(), http.StatusInternalServerError)
+}
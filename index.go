@@ -0,0 +1,190 @@
+// Modified by Giacomo Tartari
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkt
+
+import (
+	"bytes"
+	"io"
+	"strconv"
+	"sync"
+)
+
+// PacketKind is the kind of an indexed packet.
+type PacketKind int
+
+const (
+	// DataPacketKind is a packet with a payload.
+	DataPacketKind PacketKind = iota
+	// FlushPacketKind is a flush packet.
+	FlushPacketKind
+	// DelimPacketKind is a delim packet.
+	DelimPacketKind
+	// ErrorPacketKind is an "ERR" packet.
+	ErrorPacketKind
+	// PackFileKind is the pack file, from its "PACK" signature to the end
+	// of the stream.
+	PackFileKind
+	// ResponseEndPacketKind is a response-end packet.
+	ResponseEndPacketKind
+)
+
+func (k PacketKind) String() string {
+	switch k {
+	case DataPacketKind:
+		return "data"
+	case FlushPacketKind:
+		return "flush"
+	case DelimPacketKind:
+		return "delim"
+	case ErrorPacketKind:
+		return "error"
+	case PackFileKind:
+		return "pack"
+	case ResponseEndPacketKind:
+		return "response-end"
+	}
+	return "PacketKind(" + strconv.Itoa(int(k)) + ")"
+}
+
+// IndexEntry is the position of a packet in a recorded stream.
+type IndexEntry struct {
+	Offset int64
+	// Length is the encoded length, including the length header.
+	Length int64
+	Kind   PacketKind
+}
+
+// PacketIndex is the list of the packet boundaries of a recorded stream. It
+// allows parsing parts of the stream independently, e.g. in parallel.
+type PacketIndex struct {
+	r       io.ReaderAt
+	Entries []IndexEntry
+}
+
+// IndexPackets reads the length headers of the packets in the first size
+// bytes of r. Only the headers are read, not the payloads.
+func IndexPackets(r io.ReaderAt, size int64) (*PacketIndex, error) {
+	x := &PacketIndex{r: r}
+	var hdr [8]byte
+	for off := int64(0); off < size; {
+		if size-off < 4 {
+			return nil, SyntaxError("truncated packet at offset " + strconv.FormatInt(off, 10))
+		}
+		n, err := r.ReadAt(hdr[:min(8, size-off)], off)
+		if n < 4 {
+			return nil, err
+		}
+		if bytes.Equal(hdr[:4], []byte("PACK")) {
+			x.Entries = append(x.Entries, IndexEntry{Offset: off, Length: size - off, Kind: PackFileKind})
+			break
+		}
+		sz, ok := ParseLength(hdr[:4])
+		if !ok {
+			return nil, SyntaxError("cannot parse the packet length at offset " + strconv.FormatInt(off, 10))
+		}
+		e := IndexEntry{Offset: off, Length: int64(sz)}
+		switch {
+		case sz == 0:
+			e.Kind, e.Length = FlushPacketKind, 4
+		case sz == 1:
+			e.Kind, e.Length = DelimPacketKind, 4
+		case sz == 2:
+			e.Kind, e.Length = ResponseEndPacketKind, 4
+		case sz < 4:
+			return nil, SyntaxError("unknown special packet at offset " + strconv.FormatInt(off, 10))
+		case off+e.Length > size:
+			return nil, SyntaxError("truncated packet at offset " + strconv.FormatInt(off, 10))
+		case n == 8 && bytes.Equal(hdr[4:8], []byte("ERR ")):
+			e.Kind = ErrorPacketKind
+		}
+		x.Entries = append(x.Entries, e)
+		off += e.Length
+	}
+	return x, nil
+}
+
+// Section is a run of packets of an index: the packets up to and including
+// a flush packet, or the pack file.
+type Section struct {
+	// First and Last are the indices of the first and the last entries.
+	First, Last int
+	Offset      int64
+	Length      int64
+}
+
+// Sections splits the index into sections. An advertisement, a negotiation
+// round and a pack file each end up in their own sections.
+func (x *PacketIndex) Sections() []Section {
+	var secs []Section
+	first := 0
+	for i, e := range x.Entries {
+		if e.Kind == PackFileKind && i > first {
+			secs = append(secs, x.section(first, i-1))
+			first = i
+		}
+		if e.Kind == FlushPacketKind || e.Kind == PackFileKind || i == len(x.Entries)-1 {
+			secs = append(secs, x.section(first, i))
+			first = i + 1
+		}
+	}
+	return secs
+}
+
+func (x *PacketIndex) section(first, last int) Section {
+	start := x.Entries[first].Offset
+	end := x.Entries[last].Offset + x.Entries[last].Length
+	return Section{First: first, Last: last, Offset: start, Length: end - start}
+}
+
+// SectionReader returns a reader for the bytes of s.
+func (x *PacketIndex) SectionReader(s Section) *io.SectionReader {
+	return io.NewSectionReader(x.r, s.Offset, s.Length)
+}
+
+// Scanner returns a new PacketScanner reading the packets of s.
+func (x *PacketIndex) Scanner(s Section) *PacketScanner {
+	return NewPacketScanner(x.SectionReader(s))
+}
+
+// ParseSections calls fn with a scanner for every section, running at most
+// parallelism calls concurrently. It returns the error of the lowest section
+// that failed, if any. A parallelism of zero or less means one goroutine per
+// section.
+func (x *PacketIndex) ParseSections(parallelism int, fn func(i int, s Section, sc *PacketScanner) error) error {
+	secs := x.Sections()
+	if parallelism <= 0 {
+		parallelism = len(secs)
+	}
+	errs := make([]error, len(secs))
+	sem := make(chan struct{}, max(parallelism, 1))
+	var wg sync.WaitGroup
+	for i, s := range secs {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, s Section) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			errs[i] = fn(i, s, x.Scanner(s))
+		}(i, s)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
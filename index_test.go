@@ -0,0 +1,215 @@
+// Modified by Giacomo Tartari
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkt
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// pktLines encodes lines as packets, except "0000" that is a flush.
+func pktLines(lines ...string) string {
+	var b strings.Builder
+	for _, l := range lines {
+		if l == "0000" {
+			b.WriteString(l)
+			continue
+		}
+		fmt.Fprintf(&b, "%04x%s", len(l)+4, l)
+	}
+	return b.String()
+}
+
+func TestIndexPackets(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want []IndexEntry
+		err  bool
+	}{
+		{
+			name: "packets",
+			in:   pktLines("a\n", "ERR no\n") + "0000" + "0001" + "0002" + pktLines("b") + "PACKdata",
+			want: []IndexEntry{
+				{Offset: 0, Length: 6, Kind: DataPacketKind},
+				{Offset: 6, Length: 11, Kind: ErrorPacketKind},
+				{Offset: 17, Length: 4, Kind: FlushPacketKind},
+				{Offset: 21, Length: 4, Kind: DelimPacketKind},
+				{Offset: 25, Length: 4, Kind: ResponseEndPacketKind},
+				{Offset: 29, Length: 5, Kind: DataPacketKind},
+				{Offset: 34, Length: 8, Kind: PackFileKind},
+			},
+		},
+		{
+			name: "pack file only",
+			in:   "PACKdata",
+			want: []IndexEntry{{Offset: 0, Length: 8, Kind: PackFileKind}},
+		},
+		{
+			// The payload is shorter than the header of "ERR ".
+			name: "short packet",
+			in:   pktLines("E"),
+			want: []IndexEntry{{Offset: 0, Length: 5, Kind: DataPacketKind}},
+		},
+		{
+			name: "empty",
+		},
+		{
+			name: "truncated header",
+			in:   pktLines("a\n") + "00",
+			err:  true,
+		},
+		{
+			name: "truncated payload",
+			in:   pktLines("a\n") + "000aabc",
+			err:  true,
+		},
+		{
+			name: "bad header",
+			in:   "zzzz",
+			err:  true,
+		},
+		{
+			name: "unknown special packet",
+			in:   "0003",
+			err:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			x, err := IndexPackets(strings.NewReader(tt.in), int64(len(tt.in)))
+			if tt.err {
+				var se SyntaxError
+				if !errors.As(err, &se) {
+					t.Errorf("got error %v, want a SyntaxError", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(tt.want, x.Entries); diff != "" {
+				t.Errorf("entries mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestPacketIndex_Sections(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want []Section
+	}{
+		{
+			// The pack file follows the packets of the last section
+			// without a flush.
+			name: "before the pack file",
+			in:   pktLines("a\n", "0000", "b\n", "c\n") + "PACKdata",
+			want: []Section{
+				{First: 0, Last: 1, Offset: 0, Length: 10},
+				{First: 2, Last: 3, Offset: 10, Length: 12},
+				{First: 4, Last: 4, Offset: 22, Length: 8},
+			},
+		},
+		{
+			name: "after a flush",
+			in:   pktLines("a\n", "0000") + "PACKdata",
+			want: []Section{
+				{First: 0, Last: 1, Offset: 0, Length: 10},
+				{First: 2, Last: 2, Offset: 10, Length: 8},
+			},
+		},
+		{
+			name: "without flush",
+			in:   pktLines("a\n", "0000", "b\n"),
+			want: []Section{
+				{First: 0, Last: 1, Offset: 0, Length: 10},
+				{First: 2, Last: 2, Offset: 10, Length: 6},
+			},
+		},
+		{
+			name: "delim and response-end",
+			in:   pktLines("a\n") + "0001" + pktLines("b\n") + "0002" + "0000",
+			want: []Section{{First: 0, Last: 4, Offset: 0, Length: 24}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			x, err := IndexPackets(strings.NewReader(tt.in), int64(len(tt.in)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			secs := x.Sections()
+			if diff := cmp.Diff(tt.want, secs); diff != "" {
+				t.Errorf("sections mismatch (-want +got):\n%s", diff)
+			}
+			// The sections cover the input.
+			var b strings.Builder
+			for _, s := range secs {
+				bs := make([]byte, s.Length)
+				if _, err := x.SectionReader(s).Read(bs); err != nil {
+					t.Fatal(err)
+				}
+				b.Write(bs)
+			}
+			if b.String() != tt.in {
+				t.Errorf("sections read %q, want %q", b.String(), tt.in)
+			}
+		})
+	}
+}
+
+func TestPacketIndex_ParseSections(t *testing.T) {
+	in := pktLines("0\n", "0000", "1\n", "0000", "2\n", "0000", "3\n", "0000")
+	x, err := IndexPackets(strings.NewReader(in), int64(len(in)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, parallelism := range []int{1, 2, 0} {
+		t.Run(fmt.Sprint(parallelism), func(t *testing.T) {
+			got := make([]string, 4)
+			err := x.ParseSections(parallelism, func(i int, s Section, sc *PacketScanner) error {
+				if !sc.Scan() {
+					return sc.Err()
+				}
+				p, _ := sc.Packet().(BytesPacket)
+				got[i] = string(p)
+				switch i {
+				case 1:
+					// The lowest failed section wins even when it fails
+					// last.
+					time.Sleep(10 * time.Millisecond)
+					return errors.New("section 1")
+				case 3:
+					return errors.New("section 3")
+				}
+				return nil
+			})
+			if err == nil || err.Error() != "section 1" {
+				t.Errorf("got error %v, want %q", err, "section 1")
+			}
+			if diff := cmp.Diff([]string{"0\n", "1\n", "2\n", "3\n"}, got); diff != "" {
+				t.Errorf("packets mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -47,6 +47,31 @@ type InfoRefsResponseChunk struct {
 	EndOfRequest       bool
 }
 
+// Validate checks that the chunk can be encoded.
+func (c *InfoRefsResponseChunk) Validate() error {
+	v := NewChunkValidator("InfoRefsResponseChunk")
+	ref := c.ObjectID != "" || c.Ref != ""
+	v.Kind("ServiceHeader", c.ServiceHeader != "")
+	v.Kind("ServiceHeaderFlush", c.ServiceHeaderFlush)
+	v.Kind("ProtocolVersion", c.ProtocolVersion != 0)
+	v.Kind("ObjectID, Ref", ref)
+	v.Kind("Capabilities", len(c.Capabilities) != 0 && !ref)
+	v.Kind("EndOfRequest", c.EndOfRequest)
+	if ref && (c.ObjectID == "" || c.Ref == "") {
+		v.Fail("a ref needs ObjectID and Ref")
+	}
+	if !ref && len(c.Capabilities) > 1 {
+		v.Fail("a protocol v2 capability line needs exactly one capability")
+	}
+	for _, cp := range c.Capabilities {
+		v.Line("Capabilities", cp)
+	}
+	v.Line("ServiceHeader", c.ServiceHeader)
+	v.Line("ObjectID", c.ObjectID)
+	v.Line("Ref", c.Ref)
+	return v.Err()
+}
+
 // EncodeToPktLine serializes the chunk.
 func (c *InfoRefsResponseChunk) EncodeToPktLine() []byte {
 	if c.ServiceHeader != "" {
@@ -79,14 +104,15 @@ func (c *InfoRefsResponseChunk) EncodeToPktLine() []byte {
 // The usage is same as bufio.Scanner.
 type InfoRefsResponse struct {
 	scanner *PacketScanner
+	cfg     *Config
 	state   infoRefsResponseState
 	err     error
 	curr    *InfoRefsResponseChunk
 }
 
 // NewInfoRefsResponse returns a new InfoRefsResponse to read from rd.
-func NewInfoRefsResponse(rd io.Reader) (r *InfoRefsResponse) {
-	return &InfoRefsResponse{scanner: NewPacketScanner(rd)}
+func NewInfoRefsResponse(rd io.Reader, opts ...Option) (r *InfoRefsResponse) {
+	return &InfoRefsResponse{scanner: NewPacketScanner(rd, opts...), cfg: NewConfig(opts...)}
 }
 
 // Err returns the first non-EOF error that was encountered by the
@@ -100,11 +126,38 @@ func (r *InfoRefsResponse) Chunk() *InfoRefsResponseChunk {
 	return r.curr
 }
 
+// Reset prepares r to read the next document of its input, e.g. the
+// response following an advertisement in a capture of a stateless HTTP
+// exchange, reusing its buffers. See PacketScanner.Reset.
+func (r *InfoRefsResponse) Reset() {
+	r.scanner.Reset()
+	*r = InfoRefsResponse{scanner: r.scanner, cfg: r.cfg}
+}
+
+// Rest returns the unread part of the input, e.g. to continue with the next
+// exchange of a stateful connection once Scan returned false at the end of
+// the advertisement. r must not be used afterwards.
+func (r *InfoRefsResponse) Rest() io.Reader {
+	return r.scanner.Rest()
+}
+
 // Scan advances the scanner to the next chunk. It returns false when the scan
 // stops, either by reaching the end of the input or an error. After Scan
 // returns false, the Err method will return any error that occurred during
 // scanning, except that if it was io.EOF, Err will return nil.
+// A malformed stream is reported as a ParseError.
 func (r *InfoRefsResponse) Scan() bool {
+	from := r.state
+	ok := r.scan()
+	r.scanner.TraceStateTransition(from, r.state)
+	if ok {
+		return true
+	}
+	r.err = r.scanner.NewParseError(r.err, r.state)
+	return false
+}
+
+func (r *InfoRefsResponse) scan() bool {
 	if r.err != nil || r.state == infoRefsResponseEnd {
 		return false
 	}
@@ -175,7 +228,9 @@ transition:
 		if ver == 2 {
 			r.state = infoRefsResponseScanProtocolV2Capabilities
 		} else {
-			r.state = infoRefsResponseScanRefs
+			// As without the version line, the capabilities follow the
+			// first ref.
+			r.state = infoRefsResponseScanCapabilities
 		}
 		r.curr = &InfoRefsResponseChunk{
 			ProtocolVersion: ver,
@@ -195,16 +250,15 @@ transition:
 				r.err = SyntaxError("cannot split into two: " + string(p))
 				return false
 			}
-			caps := []string{}
-			if capStr := strings.TrimSuffix(string(zss[1]), "\n"); capStr != "" {
-				// This is to avoid strings.Split("", " ") => []string{""}.
-				caps = strings.Split(capStr, " ")
-			}
+			caps := r.cfg.splitCapabilities(strings.TrimSuffix(string(zss[1]), "\n"))
 			ss := strings.SplitN(string(zss[0]), " ", 2)
 			if len(ss) != 2 {
 				r.err = SyntaxError("cannot split into two: " + string(zss[0]))
 				return false
 			}
+			if r.err = r.checkRef(ss[0], ss[1]); r.err != nil {
+				return false
+			}
 			r.state = infoRefsResponseScanRefs
 			r.curr = &InfoRefsResponseChunk{
 				Capabilities: caps,
@@ -230,6 +284,9 @@ transition:
 				r.err = SyntaxError("cannot split into two: " + string(p))
 				return false
 			}
+			if r.err = r.checkRef(ss[0], ss[1]); r.err != nil {
+				return false
+			}
 			r.curr = &InfoRefsResponseChunk{
 				ObjectID: ss[0],
 				Ref:      strings.TrimSuffix(ss[1], "\n"),
@@ -259,3 +316,16 @@ transition:
 	}
 	panic("impossible state")
 }
+
+// checkRef validates an advertised ref in strict mode. The peeled tags end
+// with "^{}", and an empty repository advertises its capabilities on the
+// "capabilities^{}" ref with the zero ID.
+func (r *InfoRefsResponse) checkRef(oid, ref string) error {
+	if err := r.cfg.checkObjectID(oid); err != nil {
+		return err
+	}
+	if ref == "capabilities^{}" {
+		return nil
+	}
+	return r.cfg.CheckRefName(strings.TrimSuffix(ref, "^{}"))
+}
@@ -0,0 +1,112 @@
+// Modified by Giacomo Tartari
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkt
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestInfoRefsResponse(t *testing.T) {
+	head := "0000000000000000000000000000000000000001"
+	main := "0000000000000000000000000000000000000002"
+	for _, tc := range []struct {
+		name string
+		in   string
+		want []*InfoRefsResponseChunk
+	}{
+		{
+			name: "v0",
+			in: pktLines(head+" HEAD\x00multi_ack symref=HEAD:refs/heads/main\n",
+				main+" refs/heads/main\n", "0000"),
+			want: []*InfoRefsResponseChunk{
+				{ObjectID: head, Ref: "HEAD", Capabilities: []string{"multi_ack", "symref=HEAD:refs/heads/main"}},
+				{ObjectID: main, Ref: "refs/heads/main"},
+				{EndOfRequest: true},
+			},
+		},
+		{
+			// The capabilities are on the first ref after the version line
+			// too, and not part of its name.
+			name: "v1",
+			in: pktLines("version 1\n",
+				head+" HEAD\x00multi_ack symref=HEAD:refs/heads/main\n",
+				main+" refs/heads/main\n", "0000"),
+			want: []*InfoRefsResponseChunk{
+				{ProtocolVersion: 1},
+				{ObjectID: head, Ref: "HEAD", Capabilities: []string{"multi_ack", "symref=HEAD:refs/heads/main"}},
+				{ObjectID: main, Ref: "refs/heads/main"},
+				{EndOfRequest: true},
+			},
+		},
+		{
+			name: "v1 over HTTP",
+			in: pktLines("# service=git-upload-pack\n", "0000", "version 1\n",
+				head+" HEAD\x00multi_ack\n", "0000"),
+			want: []*InfoRefsResponseChunk{
+				{ServiceHeader: "git-upload-pack"},
+				{ServiceHeaderFlush: true},
+				{ProtocolVersion: 1},
+				{ObjectID: head, Ref: "HEAD", Capabilities: []string{"multi_ack"}},
+				{EndOfRequest: true},
+			},
+		},
+		{
+			name: "v1 empty repository",
+			in: pktLines("version 1\n",
+				strings.Repeat("0", 40)+" capabilities^{}\x00agent=git/2.40\n", "0000"),
+			want: []*InfoRefsResponseChunk{
+				{ProtocolVersion: 1},
+				{ObjectID: strings.Repeat("0", 40), Ref: "capabilities^{}", Capabilities: []string{"agent=git/2.40"}},
+				{EndOfRequest: true},
+			},
+		},
+		{
+			name: "v2",
+			in:   pktLines("version 2\n", "agent=git/2.40\n", "ls-refs=unborn\n", "0000"),
+			want: []*InfoRefsResponseChunk{
+				{ProtocolVersion: 2},
+				{Capabilities: []string{"agent=git/2.40"}},
+				{Capabilities: []string{"ls-refs=unborn"}},
+				{EndOfRequest: true},
+			},
+		},
+	} {
+		r := NewInfoRefsResponse(strings.NewReader(tc.in))
+		var got []*InfoRefsResponseChunk
+		for r.Scan() {
+			got = append(got, r.Chunk())
+		}
+		if err := r.Err(); err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+		if diff := cmp.Diff(tc.want, got); diff != "" {
+			t.Errorf("%s: chunks differ (-want +got):\n%s", tc.name, diff)
+		}
+
+		// The chunks are valid and encode the advertisement back.
+		var buf bytes.Buffer
+		if _, err := writeChunks(&buf, got); err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+		} else if buf.String() != tc.in {
+			t.Errorf("%s: chunks encode to %q, want %q", tc.name, buf.String(), tc.in)
+		}
+	}
+}
@@ -0,0 +1,231 @@
+// Modified by Giacomo Tartari
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkt
+
+import (
+	"bytes"
+	"strings"
+)
+
+// lazyChunk returns a chunk deferring the parsing of bp if it is a want,
+// have or shallow line valid in the current state, or nil.
+func (r *UploadRequest) lazyChunk(bp BytesPacket) *UploadRequestChunk {
+	var state UploadRequestState
+	var off int
+	switch {
+	case bytes.HasPrefix(bp, []byte("want ")):
+		if r.state != UploadRequestScanWants {
+			return nil
+		}
+		state, off = r.state, len("want ")
+	case bytes.HasPrefix(bp, []byte("shallow ")):
+		if r.state != UploadRequestScanWants && r.state != UploadRequestScanShallows {
+			return nil
+		}
+		state, off = UploadRequestScanShallows, len("shallow ")
+	case bytes.HasPrefix(bp, []byte("have ")):
+		if r.state != UploadRequestNegotiation && r.state != UploadRequestBeginNegotiationOrDoneOrEnd {
+			return nil
+		}
+		state, off = UploadRequestNegotiation, len("have ")
+	default:
+		return nil
+	}
+	if r.cfg.checkLazy(bp, off) != nil {
+		// Let the parser report the error.
+		return nil
+	}
+	r.state = state
+	r.haves = r.haves || state == UploadRequestNegotiation
+	return r.cfg.Arena.NewUploadRequestChunk(UploadRequestChunk{raw: bp})
+}
+
+// lazyChunk returns a chunk deferring the parsing of bp if it is a shallow,
+// unshallow or ACK line valid in the current state, or nil.
+func (r *UploadResponse) lazyChunk(bp BytesPacket) *UploadResponseChunk {
+	var state UploadResponseState
+	var off int
+	switch {
+	case bytes.HasPrefix(bp, []byte("shallow ")):
+		if r.state != UploadResponseBegin && r.state != UploadResponseScanShallows {
+			return nil
+		}
+		state, off = UploadResponseScanShallows, len("shallow ")
+	case bytes.HasPrefix(bp, []byte("unshallow ")):
+		if r.state > UploadResponseScanUnshallows {
+			return nil
+		}
+		state, off = UploadResponseScanUnshallows, len("unshallow ")
+	case bytes.HasPrefix(bp, []byte("ACK ")):
+		if r.state > UploadResponseScanAcknowledgements {
+			return nil
+		}
+		state, off = UploadResponseScanAcknowledgements, len("ACK ")
+	default:
+		return nil
+	}
+	if r.cfg.checkLazy(bp, off) != nil {
+		// Let the parser report the error.
+		return nil
+	}
+	r.state = state
+	return r.cfg.Arena.NewUploadResponseChunk(UploadResponseChunk{raw: bp})
+}
+
+// lazyFields splits the raw line of a lazy chunk into at most n fields.
+func lazyFields(raw []byte, n int) []string {
+	return strings.SplitN(strings.TrimSuffix(string(raw), "\n"), " ", n)
+}
+
+// Raw returns the payload of the packet the chunk was read from if its
+// fields have not been parsed yet, or nil. See WithLazyFields.
+func (c *UploadRequestChunk) Raw() []byte {
+	return c.raw
+}
+
+// Resolve parses the fields of a chunk read with WithLazyFields. It does
+// nothing for other chunks.
+func (c *UploadRequestChunk) Resolve() {
+	if c.raw == nil {
+		return
+	}
+	ss := lazyFields(c.raw, 2)
+	switch ss[0] {
+	case "want":
+		c.WantObjectID = ss[1]
+	case "have":
+		c.HaveObjectID = ss[1]
+	case "shallow":
+		c.ShallowObjectID = ss[1]
+	}
+	c.raw = nil
+}
+
+// Want returns the object ID of a want line, or "".
+func (c *UploadRequestChunk) Want() string {
+	c.Resolve()
+	return c.WantObjectID
+}
+
+// Have returns the object ID of a have line, or "".
+func (c *UploadRequestChunk) Have() string {
+	c.Resolve()
+	return c.HaveObjectID
+}
+
+// Shallow returns the object ID of a shallow line, or "".
+func (c *UploadRequestChunk) Shallow() string {
+	c.Resolve()
+	return c.ShallowObjectID
+}
+
+// Raw returns the payload of the packet the chunk was read from if its
+// fields have not been parsed yet, or nil. See WithLazyFields.
+func (c *UploadResponseChunk) Raw() []byte {
+	return c.raw
+}
+
+// Resolve parses the fields of a chunk read with WithLazyFields. It does
+// nothing for other chunks.
+func (c *UploadResponseChunk) Resolve() {
+	if c.raw == nil {
+		return
+	}
+	ss := lazyFields(c.raw, 3)
+	switch ss[0] {
+	case "shallow":
+		c.ShallowObjectID = strings.Join(ss[1:], " ")
+	case "unshallow":
+		c.UnshallowObjectID = strings.Join(ss[1:], " ")
+	case "ACK":
+		c.AckObjectID = ss[1]
+		if len(ss) == 3 {
+			c.AckDetail = ss[2]
+		}
+	}
+	c.raw = nil
+}
+
+// Shallow returns the object ID of a shallow line, or "".
+func (c *UploadResponseChunk) Shallow() string {
+	c.Resolve()
+	return c.ShallowObjectID
+}
+
+// Unshallow returns the object ID of an unshallow line, or "".
+func (c *UploadResponseChunk) Unshallow() string {
+	c.Resolve()
+	return c.UnshallowObjectID
+}
+
+// Ack returns the object ID and the detail of an ACK line, or "".
+func (c *UploadResponseChunk) Ack() (oid, detail string) {
+	c.Resolve()
+	return c.AckObjectID, c.AckDetail
+}
+
+// Raw returns the payload of the packet the chunk was read from if its
+// fields have not been parsed yet, or nil. See WithLazyFields.
+func (c *ReceiveRequestChunk) Raw() []byte {
+	return c.raw
+}
+
+// Resolve parses the fields of a chunk read with WithLazyFields. It does
+// nothing for other chunks.
+func (c *ReceiveRequestChunk) Resolve() {
+	if c.raw == nil {
+		return
+	}
+	ss := lazyFields(c.raw, 3)
+	c.OldObjectID, c.NewObjectID, c.RefName = ss[0], ss[1], ss[2]
+	c.raw = nil
+}
+
+// Command returns the fields of a ref update command, or "".
+func (c *ReceiveRequestChunk) Command() (oldOID, newOID, ref string) {
+	c.Resolve()
+	return c.OldObjectID, c.NewObjectID, c.RefName
+}
+
+// Raw returns the payload of the packet the chunk was read from if its
+// fields have not been parsed yet, or nil. See WithLazyFields.
+func (c *ReceiveResponseChunk) Raw() []byte {
+	return c.raw
+}
+
+// Resolve parses the fields of a chunk read with WithLazyFields. It does
+// nothing for other chunks.
+func (c *ReceiveResponseChunk) Resolve() {
+	if c.raw == nil {
+		return
+	}
+	n := 3
+	if bytes.HasPrefix(c.raw, []byte("ok ")) {
+		n = 2
+	}
+	ss := lazyFields(c.raw, n)
+	c.RefUpdateStatus, c.RefName = ss[0], ss[1]
+	if n == 3 {
+		c.RefUpdateFailMessage = ss[2]
+	}
+	c.raw = nil
+}
+
+// RefResult returns the fields of a ref update result, or "".
+func (c *ReceiveResponseChunk) RefResult() (ref, status, msg string) {
+	c.Resolve()
+	return c.RefName, c.RefUpdateStatus, c.RefUpdateFailMessage
+}
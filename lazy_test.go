@@ -0,0 +1,149 @@
+// Modified by Giacomo Tartari
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkt
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+var oid = strings.Repeat("a", 40)
+
+func TestLazyFields(t *testing.T) {
+	oid2 := strings.Repeat("b", 40)
+	zero := strings.Repeat("0", 40)
+	tests := []struct {
+		name string
+		in   string
+		// parse returns the chunks, resolved, and the number of chunks
+		// read lazily.
+		parse func(in string, opts ...Option) ([]any, int, error)
+	}{
+		{
+			name: "upload request",
+			in: pktLines("want "+oid+" ofs-delta\n", "want "+oid2+"\n", "shallow "+oid2+"\n", "deepen 1\n", "0000",
+				"have "+oid+"\n", "have "+oid2+"\n", "0000", "have "+oid+"\n", "done\n"),
+			parse: func(in string, opts ...Option) ([]any, int, error) {
+				r := NewUploadRequest(strings.NewReader(in), opts...)
+				var cs []any
+				lazy := 0
+				for r.Scan() {
+					c := r.Chunk()
+					if c.Raw() != nil {
+						lazy++
+					}
+					c.Resolve()
+					cs = append(cs, *c)
+				}
+				return cs, lazy, r.Err()
+			},
+		},
+		{
+			name: "upload response",
+			in:   pktLines("shallow "+oid+"\n", "unshallow "+oid2+"\n", "0000", "ACK "+oid+" common\n", "ACK "+oid2+" ready\n", "NAK\n", "ACK "+oid2+"\n") + "PACK",
+			parse: func(in string, opts ...Option) ([]any, int, error) {
+				r := NewUploadResponse(strings.NewReader(in), opts...)
+				var cs []any
+				lazy := 0
+				for r.Scan() {
+					c := r.Chunk()
+					if c.Raw() != nil {
+						lazy++
+					}
+					c.Resolve()
+					cs = append(cs, *c)
+				}
+				return cs, lazy, r.Err()
+			},
+		},
+		{
+			name: "receive request",
+			in:   pktLines(zero+" "+oid+" refs/heads/main\x00report-status\n", oid+" "+oid2+" refs/heads/topic\n", oid2+" "+zero+" refs/heads/old\n", "0000"),
+			parse: func(in string, opts ...Option) ([]any, int, error) {
+				r := NewReceiveRequest(strings.NewReader(in), opts...)
+				var cs []any
+				lazy := 0
+				for r.Scan() {
+					c := r.Chunk()
+					if c.Raw() != nil {
+						lazy++
+					}
+					c.Resolve()
+					cs = append(cs, *c)
+				}
+				return cs, lazy, r.Err()
+			},
+		},
+		{
+			name: "receive response",
+			in:   pktLines("unpack ok\n", "ok refs/heads/main\n", "ng refs/heads/topic non-fast-forward update\n", "0000"),
+			parse: func(in string, opts ...Option) ([]any, int, error) {
+				r := NewReceiveResponse(strings.NewReader(in), opts...)
+				var cs []any
+				lazy := 0
+				for r.Scan() {
+					c := r.Chunk()
+					if c.Raw() != nil {
+						lazy++
+					}
+					c.Resolve()
+					cs = append(cs, *c)
+				}
+				return cs, lazy, r.Err()
+			},
+		},
+	}
+	ignore := cmpopts.IgnoreUnexported(UploadRequestChunk{}, UploadResponseChunk{}, ReceiveRequestChunk{}, ReceiveResponseChunk{})
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			want, _, err := tt.parse(tt.in)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, lazy, err := tt.parse(tt.in, WithLazyFields())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if lazy == 0 {
+				t.Error("no chunk was read lazily")
+			}
+			if diff := cmp.Diff(want, got, ignore); diff != "" {
+				t.Errorf("resolved chunks mismatch (-eager +lazy):\n%s", diff)
+			}
+			// The strict mode parses every field.
+			if _, lazy, err := tt.parse(tt.in, WithLazyFields(), WithStrictMode()); err != nil || lazy != 0 {
+				t.Errorf("strict mode: %d chunks read lazily, error %v", lazy, err)
+			}
+		})
+	}
+}
+
+func TestLazyFields_objectFormat(t *testing.T) {
+	// The object IDs of the lazy chunks are still checked against the
+	// object format.
+	in := pktLines("want "+oid+"\n", "want "+strings.Repeat("a", 64)+"\n", "0000", "done\n")
+	r := NewUploadRequest(strings.NewReader(in), WithLazyFields(), WithObjectFormat(SHA1))
+	for r.Scan() {
+	}
+	var se SyntaxError
+	if err := r.Err(); !errors.As(err, &se) {
+		t.Errorf("got error %v, want a SyntaxError", err)
+	}
+}
@@ -0,0 +1,59 @@
+// Modified by Giacomo Tartari
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkt
+
+import "strings"
+
+// WithLenientMode makes the parsers tolerate the deviations from the
+// protocol of some servers and appliances that git itself accepts or works
+// around: text lines without the trailing LF, lowercase "ack" and "nak"
+// lines, and capability lists separated by several spaces or tabs. Without
+// it, the parsers only accept these deviations where they happen not to
+// matter, which is not guaranteed to last.
+func WithLenientMode() Option {
+	return func(c *Config) {
+		c.LenientMode = true
+	}
+}
+
+// NormalizeAck returns the acknowledgment line, without its LF, with the
+// "ACK" or "NAK" keyword in uppercase in lenient mode. It returns line as is
+// otherwise. It is exported for the protocol subpackages.
+func (c *Config) NormalizeAck(line string) string {
+	if !c.LenientMode {
+		return line
+	}
+	if len(line) >= 4 && strings.EqualFold(line[:4], "ACK ") {
+		return "ACK " + line[4:]
+	}
+	if strings.EqualFold(line, "NAK") {
+		return "NAK"
+	}
+	return line
+}
+
+// splitCapabilities splits a capability list on spaces, or on any white
+// space in lenient mode.
+func (c *Config) splitCapabilities(s string) []string {
+	if c.LenientMode {
+		return append([]string{}, strings.Fields(s)...)
+	}
+	if s == "" {
+		// This is to avoid strings.Split("", " ") => []string{""}.
+		return []string{}
+	}
+	return strings.Split(s, " ")
+}
@@ -0,0 +1,127 @@
+// Modified by Giacomo Tartari
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkt
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestConfig_NormalizeAck(t *testing.T) {
+	tests := []struct {
+		line string
+		want string
+	}{
+		{"ACK " + oid, "ACK " + oid},
+		{"ack " + oid + " continue", "ACK " + oid + " continue"},
+		{"Ack " + oid, "ACK " + oid},
+		{"nak", "NAK"},
+		{"NaK", "NAK"},
+		{"acknowledgments", "acknowledgments"},
+		{"ready", "ready"},
+	}
+	lenient, strict := NewConfig(WithLenientMode()), NewConfig()
+	for _, tt := range tests {
+		if got := lenient.NormalizeAck(tt.line); got != tt.want {
+			t.Errorf("NormalizeAck(%q) = %q, want %q", tt.line, got, tt.want)
+		}
+		if got := strict.NormalizeAck(tt.line); got != tt.line {
+			t.Errorf("NormalizeAck(%q) without lenient mode = %q", tt.line, got)
+		}
+	}
+}
+
+func TestLenientMode_acknowledgments(t *testing.T) {
+	in := pktLines("ack "+oid+" continue", "nak", "Ack "+oid+"\n") + "PACK"
+	r := NewUploadResponse(strings.NewReader(in), WithLenientMode())
+	var got []string
+	for r.Scan() {
+		c := r.Chunk()
+		switch {
+		case c.AckObjectID != "":
+			got = append(got, "ACK "+c.AckObjectID+" "+c.AckDetail)
+		case c.Nak:
+			got = append(got, "NAK")
+		}
+	}
+	if err := r.Err(); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"ACK " + oid + " continue", "NAK", "ACK " + oid + " "}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("acknowledgments mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestLenientMode_capabilities(t *testing.T) {
+	zero := strings.Repeat("0", 40)
+	want := []string{"ofs-delta", "side-band-64k", "agent=x"}
+	tests := []struct {
+		name string
+		caps func(in string) ([]string, error)
+		in   string
+	}{
+		{
+			name: "advertisement",
+			in:   pktLines(oid+" refs/heads/main\x00ofs-delta  side-band-64k\tagent=x\n", "0000"),
+			caps: func(in string) ([]string, error) {
+				r := NewInfoRefsResponse(strings.NewReader(in), WithLenientMode())
+				var caps []string
+				for r.Scan() {
+					caps = append(caps, r.Chunk().Capabilities...)
+				}
+				return caps, r.Err()
+			},
+		},
+		{
+			name: "want",
+			in:   pktLines("want "+oid+" ofs-delta\tside-band-64k  agent=x\n", "0000", "done\n"),
+			caps: func(in string) ([]string, error) {
+				r := NewUploadRequest(strings.NewReader(in), WithLenientMode())
+				var caps []string
+				for r.Scan() {
+					caps = append(caps, r.Chunk().Capabilities...)
+				}
+				return caps, r.Err()
+			},
+		},
+		{
+			name: "command",
+			in:   pktLines(zero+" "+oid+" refs/heads/main\x00 ofs-delta side-band-64k\t agent=x \n", "0000"),
+			caps: func(in string) ([]string, error) {
+				r := NewReceiveRequest(strings.NewReader(in), WithLenientMode())
+				var caps []string
+				for r.Scan() {
+					caps = append(caps, r.Chunk().Capabilities...)
+				}
+				return caps, r.Err()
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.caps(tt.in)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(want, got); diff != "" {
+				t.Errorf("capabilities mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
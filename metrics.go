@@ -0,0 +1,112 @@
+// Modified by Giacomo Tartari
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkt
+
+import (
+	"context"
+	"errors"
+	"io"
+)
+
+// Metrics receives the measurements of the scanners, writers and parsers it
+// is installed on with WithMetrics, e.g. to export them as Prometheus
+// counters and histograms. The kinds have a String method returning short
+// constant strings fit for labels. Its methods are called synchronously, so they must not block, and
+// concurrently when it is shared.
+type Metrics interface {
+	// PacketRead counts a packet read by a PacketScanner, of size bytes
+	// header included. A pack file sent without side-band is counted in
+	// the chunks returned by the scanner.
+	PacketRead(kind PacketKind, size int)
+	// PacketWritten counts a packet written by a PacketWriter.
+	PacketWritten(kind PacketKind, size int)
+	// PackStream observes the size of a pack file read, raw or over
+	// side-band, without the side-band bytes.
+	PackStream(size int64)
+	// ParseError counts a failure of a scanner, or a malformed stream
+	// reported by a parser. The kind is returned by ErrorKind.
+	ParseError(kind string)
+	// NegotiationRounds observes the number of rounds of a protocol v1
+	// negotiation, as sent by a NegotiationSession or as read by an
+	// UploadRequest ending with "done".
+	NegotiationRounds(n int)
+}
+
+// NopMetrics is the default Metrics, doing nothing.
+type NopMetrics struct{}
+
+func (NopMetrics) PacketRead(kind PacketKind, size int)    {}
+func (NopMetrics) PacketWritten(kind PacketKind, size int) {}
+func (NopMetrics) PackStream(size int64)                   {}
+func (NopMetrics) ParseError(kind string)                  {}
+func (NopMetrics) NegotiationRounds(n int)                 {}
+
+// WithMetrics installs m on the scanner, the writer or the parser.
+func WithMetrics(m Metrics) Option {
+	return func(c *Config) {
+		if m == nil {
+			m = NopMetrics{}
+		}
+		c.Metrics = m
+	}
+}
+
+// KindOf returns the kind of p. The chunks and the other packets with a
+// payload are DataPacketKind.
+func KindOf(p Packet) PacketKind {
+	switch p.(type) {
+	case FlushPacket:
+		return FlushPacketKind
+	case DelimPacket:
+		return DelimPacketKind
+	case ResponseEndPacket:
+		return ResponseEndPacketKind
+	case ErrorPacket:
+		return ErrorPacketKind
+	case PackFileIndicatorPacket, PackFilePacket:
+		return PackFileKind
+	}
+	return DataPacketKind
+}
+
+// ErrorKind returns the kind of err: "syntax", "field" for a FieldError,
+// "too-large" for a PacketTooLargeError, "remote" for an ERR packet or a
+// side-band error, "negotiation-limit", "unexpected-eof", "context" or
+// "io".
+func ErrorKind(err error) string {
+	var fe *FieldError
+	var se SyntaxError
+	var ep ErrorPacket
+	var sbe SideBandError
+	var nle *NegotiationLimitError
+	switch {
+	case errors.As(err, &fe):
+		return "field"
+	case errors.As(err, &se):
+		return "syntax"
+	case errors.Is(err, ErrPacketTooLarge):
+		return "too-large"
+	case errors.As(err, &ep), errors.As(err, &sbe):
+		return "remote"
+	case errors.As(err, &nle):
+		return "negotiation-limit"
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		return "unexpected-eof"
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return "context"
+	}
+	return "io"
+}
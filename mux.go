@@ -0,0 +1,113 @@
+// Modified by Giacomo Tartari
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkt
+
+import (
+	"io"
+	"sync"
+)
+
+const (
+	// SideBandMaxData is the largest data of a side-band packet.
+	SideBandMaxData = 1000 - 5
+	// SideBand64kMaxData is the largest data of a side-band-64k packet.
+	SideBand64kMaxData = MaxPacketDataSize - 1
+)
+
+// SideBandMuxer writes side-band or side-band-64k packets, splitting the
+// data to the maximum packet size of the negotiated capability. The bands
+// can be written from different goroutines, e.g. the progress of a pack
+// generator while the pack is copied.
+type SideBandMuxer struct {
+	mu  sync.Mutex
+	w   io.Writer
+	max int
+}
+
+// NewSideBandMuxer returns a SideBandMuxer writing to w, with the packet
+// size of side-band-64k if large is true and of side-band otherwise.
+func NewSideBandMuxer(w io.Writer, large bool) *SideBandMuxer {
+	m := &SideBandMuxer{w: w, max: SideBandMaxData}
+	if large {
+		m.max = SideBand64kMaxData
+	}
+	return m
+}
+
+// Main returns a writer for the pack data band (0x01).
+func (m *SideBandMuxer) Main() io.Writer {
+	return &sideBandWriter{m: m, band: 1}
+}
+
+// Progress returns a writer for the progress band (0x02).
+func (m *SideBandMuxer) Progress() io.Writer {
+	return &sideBandWriter{m: m, band: 2}
+}
+
+// Error returns a writer for the error band (0x03).
+func (m *SideBandMuxer) Error() io.Writer {
+	return &sideBandWriter{m: m, band: 3}
+}
+
+// WritePack copies the pack data read from r to the main band.
+func (m *SideBandMuxer) WritePack(r io.Reader) (int64, error) {
+	return io.CopyBuffer(m.Main(), r, make([]byte, m.max))
+}
+
+// KeepAlive writes an empty packet on the main band, which git clients
+// ignore.
+func (m *SideBandMuxer) KeepAlive() error {
+	return m.write(SideBandMainPacket(nil))
+}
+
+// Flush writes the flush packet ending the side-band stream.
+func (m *SideBandMuxer) Flush() error {
+	return m.write(FlushPacket{})
+}
+
+func (m *SideBandMuxer) write(p Packet) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, err := encodeTo(m.w, p)
+	return err
+}
+
+type sideBandWriter struct {
+	m    *SideBandMuxer
+	band byte
+}
+
+func (w *sideBandWriter) Write(p []byte) (int, error) {
+	n := 0
+	for len(p) > 0 {
+		sz := min(len(p), w.m.max)
+		var b Packet
+		switch w.band {
+		case 1:
+			b = SideBandMainPacket(p[:sz])
+		case 2:
+			b = SideBandReportPacket(p[:sz])
+		default:
+			b = SideBandErrorPacket(p[:sz])
+		}
+		if err := w.m.write(b); err != nil {
+			return n, err
+		}
+		n += sz
+		p = p[sz:]
+	}
+	return n, nil
+}
@@ -0,0 +1,315 @@
+// Modified by Giacomo Tartari
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package mux runs several logical pkt-line sessions over a single
+// transport, e.g. fetches of different repositories through one long-lived
+// tunnel.
+//
+// Every frame is a pkt-line whose payload starts with the big endian uint32
+// ID of the stream and the frame type:
+//
+//	frame = pkt-len stream-id type data
+//	type  = %x00 (open, data is the header) / %x01 (data) / %x02 (close)
+//
+// The side created with client set to true uses odd stream IDs and the
+// other side even ones, so both can open streams. There is no flow control:
+// the data received for a stream is buffered until it is read.
+package mux
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/cycloidio/pkt-line"
+)
+
+const (
+	frameOpen byte = iota
+	frameData
+	frameClose
+)
+
+// frameHeaderSize is the size of the stream ID and the frame type.
+const frameHeaderSize = 5
+
+// maxFrameData is the maximum data carried by a frame.
+const maxFrameData = 0xFFFF - 4 - frameHeaderSize
+
+// ErrClosed is returned by the operations on a closed Mux or stream.
+var ErrClosed = errors.New("mux: closed")
+
+// Mux multiplexes streams over a transport.
+type Mux struct {
+	conn io.ReadWriteCloser
+
+	wmu sync.Mutex
+	w   *bufio.Writer
+
+	mu      sync.Mutex
+	streams map[uint32]*Stream
+	nextID  uint32
+	accept  chan *Stream
+	err     error
+	done    chan struct{}
+}
+
+// New returns a Mux over conn and starts reading it. One side of the
+// transport must set client to true and the other to false.
+func New(conn io.ReadWriteCloser, client bool) *Mux {
+	m := &Mux{
+		conn:    conn,
+		w:       bufio.NewWriter(conn),
+		streams: map[uint32]*Stream{},
+		nextID:  2,
+		accept:  make(chan *Stream, 16),
+		done:    make(chan struct{}),
+	}
+	if client {
+		m.nextID = 1
+	}
+	go m.readLoop()
+	return m
+}
+
+// Open opens a new stream. The header is given to the peer by Accept, e.g.
+// to tell the service and the repository of the session.
+func (m *Mux) Open(header []byte) (*Stream, error) {
+	if len(header) > maxFrameData {
+		return nil, fmt.Errorf("mux: header too long (%d bytes)", len(header))
+	}
+	m.mu.Lock()
+	if m.err != nil {
+		m.mu.Unlock()
+		return nil, m.err
+	}
+	s := newStream(m, m.nextID, header)
+	m.nextID += 2
+	m.streams[s.id] = s
+	m.mu.Unlock()
+
+	if err := m.writeFrame(s.id, frameOpen, header); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Accept waits for the peer to open a stream.
+func (m *Mux) Accept() (*Stream, error) {
+	select {
+	case s := <-m.accept:
+		return s, nil
+	case <-m.done:
+		// Deliver the streams opened before the end of the transport.
+		select {
+		case s := <-m.accept:
+			return s, nil
+		default:
+		}
+		return nil, m.Err()
+	}
+}
+
+// Err returns the error that stopped the Mux: ErrClosed after Close, or
+// the error reading the transport.
+func (m *Mux) Err() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.err
+}
+
+// Close closes the transport. The pending reads of the streams fail with
+// ErrClosed.
+func (m *Mux) Close() error {
+	err := m.conn.Close()
+	m.shutdown(ErrClosed)
+	return err
+}
+
+func (m *Mux) shutdown(err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.err != nil {
+		return
+	}
+	m.err = err
+	for _, s := range m.streams {
+		s.fail(err)
+	}
+	close(m.done)
+}
+
+func (m *Mux) writeFrame(id uint32, typ byte, data []byte) error {
+	var hdr [frameHeaderSize]byte
+	binary.BigEndian.PutUint32(hdr[:], id)
+	hdr[4] = typ
+
+	m.wmu.Lock()
+	defer m.wmu.Unlock()
+	if err := m.Err(); err != nil {
+		return err
+	}
+	fmt.Fprintf(m.w, "%04x", 4+frameHeaderSize+len(data))
+	m.w.Write(hdr[:])
+	m.w.Write(data)
+	return m.w.Flush()
+}
+
+func (m *Mux) readLoop() {
+	sc := pkt.NewPacketScanner(m.conn)
+	for sc.Scan() {
+		bp, ok := sc.Packet().(pkt.BytesPacket)
+		if !ok || len(bp) < frameHeaderSize {
+			m.shutdown(pkt.SyntaxError(fmt.Sprintf("unexpected packet: %#v", sc.Packet())))
+			m.conn.Close()
+			return
+		}
+		id, typ, data := binary.BigEndian.Uint32(bp), bp[4], bp[frameHeaderSize:]
+
+		m.mu.Lock()
+		s := m.streams[id]
+		switch typ {
+		case frameOpen:
+			if s == nil {
+				s = newStream(m, id, append([]byte(nil), data...))
+				m.streams[id] = s
+				m.mu.Unlock()
+				select {
+				case m.accept <- s:
+				case <-m.done:
+				}
+				continue
+			}
+		case frameData:
+			if s != nil {
+				s.push(data)
+			}
+		case frameClose:
+			if s != nil {
+				s.fail(io.EOF)
+				s.remoteClosed = true
+				m.forget(s)
+			}
+		}
+		m.mu.Unlock()
+	}
+	err := sc.Err()
+	if err == nil {
+		err = io.EOF
+	}
+	m.shutdown(err)
+}
+
+// forget removes s once both sides closed it. m.mu must be held.
+func (m *Mux) forget(s *Stream) {
+	if s.localClosed && s.remoteClosed {
+		delete(m.streams, s.id)
+	}
+}
+
+// Stream is a logical session of a Mux. Closing it ends the writes; the peer
+// reads io.EOF.
+type Stream struct {
+	m      *Mux
+	id     uint32
+	header []byte
+
+	// Protected by m.mu.
+	cond         *sync.Cond
+	buf          []byte
+	err          error
+	localClosed  bool
+	remoteClosed bool
+}
+
+func newStream(m *Mux, id uint32, header []byte) *Stream {
+	return &Stream{m: m, id: id, header: header, cond: sync.NewCond(&m.mu)}
+}
+
+// ID returns the ID of the stream.
+func (s *Stream) ID() uint32 {
+	return s.id
+}
+
+// Header returns the header given to Open.
+func (s *Stream) Header() []byte {
+	return s.header
+}
+
+func (s *Stream) push(data []byte) {
+	s.buf = append(s.buf, data...)
+	s.cond.Broadcast()
+}
+
+func (s *Stream) fail(err error) {
+	if s.err == nil {
+		s.err = err
+	}
+	s.cond.Broadcast()
+}
+
+// Read reads the data sent by the peer.
+func (s *Stream) Read(p []byte) (int, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	for len(s.buf) == 0 && s.err == nil {
+		s.cond.Wait()
+	}
+	if len(s.buf) == 0 {
+		return 0, s.err
+	}
+	n := copy(p, s.buf)
+	s.buf = s.buf[n:]
+	if len(s.buf) == 0 {
+		s.buf = nil
+	}
+	return n, nil
+}
+
+// Write sends p to the peer, in as many frames as needed.
+func (s *Stream) Write(p []byte) (int, error) {
+	s.m.mu.Lock()
+	closed := s.localClosed
+	s.m.mu.Unlock()
+	if closed {
+		return 0, ErrClosed
+	}
+	n := 0
+	for len(p) > 0 {
+		sz := min(len(p), maxFrameData)
+		if err := s.m.writeFrame(s.id, frameData, p[:sz]); err != nil {
+			return n, err
+		}
+		n += sz
+		p = p[sz:]
+	}
+	return n, nil
+}
+
+// Close ends the writes of the stream.
+func (s *Stream) Close() error {
+	s.m.mu.Lock()
+	if s.localClosed {
+		s.m.mu.Unlock()
+		return nil
+	}
+	s.localClosed = true
+	s.m.forget(s)
+	s.m.mu.Unlock()
+	return s.m.writeFrame(s.id, frameClose, nil)
+}
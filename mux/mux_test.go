@@ -0,0 +1,162 @@
+// Modified by Giacomo Tartari
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mux
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"testing"
+
+	"github.com/cycloidio/pkt-line"
+)
+
+// echo serves the streams accepted by m by sending back their header and
+// their data.
+func echo(m *Mux) {
+	for {
+		s, err := m.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			s.Write(s.Header())
+			io.Copy(s, s)
+			s.Close()
+		}()
+	}
+}
+
+func TestMux(t *testing.T) {
+	c1, c2 := net.Pipe()
+	client, server := New(c1, true), New(c2, false)
+	defer client.Close()
+	defer server.Close()
+	go echo(server)
+	go echo(client)
+
+	tests := []struct {
+		name   string
+		m      *Mux
+		header string
+		size   int
+	}{
+		{name: "empty", m: client, header: "git-upload-pack /a.git"},
+		{name: "small", m: client, header: "git-upload-pack /b.git", size: 10},
+		{name: "several frames", m: client, header: "git-receive-pack /c.git", size: 3*maxFrameData + 1},
+		{name: "server side", m: server, header: "git-upload-pack /d.git", size: 1000},
+	}
+	var wg sync.WaitGroup
+	for i, tt := range tests {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s, err := tt.m.Open([]byte(tt.header))
+			if err != nil {
+				t.Errorf("%s: %v", tt.name, err)
+				return
+			}
+			if odd := s.ID()%2 == 1; odd != (tt.m == client) {
+				t.Errorf("%s: got stream ID %d", tt.name, s.ID())
+			}
+			data := bytes.Repeat([]byte{byte(i)}, tt.size)
+			go func() {
+				s.Write(data)
+				s.Close()
+			}()
+			got, err := io.ReadAll(s)
+			if err != nil {
+				t.Errorf("%s: %v", tt.name, err)
+				return
+			}
+			if want := append([]byte(tt.header), data...); !bytes.Equal(got, want) {
+				t.Errorf("%s: got %d bytes back, want %d", tt.name, len(got), len(want))
+			}
+			if _, err := s.Write([]byte("x")); err != ErrClosed {
+				t.Errorf("%s: Write() after Close() = %v, want ErrClosed", tt.name, err)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestMux_Close(t *testing.T) {
+	c1, c2 := net.Pipe()
+	client, server := New(c1, true), New(c2, false)
+	s, err := client.Open(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := server.Accept(); err != nil {
+		t.Fatal(err)
+	}
+
+	read := make(chan error)
+	go func() {
+		_, err := s.Read(make([]byte, 1))
+		read <- err
+	}()
+	client.Close()
+	if err := <-read; err != ErrClosed {
+		t.Errorf("pending Read() = %v, want ErrClosed", err)
+	}
+	if _, err := client.Open(nil); err != ErrClosed {
+		t.Errorf("Open() after Close() = %v, want ErrClosed", err)
+	}
+	if _, err := client.Accept(); err != ErrClosed {
+		t.Errorf("Accept() after Close() = %v, want ErrClosed", err)
+	}
+	// The peer sees the end of the transport.
+	if _, err := server.Accept(); err != io.EOF {
+		t.Errorf("peer Accept() = %v, want io.EOF", err)
+	}
+}
+
+func TestMux_Open_headerTooLong(t *testing.T) {
+	c1, c2 := net.Pipe()
+	defer c2.Close()
+	m := New(c1, true)
+	defer m.Close()
+	if _, err := m.Open(make([]byte, maxFrameData+1)); err == nil {
+		t.Error("Open() succeeded")
+	}
+}
+
+func TestMux_badFrames(t *testing.T) {
+	tests := []struct {
+		name  string
+		frame []byte
+	}{
+		{"flush", pkt.FlushPacket{}.EncodeToPktLine()},
+		{"short", pkt.BytesPacket{0, 0, 0, 1}.EncodeToPktLine()},
+		{"bad length", []byte("zzzz")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c1, c2 := net.Pipe()
+			defer c2.Close()
+			m := New(c1, false)
+			go c2.Write(tt.frame)
+			_, err := m.Accept()
+			var se pkt.SyntaxError
+			if !errors.As(err, &se) {
+				t.Errorf("Accept() = %v, want a SyntaxError", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,232 @@
+// Modified by Giacomo Tartari
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkt
+
+import (
+	"fmt"
+	"io"
+)
+
+// NegotiationLimits bounds the negotiation of a fetch, so that pathological
+// histories cannot make it go on forever. Zero fields are unlimited.
+type NegotiationLimits struct {
+	// MaxRounds limits the rounds of haves.
+	MaxRounds int
+	// MaxHaves limits the haves over all the rounds.
+	MaxHaves int
+}
+
+// NegotiationLimitError is returned when a negotiation exceeds its limits.
+type NegotiationLimitError struct {
+	// Limit is the name of the exceeded NegotiationLimits field.
+	Limit string
+	Max   int
+}
+
+func (e *NegotiationLimitError) Error() string {
+	return fmt.Sprintf("negotiation limit exceeded: %s (max %d)", e.Limit, e.Max)
+}
+
+// NegotiationTracker counts the rounds and haves of a negotiation.
+type NegotiationTracker struct {
+	Limits NegotiationLimits
+	Rounds int
+	Haves  int
+}
+
+// AddHaves records n haves and returns a NegotiationLimitError if there are
+// too many.
+func (t *NegotiationTracker) AddHaves(n int) error {
+	t.Haves += n
+	if t.Limits.MaxHaves > 0 && t.Haves > t.Limits.MaxHaves {
+		return &NegotiationLimitError{"MaxHaves", t.Limits.MaxHaves}
+	}
+	return nil
+}
+
+// AddRound records a round and returns a NegotiationLimitError if there are
+// too many.
+func (t *NegotiationTracker) AddRound() error {
+	t.Rounds++
+	if t.Limits.MaxRounds > 0 && t.Rounds > t.Limits.MaxRounds {
+		return &NegotiationLimitError{"MaxRounds", t.Limits.MaxRounds}
+	}
+	return nil
+}
+
+// Observe records a chunk of a protocol v1 upload-pack request read by a
+// server. A round is counted for every flush following haves. The server is
+// expected to report the error with an ERR packet and to end the session.
+func (t *NegotiationTracker) Observe(c *UploadRequestChunk) error {
+	switch {
+	case c.Have() != "":
+		return t.AddHaves(1)
+	case c.EndOneRound && t.Haves > 0:
+		return t.AddRound()
+	}
+	return nil
+}
+
+// Exhausted reports whether another round of haves would exceed the limits.
+func (t *NegotiationTracker) Exhausted() bool {
+	return t.Limits.MaxRounds > 0 && t.Rounds >= t.Limits.MaxRounds ||
+		t.Limits.MaxHaves > 0 && t.Haves >= t.Limits.MaxHaves
+}
+
+// NegotiationSession tracks a protocol v1 fetch negotiation over stateless
+// RPC, as done by smart HTTP, where every round is a request and a response.
+// Each request repeats the wants and the haves acknowledged as common in the
+// previous rounds, and each response only carries the acknowledgements of
+// its round, ended with a NAK, so that the state of the negotiation lives in
+// the session rather than in the parsers.
+type NegotiationSession struct {
+	// Header are the chunks starting every request: the wants with the
+	// capabilities, the shallow, deepen and filter lines, and the flush
+	// ending them.
+	Header  []*UploadRequestChunk
+	Tracker NegotiationTracker
+
+	common   []string
+	isCommon map[string]bool
+	ready    bool
+	done     bool
+}
+
+// NewNegotiationSession returns a NegotiationSession whose requests start
+// with header, within limits.
+func NewNegotiationSession(header []*UploadRequestChunk, limits NegotiationLimits) *NegotiationSession {
+	return &NegotiationSession{
+		Header:   header,
+		Tracker:  NegotiationTracker{Limits: limits},
+		isCommon: map[string]bool{},
+	}
+}
+
+// Request returns the chunks of the request of the next round: the header,
+// the common haves, the new haves and a flush, or "done" for the last round.
+// The last round is the one with done set, the one following a "ready"
+// acknowledgement, or the one reaching the limits. Request returns nil after
+// the last round.
+func (s *NegotiationSession) Request(haves []string, done bool) []*UploadRequestChunk {
+	if s.done {
+		return nil
+	}
+	chunks := append([]*UploadRequestChunk(nil), s.Header...)
+	for _, h := range s.common {
+		chunks = append(chunks, NewHaveChunk(h))
+	}
+	for _, h := range haves {
+		if !s.isCommon[h] {
+			chunks = append(chunks, NewHaveChunk(h))
+		}
+	}
+	_ = s.Tracker.AddHaves(len(haves))
+	_ = s.Tracker.AddRound()
+	if done || s.ready || s.Tracker.Exhausted() {
+		s.done = true
+		return append(chunks, NewDoneChunk())
+	}
+	return append(chunks, NewEndOfRoundChunk())
+}
+
+// Observe records a chunk of a response: the object IDs acknowledged with
+// "common", "continue" or "ready" become common haves, and "ready" ends the
+// negotiation.
+func (s *NegotiationSession) Observe(c *UploadResponseChunk) {
+	oid, _ := c.Ack()
+	if oid == "" {
+		return
+	}
+	switch st, _ := c.AckStatus(); st {
+	case AckReady:
+		s.ready = true
+		fallthrough
+	case AckCommon, AckContinue:
+		if !s.isCommon[oid] {
+			s.isCommon[oid] = true
+			s.common = append(s.common, oid)
+		}
+	}
+}
+
+// ReadResponse reads the response to the last request from rd, up to its
+// NAK or its final ACK, and records the acknowledgements. After the last
+// round, or after a "ready" round with the no-done capability, the pack
+// follows: Done reports true and the returned UploadResponse is positioned
+// before the pack, to be read with Scan or PackReader.
+func (s *NegotiationSession) ReadResponse(rd io.Reader, opts ...Option) (*UploadResponse, error) {
+	r := NewUploadResponse(rd, opts...)
+	for r.Scan() {
+		c := r.Chunk()
+		s.Observe(c)
+		if c.Nak && s.ready && s.noDone() {
+			// The server sends the pack without waiting for "done".
+			continue
+		}
+		if oid, detail := c.Ack(); oid != "" && detail == "" {
+			s.done = true
+			r.cfg.Metrics.NegotiationRounds(s.Tracker.Rounds)
+			return r, nil
+		}
+		if c.Nak {
+			if s.done {
+				r.cfg.Metrics.NegotiationRounds(s.Tracker.Rounds)
+			}
+			return r, nil
+		}
+	}
+	if err := r.Err(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// noDone reports whether the client requested the no-done capability.
+func (s *NegotiationSession) noDone() bool {
+	for _, c := range s.Header {
+		if c.WantObjectID != "" {
+			return c.HasCapability("no-done")
+		}
+	}
+	return false
+}
+
+// NeedMoreHaves reports whether the server needs another round of haves,
+// i.e. it has not acknowledged "ready" and the last round was not sent.
+func (s *NegotiationSession) NeedMoreHaves() bool {
+	return !s.ready && !s.done
+}
+
+// Ready reports whether the server acknowledged "ready".
+func (s *NegotiationSession) Ready() bool {
+	return s.ready
+}
+
+// Done reports whether the negotiation is over: the last round was sent, or
+// the server sends the pack of a "ready" round.
+func (s *NegotiationSession) Done() bool {
+	return s.done
+}
+
+// Common returns the haves acknowledged as common, in order.
+func (s *NegotiationSession) Common() []string {
+	return s.common
+}
+
+// Rounds returns the number of requests made.
+func (s *NegotiationSession) Rounds() int {
+	return s.Tracker.Rounds
+}
@@ -0,0 +1,152 @@
+// Modified by Giacomo Tartari
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkt
+
+import "fmt"
+
+// ObjectFormat is the hash algorithm of the object IDs of a repository, as
+// named by the object-format capability.
+type ObjectFormat string
+
+const (
+	// SHA1 is the object format of the repositories that do not advertise
+	// one.
+	SHA1 ObjectFormat = "sha1"
+	// SHA256 is the object format of the repositories created with
+	// "git init --object-format=sha256".
+	SHA256 ObjectFormat = "sha256"
+)
+
+// ParseObjectFormat returns the object format named s.
+func ParseObjectFormat(s string) (ObjectFormat, error) {
+	switch f := ObjectFormat(s); f {
+	case SHA1, SHA256:
+		return f, nil
+	}
+	return "", fmt.Errorf("unknown object format %q", s)
+}
+
+// HexSize returns the length of a hex encoded object ID, or 0 for an
+// unknown format.
+func (f ObjectFormat) HexSize() int {
+	switch f {
+	case SHA1:
+		return 40
+	case SHA256:
+		return 64
+	}
+	return 0
+}
+
+// ZeroID returns the all-zero object ID, which stands for a missing object,
+// e.g. in the ref update commands creating or deleting a ref.
+func (f ObjectFormat) ZeroID() ObjectID {
+	return ObjectID(fmt.Sprintf("%0*d", f.HexSize(), 0))
+}
+
+// ObjectFormatOf returns the object format of id, told by its length:
+// SHA256 for 64 hex digits, SHA1 otherwise.
+func ObjectFormatOf(id ObjectID) ObjectFormat {
+	return objectFormatOfSize(len(id))
+}
+
+// IsZero reports whether id is the all-zero object ID of its format.
+func (id ObjectID) IsZero() bool {
+	return id != "" && id == ObjectFormatOf(id).ZeroID()
+}
+
+// ValidateObjectID returns a SyntaxError if id is not a lowercase hex
+// encoded object ID of the format.
+func (f ObjectFormat) ValidateObjectID(id string) error {
+	if !f.validObjectID([]byte(id)) {
+		return SyntaxError(fmt.Sprintf("invalid %s object ID: %q", f, id))
+	}
+	return nil
+}
+
+func (f ObjectFormat) validObjectID(id []byte) bool {
+	if len(id) != f.HexSize() {
+		return false
+	}
+	for _, c := range id {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// checkObjectID validates id against the object format of the
+// configuration, if any. See WithObjectFormat and WithStrictMode.
+func (c *Config) checkObjectID(id string) error {
+	f := c.ObjectFormat
+	if f == "" {
+		if !c.StrictMode {
+			return nil
+		}
+		f = objectFormatOfSize(len(id))
+	}
+	if err := f.ValidateObjectID(id); err != nil {
+		if c.StrictMode {
+			return &FieldError{Field: "object ID", Value: id, Err: err}
+		}
+		return err
+	}
+	return nil
+}
+
+// objectFormatOfSize returns the object format whose object IDs are n hex
+// digits long, defaulting to SHA1.
+func objectFormatOfSize(n int) ObjectFormat {
+	if n == SHA256.HexSize() {
+		return SHA256
+	}
+	return SHA1
+}
+
+// CheckObjectIDs validates ids against the object format of the
+// configuration, if any. It is exported for the protocol subpackages.
+func (c *Config) CheckObjectIDs(ids ...string) error {
+	for _, id := range ids {
+		if err := c.checkObjectID(id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// lazyObjectID returns the field of raw starting at off, up to the next
+// space or LF.
+func lazyObjectID(raw []byte, off int) []byte {
+	id := raw[min(off, len(raw)):]
+	for i, c := range id {
+		if c == ' ' || c == '\n' || c == 0 {
+			return id[:i]
+		}
+	}
+	return id
+}
+
+// checkLazy validates the object ID of a lazy chunk without allocating.
+func (c *Config) checkLazy(raw []byte, off int) error {
+	if c.ObjectFormat == "" {
+		return nil
+	}
+	if id := lazyObjectID(raw, off); !c.ObjectFormat.validObjectID(id) {
+		return c.checkObjectID(string(id))
+	}
+	return nil
+}
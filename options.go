@@ -0,0 +1,148 @@
+// Modified by Giacomo Tartari
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkt
+
+import "context"
+
+// Config holds the settings of a PacketScanner and of the parsers built on
+// top of it.
+type Config struct {
+	// Arena allocates the chunks returned by the parsers. See Arena.
+	Arena *Arena
+	// LazyFields makes the parsers defer the parsing of chunk fields. See
+	// WithLazyFields.
+	LazyFields bool
+	// BufferSize is the size of the pack file chunks of PacketScanner. See
+	// WithBufferSize.
+	BufferSize int
+	// MaxPacketSize is the largest packet accepted by PacketScanner. See
+	// WithMaxPacketSize.
+	MaxPacketSize int
+	// ReuseBuffer makes the scanner return packets pointing to its buffer.
+	// See WithReuseBuffer.
+	ReuseBuffer bool
+	// ObjectFormat is the format the object IDs read by the parsers are
+	// validated against. See WithObjectFormat.
+	ObjectFormat ObjectFormat
+	// Context stops the scanner when it is done. See WithContext.
+	Context context.Context
+	// ErrorPackets makes the scanner return the "ERR" packets. See
+	// WithErrorPackets.
+	ErrorPackets bool
+	// StrictMode makes the parsers validate the fields of the chunks. See
+	// WithStrictMode.
+	StrictMode bool
+	// LenientMode makes the parsers tolerate common deviations from the
+	// protocol. See WithLenientMode.
+	LenientMode bool
+	// Trace receives the packets and the state transitions. See WithTrace.
+	Trace Trace
+	// Metrics receives the measurements. See WithMetrics.
+	Metrics Metrics
+	// DelimPolicy is the handling of the delim packets by the protocol v1
+	// parsers. See WithDelimPolicy.
+	DelimPolicy DelimPolicy
+}
+
+// Option configures a PacketScanner or a parser.
+type Option func(*Config)
+
+// NewConfig returns the configuration set by opts. It is exported for the
+// protocol subpackages.
+func NewConfig(opts ...Option) *Config {
+	c := &Config{Metrics: NopMetrics{}}
+	for _, o := range opts {
+		o(c)
+	}
+	return c
+}
+
+// WithArena makes the parsers allocate their chunks from a.
+func WithArena(a *Arena) Option {
+	return func(c *Config) {
+		c.Arena = a
+	}
+}
+
+// WithLazyFields makes the parsers return the lines carrying object IDs and
+// ref names (wants, haves, shallows, ACKs, ref update commands and results)
+// without splitting them into fields, avoiding the string allocations for
+// packets that are merely forwarded. The fields of such a chunk are filled in
+// by Resolve, which the accessor methods call, and the chunk is encoded from
+// the original packet until then.
+//
+// With WithReuseBuffer, a lazy chunk refers to the buffer of the scanner, so
+// it must be resolved or encoded before the next call to Scan.
+func WithLazyFields() Option {
+	return func(c *Config) {
+		c.LazyFields = true
+	}
+}
+
+// WithBufferSize sets the largest chunk of pack file returned by a Scan of
+// the scanner, and bounds its read buffer. It defaults to 64 KiB. The
+// buffers are allocated as the data is read, so that a scanner reading
+// small packets stays small.
+func WithBufferSize(n int) Option {
+	return func(c *Config) {
+		c.BufferSize = n
+	}
+}
+
+// WithMaxPacketSize makes the scanner reject the packets longer than n
+// bytes, header included, with a PacketTooLargeError, e.g. to bound the
+// memory used for hostile inputs. It defaults to 65535, the largest length
+// that can be encoded; git itself never sends more than 65520 bytes.
+func WithMaxPacketSize(n int) Option {
+	return func(c *Config) {
+		c.MaxPacketSize = n
+	}
+}
+
+// WithReuseBuffer makes the scanner return packets whose payload points to
+// its internal buffer, which is overwritten by the next call to Scan,
+// instead of a copy. It saves an allocation per packet for proxies that
+// forward the packets as they come; the packets or chunks to keep must be
+// copied, e.g. with ClonePacket.
+func WithReuseBuffer(reuse bool) Option {
+	return func(c *Config) {
+		c.ReuseBuffer = reuse
+	}
+}
+
+// WithObjectFormat makes the parsers reject the want, have, shallow,
+// unshallow and ACK lines and the ref update commands whose object IDs are
+// not of format f, with a SyntaxError. Without it, the object IDs are not
+// checked, except by the v2 Request which uses the object-format capability
+// of the request if any.
+func WithObjectFormat(f ObjectFormat) Option {
+	return func(c *Config) {
+		c.ObjectFormat = f
+	}
+}
+
+// WithErrorPackets makes the scanner return an "ERR" packet as an
+// ErrorPacket, which can be inspected and encoded back, and continue with
+// the next packet, instead of stopping with the ErrorPacket as error. It is
+// meant for proxies and dump tools that must not lose the rest of the
+// stream. UploadResponse and ReceiveResponse return the packet as a chunk
+// with RemoteError set, so that the errors reported by the server are told
+// apart from the syntax errors, and keep reading the response.
+func WithErrorPackets() Option {
+	return func(c *Config) {
+		c.ErrorPackets = true
+	}
+}
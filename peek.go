@@ -0,0 +1,107 @@
+// Modified by Giacomo Tartari
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkt
+
+import "io"
+
+// scanState is the part of a PacketScanner describing the current packet,
+// saved around the read of a peeked packet.
+type scanState struct {
+	err          error
+	curr         Packet
+	packFileMode bool
+	index        int
+	pos          int64
+	offset       int64
+	done         bool
+	hdr          [4]byte
+	payload      []byte
+}
+
+// peekState is the packet read ahead by Peek.
+type peekState struct {
+	next scanState
+	ok   bool
+	// altBuf is the buffer the packets are read into alternately with buf
+	// when the buffer is reused, so that a peeked packet does not overwrite
+	// the current one.
+	altBuf []byte
+	peeked bool
+}
+
+// Peek returns the next packet without advancing the scanner: Packet,
+// RawBytes and Err still describe the current packet, and the next call to
+// Scan returns the peeked packet. It returns io.EOF at the end of the
+// input, and the error Scan will report otherwise. Peek must not be called
+// after Scan returned a PackFileIndicatorPacket if the pack file is read
+// with ReadPackData or WritePackTo.
+func (s *PacketScanner) Peek() (Packet, error) {
+	if !s.peek.peeked {
+		cur := s.saveState()
+		if s.reuse {
+			s.buf, s.peek.altBuf = s.peek.altBuf, s.buf
+		}
+		s.peek.ok = s.scan()
+		s.peek.next = s.saveState()
+		s.peek.peeked = true
+		s.restoreState(cur)
+	}
+	if !s.peek.ok {
+		if s.peek.next.err != nil {
+			return nil, s.peek.next.err
+		}
+		return nil, io.EOF
+	}
+	return s.peek.next.curr, nil
+}
+
+// unpeek makes the peeked packet the current one, and reports whether there
+// was one.
+func (s *PacketScanner) unpeek() (ok, peeked bool) {
+	if !s.peek.peeked {
+		return false, false
+	}
+	s.peek.peeked = false
+	s.restoreState(s.peek.next)
+	s.peek.next = scanState{}
+	return s.peek.ok, true
+}
+
+func (s *PacketScanner) saveState() scanState {
+	return scanState{
+		err:          s.err,
+		curr:         s.curr,
+		packFileMode: s.packFileMode,
+		index:        s.index,
+		pos:          s.pos,
+		offset:       s.offset,
+		done:         s.done,
+		hdr:          s.hdr,
+		payload:      s.payload,
+	}
+}
+
+func (s *PacketScanner) restoreState(st scanState) {
+	s.err = st.err
+	s.curr = st.curr
+	s.packFileMode = st.packFileMode
+	s.index = st.index
+	s.pos = st.pos
+	s.offset = st.offset
+	s.done = st.done
+	s.hdr = st.hdr
+	s.payload = st.payload
+}
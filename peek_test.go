@@ -0,0 +1,115 @@
+// Modified by Giacomo Tartari
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkt
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+// packetString returns "0000", "0001" and "0002" for the special packets,
+// the payload of a BytesPacket, and "<nil>" for no packet.
+func packetString(p Packet) string {
+	switch p := p.(type) {
+	case nil:
+		return "<nil>"
+	case BytesPacket:
+		return string(p)
+	}
+	return string(p.EncodeToPktLine())
+}
+
+func TestPacketScanner_Peek(t *testing.T) {
+	for _, opts := range [][]Option{nil, {WithReuseBuffer(true)}} {
+		s := NewPacketScanner(strings.NewReader(pktLines("a\n", "b\n", "0000")), opts...)
+		// Before the first packet.
+		if p, err := s.Peek(); err != nil || packetString(p) != "a\n" {
+			t.Fatalf("Peek() = %q, %v", packetString(p), err)
+		}
+		if s.Packet() != nil {
+			t.Errorf("got packet %q before Scan", packetString(s.Packet()))
+		}
+		for _, want := range []string{"a\n", "b\n"} {
+			if !s.Scan() {
+				t.Fatal(s.Err())
+			}
+			if got := packetString(s.Packet()); got != want {
+				t.Fatalf("got packet %q, want %q", got, want)
+			}
+			raw := string(s.RawBytes())
+			// Peeking twice returns the same packet, and the current one
+			// is kept, even when the buffer is reused.
+			for i := 0; i < 2; i++ {
+				p, err := s.Peek()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if want == "a\n" && packetString(p) != "b\n" || want == "b\n" && packetString(p) != "0000" {
+					t.Errorf("Peek() = %q after %q", packetString(p), want)
+				}
+			}
+			if got := packetString(s.Packet()); got != want || string(s.RawBytes()) != raw {
+				t.Errorf("got packet %q, raw %q after Peek, want %q", got, s.RawBytes(), want)
+			}
+		}
+		if !s.Scan() {
+			t.Fatal(s.Err())
+		}
+		if _, ok := s.Packet().(FlushPacket); !ok {
+			t.Errorf("got packet %q, want a flush", packetString(s.Packet()))
+		}
+		// At the end of the input.
+		if p, err := s.Peek(); p != nil || err != io.EOF {
+			t.Errorf("Peek() = %v, %v at EOF", p, err)
+		}
+		if _, ok := s.Packet().(FlushPacket); !ok || s.Err() != nil {
+			t.Errorf("got packet %q and error %v after Peek at EOF", packetString(s.Packet()), s.Err())
+		}
+		if s.Scan() {
+			t.Errorf("got packet %q after EOF", packetString(s.Packet()))
+		}
+		if err := s.Err(); err != nil {
+			t.Errorf("got error %v at EOF", err)
+		}
+	}
+}
+
+func TestPacketScanner_peekError(t *testing.T) {
+	s := NewPacketScanner(strings.NewReader(pktLines("a\n") + "zzzz"))
+	if !s.Scan() {
+		t.Fatal(s.Err())
+	}
+	_, perr := s.Peek()
+	var pe *ParseError
+	if !errors.As(perr, &pe) {
+		t.Fatalf("Peek() = %v, want a ParseError", perr)
+	}
+	// The error is reported by Scan, not before.
+	if err := s.Err(); err != nil {
+		t.Errorf("got error %v before Scan", err)
+	}
+	if packetString(s.Packet()) != "a\n" {
+		t.Errorf("got packet %q, want %q", packetString(s.Packet()), "a\n")
+	}
+	if s.Scan() {
+		t.Fatalf("got packet %q, want an error", packetString(s.Packet()))
+	}
+	if err := s.Err(); err != perr {
+		t.Errorf("got error %v, want %v", err, perr)
+	}
+}
@@ -0,0 +1,69 @@
+// Modified by Giacomo Tartari
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package pktcmp provides go-cmp options for comparing packets and chunks.
+//
+// The packet and chunk types have Equal methods, which cmp.Diff and
+// cmp.Equal use automatically. The options of this package cover the cases
+// the methods cannot, such as comparing packets of different types.
+package pktcmp
+
+import (
+	"bytes"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/cycloidio/pkt-line"
+)
+
+// Packets returns an option comparing pkt.Packet values with
+// pkt.PacketsEqual, so that a BytesPacket equals a StringPacket with the same
+// payload and the trailing LF of text packets is ignored. Chunks keep being
+// compared by their Equal methods.
+func Packets() cmp.Option {
+	return cmp.FilterValues(notChunks, cmp.Comparer(pkt.PacketsEqual))
+}
+
+// ExactPackets returns an option comparing pkt.Packet values by their
+// encoding, byte for byte. Use it when the trailing LF matters, e.g. for
+// golden files of a proxy. Chunks keep being compared by their Equal methods.
+func ExactPackets() cmp.Option {
+	return cmp.FilterValues(notChunks, cmp.Comparer(func(a, b pkt.Packet) bool {
+		if a == nil || b == nil {
+			return a == nil && b == nil
+		}
+		return bytes.Equal(a.EncodeToPktLine(), b.EncodeToPktLine())
+	}))
+}
+
+// notChunks excludes the chunk types, which implement pkt.Packet too but
+// cannot always be encoded.
+func notChunks(a, b pkt.Packet) bool {
+	_, ac := a.(pkt.Validator)
+	_, bc := b.(pkt.Validator)
+	return !ac && !bc
+}
+
+// Options returns the options for comparing protocol streams: Packets, and
+// the PackRepo field of pkt.UploadResponseChunk is ignored.
+func Options() cmp.Options {
+	return cmp.Options{
+		Packets(),
+		cmp.FilterPath(func(p cmp.Path) bool {
+			sf, ok := p.Last().(cmp.StructField)
+			return ok && sf.Name() == "PackRepo"
+		}, cmp.Ignore()),
+	}
+}
@@ -0,0 +1,77 @@
+// Modified by Giacomo Tartari
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkttest
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/cycloidio/pkt-line"
+)
+
+// SmallPackets returns a stream of n data packets with a payload of size
+// bytes, like the lines of a negotiation, ended with a flush.
+func SmallPackets(n, size int) []byte {
+	var b bytes.Buffer
+	line := make([]byte, size)
+	for i := 0; i < n; i++ {
+		for j := range line {
+			line[j] = "0123456789abcdef"[(i+j)%16]
+		}
+		if size > 0 {
+			line[size-1] = '\n'
+		}
+		b.Write(pkt.BytesPacket(line).EncodeToPktLine())
+	}
+	b.Write(pkt.FlushPacket{}.EncodeToPktLine())
+	return b.Bytes()
+}
+
+// PackData returns size bytes starting with the header of a version 2 pack
+// file, followed by filler. It is not a valid pack, but the parsers do not
+// look into the pack.
+func PackData(size int) []byte {
+	p := make([]byte, max(size, 12))
+	copy(p, "PACK\x00\x00\x00\x02\x00\x00\x00\x00")
+	for i := 12; i < len(p); i++ {
+		p[i] = byte(i * 31)
+	}
+	return p
+}
+
+// UploadResponse returns a protocol v1 git-upload-pack response with acks
+// "common" acknowledgements, the final ACK, or a NAK if acks is 0, and a
+// pack of packSize bytes, sent over side-band-64k if sideBand is true.
+func UploadResponse(acks, packSize int, sideBand bool) []byte {
+	var b bytes.Buffer
+	for i := 0; i < acks; i++ {
+		b.Write(pkt.NewAckChunk(fmt.Sprintf("%040x", i), "common").EncodeToPktLine())
+	}
+	if acks == 0 {
+		b.Write(pkt.NewNakChunk().EncodeToPktLine())
+	} else {
+		b.Write(pkt.NewAckChunk(fmt.Sprintf("%040x", acks-1), "").EncodeToPktLine())
+	}
+	pack := PackData(packSize)
+	if !sideBand {
+		b.Write(pack)
+		return b.Bytes()
+	}
+	m := pkt.NewSideBandMuxer(&b, true)
+	m.Main().Write(pack)
+	m.Flush()
+	return b.Bytes()
+}
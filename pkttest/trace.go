@@ -0,0 +1,225 @@
+// Modified by Giacomo Tartari
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package pkttest provides a textual trace format of packet streams, so that
+// protocol conversations can be stored as golden files, reviewed in diffs and
+// replayed in tests of the code built on the pkt package.
+//
+// A trace has a packet per line:
+//
+//	flush
+//	delim
+//	response-end
+//	data "want 1234...\n"
+//	error "access denied"
+//	pack-start
+//	pack "\x00\x00\x00\x02..."
+//
+// The payloads are quoted as Go strings. Empty lines and lines starting with
+// "#" are ignored.
+//
+// The package also generates synthetic streams of configurable size, e.g.
+// for benchmarks.
+package pkttest
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/cycloidio/pkt-line"
+)
+
+// FormatPacket returns the trace line of p, without LF.
+func FormatPacket(p pkt.Packet) string {
+	switch p := p.(type) {
+	case pkt.FlushPacket:
+		return "flush"
+	case pkt.DelimPacket:
+		return "delim"
+	case pkt.ResponseEndPacket:
+		return "response-end"
+	case pkt.ErrorPacket:
+		return "error " + strconv.Quote(string(p))
+	case pkt.PackFileIndicatorPacket:
+		return "pack-start"
+	case pkt.PackFilePacket:
+		return "pack " + strconv.Quote(string(p))
+	case pkt.BytesPacket:
+		return "data " + strconv.Quote(string(p))
+	case pkt.StringPacket:
+		return "data " + strconv.Quote(string(p))
+	}
+	// A chunk or another packet: record the packet it encodes to.
+	enc := p.EncodeToPktLine()
+	s := pkt.NewPacketScanner(bytes.NewReader(enc), pkt.WithErrorPackets())
+	if s.Scan() {
+		if _, ok := s.Packet().(pkt.PackFileIndicatorPacket); !ok {
+			q := s.Packet()
+			if !s.Scan() && s.Err() == nil {
+				return FormatPacket(q)
+			}
+		}
+	}
+	return "pack " + strconv.Quote(string(enc))
+}
+
+// ParsePacket parses a trace line.
+func ParsePacket(line string) (pkt.Packet, error) {
+	kind, arg, _ := strings.Cut(strings.TrimSpace(line), " ")
+	switch kind {
+	case "flush":
+		return pkt.FlushPacket{}, nil
+	case "delim":
+		return pkt.DelimPacket{}, nil
+	case "response-end":
+		return pkt.ResponseEndPacket{}, nil
+	case "pack-start":
+		return pkt.PackFileIndicatorPacket{}, nil
+	}
+	s, err := strconv.Unquote(arg)
+	if err != nil {
+		return nil, fmt.Errorf("pkttest: bad payload in %q: %v", line, err)
+	}
+	switch kind {
+	case "data":
+		return pkt.BytesPacket(s), nil
+	case "error":
+		return pkt.ErrorPacket(s), nil
+	case "pack":
+		return pkt.PackFilePacket(s), nil
+	}
+	return nil, fmt.Errorf("pkttest: unknown packet kind in %q", line)
+}
+
+// PacketRecorder writes the trace of packets to a writer.
+type PacketRecorder struct {
+	w   io.Writer
+	err error
+}
+
+// NewPacketRecorder returns a PacketRecorder writing to w.
+func NewPacketRecorder(w io.Writer) *PacketRecorder {
+	return &PacketRecorder{w: w}
+}
+
+// Err returns the first error that was encountered by the PacketRecorder.
+func (r *PacketRecorder) Err() error {
+	return r.err
+}
+
+// Record writes the trace line of p.
+func (r *PacketRecorder) Record(p pkt.Packet) error {
+	if r.err != nil {
+		return r.err
+	}
+	_, r.err = io.WriteString(r.w, FormatPacket(p)+"\n")
+	return r.err
+}
+
+// Comment writes a comment line, e.g. to separate the requests and the
+// responses of a conversation.
+func (r *PacketRecorder) Comment(s string) error {
+	for _, line := range strings.Split(s, "\n") {
+		if r.err != nil {
+			return r.err
+		}
+		_, r.err = io.WriteString(r.w, "# "+line+"\n")
+	}
+	return r.err
+}
+
+// Transformer returns a Transformer recording the packets passing through
+// it, to be used with pkt.Transform in a proxy under test.
+func (r *PacketRecorder) Transformer() pkt.Transformer {
+	return func(p pkt.Packet) ([]pkt.Packet, error) {
+		if err := r.Record(p); err != nil {
+			return nil, err
+		}
+		return []pkt.Packet{p}, nil
+	}
+}
+
+// RecordStream records all the packets read from rd, ERR packets included.
+func (r *PacketRecorder) RecordStream(rd io.Reader) error {
+	s := pkt.NewPacketScanner(rd, pkt.WithErrorPackets())
+	for s.Scan() {
+		if err := r.Record(s.Packet()); err != nil {
+			return err
+		}
+	}
+	return s.Err()
+}
+
+// ReadTrace reads the packets of a trace.
+func ReadTrace(r io.Reader) ([]pkt.Packet, error) {
+	var pkts []pkt.Packet
+	sc := bufio.NewScanner(r)
+	sc.Buffer(nil, 1<<20)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		p, err := ParsePacket(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", n, err)
+		}
+		pkts = append(pkts, p)
+	}
+	return pkts, sc.Err()
+}
+
+// Replayer is an io.Reader of the byte stream of a trace, to be given to the
+// parsers of the pkt package or to the code under test.
+type Replayer struct {
+	pkts []pkt.Packet
+	buf  []byte
+}
+
+// NewReplayer returns a Replayer of the trace read from r.
+func NewReplayer(r io.Reader) (*Replayer, error) {
+	pkts, err := ReadTrace(r)
+	if err != nil {
+		return nil, err
+	}
+	return &Replayer{pkts: pkts}, nil
+}
+
+// Read reads the encoding of the packets of the trace.
+func (r *Replayer) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		if len(r.pkts) == 0 {
+			return 0, io.EOF
+		}
+		r.buf = r.pkts[0].EncodeToPktLine()
+		r.pkts = r.pkts[1:]
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+// Replay returns the byte stream of the trace read from r.
+func Replay(r io.Reader) ([]byte, error) {
+	rp, err := NewReplayer(r)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(rp)
+}
@@ -0,0 +1,204 @@
+// Modified by Giacomo Tartari
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package prototest provides fluent assertions over the packets of a
+// stream, for the tests of Git clients and servers:
+//
+//	prototest.New(t, s).
+//		ExpectCommand("fetch").
+//		ExpectDelim().
+//		ExpectBytesPrefix("want ").
+//		ExpectFlush()
+//
+// A failed assertion stops the test with the index of the packet, the
+// expectation and the packet read, as formatted by pkttest.FormatPacket.
+package prototest
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/cycloidio/pkt-line"
+	"github.com/cycloidio/pkt-line/pkttest"
+)
+
+// TB is the subset of testing.TB used by Expecter.
+type TB interface {
+	Helper()
+	Fatalf(format string, args ...any)
+}
+
+// Expecter checks the packets read by a PacketScanner, in order.
+type Expecter struct {
+	t TB
+	s *pkt.PacketScanner
+	// n is the number of packets read.
+	n    int
+	last pkt.Packet
+}
+
+// New returns an Expecter reading the packets of s.
+func New(t TB, s *pkt.PacketScanner) *Expecter {
+	return &Expecter{t: t, s: s}
+}
+
+// NewReader returns an Expecter reading the packets of rd.
+func NewReader(t TB, rd io.Reader, opts ...pkt.Option) *Expecter {
+	return New(t, pkt.NewPacketScanner(rd, opts...))
+}
+
+// Packet returns the last packet read.
+func (e *Expecter) Packet() pkt.Packet {
+	return e.last
+}
+
+// Index returns the index of the last packet read, from 0, or -1 if none
+// was read.
+func (e *Expecter) Index() int {
+	return e.n - 1
+}
+
+// Next reads the next packet. It fails the test at the end of the stream
+// or on an error, with what was expected.
+func (e *Expecter) Next(want string) pkt.Packet {
+	e.t.Helper()
+	if !e.s.Scan() {
+		if err := e.s.Err(); err != nil {
+			e.t.Fatalf("packet #%d: want %s, got error: %v", e.n, want, err)
+		} else {
+			e.t.Fatalf("packet #%d: want %s, got EOF", e.n, want)
+		}
+		return nil
+	}
+	e.last = e.s.Packet()
+	e.n++
+	return e.last
+}
+
+// Expect reads the next packet and checks it with ok, described by want,
+// e.g. "a shallow line".
+func (e *Expecter) Expect(want string, ok func(pkt.Packet) bool) *Expecter {
+	e.t.Helper()
+	p := e.Next(want)
+	if p != nil && !ok(p) {
+		e.t.Fatalf("packet #%d: want %s, got %s", e.Index(), want, pkttest.FormatPacket(p))
+	}
+	return e
+}
+
+// ExpectFlush expects a flush packet.
+func (e *Expecter) ExpectFlush() *Expecter {
+	e.t.Helper()
+	return e.Expect("flush", func(p pkt.Packet) bool {
+		_, ok := p.(pkt.FlushPacket)
+		return ok
+	})
+}
+
+// ExpectDelim expects a delim packet.
+func (e *Expecter) ExpectDelim() *Expecter {
+	e.t.Helper()
+	return e.Expect("delim", func(p pkt.Packet) bool {
+		_, ok := p.(pkt.DelimPacket)
+		return ok
+	})
+}
+
+// ExpectResponseEnd expects a response-end packet.
+func (e *Expecter) ExpectResponseEnd() *Expecter {
+	e.t.Helper()
+	return e.Expect("response-end", func(p pkt.Packet) bool {
+		_, ok := p.(pkt.ResponseEndPacket)
+		return ok
+	})
+}
+
+// ExpectBytes expects a data packet with the payload b.
+func (e *Expecter) ExpectBytes(b string) *Expecter {
+	e.t.Helper()
+	return e.Expect(fmt.Sprintf("data %q", b), func(p pkt.Packet) bool {
+		bp, ok := p.(pkt.BytesPacket)
+		return ok && string(bp) == b
+	})
+}
+
+// ExpectLine expects a data packet with the text line s, the trailing LF
+// being optional.
+func (e *Expecter) ExpectLine(s string) *Expecter {
+	e.t.Helper()
+	return e.Expect(fmt.Sprintf("line %q", s), func(p pkt.Packet) bool {
+		bp, ok := p.(pkt.BytesPacket)
+		return ok && string(bytes.TrimSuffix(bp, []byte("\n"))) == s
+	})
+}
+
+// ExpectBytesPrefix expects a data packet whose payload starts with prefix,
+// e.g. "ACK ".
+func (e *Expecter) ExpectBytesPrefix(prefix string) *Expecter {
+	e.t.Helper()
+	return e.Expect(fmt.Sprintf("data starting with %q", prefix), func(p pkt.Packet) bool {
+		bp, ok := p.(pkt.BytesPacket)
+		return ok && bytes.HasPrefix(bp, []byte(prefix))
+	})
+}
+
+// ExpectCommand expects the "command=<name>" line of a protocol v2 request.
+func (e *Expecter) ExpectCommand(name string) *Expecter {
+	e.t.Helper()
+	return e.ExpectLine("command=" + name)
+}
+
+// ExpectError expects an ERR packet whose message contains msg. The
+// scanner must be created with pkt.WithErrorPackets.
+func (e *Expecter) ExpectError(msg string) *Expecter {
+	e.t.Helper()
+	return e.Expect(fmt.Sprintf("ERR containing %q", msg), func(p pkt.Packet) bool {
+		ep, ok := p.(pkt.ErrorPacket)
+		return ok && strings.Contains(string(ep), msg)
+	})
+}
+
+// Skip reads n packets, whatever they are.
+func (e *Expecter) Skip(n int) *Expecter {
+	e.t.Helper()
+	for i := 0; i < n; i++ {
+		e.Next("a packet")
+	}
+	return e
+}
+
+// SkipUntilFlush reads the packets up to the next flush packet, included.
+func (e *Expecter) SkipUntilFlush() *Expecter {
+	e.t.Helper()
+	for {
+		switch e.Next("flush").(type) {
+		case pkt.FlushPacket, nil:
+			return e
+		}
+	}
+}
+
+// ExpectEOF expects the end of the stream.
+func (e *Expecter) ExpectEOF() *Expecter {
+	e.t.Helper()
+	if e.s.Scan() {
+		e.t.Fatalf("packet #%d: want EOF, got %s", e.n, pkttest.FormatPacket(e.s.Packet()))
+	} else if err := e.s.Err(); err != nil {
+		e.t.Fatalf("packet #%d: want EOF, got error: %v", e.n, err)
+	}
+	return e
+}
@@ -0,0 +1,138 @@
+// Modified by Giacomo Tartari
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkt
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// PushCertCommand is a ref update command of a push certificate.
+type PushCertCommand struct {
+	OldObjectID string
+	NewObjectID string
+	RefName     string
+}
+
+// PushCert is the certificate of a signed push, sent in place of the commands
+// of a receive-pack request when the server advertises push-cert.
+type PushCert struct {
+	// Pusher is the signing identity followed by the timestamp and time
+	// zone of the push, e.g. "A U Thor <author@example.com> 1700000000 +0000".
+	Pusher string
+	// Pushee is the URL of the repository, if known.
+	Pushee string
+	// Nonce is the nonce advertised by the server with push-cert.
+	Nonce       string
+	PushOptions []string
+	Commands    []PushCertCommand
+	// Signature is the armored signature of Payload, e.g. a
+	// "-----BEGIN PGP SIGNATURE-----" block, lines included.
+	Signature []byte
+}
+
+// Payload returns the signed part of the certificate, against which
+// Signature is verified, as git does with gpg.program or ssh-keygen.
+func (c *PushCert) Payload() []byte {
+	var b bytes.Buffer
+	b.WriteString("certificate version 0.1\n")
+	fmt.Fprintf(&b, "pusher %s\n", c.Pusher)
+	if c.Pushee != "" {
+		fmt.Fprintf(&b, "pushee %s\n", c.Pushee)
+	}
+	fmt.Fprintf(&b, "nonce %s\n", c.Nonce)
+	for _, o := range c.PushOptions {
+		fmt.Fprintf(&b, "push-option %s\n", o)
+	}
+	b.WriteString("\n")
+	for _, cmd := range c.Commands {
+		fmt.Fprintf(&b, "%s %s %s\n", cmd.OldObjectID, cmd.NewObjectID, cmd.RefName)
+	}
+	return b.Bytes()
+}
+
+// Sign sets Signature to the signature of Payload returned by sign, e.g. the
+// output of "gpg --detach-sign --armor" or "ssh-keygen -Y sign -n git".
+func (c *PushCert) Sign(sign func(payload []byte) ([]byte, error)) error {
+	sig, err := sign(c.Payload())
+	if err != nil {
+		return err
+	}
+	c.Signature = sig
+	return nil
+}
+
+// Add records a chunk of a ReceiveRequest read between StartOfPushCert and
+// EndOfPushCert, and reports whether the chunk was part of the certificate.
+// A receive-pack server calls it with every chunk; the certificate is
+// complete once a chunk with EndOfPushCert is added.
+func (c *PushCert) Add(chunk *ReceiveRequestChunk) bool {
+	chunk.Resolve()
+	switch {
+	case chunk.StartOfPushCert, chunk.PushCertHeader, chunk.EndOfCertPushOptions, chunk.EndOfPushCert:
+	case chunk.Pusher != "":
+		c.Pusher = chunk.Pusher
+	case chunk.Pushee != "":
+		c.Pushee = chunk.Pushee
+	case chunk.Nonce != "":
+		c.Nonce = chunk.Nonce
+	case chunk.CertPushOption != "":
+		c.PushOptions = append(c.PushOptions, chunk.CertPushOption)
+	case len(chunk.GPGSignaturePart) != 0:
+		c.Signature = append(c.Signature, chunk.GPGSignaturePart...)
+	case chunk.RefName != "" && c.Nonce != "" && len(c.Signature) == 0:
+		c.Commands = append(c.Commands, PushCertCommand{
+			OldObjectID: chunk.OldObjectID,
+			NewObjectID: chunk.NewObjectID,
+			RefName:     chunk.RefName,
+		})
+	default:
+		return false
+	}
+	return true
+}
+
+// Chunks returns the chunks of the certificate, from the push-cert line with
+// the capabilities of the request to push-cert-end. They replace the command
+// chunks of the request, and are followed by the flush ending the commands.
+func (c *PushCert) Chunks(caps []string) []*ReceiveRequestChunk {
+	if caps == nil {
+		caps = []string{}
+	}
+	chunks := []*ReceiveRequestChunk{
+		{StartOfPushCert: true, Capabilities: caps},
+		{PushCertHeader: true},
+		{Pusher: c.Pusher},
+	}
+	if c.Pushee != "" {
+		chunks = append(chunks, &ReceiveRequestChunk{Pushee: c.Pushee})
+	}
+	chunks = append(chunks, &ReceiveRequestChunk{Nonce: c.Nonce})
+	for _, o := range c.PushOptions {
+		chunks = append(chunks, &ReceiveRequestChunk{CertPushOption: o})
+	}
+	chunks = append(chunks, &ReceiveRequestChunk{EndOfCertPushOptions: true})
+	for _, cmd := range c.Commands {
+		chunks = append(chunks, NewCommandChunk(cmd.OldObjectID, cmd.NewObjectID, cmd.RefName))
+	}
+	for _, line := range strings.SplitAfter(string(c.Signature), "\n") {
+		if line != "" {
+			chunks = append(chunks, &ReceiveRequestChunk{GPGSignaturePart: []byte(line)})
+		}
+	}
+	return append(chunks, &ReceiveRequestChunk{EndOfPushCert: true})
+}
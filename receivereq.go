@@ -69,10 +69,103 @@ type ReceiveRequestChunk struct {
 	EndOfPushOptions bool
 
 	PackStream []byte
+
+	// raw is the payload of the packet the chunk was read from, when its
+	// fields are parsed lazily.
+	raw []byte
+}
+
+// NewCommandChunk returns a chunk for a ref update command. Capabilities
+// must only be given for the first command.
+func NewCommandChunk(oldOID, newOID, ref string, caps ...string) *ReceiveRequestChunk {
+	return &ReceiveRequestChunk{
+		OldObjectID:  oldOID,
+		NewObjectID:  newOID,
+		RefName:      ref,
+		Capabilities: caps,
+	}
+}
+
+// NewEndOfCommandsChunk returns a chunk for the flush packet ending the
+// commands.
+func NewEndOfCommandsChunk() *ReceiveRequestChunk {
+	return &ReceiveRequestChunk{EndOfCommands: true}
+}
+
+// NewPushOptionChunk returns a chunk for a push option.
+func NewPushOptionChunk(opt string) *ReceiveRequestChunk {
+	return &ReceiveRequestChunk{PushOption: opt}
+}
+
+// NewPushOptionsChunks returns the chunks of a push options section: a chunk
+// per option and the flush ending them. The section is sent after the
+// commands, when the server advertises push-options and the client requests
+// it on the first command.
+func NewPushOptionsChunks(opts ...string) []*ReceiveRequestChunk {
+	chunks := make([]*ReceiveRequestChunk, 0, len(opts)+1)
+	for _, o := range opts {
+		chunks = append(chunks, NewPushOptionChunk(o))
+	}
+	return append(chunks, NewEndOfPushOptionsChunk())
+}
+
+// NewEndOfPushOptionsChunk returns a chunk for the flush packet ending the
+// push options.
+func NewEndOfPushOptionsChunk() *ReceiveRequestChunk {
+	return &ReceiveRequestChunk{EndOfPushOptions: true}
+}
+
+// Validate checks that the chunk can be encoded.
+func (c *ReceiveRequestChunk) Validate() error {
+	// A chunk read with WithLazyFields encodes the packet it was read from.
+	if c.raw != nil {
+		return nil
+	}
+	c.Resolve()
+	v := NewChunkValidator("ReceiveRequestChunk")
+	command := c.OldObjectID != "" || c.NewObjectID != "" || c.RefName != ""
+	v.Kind("ClientShallow", c.ClientShallow != "")
+	v.Kind("OldObjectID, NewObjectID, RefName", command)
+	v.Kind("EndOfCommands", c.EndOfCommands)
+	v.Kind("StartOfPushCert", c.StartOfPushCert)
+	v.Kind("PushCertHeader", c.PushCertHeader)
+	v.Kind("Pusher", c.Pusher != "")
+	v.Kind("Pushee", c.Pushee != "")
+	v.Kind("Nonce", c.Nonce != "")
+	v.Kind("CertPushOption", c.CertPushOption != "")
+	v.Kind("EndOfCertPushOptions", c.EndOfCertPushOptions)
+	v.Kind("GPGSignaturePart", len(c.GPGSignaturePart) != 0)
+	v.Kind("EndOfPushCert", c.EndOfPushCert)
+	v.Kind("PushOption", c.PushOption != "")
+	v.Kind("EndOfPushOptions", c.EndOfPushOptions)
+	v.Kind("PackStream", len(c.PackStream) != 0)
+	if command && (c.OldObjectID == "" || c.NewObjectID == "" || c.RefName == "") {
+		v.Fail("a command needs OldObjectID, NewObjectID and RefName")
+	}
+	if len(c.Capabilities) != 0 && !command && !c.StartOfPushCert {
+		v.Fail("Capabilities are set without a command or StartOfPushCert")
+	}
+	for _, cp := range c.Capabilities {
+		v.Line("Capabilities", cp)
+	}
+	v.Line("ClientShallow", c.ClientShallow)
+	v.Line("OldObjectID", c.OldObjectID)
+	v.Line("NewObjectID", c.NewObjectID)
+	v.Line("RefName", c.RefName)
+	v.Line("Pusher", c.Pusher)
+	v.Line("Pushee", c.Pushee)
+	v.Line("Nonce", c.Nonce)
+	v.Line("CertPushOption", c.CertPushOption)
+	v.Line("PushOption", c.PushOption)
+	v.Payload("GPGSignaturePart", len(c.GPGSignaturePart))
+	return v.Err()
 }
 
 // EncodeToPktLine serializes the chunk.
 func (c *ReceiveRequestChunk) EncodeToPktLine() []byte {
+	if c.raw != nil {
+		return BytesPacket(c.raw).EncodeToPktLine()
+	}
 	if c.ClientShallow != "" {
 		return BytesPacket([]byte(fmt.Sprintf("shallow %s\n", c.ClientShallow))).EncodeToPktLine()
 	}
@@ -129,15 +222,20 @@ func (c *ReceiveRequestChunk) EncodeToPktLine() []byte {
 // git-receive-pack request.
 type ReceiveRequest struct {
 	scanner *PacketScanner
+	cfg     *Config
 	state   ReceiveRequestState
 	err     error
 	curr    *ReceiveRequestChunk
+
+	// pushOptions is set when the client requested the push-options
+	// capability, without which no push option can follow the commands.
+	pushOptions bool
 }
 
 // NewReceiveRequest returns a new ProtocolV1ReceivePackRequest to
 // read from rd.
-func NewReceiveRequest(rd io.Reader) *ReceiveRequest {
-	return &ReceiveRequest{scanner: NewPacketScanner(rd)}
+func NewReceiveRequest(rd io.Reader, opts ...Option) *ReceiveRequest {
+	return &ReceiveRequest{scanner: NewPacketScanner(rd, opts...), cfg: NewConfig(opts...)}
 }
 
 // Err returns the first non-EOF error that was encountered by the
@@ -151,11 +249,38 @@ func (r *ReceiveRequest) Chunk() *ReceiveRequestChunk {
 	return r.curr
 }
 
+// Reset prepares r to read the next document of its input, e.g. the
+// response following an advertisement in a capture of a stateless HTTP
+// exchange, reusing its buffers. See PacketScanner.Reset.
+func (r *ReceiveRequest) Reset() {
+	r.scanner.Reset()
+	*r = ReceiveRequest{scanner: r.scanner, cfg: r.cfg}
+}
+
+// Rest returns the unread part of the input, e.g. to continue with the next
+// exchange of a stateful connection once Scan returned false at the end of
+// the receive request. r must not be used afterwards.
+func (r *ReceiveRequest) Rest() io.Reader {
+	return r.scanner.Rest()
+}
+
 // Scan advances the scanner to the next packet. It returns false when the scan
 // stops, either by reaching the end of the input or an error. After scan
 // returns false, the Err method will return any error that occurred during
 // scanning, except that if it was io.EOF, Err will return nil.
+// A malformed stream is reported as a ParseError.
 func (r *ReceiveRequest) Scan() bool {
+	from := r.state
+	ok := r.scan()
+	r.scanner.TraceStateTransition(from, r.state)
+	if ok {
+		return true
+	}
+	r.err = r.scanner.NewParseError(r.err, r.state)
+	return false
+}
+
+func (r *ReceiveRequest) scan() bool {
 	if r.err != nil {
 		return false
 	}
@@ -171,15 +296,27 @@ func (r *ReceiveRequest) Scan() bool {
 transition:
 	switch r.state {
 	case ReceiveRequestBegin:
+		if _, ok := pkt.(FlushPacket); ok {
+			// A client with nothing to update only sends a flush.
+			r.state = ReceiveRequestScanOptionalPushOptions
+			r.curr = r.cfg.Arena.NewReceiveRequestChunk(ReceiveRequestChunk{
+				EndOfCommands: true,
+			})
+			return true
+		}
 		bp, ok := pkt.(BytesPacket)
 		if !ok {
 			r.err = SyntaxError(fmt.Sprintf("unexpected packet: %#v", pkt))
 			return false
 		}
 		if bytes.HasPrefix(bp, []byte("shallow ")) {
-			r.curr = &ReceiveRequestChunk{
-				ClientShallow: strings.TrimPrefix(strings.TrimSuffix(string(bp), "\n"), "shallow "),
+			shallow := strings.TrimPrefix(strings.TrimSuffix(r.cfg.Arena.String(bp), "\n"), "shallow ")
+			if r.err = r.cfg.checkObjectID(shallow); r.err != nil {
+				return false
 			}
+			r.curr = r.cfg.Arena.NewReceiveRequestChunk(ReceiveRequestChunk{
+				ClientShallow: shallow,
+			})
 			return true
 		}
 		if bytes.HasPrefix(bp, []byte("push-cert\x00")) {
@@ -199,43 +336,57 @@ transition:
 			r.err = SyntaxError("cannot split into two: " + string(bp))
 			return false
 		}
-		caps := []string{}
-		if capStr := strings.TrimPrefix(strings.TrimSuffix(string(zss[1]), "\n"), " "); capStr != "" {
-			// This is to avoid strings.Split("", " ") => []string{""}.
-			caps = strings.Split(capStr, " ")
-		}
+		caps := r.cfg.splitCapabilities(strings.TrimPrefix(strings.TrimSuffix(string(zss[1]), "\n"), " "))
 		ss := strings.SplitN(string(zss[0]), " ", 3)
 		if len(ss) != 3 {
 			r.err = SyntaxError("cannot split into three: " + string(zss[0]))
 			return false
 		}
+		if r.err = r.cfg.CheckObjectIDs(ss[0], ss[1]); r.err != nil {
+			return false
+		}
+		if r.err = r.cfg.CheckRefName(ss[2]); r.err != nil {
+			return false
+		}
 		r.state = ReceiveRequestScanCommand
-		r.curr = &ReceiveRequestChunk{
+		r.pushOptions = Capabilities(caps).Has("push-options")
+		r.curr = r.cfg.Arena.NewReceiveRequestChunk(ReceiveRequestChunk{
 			Capabilities: caps,
 			OldObjectID:  ss[0],
 			NewObjectID:  ss[1],
 			RefName:      ss[2],
-		}
+		})
 		return true
 	case ReceiveRequestScanCommand:
 		switch p := pkt.(type) {
 		case FlushPacket:
 			r.state = ReceiveRequestScanOptionalPushOptions
-			r.curr = &ReceiveRequestChunk{
+			r.curr = r.cfg.Arena.NewReceiveRequestChunk(ReceiveRequestChunk{
 				EndOfCommands: true,
-			}
+			})
 			return true
 		case BytesPacket:
-			ss := strings.SplitN(strings.TrimSuffix(string(p), "\n"), " ", 3)
+			if r.cfg.lazy() && bytes.Count(p, []byte(" ")) >= 2 &&
+				r.cfg.checkLazy(p, 0) == nil && r.cfg.checkLazy(p, r.cfg.ObjectFormat.HexSize()+1) == nil {
+				r.curr = r.cfg.Arena.NewReceiveRequestChunk(ReceiveRequestChunk{raw: p})
+				return true
+			}
+			ss := strings.SplitN(strings.TrimSuffix(r.cfg.Arena.String(p), "\n"), " ", 3)
 			if len(ss) != 3 {
 				r.err = SyntaxError("cannot split into three: " + string(p))
 				return false
 			}
-			r.curr = &ReceiveRequestChunk{
+			if r.err = r.cfg.CheckObjectIDs(ss[0], ss[1]); r.err != nil {
+				return false
+			}
+			if r.err = r.cfg.CheckRefName(ss[2]); r.err != nil {
+				return false
+			}
+			r.curr = r.cfg.Arena.NewReceiveRequestChunk(ReceiveRequestChunk{
 				OldObjectID: ss[0],
 				NewObjectID: ss[1],
 				RefName:     ss[2],
-			}
+			})
 			return true
 		default:
 			r.err = SyntaxError(fmt.Sprintf("unexpected packet: %#v", p))
@@ -252,16 +403,13 @@ transition:
 			r.err = SyntaxError("cannot split into two: " + string(bp))
 			return false
 		}
-		caps := []string{}
-		if capStr := strings.TrimPrefix(strings.TrimSuffix(string(zss[1]), "\n"), " "); capStr != "" {
-			// This is to avoid strings.Split("", " ") => []string{""}.
-			caps = strings.Split(capStr, " ")
-		}
+		caps := r.cfg.splitCapabilities(strings.TrimPrefix(strings.TrimSuffix(string(zss[1]), "\n"), " "))
 		r.state = ReceiveRequestScanCertVersion
-		r.curr = &ReceiveRequestChunk{
+		r.pushOptions = Capabilities(caps).Has("push-options")
+		r.curr = r.cfg.Arena.NewReceiveRequestChunk(ReceiveRequestChunk{
 			Capabilities:    caps,
 			StartOfPushCert: true,
-		}
+		})
 		return true
 	case ReceiveRequestScanCertVersion:
 		bp, ok := pkt.(BytesPacket)
@@ -274,9 +422,9 @@ transition:
 			return false
 		}
 		r.state = ReceiveRequestScanCertPusher
-		r.curr = &ReceiveRequestChunk{
+		r.curr = r.cfg.Arena.NewReceiveRequestChunk(ReceiveRequestChunk{
 			PushCertHeader: true,
-		}
+		})
 		return true
 	case ReceiveRequestScanCertPusher:
 		bp, ok := pkt.(BytesPacket)
@@ -284,7 +432,7 @@ transition:
 			r.err = SyntaxError(fmt.Sprintf("unexpected packet: %#v", pkt))
 			return false
 		}
-		ss := strings.SplitN(strings.TrimSuffix(string(bp), "\n"), " ", 2)
+		ss := strings.SplitN(strings.TrimSuffix(r.cfg.Arena.String(bp), "\n"), " ", 2)
 		if len(ss) != 2 {
 			r.err = SyntaxError("cannot split into two: " + string(bp))
 			return false
@@ -294,9 +442,9 @@ transition:
 			return false
 		}
 		r.state = ReceiveRequestScanCertPusheeOrNonce
-		r.curr = &ReceiveRequestChunk{
+		r.curr = r.cfg.Arena.NewReceiveRequestChunk(ReceiveRequestChunk{
 			Pusher: ss[1],
-		}
+		})
 		return true
 	case ReceiveRequestScanCertPusheeOrNonce:
 		bp, ok := pkt.(BytesPacket)
@@ -304,7 +452,7 @@ transition:
 			r.err = SyntaxError(fmt.Sprintf("unexpected packet: %#v", pkt))
 			return false
 		}
-		ss := strings.SplitN(strings.TrimSuffix(string(bp), "\n"), " ", 2)
+		ss := strings.SplitN(strings.TrimSuffix(r.cfg.Arena.String(bp), "\n"), " ", 2)
 		if len(ss) != 2 {
 			r.err = SyntaxError("cannot split into two: " + string(bp))
 			return false
@@ -318,9 +466,9 @@ transition:
 			return false
 		}
 		r.state = ReceiveRequestScanCertNonce
-		r.curr = &ReceiveRequestChunk{
+		r.curr = r.cfg.Arena.NewReceiveRequestChunk(ReceiveRequestChunk{
 			Pushee: ss[1],
-		}
+		})
 		return true
 	case ReceiveRequestScanCertNonce:
 		bp, ok := pkt.(BytesPacket)
@@ -328,7 +476,7 @@ transition:
 			r.err = SyntaxError(fmt.Sprintf("unexpected packet: %#v", pkt))
 			return false
 		}
-		ss := strings.SplitN(strings.TrimSuffix(string(bp), "\n"), " ", 2)
+		ss := strings.SplitN(strings.TrimSuffix(r.cfg.Arena.String(bp), "\n"), " ", 2)
 		if len(ss) != 2 {
 			r.err = SyntaxError("cannot split into two: " + string(bp))
 			return false
@@ -338,9 +486,9 @@ transition:
 			return false
 		}
 		r.state = ReceiveRequestScanOptionalCertPushOptions
-		r.curr = &ReceiveRequestChunk{
+		r.curr = r.cfg.Arena.NewReceiveRequestChunk(ReceiveRequestChunk{
 			Nonce: ss[1],
-		}
+		})
 		return true
 	case ReceiveRequestScanOptionalCertPushOptions:
 		bp, ok := pkt.(BytesPacket)
@@ -350,12 +498,12 @@ transition:
 		}
 		if string(bp) == "\n" {
 			r.state = ReceiveRequestScanCertCommand
-			r.curr = &ReceiveRequestChunk{
+			r.curr = r.cfg.Arena.NewReceiveRequestChunk(ReceiveRequestChunk{
 				EndOfCertPushOptions: true,
-			}
+			})
 			return true
 		}
-		ss := strings.SplitN(strings.TrimSuffix(string(bp), "\n"), " ", 2)
+		ss := strings.SplitN(strings.TrimSuffix(r.cfg.Arena.String(bp), "\n"), " ", 2)
 		if len(ss) != 2 {
 			r.err = SyntaxError("cannot split into two: " + string(bp))
 			return false
@@ -364,9 +512,9 @@ transition:
 			r.err = SyntaxError(fmt.Sprintf("unexpected packet: %#v", string(bp)))
 			return false
 		}
-		r.curr = &ReceiveRequestChunk{
+		r.curr = r.cfg.Arena.NewReceiveRequestChunk(ReceiveRequestChunk{
 			CertPushOption: ss[1],
-		}
+		})
 		return true
 	case ReceiveRequestScanCertCommand:
 		bp, ok := pkt.(BytesPacket)
@@ -374,20 +522,27 @@ transition:
 			r.err = SyntaxError(fmt.Sprintf("unexpected packet: %#v", pkt))
 			return false
 		}
-		if string(bp) == "-----BEGIN PGP SIGNATURE-----\n" {
+		// The signature is a PGP, SSH or X.509 armored block.
+		if bytes.HasPrefix(bp, []byte("-----BEGIN ")) {
 			r.state = ReceiveRequestScanCertGPGLine
 			goto transition
 		}
-		ss := strings.SplitN(strings.TrimSuffix(string(bp), "\n"), " ", 3)
+		ss := strings.SplitN(strings.TrimSuffix(r.cfg.Arena.String(bp), "\n"), " ", 3)
 		if len(ss) != 3 {
 			r.err = SyntaxError("cannot split into three: " + string(bp))
 			return false
 		}
-		r.curr = &ReceiveRequestChunk{
+		if r.err = r.cfg.CheckObjectIDs(ss[0], ss[1]); r.err != nil {
+			return false
+		}
+		if r.err = r.cfg.CheckRefName(ss[2]); r.err != nil {
+			return false
+		}
+		r.curr = r.cfg.Arena.NewReceiveRequestChunk(ReceiveRequestChunk{
 			OldObjectID: ss[0],
 			NewObjectID: ss[1],
 			RefName:     ss[2],
-		}
+		})
 		return true
 	case ReceiveRequestScanCertGPGLine:
 		bp, ok := pkt.(BytesPacket)
@@ -396,15 +551,17 @@ transition:
 			return false
 		}
 		if string(bp) == "push-cert-end\n" {
-			r.state = ReceiveRequestScanPushOptions
-			r.curr = &ReceiveRequestChunk{
+			// The commands are in the certificate; the flush ending
+			// them follows.
+			r.state = ReceiveRequestScanCommand
+			r.curr = r.cfg.Arena.NewReceiveRequestChunk(ReceiveRequestChunk{
 				EndOfPushCert: true,
-			}
+			})
 			return true
 		}
-		r.curr = &ReceiveRequestChunk{
+		r.curr = r.cfg.Arena.NewReceiveRequestChunk(ReceiveRequestChunk{
 			GPGSignaturePart: bp,
-		}
+		})
 		return true
 	case ReceiveRequestScanOptionalPushOptions:
 		if _, ok := pkt.(PackFileIndicatorPacket); ok {
@@ -416,33 +573,51 @@ transition:
 			r.err = SyntaxError(fmt.Sprintf("unexpected packet: %#v", pkt))
 			return false
 		}
-		r.state = ReceiveRequestScanPushOptions
-		r.curr = &ReceiveRequestChunk{
-			PushOption: strings.TrimSuffix(string(bp), "\n"),
+		if !r.pushOptions {
+			r.err = SyntaxError(fmt.Sprintf("push option without the push-options capability: %#v", string(bp)))
+			return false
 		}
+		r.state = ReceiveRequestScanPushOptions
+		r.curr = r.cfg.Arena.NewReceiveRequestChunk(ReceiveRequestChunk{
+			PushOption: strings.TrimSuffix(r.cfg.Arena.String(bp), "\n"),
+		})
 		return true
 	case ReceiveRequestScanPushOptions:
 		switch p := pkt.(type) {
 		case FlushPacket:
 			r.state = ReceiveRequestScanPackFile
-			r.curr = &ReceiveRequestChunk{
+			r.curr = r.cfg.Arena.NewReceiveRequestChunk(ReceiveRequestChunk{
 				EndOfPushOptions: true,
-			}
+			})
 			return true
 		case BytesPacket:
-			r.curr = &ReceiveRequestChunk{
-				PushOption: strings.TrimSuffix(string(p), "\n"),
-			}
+			r.curr = r.cfg.Arena.NewReceiveRequestChunk(ReceiveRequestChunk{
+				PushOption: strings.TrimSuffix(r.cfg.Arena.String(p), "\n"),
+			})
 			return true
 		default:
 			r.err = SyntaxError(fmt.Sprintf("unexpected packet: %#v", p))
 			return false
 		}
 	case ReceiveRequestScanPackFile:
-		r.curr = &ReceiveRequestChunk{
+		r.curr = r.cfg.Arena.NewReceiveRequestChunk(ReceiveRequestChunk{
 			PackStream: pkt.EncodeToPktLine(),
-		}
+		})
 		return true
 	}
 	panic("impossible state")
 }
+
+// WritePackTo writes the rest of the pack file to w with
+// PacketScanner.WritePackTo, instead of returning it chunk by chunk. It must
+// be called after Scan returned the chunk holding the "PACK" signature.
+func (r *ReceiveRequest) WritePackTo(w io.Writer) (int64, error) {
+	if r.err != nil {
+		return 0, r.err
+	}
+	n, err := r.scanner.WritePackTo(w)
+	if err != nil {
+		r.err = err
+	}
+	return n, err
+}
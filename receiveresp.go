@@ -16,6 +16,7 @@
 package pkt
 
 import (
+	"bytes"
 	"fmt"
 	"io"
 	"strings"
@@ -26,6 +27,7 @@ type ReceiveResponseState int
 const (
 	ReceiveResponseBegin ReceiveResponseState = iota
 	ReceiveResponseScanResult
+	ReceiveResponseScanRefOptions
 	ReceiveResponseEnd
 )
 
@@ -36,11 +38,97 @@ type ReceiveResponseChunk struct {
 	RefUpdateStatus      string
 	RefName              string
 	RefUpdateFailMessage string
-	EndOfResponse        bool
+	// RefOption and RefOptionValue are an "option" line of
+	// report-status-v2, e.g. "new-oid" and the object ID, following a ref
+	// result. The value of forced-update is empty.
+	RefOption      string
+	RefOptionValue string
+	EndOfResponse  bool
+	// Delim is a delim packet, read with DelimExpose.
+	Delim bool
+	// RemoteError is the message of an "ERR" packet, read with
+	// WithErrorPackets.
+	RemoteError string
+
+	// raw is the payload of the packet the chunk was read from, when its
+	// fields are parsed lazily.
+	raw []byte
+}
+
+// NewUnpackOK returns a chunk for a successful "unpack" line.
+func NewUnpackOK() *ReceiveResponseChunk {
+	return &ReceiveResponseChunk{UnpackStatus: "ok"}
+}
+
+// NewUnpackError returns a chunk for a failed "unpack" line.
+func NewUnpackError(msg string) *ReceiveResponseChunk {
+	return &ReceiveResponseChunk{UnpackStatus: msg}
+}
+
+// NewRefResultOK returns a chunk reporting that the ref was updated.
+func NewRefResultOK(ref string) *ReceiveResponseChunk {
+	return &ReceiveResponseChunk{RefUpdateStatus: "ok", RefName: ref}
+}
+
+// NewRefResultNG returns a chunk reporting that the ref was not updated
+// because of reason, which must not be empty.
+func NewRefResultNG(ref, reason string) *ReceiveResponseChunk {
+	return &ReceiveResponseChunk{RefUpdateStatus: "ng", RefName: ref, RefUpdateFailMessage: reason}
+}
+
+// NewRefOptionChunk returns a chunk for an "option" line of
+// report-status-v2, e.g. NewRefOptionChunk("old-oid", oid) or
+// NewRefOptionChunk("forced-update", "").
+func NewRefOptionChunk(key, value string) *ReceiveResponseChunk {
+	return &ReceiveResponseChunk{RefOption: key, RefOptionValue: value}
+}
+
+// NewReceiveResponseEndChunk returns a chunk for the flush packet ending the
+// response.
+func NewReceiveResponseEndChunk() *ReceiveResponseChunk {
+	return &ReceiveResponseChunk{EndOfResponse: true}
+}
+
+// Validate checks that the chunk can be encoded.
+func (c *ReceiveResponseChunk) Validate() error {
+	// A chunk read with WithLazyFields encodes the packet it was read from.
+	if c.raw != nil {
+		return nil
+	}
+	c.Resolve()
+	v := NewChunkValidator("ReceiveResponseChunk")
+	v.Kind("UnpackStatus", c.UnpackStatus != "")
+	v.Kind("RefUpdateStatus", c.RefUpdateStatus != "")
+	v.Kind("RefOption", c.RefOption != "")
+	v.Kind("EndOfResponse", c.EndOfResponse)
+	v.Kind("Delim", c.Delim)
+	v.Kind("RemoteError", c.RemoteError != "")
+	if c.RefOption == "" && c.RefOptionValue != "" {
+		v.Fail("RefOptionValue is set without RefOption")
+	}
+	if strings.Contains(c.RefOption, " ") {
+		v.Fail("RefOption contains a space")
+	}
+	if c.RefUpdateStatus == "" && (c.RefName != "" || c.RefUpdateFailMessage != "") {
+		v.Fail("RefName or RefUpdateFailMessage is set without RefUpdateStatus")
+	}
+	if c.RefUpdateStatus != "" && c.RefName == "" {
+		v.Fail("RefUpdateStatus is set without RefName")
+	}
+	v.Line("UnpackStatus", c.UnpackStatus)
+	v.Line("RefUpdateStatus", c.RefUpdateStatus)
+	v.Line("RefName", c.RefName)
+	v.Line("RefUpdateFailMessage", c.RefUpdateFailMessage)
+	v.Line("RefOption", c.RefOption)
+	v.Line("RefOptionValue", c.RefOptionValue)
+	return v.Err()
 }
 
 // EncodeToPktLine serializes the chunk.
 func (c *ReceiveResponseChunk) EncodeToPktLine() []byte {
+	if c.raw != nil {
+		return BytesPacket(c.raw).EncodeToPktLine()
+	}
 	if c.UnpackStatus != "" {
 		return BytesPacket([]byte(fmt.Sprintf("unpack %s\n", c.UnpackStatus))).EncodeToPktLine()
 	}
@@ -50,9 +138,21 @@ func (c *ReceiveResponseChunk) EncodeToPktLine() []byte {
 		}
 		return BytesPacket([]byte(fmt.Sprintf("%s %s %s\n", c.RefUpdateStatus, c.RefName, c.RefUpdateFailMessage))).EncodeToPktLine()
 	}
+	if c.RefOption != "" {
+		if c.RefOptionValue == "" {
+			return BytesPacket([]byte(fmt.Sprintf("option %s\n", c.RefOption))).EncodeToPktLine()
+		}
+		return BytesPacket([]byte(fmt.Sprintf("option %s %s\n", c.RefOption, c.RefOptionValue))).EncodeToPktLine()
+	}
 	if c.EndOfResponse {
 		return FlushPacket{}.EncodeToPktLine()
 	}
+	if c.Delim {
+		return DelimPacket{}.EncodeToPktLine()
+	}
+	if c.RemoteError != "" {
+		return ErrorPacket(c.RemoteError).EncodeToPktLine()
+	}
 	panic("impossible chunk")
 }
 
@@ -60,6 +160,7 @@ func (c *ReceiveResponseChunk) EncodeToPktLine() []byte {
 // git-receive-pack response.
 type ReceiveResponse struct {
 	scanner *PacketScanner
+	cfg     *Config
 	state   ReceiveResponseState
 	err     error
 	curr    *ReceiveResponseChunk
@@ -67,8 +168,8 @@ type ReceiveResponse struct {
 
 // NewReceiveResponse returns a new ReceiveResponse
 // to read from rd.
-func NewReceiveResponse(rd io.Reader) *ReceiveResponse {
-	return &ReceiveResponse{scanner: NewPacketScanner(rd)}
+func NewReceiveResponse(rd io.Reader, opts ...Option) *ReceiveResponse {
+	return &ReceiveResponse{scanner: NewPacketScanner(rd, opts...), cfg: NewConfig(opts...)}
 }
 
 // Err returns the first non-EOF error that was encountered by the
@@ -82,15 +183,42 @@ func (r *ReceiveResponse) Chunk() *ReceiveResponseChunk {
 	return r.curr
 }
 
+// Reset prepares r to read the next document of its input, e.g. the
+// response following an advertisement in a capture of a stateless HTTP
+// exchange, reusing its buffers. See PacketScanner.Reset.
+func (r *ReceiveResponse) Reset() {
+	r.scanner.Reset()
+	*r = ReceiveResponse{scanner: r.scanner, cfg: r.cfg}
+}
+
+// Rest returns the unread part of the input, e.g. to continue with the next
+// exchange of a stateful connection once Scan returned false at the end of
+// the receive response. r must not be used afterwards.
+func (r *ReceiveResponse) Rest() io.Reader {
+	return r.scanner.Rest()
+}
+
 // Scan advances the scanner to the next packet. It returns false when the scan
 // stops, either by reaching the end of the input or an error. After scan
 // returns false, the Err method will return any error that occurred during
 // scanning, except that if it was io.EOF, Err will return nil.
+// A malformed stream is reported as a ParseError.
 func (r *ReceiveResponse) Scan() bool {
+	from := r.state
+	ok := r.scan()
+	r.scanner.TraceStateTransition(from, r.state)
+	if ok {
+		return true
+	}
+	r.err = r.scanner.NewParseError(r.err, r.state)
+	return false
+}
+
+func (r *ReceiveResponse) scan() bool {
 	if r.err != nil || r.state == ReceiveResponseEnd {
 		return false
 	}
-	if !r.scanner.Scan() {
+	if !r.cfg.scanPacket(r.scanner) {
 		r.err = r.scanner.Err()
 		if r.err == nil && r.state != ReceiveResponseBegin {
 			r.err = SyntaxError("early EOF")
@@ -98,6 +226,15 @@ func (r *ReceiveResponse) Scan() bool {
 		return false
 	}
 	pkt := r.scanner.Packet()
+	if _, ok := pkt.(DelimPacket); ok && r.cfg.DelimPolicy == DelimExpose {
+		r.curr = r.cfg.Arena.NewReceiveResponseChunk(ReceiveResponseChunk{Delim: true})
+		return true
+	}
+	if ep, ok := pkt.(ErrorPacket); ok {
+		// The state is kept, the server may send more packets.
+		r.curr = r.cfg.Arena.NewReceiveResponseChunk(ReceiveResponseChunk{RemoteError: string(ep)})
+		return true
+	}
 	switch r.state {
 	case ReceiveResponseBegin:
 		bp, ok := pkt.(BytesPacket)
@@ -105,32 +242,57 @@ func (r *ReceiveResponse) Scan() bool {
 			r.err = SyntaxError(fmt.Sprintf("unexpected packet: %#v", pkt))
 			return false
 		}
-		s := strings.TrimSuffix(string(bp), "\n")
+		s := strings.TrimSuffix(r.cfg.Arena.String(bp), "\n")
 		if !strings.HasPrefix(s, "unpack ") {
 			r.err = SyntaxError(fmt.Sprintf("unexpected packet: %#v", s))
 			return false
 		}
 		r.state = ReceiveResponseScanResult
-		r.curr = &ReceiveResponseChunk{
+		r.curr = r.cfg.Arena.NewReceiveResponseChunk(ReceiveResponseChunk{
 			UnpackStatus: strings.SplitN(s, " ", 2)[1],
-		}
+		})
 		return true
-	case ReceiveResponseScanResult:
+	case ReceiveResponseScanResult, ReceiveResponseScanRefOptions:
 		switch p := pkt.(type) {
 		case FlushPacket:
 			r.state = ReceiveResponseEnd
-			r.curr = &ReceiveResponseChunk{
+			r.curr = r.cfg.Arena.NewReceiveResponseChunk(ReceiveResponseChunk{
 				EndOfResponse: true,
-			}
+			})
 			return true
 		case BytesPacket:
-			s := strings.TrimSuffix(string(p), "\n")
+			if bytes.HasPrefix(p, []byte("option ")) {
+				if r.state != ReceiveResponseScanRefOptions {
+					r.err = SyntaxError(fmt.Sprintf("unexpected packet: %#v", p))
+					return false
+				}
+				ss := strings.SplitN(strings.TrimSuffix(strings.TrimPrefix(r.cfg.Arena.String(p), "option "), "\n"), " ", 2)
+				c := ReceiveResponseChunk{RefOption: ss[0]}
+				if len(ss) == 2 {
+					c.RefOptionValue = ss[1]
+				}
+				if r.err = r.checkRefOption(c.RefOption, c.RefOptionValue); r.err != nil {
+					return false
+				}
+				r.curr = r.cfg.Arena.NewReceiveResponseChunk(c)
+				return true
+			}
+			// The options of report-status-v2 follow a ref result.
+			r.state = ReceiveResponseScanRefOptions
+			if r.cfg.lazy() && (bytes.HasPrefix(p, []byte("ok ")) || bytes.HasPrefix(p, []byte("ng ")) && bytes.Count(p, []byte(" ")) >= 2) {
+				r.curr = r.cfg.Arena.NewReceiveResponseChunk(ReceiveResponseChunk{raw: p})
+				return true
+			}
+			s := strings.TrimSuffix(r.cfg.Arena.String(p), "\n")
 			if strings.HasPrefix(s, "ok ") {
 				ss := strings.SplitN(s, " ", 2)
-				r.curr = &ReceiveResponseChunk{
+				if r.err = r.cfg.CheckRefName(ss[1]); r.err != nil {
+					return false
+				}
+				r.curr = r.cfg.Arena.NewReceiveResponseChunk(ReceiveResponseChunk{
 					RefUpdateStatus: ss[0],
 					RefName:         ss[1],
-				}
+				})
 				return true
 			}
 			if strings.HasPrefix(s, "ng ") {
@@ -139,11 +301,14 @@ func (r *ReceiveResponse) Scan() bool {
 					r.err = SyntaxError("cannot split into three: " + s)
 					return false
 				}
-				r.curr = &ReceiveResponseChunk{
+				if r.err = r.cfg.CheckRefName(ss[1]); r.err != nil {
+					return false
+				}
+				r.curr = r.cfg.Arena.NewReceiveResponseChunk(ReceiveResponseChunk{
 					RefUpdateStatus:      ss[0],
 					RefName:              ss[1],
 					RefUpdateFailMessage: ss[2],
-				}
+				})
 				return true
 			}
 			r.err = SyntaxError(fmt.Sprintf("unexpected packet: %#v", p))
@@ -155,3 +320,150 @@ func (r *ReceiveResponse) Scan() bool {
 	}
 	panic("impossible state")
 }
+
+// checkRefOption validates an option of report-status-v2 in strict mode.
+func (r *ReceiveResponse) checkRefOption(name, value string) error {
+	if err := r.cfg.CheckStatus("ref option", name, "refname", "old-oid", "new-oid", "forced-update"); err != nil {
+		return err
+	}
+	if !r.cfg.StrictMode {
+		return nil
+	}
+	switch name {
+	case "refname":
+		return r.cfg.CheckRefName(value)
+	case "old-oid", "new-oid":
+		return r.cfg.checkObjectID(value)
+	}
+	if value != "" {
+		return &FieldError{Field: "ref option value", Value: value, Err: SyntaxError("unexpected value for " + name)}
+	}
+	return nil
+}
+
+// ReceiveResponseEvent is a typed view of a ReceiveResponseChunk, to be used
+// in a type switch. It is one of UnpackResultEvent, RefResultEvent,
+// RefOptionEvent, ReceiveEndEvent, ReceiveDelimEvent and
+// ReceiveRemoteErrorEvent; none of them is an UploadResponseEvent.
+type ReceiveResponseEvent interface {
+	Packet
+	// Chunk returns the equivalent chunk.
+	Chunk() *ReceiveResponseChunk
+	receiveResponseEvent()
+}
+
+// UnpackResultEvent is the "unpack" line of a report-status response. Status
+// is "ok" or the error message.
+type UnpackResultEvent struct {
+	Status string
+}
+
+// OK returns true if the pack was unpacked successfully.
+func (e UnpackResultEvent) OK() bool {
+	return e.Status == "ok"
+}
+
+// RefResultEvent is the "ok" or "ng" line reporting the result of a ref
+// update. Message is the reason of an "ng".
+type RefResultEvent struct {
+	Status  string
+	RefName string
+	Message string
+}
+
+// OK returns true if the ref was updated.
+func (e RefResultEvent) OK() bool {
+	return e.Status == "ok"
+}
+
+// RefOptionEvent is an "option" line of report-status-v2, following a
+// RefResultEvent. Key is one of refname, old-oid, new-oid and forced-update.
+type RefOptionEvent struct {
+	Key   string
+	Value string
+}
+
+// ReceiveEndEvent is the flush packet ending a receive-pack response.
+type ReceiveEndEvent struct{}
+
+// Chunk returns the equivalent chunk.
+func (e UnpackResultEvent) Chunk() *ReceiveResponseChunk {
+	return &ReceiveResponseChunk{UnpackStatus: e.Status}
+}
+
+// Chunk returns the equivalent chunk.
+func (e RefResultEvent) Chunk() *ReceiveResponseChunk {
+	return &ReceiveResponseChunk{
+		RefUpdateStatus:      e.Status,
+		RefName:              e.RefName,
+		RefUpdateFailMessage: e.Message,
+	}
+}
+
+// Chunk returns the equivalent chunk.
+func (e RefOptionEvent) Chunk() *ReceiveResponseChunk {
+	return &ReceiveResponseChunk{RefOption: e.Key, RefOptionValue: e.Value}
+}
+
+// Chunk returns the equivalent chunk.
+func (ReceiveEndEvent) Chunk() *ReceiveResponseChunk {
+	return &ReceiveResponseChunk{EndOfResponse: true}
+}
+
+// EncodeToPktLine serializes the event.
+func (e UnpackResultEvent) EncodeToPktLine() []byte {
+	return e.Chunk().EncodeToPktLine()
+}
+
+// EncodeToPktLine serializes the event.
+func (e RefResultEvent) EncodeToPktLine() []byte {
+	return e.Chunk().EncodeToPktLine()
+}
+
+// EncodeToPktLine serializes the event.
+func (e RefOptionEvent) EncodeToPktLine() []byte {
+	return e.Chunk().EncodeToPktLine()
+}
+
+// EncodeToPktLine serializes the event.
+func (e ReceiveEndEvent) EncodeToPktLine() []byte {
+	return e.Chunk().EncodeToPktLine()
+}
+
+func (UnpackResultEvent) receiveResponseEvent() {}
+func (RefResultEvent) receiveResponseEvent()    {}
+func (RefOptionEvent) receiveResponseEvent()    {}
+func (ReceiveEndEvent) receiveResponseEvent()   {}
+
+// Event returns the typed view of the chunk, or nil for an empty chunk.
+func (c *ReceiveResponseChunk) Event() ReceiveResponseEvent {
+	c.Resolve()
+	switch {
+	case c.UnpackStatus != "":
+		return UnpackResultEvent{Status: c.UnpackStatus}
+	case c.RefUpdateStatus != "":
+		return RefResultEvent{
+			Status:  c.RefUpdateStatus,
+			RefName: c.RefName,
+			Message: c.RefUpdateFailMessage,
+		}
+	case c.RefOption != "":
+		return RefOptionEvent{Key: c.RefOption, Value: c.RefOptionValue}
+	case c.EndOfResponse:
+		return ReceiveEndEvent{}
+	case c.Delim:
+		return ReceiveDelimEvent{}
+	case c.RemoteError != "":
+		return ReceiveRemoteErrorEvent{Message: c.RemoteError}
+	}
+	return nil
+}
+
+// Event returns the typed view of the most recent chunk generated by a call
+// to Scan.
+func (r *ReceiveResponse) Event() ReceiveResponseEvent {
+	if r.curr == nil {
+		return nil
+	}
+	return r.curr.Event()
+}
@@ -0,0 +1,137 @@
+// Modified by Giacomo Tartari
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkt
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+)
+
+// ReceiveReportWriter writes the report-status of a protocol v1
+// git-receive-pack response: the "unpack" line, the "ok" and "ng" line of
+// each ref, with the "option" lines of report-status-v2, and the
+// terminating flush. It is the counterpart of ReceiveResponse. After the
+// first error, all the methods return it.
+type ReceiveReportWriter struct {
+	w        io.Writer
+	sideBand bool
+	buf      bytes.Buffer
+	state    ReceiveResponseState
+	err      error
+}
+
+// NewReceiveReportWriter returns a new ReceiveReportWriter writing to w. If
+// sideBand is true, for a client that negotiated side-band-64k, the report
+// is sent on the main band followed by a flush, as git does; it is then
+// buffered until End.
+func NewReceiveReportWriter(w io.Writer, sideBand bool) *ReceiveReportWriter {
+	return &ReceiveReportWriter{w: w, sideBand: sideBand}
+}
+
+// Err returns the first error that was encountered by the
+// ReceiveReportWriter.
+func (w *ReceiveReportWriter) Err() error {
+	return w.err
+}
+
+// UnpackOK writes the "unpack ok" line.
+func (w *ReceiveReportWriter) UnpackOK() error {
+	return w.unpack(NewUnpackOK())
+}
+
+// UnpackError writes the "unpack" line of a pack that could not be
+// unpacked.
+func (w *ReceiveReportWriter) UnpackError(msg string) error {
+	return w.unpack(NewUnpackError(msg))
+}
+
+func (w *ReceiveReportWriter) unpack(c *ReceiveResponseChunk) error {
+	if w.state != ReceiveResponseBegin {
+		return w.fail("unpack")
+	}
+	w.state = ReceiveResponseScanResult
+	return w.write(c)
+}
+
+// RefOK writes an "ok" line reporting that the ref was updated.
+func (w *ReceiveReportWriter) RefOK(ref string) error {
+	return w.refResult(NewRefResultOK(ref))
+}
+
+// RefNG writes an "ng" line reporting that the ref was not updated because
+// of reason.
+func (w *ReceiveReportWriter) RefNG(ref, reason string) error {
+	return w.refResult(NewRefResultNG(ref, reason))
+}
+
+func (w *ReceiveReportWriter) refResult(c *ReceiveResponseChunk) error {
+	if w.state != ReceiveResponseScanResult && w.state != ReceiveResponseScanRefOptions {
+		return w.fail("ref result")
+	}
+	w.state = ReceiveResponseScanRefOptions
+	return w.write(c)
+}
+
+// RefOption writes an "option" line of report-status-v2 for the last ref
+// result, e.g. RefOption("new-oid", oid) or RefOption("forced-update", "").
+// It must only be used when the client negotiated report-status-v2.
+func (w *ReceiveReportWriter) RefOption(key, value string) error {
+	if w.state != ReceiveResponseScanRefOptions {
+		return w.fail("RefOption")
+	}
+	return w.write(NewRefOptionChunk(key, value))
+}
+
+// End writes the flush packet ending the report, and with side-band, sends
+// the report.
+func (w *ReceiveReportWriter) End() error {
+	if w.state != ReceiveResponseScanResult && w.state != ReceiveResponseScanRefOptions {
+		return w.fail("End")
+	}
+	w.state = ReceiveResponseEnd
+	if err := w.write(NewReceiveResponseEndChunk()); err != nil || !w.sideBand {
+		return err
+	}
+	m := NewSideBandMuxer(w.w, true)
+	if _, w.err = m.Main().Write(w.buf.Bytes()); w.err != nil {
+		return w.err
+	}
+	w.err = m.Flush()
+	return w.err
+}
+
+func (w *ReceiveReportWriter) fail(what string) error {
+	if w.err == nil {
+		w.err = fmt.Errorf("ReceiveReportWriter: unexpected %s in state %d", what, w.state)
+	}
+	return w.err
+}
+
+func (w *ReceiveReportWriter) write(c *ReceiveResponseChunk) error {
+	if w.err != nil {
+		return w.err
+	}
+	if w.err = c.Validate(); w.err != nil {
+		return w.err
+	}
+	if w.sideBand {
+		w.buf.Write(c.EncodeToPktLine())
+		return nil
+	}
+	_, w.err = w.w.Write(c.EncodeToPktLine())
+	return w.err
+}
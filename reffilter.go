@@ -0,0 +1,135 @@
+// Modified by Giacomo Tartari
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkt
+
+import (
+	"path"
+	"strings"
+)
+
+// RefFilter hides refs from advertisements, e.g. the refs/pull/* refs of a
+// mirror, as git does with transfer.hideRefs.
+type RefFilter struct {
+	// Patterns are the patterns of the hidden refs. As in git, a pattern
+	// matches the refs it is a path prefix of, a leading "!" negates it and
+	// the last matching pattern wins. A pattern with the wildcards of
+	// path.Match is matched with it, except that a trailing "/*" matches
+	// the whole hierarchy, e.g. "refs/changes/*" hides
+	// "refs/changes/01/1/1".
+	Patterns []string
+}
+
+// Hidden reports whether the ref is hidden. The peeled line of a tag,
+// "<tag>^{}", is hidden with the tag.
+func (f *RefFilter) Hidden(ref string) bool {
+	ref = strings.TrimSuffix(ref, "^{}")
+	for i := len(f.Patterns) - 1; i >= 0; i-- {
+		p := f.Patterns[i]
+		neg := strings.HasPrefix(p, "!")
+		p = strings.TrimPrefix(strings.TrimPrefix(p, "!"), "^")
+		if matchRefPattern(p, ref) {
+			return !neg
+		}
+	}
+	return false
+}
+
+func matchRefPattern(p, ref string) bool {
+	if prefix, ok := strings.CutSuffix(p, "/*"); ok && !strings.ContainsAny(prefix, "*?[\\") {
+		return strings.HasPrefix(ref, prefix+"/")
+	}
+	if strings.ContainsAny(p, "*?[\\") {
+		ok, _ := path.Match(p, ref)
+		return ok
+	}
+	return strings.HasPrefix(ref, p) && (len(ref) == len(p) || ref[len(p)] == '/' || strings.HasSuffix(p, "/"))
+}
+
+// Filter returns the refs that are not hidden. The symref target of a
+// visible ref is cleared when the target is hidden.
+func (f *RefFilter) Filter(refs Refs) Refs {
+	var out Refs
+	for _, r := range refs {
+		if f.Hidden(r.Name) {
+			continue
+		}
+		if r.SymrefTarget != "" && f.Hidden(r.SymrefTarget) {
+			r.SymrefTarget = ""
+		}
+		out = append(out, r)
+	}
+	return out
+}
+
+// Capabilities returns caps without the symref capabilities naming a hidden
+// ref.
+func (f *RefFilter) Capabilities(caps []string) []string {
+	out := make([]string, 0, len(caps))
+	for _, c := range caps {
+		if v, ok := strings.CutPrefix(c, "symref="); ok {
+			if ref, target, _ := strings.Cut(v, ":"); f.Hidden(ref) || f.Hidden(target) {
+				continue
+			}
+		}
+		out = append(out, c)
+	}
+	return out
+}
+
+// Transformer returns a ChunkTransformer removing the hidden refs from the
+// chunks of a protocol v1 InfoRefsResponse, to be used with TransformChunks.
+// The capabilities of a hidden first ref move to the next visible ref, or to
+// a "capabilities^{}" line if all the refs are hidden, and the symref
+// capabilities naming a hidden ref are removed. A protocol v2 advertisement
+// has no refs and is passed through; see the ls-refs filter of the v2
+// package. The transformer keeps the state of one advertisement.
+func (f *RefFilter) Transformer() ChunkTransformer[*InfoRefsResponseChunk] {
+	var caps []string
+	first, sent := true, false
+	return func(c *InfoRefsResponseChunk) ([]*InfoRefsResponseChunk, error) {
+		switch {
+		case c.ObjectID != "" && first:
+			first = false
+			caps = f.Capabilities(c.Capabilities)
+			if c.Ref == "capabilities^{}" {
+				sent = true
+				rc := *c
+				rc.Capabilities = caps
+				return []*InfoRefsResponseChunk{&rc}, nil
+			}
+			fallthrough
+		case c.ObjectID != "":
+			if f.Hidden(c.Ref) {
+				return nil, nil
+			}
+			if sent {
+				return []*InfoRefsResponseChunk{c}, nil
+			}
+			sent = true
+			rc := *c
+			rc.Capabilities = caps
+			return []*InfoRefsResponseChunk{&rc}, nil
+		case c.EndOfRequest && !first && !sent:
+			// All the refs are hidden.
+			return []*InfoRefsResponseChunk{{
+				ObjectID:     string(Capabilities(caps).ObjectFormat().ZeroID()),
+				Ref:          "capabilities^{}",
+				Capabilities: caps,
+			}, c}, nil
+		}
+		return []*InfoRefsResponseChunk{c}, nil
+	}
+}
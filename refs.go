@@ -0,0 +1,173 @@
+// Modified by Giacomo Tartari
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkt
+
+import (
+	"sort"
+	"strings"
+)
+
+// ObjectID is a hex encoded object name.
+type ObjectID string
+
+// Ref is a ref advertised by a server.
+type Ref struct {
+	Name     string
+	ObjectID ObjectID
+	// Peeled is the object an annotated tag points to, if advertised.
+	Peeled ObjectID
+	// SymrefTarget is the ref a symbolic ref points to, if advertised.
+	SymrefTarget string
+}
+
+// Target returns the peeled object ID if any, the object ID otherwise.
+func (r Ref) Target() ObjectID {
+	if r.Peeled != "" {
+		return r.Peeled
+	}
+	return r.ObjectID
+}
+
+// Refs is a list of advertised refs.
+type Refs []Ref
+
+// Get returns the named ref.
+func (rs Refs) Get(name string) (Ref, bool) {
+	for _, r := range rs {
+		if r.Name == name {
+			return r, true
+		}
+	}
+	return Ref{}, false
+}
+
+// Map returns the object IDs keyed by ref name.
+func (rs Refs) Map() map[string]ObjectID {
+	m := make(map[string]ObjectID, len(rs))
+	for _, r := range rs {
+		m[r.Name] = r.ObjectID
+	}
+	return m
+}
+
+// PeeledMap returns the peeled object IDs keyed by ref name, i.e. annotated
+// tags are resolved to the object they point to.
+func (rs Refs) PeeledMap() map[string]ObjectID {
+	m := make(map[string]ObjectID, len(rs))
+	for _, r := range rs {
+		m[r.Name] = r.Target()
+	}
+	return m
+}
+
+// ObjectIDs returns the advertised object IDs, including the peeled ones,
+// without duplicates. It can be passed to ValidateWants.
+func (rs Refs) ObjectIDs() []string {
+	seen := map[ObjectID]bool{}
+	var ids []string
+	for _, r := range rs {
+		for _, id := range []ObjectID{r.ObjectID, r.Peeled} {
+			if id != "" && !seen[id] {
+				seen[id] = true
+				ids = append(ids, string(id))
+			}
+		}
+	}
+	return ids
+}
+
+// Sorted returns a copy of rs sorted by name.
+func (rs Refs) Sorted() Refs {
+	ret := append(Refs(nil), rs...)
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Name < ret[j].Name })
+	return ret
+}
+
+// Filter returns the refs for which keep returns true.
+func (rs Refs) Filter(keep func(Ref) bool) Refs {
+	var ret Refs
+	for _, r := range rs {
+		if keep(r) {
+			ret = append(ret, r)
+		}
+	}
+	return ret
+}
+
+// WithPrefix returns the refs whose name starts with prefix.
+func (rs Refs) WithPrefix(prefix string) Refs {
+	return rs.Filter(func(r Ref) bool { return strings.HasPrefix(r.Name, prefix) })
+}
+
+// Heads returns the branches.
+func (rs Refs) Heads() Refs {
+	return rs.WithPrefix("refs/heads/")
+}
+
+// Tags returns the tags.
+func (rs Refs) Tags() Refs {
+	return rs.WithPrefix("refs/tags/")
+}
+
+// ReadInfoRefs reads a protocol v0/v1 ref advertisement and returns the
+// advertised refs and capabilities. The "^{}" entries are folded into the
+// Peeled field of the tag they follow, and the symref capabilities into the
+// SymrefTarget field. The "capabilities^{}" placeholder of empty
+// repositories is skipped.
+func ReadInfoRefs(r *InfoRefsResponse) (Refs, []string, error) {
+	var (
+		refs Refs
+		caps []string
+	)
+	for r.Scan() {
+		c := r.Chunk()
+		if c.ObjectID == "" || c.Ref == "" {
+			continue
+		}
+		if c.Capabilities != nil {
+			caps = c.Capabilities
+		}
+		if c.Ref == "capabilities^{}" {
+			continue
+		}
+		if name, ok := strings.CutSuffix(c.Ref, "^{}"); ok {
+			if n := len(refs); n > 0 && refs[n-1].Name == name {
+				refs[n-1].Peeled = ObjectID(c.ObjectID)
+			}
+			continue
+		}
+		refs = append(refs, Ref{Name: c.Ref, ObjectID: ObjectID(c.ObjectID)})
+	}
+	if err := r.Err(); err != nil {
+		return nil, nil, err
+	}
+	for _, c := range caps {
+		v, ok := strings.CutPrefix(c, "symref=")
+		if !ok {
+			continue
+		}
+		name, target, ok := strings.Cut(v, ":")
+		if !ok {
+			continue
+		}
+		for i := range refs {
+			if refs[i].Name == name {
+				refs[i].SymrefTarget = target
+			}
+		}
+	}
+	return refs, caps, nil
+}
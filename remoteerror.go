@@ -0,0 +1,56 @@
+// Modified by Giacomo Tartari
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkt
+
+// RemoteErrorEvent is an "ERR" packet read by UploadResponse with
+// WithErrorPackets: an error reported by the server, rather than a syntax
+// error of the response.
+type RemoteErrorEvent struct {
+	Message string
+}
+
+// ReceiveRemoteErrorEvent is an "ERR" packet read by ReceiveResponse with
+// WithErrorPackets.
+type ReceiveRemoteErrorEvent struct {
+	Message string
+}
+
+// Chunk returns the equivalent chunk.
+func (e RemoteErrorEvent) Chunk() *UploadResponseChunk {
+	return &UploadResponseChunk{RemoteError: e.Message}
+}
+
+// Chunk returns the equivalent chunk.
+func (e ReceiveRemoteErrorEvent) Chunk() *ReceiveResponseChunk {
+	return &ReceiveResponseChunk{RemoteError: e.Message}
+}
+
+// EncodeToPktLine serializes the event.
+func (e RemoteErrorEvent) EncodeToPktLine() []byte { return ErrorPacket(e.Message).EncodeToPktLine() }
+
+// EncodeToPktLine serializes the event.
+func (e ReceiveRemoteErrorEvent) EncodeToPktLine() []byte {
+	return ErrorPacket(e.Message).EncodeToPktLine()
+}
+
+// Error returns the message as an ErrorPacket does.
+func (e RemoteErrorEvent) Error() string { return ErrorPacket(e.Message).Error() }
+
+// Error returns the message as an ErrorPacket does.
+func (e ReceiveRemoteErrorEvent) Error() string { return ErrorPacket(e.Message).Error() }
+
+func (RemoteErrorEvent) uploadResponseEvent()         {}
+func (ReceiveRemoteErrorEvent) receiveResponseEvent() {}
@@ -0,0 +1,49 @@
+// Modified by Giacomo Tartari
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkt
+
+import "io"
+
+// Reset prepares s to read the next document of its input, e.g. the
+// response following an advertisement in a capture of a stateless HTTP
+// exchange. The error, the pack file mode, the peeked packet and the
+// positions are reset, and the buffered data is kept. A pack file without
+// side-band extends to the end of the input, so Reset after it only makes
+// sense if the pack was read up to its end with ReadPackData.
+func (s *PacketScanner) Reset() {
+	s.resetState()
+}
+
+// ResetInput prepares s to read from r, reusing its buffers. The buffered
+// data of the previous input is discarded, and the previous input is
+// released from the context of s, see WithContext.
+func (s *PacketScanner) ResetInput(r io.Reader) {
+	if s.ctxr != nil {
+		s.ctxr.detach()
+		s.ctxr = newContextReader(s.ctx, r)
+		r = s.ctxr
+	}
+	s.rd.Reset(r)
+	s.resetState()
+}
+
+func (s *PacketScanner) resetState() {
+	s.restoreState(scanState{})
+	s.peek.next = scanState{}
+	s.peek.peeked = false
+	s.packSize = 0
+	s.ended = false
+}
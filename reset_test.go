@@ -0,0 +1,118 @@
+// Modified by Giacomo Tartari
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkt
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+// scanPackets returns the payloads of the packets read by s, "0000" for a
+// flush.
+func scanPackets(s *PacketScanner) []string {
+	var got []string
+	for s.Scan() {
+		switch p := s.Packet().(type) {
+		case BytesPacket:
+			got = append(got, string(p))
+		case FlushPacket:
+			got = append(got, "0000")
+		}
+	}
+	return got
+}
+
+func TestPacketScanner_Reset(t *testing.T) {
+	// The bad header stops the scan, Reset resumes after the packets
+	// already read.
+	s := NewPacketScanner(strings.NewReader(pktLines("a\n", "0000") + "zzzz"))
+	if diff := cmp.Diff([]string{"a\n", "0000"}, scanPackets(s)); diff != "" {
+		t.Errorf("packets mismatch (-want +got):\n%s", diff)
+	}
+	if s.Err() == nil {
+		t.Fatal("got no error for a bad header")
+	}
+	s.Reset()
+	if err := s.Err(); err != nil {
+		t.Errorf("got error %v after Reset", err)
+	}
+}
+
+func TestPacketScanner_ResetInput(t *testing.T) {
+	for _, opts := range [][]Option{nil, {WithReuseBuffer(true)}} {
+		s := NewPacketScanner(strings.NewReader(pktLines("a\n", "b\n")), opts...)
+		if !s.Scan() {
+			t.Fatal(s.Err())
+		}
+		// The peeked packet belongs to the previous input.
+		s.Peek()
+		s.ResetInput(strings.NewReader(pktLines("c\n", "0000")))
+		if diff := cmp.Diff([]string{"c\n", "0000"}, scanPackets(s)); diff != "" {
+			t.Errorf("packets mismatch (-want +got):\n%s", diff)
+		}
+		if err := s.Err(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestReset(t *testing.T) {
+	head := strings.Repeat("1", 40)
+	main := strings.Repeat("2", 40)
+	// Captures of two stateless exchanges.
+	adv := pktLines(head+" HEAD\x00multi_ack\n", main+" refs/heads/main\n", "0000")
+	r := NewInfoRefsResponse(strings.NewReader(adv + adv))
+	for i := 0; i < 2; i++ {
+		var refs []string
+		for r.Scan() {
+			if c := r.Chunk(); c.Ref != "" {
+				refs = append(refs, c.Ref)
+			}
+		}
+		if err := r.Err(); err != nil {
+			t.Fatalf("advertisement %d: %v", i, err)
+		}
+		if diff := cmp.Diff([]string{"HEAD", "refs/heads/main"}, refs); diff != "" {
+			t.Errorf("advertisement %d: refs mismatch (-want +got):\n%s", i, diff)
+		}
+		r.Reset()
+	}
+
+	req := pktLines("want "+main+"\n", "0000", "have "+head+"\n", "done\n")
+	u := NewUploadRequest(strings.NewReader(req + req))
+	for i := 0; i < 2; i++ {
+		var got []*UploadRequestChunk
+		for u.Scan() {
+			got = append(got, u.Chunk())
+		}
+		if err := u.Err(); err != nil {
+			t.Fatalf("request %d: %v", i, err)
+		}
+		want := []*UploadRequestChunk{
+			{WantObjectID: main},
+			{EndOneRound: true},
+			{HaveObjectID: head},
+			{NoMoreNegotiation: true},
+		}
+		if diff := cmp.Diff(want, got, cmpopts.IgnoreUnexported(UploadRequestChunk{})); diff != "" {
+			t.Errorf("request %d: chunks mismatch (-want +got):\n%s", i, diff)
+		}
+		u.Reset()
+	}
+}
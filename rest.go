@@ -0,0 +1,38 @@
+// Modified by Giacomo Tartari
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkt
+
+import (
+	"bytes"
+	"io"
+)
+
+// Rest returns the unread part of the input: the packet peeked if any, the
+// data read ahead in the buffer, and then the rest of the underlying
+// reader. It lets the caller hand the connection over to another reader
+// once a parser reached its end, e.g. in a stateful exchange. s must not be
+// used afterwards. The input is released from the context of s, see
+// WithContext.
+func (s *PacketScanner) Rest() io.Reader {
+	if s.ctxr != nil {
+		s.ctxr.detach()
+	}
+	if s.peek.peeked && s.peek.ok {
+		next := s.peek.next
+		return io.MultiReader(bytes.NewReader(rawBytes(next.curr, next.hdr, next.payload)), s.rd)
+	}
+	return s.rd
+}
@@ -0,0 +1,94 @@
+// Modified by Giacomo Tartari
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkt
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestPacketScanner_Rest(t *testing.T) {
+	in := pktLines("a\n", "b\n", "c\n")
+	for _, peek := range []bool{false, true} {
+		s := NewPacketScanner(strings.NewReader(in))
+		if !s.Scan() {
+			t.Fatal(s.Err())
+		}
+		if peek {
+			s.Peek()
+		}
+		bs, err := io.ReadAll(s.Rest())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := pktLines("b\n", "c\n"); string(bs) != want {
+			t.Errorf("peek %v: got %q, want %q", peek, bs, want)
+		}
+	}
+}
+
+func TestRest(t *testing.T) {
+	head := strings.Repeat("1", 40)
+	main := strings.Repeat("2", 40)
+	// A stateful exchange: the advertisement and the request follow each
+	// other on the connection.
+	adv := pktLines(head+" HEAD\x00multi_ack\n", main+" refs/heads/main\n", "0000")
+	req := pktLines("want "+main+"\n", "0000", "have "+head+"\n", "done\n")
+	r := NewInfoRefsResponse(strings.NewReader(adv + req + "PACK"))
+	var refs []string
+	for r.Scan() {
+		if c := r.Chunk(); c.Ref != "" {
+			refs = append(refs, c.Ref)
+		}
+	}
+	if err := r.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]string{"HEAD", "refs/heads/main"}, refs); diff != "" {
+		t.Errorf("refs mismatch (-want +got):\n%s", diff)
+	}
+
+	u := NewUploadRequest(r.Rest())
+	var wants, haves []string
+	for u.Scan() {
+		c := u.Chunk()
+		if c.WantObjectID != "" {
+			wants = append(wants, c.WantObjectID)
+		}
+		if c.HaveObjectID != "" {
+			haves = append(haves, c.HaveObjectID)
+		}
+	}
+	if err := u.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]string{main}, wants); diff != "" {
+		t.Errorf("wants mismatch (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff([]string{head}, haves); diff != "" {
+		t.Errorf("haves mismatch (-want +got):\n%s", diff)
+	}
+	bs, err := io.ReadAll(u.Rest())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(bs) != "PACK" {
+		t.Errorf("got rest %q, want %q", bs, "PACK")
+	}
+}
@@ -0,0 +1,152 @@
+// Modified by Giacomo Tartari
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"errors"
+	"io"
+)
+
+// AuthMethod is the way a principal was authenticated.
+type AuthMethod int
+
+const (
+	// AuthNone is used for anonymous or unspecified authentication.
+	AuthNone AuthMethod = iota
+	// AuthHTTP is HTTP authentication, e.g. basic or bearer.
+	AuthHTTP
+	// AuthSSH is SSH public key or password authentication.
+	AuthSSH
+	// AuthDaemon is a git daemon access policy, e.g. based on the host or
+	// the client address.
+	AuthDaemon
+)
+
+func (m AuthMethod) String() string {
+	switch m {
+	case AuthHTTP:
+		return "http"
+	case AuthSSH:
+		return "ssh"
+	case AuthDaemon:
+		return "daemon"
+	}
+	return "none"
+}
+
+// Principal is the authenticated identity of the client of a session.
+type Principal struct {
+	// Name identifies the principal, e.g. a user name.
+	Name   string
+	Method AuthMethod
+	// Attributes carry transport specific details, e.g. the fingerprint of
+	// the SSH key or the remote address.
+	Attributes map[string]string
+}
+
+// ErrUnauthenticated is returned when a session requires a principal but has
+// none.
+var ErrUnauthenticated = errors.New("authentication required")
+
+type principalKey struct{}
+
+// WithPrincipal returns a copy of ctx carrying p.
+func WithPrincipal(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+// PrincipalFromContext returns the principal carried by ctx, if any.
+func PrincipalFromContext(ctx context.Context) (*Principal, bool) {
+	p, ok := ctx.Value(principalKey{}).(*Principal)
+	return p, ok && p != nil
+}
+
+// Principal returns the principal of the session, or nil if the session is
+// anonymous.
+func (s *Session) Principal() *Principal {
+	p, _ := PrincipalFromContext(s.Context())
+	return p
+}
+
+// Principal returns the principal of the session of the command, or nil if
+// the session is anonymous.
+func (c *Command) Principal() *Principal {
+	p, _ := PrincipalFromContext(c.Context())
+	return p
+}
+
+// Authenticator authenticates the client of a session. It returns a nil
+// principal for an anonymous session.
+type Authenticator interface {
+	Authenticate(s *Session) (*Principal, error)
+}
+
+// AuthenticatorFunc is an adapter to allow the use of ordinary functions as
+// Authenticator.
+type AuthenticatorFunc func(s *Session) (*Principal, error)
+
+// Authenticate calls f(s).
+func (f AuthenticatorFunc) Authenticate(s *Session) (*Principal, error) {
+	return f(s)
+}
+
+// Authenticate returns a middleware attaching the principal returned by a to
+// the session, so that it is available to the command handlers. The session
+// is aborted if a returns an error. A principal attached beforehand, e.g. by
+// the HTTP or SSH layer through WithPrincipal, is kept as is.
+func Authenticate(a Authenticator) SessionMiddleware {
+	return func(next SessionHandler) SessionHandler {
+		return SessionHandlerFunc(func(s *Session) error {
+			if s.Principal() != nil {
+				return next.ServeSession(s)
+			}
+			p, err := a.Authenticate(s)
+			if err != nil {
+				return err
+			}
+			if p != nil {
+				s = s.WithContext(WithPrincipal(s.Context(), p))
+			}
+			return next.ServeSession(s)
+		})
+	}
+}
+
+// Authorize returns a middleware calling allow before each command. The
+// principal is nil for anonymous sessions. The command is rejected with the
+// error returned by allow, if any.
+func Authorize(allow func(p *Principal, cmd *Command) error) Middleware {
+	return func(next Handler) Handler {
+		return HandlerFunc(func(w io.Writer, cmd *Command) error {
+			if err := allow(cmd.Principal(), cmd); err != nil {
+				return err
+			}
+			return next.ServeCommand(w, cmd)
+		})
+	}
+}
+
+// RequirePrincipal returns a middleware rejecting the commands of anonymous
+// sessions with ErrUnauthenticated.
+func RequirePrincipal() Middleware {
+	return Authorize(func(p *Principal, _ *Command) error {
+		if p == nil {
+			return ErrUnauthenticated
+		}
+		return nil
+	})
+}
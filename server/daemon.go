@@ -0,0 +1,61 @@
+// Modified by Giacomo Tartari
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"net"
+
+	"github.com/cycloidio/pkt-line"
+)
+
+// DaemonPolicy decides whether the git daemon request req received from
+// remote is served, and returns the principal it is served for. It returns
+// an error to refuse the request, reported to the client.
+type DaemonPolicy func(req *pkt.DaemonRequest, remote net.Addr) (*Principal, error)
+
+// NewDaemonSession reads the request starting the git daemon connection
+// conn and returns the session serving it, with the principal returned by
+// policy, and the request. If policy is nil, every request is served for a
+// principal with no name. A refused request is reported to the client as
+// an error packet and its error returned.
+func NewDaemonSession(ctx context.Context, conn net.Conn, policy DaemonPolicy) (*Session, *pkt.DaemonRequest, error) {
+	sc := pkt.NewPacketScanner(conn)
+	req, err := pkt.ReadDaemonRequest(sc)
+	if err != nil {
+		return nil, nil, err
+	}
+	p := &Principal{Method: AuthDaemon}
+	if policy != nil {
+		if p, err = policy(req, conn.RemoteAddr()); err != nil {
+			_ = WriteError(conn, err)
+			return nil, req, err
+		}
+	}
+	if p != nil {
+		if p.Attributes == nil {
+			p.Attributes = map[string]string{}
+		}
+		p.Attributes["host"] = req.Host
+		if addr := conn.RemoteAddr(); addr != nil {
+			p.Attributes["remote_addr"] = addr.String()
+		}
+		ctx = WithPrincipal(ctx, p)
+	}
+	s := NewSession(ctx, req.Service, sc.Rest(), conn)
+	s.Protocol = req.Version()
+	return s, req, nil
+}
@@ -0,0 +1,136 @@
+// Modified by Giacomo Tartari
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/cycloidio/pkt-line"
+)
+
+// principalRepo hides the refs under refs/private/ from the principals
+// other than alice.
+type principalRepo pkt.Refs
+
+func (r principalRepo) Refs(ctx context.Context) (pkt.Refs, error) {
+	if p, ok := PrincipalFromContext(ctx); ok && p.Name == "alice" {
+		return pkt.Refs(r), nil
+	}
+	return pkt.Refs(r).WithPrefix("refs/heads/"), nil
+}
+
+func (r principalRepo) HasObject(ctx context.Context, oid pkt.ObjectID) (bool, error) {
+	return false, nil
+}
+
+func TestNewDaemonSession(t *testing.T) {
+	oid := pkt.ObjectID(strings.Repeat("a", 40))
+	up := &UploadPack{Repo: principalRepo{
+		{Name: "refs/heads/main", ObjectID: oid},
+		{Name: "refs/private/alice", ObjectID: oid},
+	}}
+	// The principal is named after the virtual host, some are refused.
+	policy := func(req *pkt.DaemonRequest, remote net.Addr) (*Principal, error) {
+		if req.Host == "evil.example.com" {
+			return nil, errors.New("access denied")
+		}
+		name, _, _ := strings.Cut(req.Host, ".")
+		return &Principal{Name: name, Method: AuthDaemon}, nil
+	}
+	tests := []struct {
+		name     string
+		req      pkt.DaemonRequest
+		policy   DaemonPolicy
+		protocol int
+		private  bool
+		err      string
+	}{
+		{
+			name: "no policy",
+			req:  pkt.DaemonRequest{Service: "git-upload-pack", Path: "/repo.git", Host: "alice.example.com"},
+		},
+		{
+			name:   "bob",
+			req:    pkt.DaemonRequest{Service: "git-upload-pack", Path: "/repo.git", Host: "bob.example.com"},
+			policy: policy,
+		},
+		{
+			name:     "alice",
+			req:      pkt.DaemonRequest{Service: "git-upload-pack", Path: "/repo.git", Host: "alice.example.com", ExtraParameters: []string{"version=1"}},
+			policy:   policy,
+			protocol: 1,
+			private:  true,
+		},
+		{
+			name:   "refused",
+			req:    pkt.DaemonRequest{Service: "git-upload-pack", Path: "/repo.git", Host: "evil.example.com"},
+			policy: policy,
+			err:    "access denied",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, conn := net.Pipe()
+			defer client.Close()
+			out := make(chan []byte)
+			go func() {
+				client.Write(tt.req.EncodeToPktLine())
+				client.Write([]byte("0000"))
+			}()
+			go func() {
+				bs, _ := io.ReadAll(client)
+				out <- bs
+			}()
+
+			s, req, err := NewDaemonSession(context.Background(), conn, tt.policy)
+			if err == nil {
+				if req.Host != tt.req.Host {
+					t.Errorf("got host %q, want %q", req.Host, tt.req.Host)
+				}
+				if s.Protocol != tt.protocol {
+					t.Errorf("got protocol %d, want %d", s.Protocol, tt.protocol)
+				}
+				if p := s.Principal(); p == nil || p.Method != AuthDaemon || p.Attributes["host"] != tt.req.Host || p.Attributes["remote_addr"] == "" {
+					t.Errorf("unexpected principal %+v", p)
+				}
+				err = up.ServeSession(s)
+			}
+			conn.Close()
+			bs := <-out
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("got error %v, want %q", err, tt.err)
+				}
+				if want := pkt.ErrorPacket(tt.err).EncodeToPktLine(); !bytes.Equal(bs, want) {
+					t.Errorf("got %q, want %q", bs, want)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := bytes.Contains(bs, []byte("refs/private/alice")); got != tt.private {
+				t.Errorf("private ref advertised: got %v, want %v", got, tt.private)
+			}
+		})
+	}
+}
@@ -0,0 +1,232 @@
+// Modified by Giacomo Tartari
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package server provides building blocks for Git protocol servers.
+//
+// A server is made of two layers of handlers. A SessionHandler serves a whole
+// connection, e.g. the body of a smart HTTP request or an SSH channel. A
+// Handler serves a single command of a session, e.g. a protocol v2 "ls-refs"
+// or "fetch". Both can be wrapped by middlewares, the same way http.Handler
+// is, so that cross-cutting concerns such as authentication, logging, quotas
+// and tracing are written once. V2Server is the SessionHandler of protocol
+// v2, dispatching the commands of a session to a CommandMux.
+package server
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"unicode/utf8"
+
+	"github.com/cycloidio/pkt-line"
+)
+
+// Session is a single connection served by a SessionHandler.
+type Session struct {
+	// Service is the requested service, e.g. "git-upload-pack".
+	Service string
+	// Protocol is the protocol version requested by the client.
+	Protocol int
+	// Reader reads the requests of the client.
+	Reader io.Reader
+	// Writer writes the responses to the client.
+	Writer io.Writer
+
+	ctx context.Context
+}
+
+// NewSession returns a new Session for service reading the requests from rd
+// and writing the responses to w.
+func NewSession(ctx context.Context, service string, rd io.Reader, w io.Writer) *Session {
+	return &Session{
+		Service: service,
+		Reader:  rd,
+		Writer:  w,
+		ctx:     ctx,
+	}
+}
+
+// Context returns the context of the session. It is never nil.
+func (s *Session) Context() context.Context {
+	if s.ctx == nil {
+		return context.Background()
+	}
+	return s.ctx
+}
+
+// WithContext returns a shallow copy of s with its context changed to ctx.
+func (s *Session) WithContext(ctx context.Context) *Session {
+	s2 := *s
+	s2.ctx = ctx
+	return &s2
+}
+
+// SessionHandler serves a whole session.
+type SessionHandler interface {
+	ServeSession(s *Session) error
+}
+
+// SessionHandlerFunc is an adapter to allow the use of ordinary functions as
+// SessionHandler.
+type SessionHandlerFunc func(s *Session) error
+
+// ServeSession calls f(s).
+func (f SessionHandlerFunc) ServeSession(s *Session) error {
+	return f(s)
+}
+
+// SessionMiddleware wraps a SessionHandler.
+type SessionMiddleware func(SessionHandler) SessionHandler
+
+// ChainSession wraps h with mws. The first middleware is the outermost one,
+// i.e. it sees the session first.
+func ChainSession(h SessionHandler, mws ...SessionMiddleware) SessionHandler {
+	for i := len(mws) - 1; i >= 0; i-- {
+		h = mws[i](h)
+	}
+	return h
+}
+
+// Command is a single command of a session.
+type Command struct {
+	// Name is the name of the command, e.g. "ls-refs".
+	Name string
+	// Capabilities are the capabilities sent with the command, without the
+	// trailing LF.
+	Capabilities []string
+	// Arguments are the arguments of the command, without the trailing LF.
+	Arguments []string
+	// Session is the session the command belongs to.
+	Session *Session
+}
+
+// Context returns the context of the session of the command.
+func (c *Command) Context() context.Context {
+	if c.Session == nil {
+		return context.Background()
+	}
+	return c.Session.Context()
+}
+
+// Handler responds to a command by writing pkt-lines to w. Returning an
+// error aborts the session; the caller reports it to the client as an error
+// packet.
+type Handler interface {
+	ServeCommand(w io.Writer, cmd *Command) error
+}
+
+// HandlerFunc is an adapter to allow the use of ordinary functions as
+// Handler.
+type HandlerFunc func(w io.Writer, cmd *Command) error
+
+// ServeCommand calls f(w, cmd).
+func (f HandlerFunc) ServeCommand(w io.Writer, cmd *Command) error {
+	return f(w, cmd)
+}
+
+// Middleware wraps a Handler.
+type Middleware func(Handler) Handler
+
+// Chain wraps h with mws. The first middleware is the outermost one, i.e. it
+// sees the command first.
+func Chain(h Handler, mws ...Middleware) Handler {
+	for i := len(mws) - 1; i >= 0; i-- {
+		h = mws[i](h)
+	}
+	return h
+}
+
+// UnknownCommandError is returned by CommandMux for a command without a
+// handler.
+type UnknownCommandError string
+
+func (e UnknownCommandError) Error() string {
+	return fmt.Sprintf("unknown command %q", string(e))
+}
+
+// CommandMux dispatches commands to the handler registered for their name.
+type CommandMux struct {
+	handlers    map[string]Handler
+	middlewares []Middleware
+}
+
+// NewCommandMux returns a new CommandMux.
+func NewCommandMux() *CommandMux {
+	return &CommandMux{handlers: map[string]Handler{}}
+}
+
+// Handle registers the handler for the named command.
+func (m *CommandMux) Handle(name string, h Handler) {
+	m.handlers[name] = h
+}
+
+// HandleFunc registers the handler function for the named command.
+func (m *CommandMux) HandleFunc(name string, f func(w io.Writer, cmd *Command) error) {
+	m.Handle(name, HandlerFunc(f))
+}
+
+// Use appends middlewares wrapping every command handled by m, including the
+// ones registered afterwards.
+func (m *CommandMux) Use(mws ...Middleware) {
+	m.middlewares = append(m.middlewares, mws...)
+}
+
+// Handler returns the handler for the named command with the middlewares
+// applied, or nil if there is none.
+func (m *CommandMux) Handler(name string) Handler {
+	h, ok := m.handlers[name]
+	if !ok {
+		return nil
+	}
+	return Chain(h, m.middlewares...)
+}
+
+// Commands returns the names of the registered commands in lexical order.
+func (m *CommandMux) Commands() []string {
+	names := make([]string, 0, len(m.handlers))
+	for name := range m.handlers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ServeCommand dispatches cmd to its handler.
+func (m *CommandMux) ServeCommand(w io.Writer, cmd *Command) error {
+	h := m.Handler(cmd.Name)
+	if h == nil {
+		return UnknownCommandError(cmd.Name)
+	}
+	return h.ServeCommand(w, cmd)
+}
+
+// maxErrorMessage is the longest message of an error packet git accepts.
+const maxErrorMessage = pkt.MaxPacketDataSize - len("ERR ")
+
+// WriteError reports err to the client as an error packet. A message too
+// long for a packet is truncated.
+func WriteError(w io.Writer, err error) error {
+	msg := err.Error()
+	if len(msg) > maxErrorMessage {
+		n := maxErrorMessage
+		for n > 0 && !utf8.RuneStart(msg[n]) {
+			n--
+		}
+		msg = msg[:n]
+	}
+	_, werr := w.Write(pkt.ErrorPacket(msg).EncodeToPktLine())
+	return werr
+}
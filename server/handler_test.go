@@ -0,0 +1,180 @@
+// Modified by Giacomo Tartari
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"slices"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/cycloidio/pkt-line"
+)
+
+// recordingMiddleware records its name in calls when it sees a command.
+func recordingMiddleware(name string, calls *[]string) Middleware {
+	return func(next Handler) Handler {
+		return HandlerFunc(func(w io.Writer, cmd *Command) error {
+			*calls = append(*calls, name)
+			return next.ServeCommand(w, cmd)
+		})
+	}
+}
+
+func TestChain(t *testing.T) {
+	var calls []string
+	h := Chain(HandlerFunc(func(w io.Writer, cmd *Command) error {
+		calls = append(calls, "handler")
+		return nil
+	}), recordingMiddleware("outer", &calls), recordingMiddleware("inner", &calls))
+	if err := h.ServeCommand(io.Discard, &Command{Name: "fetch"}); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"outer", "inner", "handler"}; !slices.Equal(calls, want) {
+		t.Errorf("got calls %q, want %q", calls, want)
+	}
+}
+
+func TestChainSession(t *testing.T) {
+	var calls []string
+	mw := func(name string) SessionMiddleware {
+		return func(next SessionHandler) SessionHandler {
+			return SessionHandlerFunc(func(s *Session) error {
+				calls = append(calls, name)
+				return next.ServeSession(s)
+			})
+		}
+	}
+	h := ChainSession(SessionHandlerFunc(func(s *Session) error {
+		calls = append(calls, "handler")
+		return nil
+	}), mw("outer"), mw("inner"))
+	if err := h.ServeSession(NewSession(context.Background(), "git-upload-pack", nil, nil)); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"outer", "inner", "handler"}; !slices.Equal(calls, want) {
+		t.Errorf("got calls %q, want %q", calls, want)
+	}
+}
+
+func TestCommandMux(t *testing.T) {
+	var calls []string
+	m := NewCommandMux()
+	m.Use(recordingMiddleware("first", &calls))
+	m.HandleFunc("ls-refs", func(w io.Writer, cmd *Command) error {
+		calls = append(calls, "ls-refs")
+		_, err := io.WriteString(w, "0000")
+		return err
+	})
+	// The middlewares apply to the handlers registered before Use too.
+	m.Use(recordingMiddleware("second", &calls))
+	m.HandleFunc("fetch", func(w io.Writer, cmd *Command) error {
+		calls = append(calls, "fetch")
+		return errors.New("fetch failed")
+	})
+
+	if want := []string{"fetch", "ls-refs"}; !slices.Equal(m.Commands(), want) {
+		t.Errorf("Commands() = %q, want %q", m.Commands(), want)
+	}
+	if m.Handler("object-info") != nil {
+		t.Error("Handler(object-info) is not nil")
+	}
+
+	tests := []struct {
+		name  string
+		out   string
+		err   string
+		calls []string
+	}{
+		{name: "ls-refs", out: "0000", calls: []string{"first", "second", "ls-refs"}},
+		{name: "fetch", err: "fetch failed", calls: []string{"first", "second", "fetch"}},
+		{name: "object-info", err: `unknown command "object-info"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls = nil
+			var out bytes.Buffer
+			err := m.ServeCommand(&out, &Command{Name: tt.name})
+			if tt.err == "" && err != nil || tt.err != "" && (err == nil || err.Error() != tt.err) {
+				t.Errorf("got error %v, want %q", err, tt.err)
+			}
+			if out.String() != tt.out {
+				t.Errorf("got output %q, want %q", out.String(), tt.out)
+			}
+			if !slices.Equal(calls, tt.calls) {
+				t.Errorf("got calls %q, want %q", calls, tt.calls)
+			}
+		})
+	}
+	var unknown UnknownCommandError
+	if err := m.ServeCommand(io.Discard, &Command{Name: "bundle-uri"}); !errors.As(err, &unknown) || unknown != "bundle-uri" {
+		t.Errorf("got error %v, want an UnknownCommandError", err)
+	}
+}
+
+func TestSession_WithContext(t *testing.T) {
+	type key struct{}
+	s := &Session{Service: "git-upload-pack", Reader: strings.NewReader(""), Writer: io.Discard}
+	if s.Context() != context.Background() {
+		t.Error("the context of a session without one is not context.Background()")
+	}
+	if (&Command{Name: "fetch"}).Context() != context.Background() {
+		t.Error("the context of a command without a session is not context.Background()")
+	}
+
+	ctx := context.WithValue(context.Background(), key{}, "value")
+	s2 := s.WithContext(ctx)
+	if s2.Context() != ctx || s2.Service != s.Service || s2.Reader != s.Reader || s2.Writer != s.Writer {
+		t.Errorf("WithContext() = %+v", s2)
+	}
+	if s.Context() != context.Background() {
+		t.Error("WithContext() changed the context of the original session")
+	}
+	if cmd := (&Command{Session: s2}); cmd.Context() != ctx {
+		t.Error("the context of a command is not the one of its session")
+	}
+}
+
+func TestWriteError(t *testing.T) {
+	var out bytes.Buffer
+	if err := WriteError(&out, errors.New("not our ref")); err != nil {
+		t.Fatal(err)
+	}
+	if want := "0013ERR not our ref"; out.String() != want {
+		t.Errorf("got %q, want %q", out.String(), want)
+	}
+}
+
+func TestWriteError_long(t *testing.T) {
+	for _, msg := range []string{strings.Repeat("a", 70000), strings.Repeat("é", 40000)} {
+		var out bytes.Buffer
+		if err := WriteError(&out, errors.New(msg)); err != nil {
+			t.Fatal(err)
+		}
+		s := pkt.NewPacketScanner(&out, pkt.WithErrorPackets())
+		if !s.Scan() {
+			t.Fatal(s.Err())
+		}
+		p, ok := s.Packet().(pkt.ErrorPacket)
+		if !ok || len(p) > maxErrorMessage || len(p) < maxErrorMessage-1 || !strings.HasPrefix(msg, string(p)) || !utf8.ValidString(string(p)) {
+			t.Errorf("got packet of %d bytes %q...", len(p), p[:min(len(p), 10)])
+		}
+	}
+}
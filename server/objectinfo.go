@@ -0,0 +1,87 @@
+// Modified by Giacomo Tartari
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/cycloidio/pkt-line"
+)
+
+// ErrObjectNotFound is returned by an ObjectStore for a missing object.
+var ErrObjectNotFound = errors.New("object not found")
+
+// ObjectStore gives access to the objects of a repository.
+type ObjectStore interface {
+	// ObjectSize returns the size of the object, or ErrObjectNotFound.
+	ObjectSize(ctx context.Context, oid pkt.ObjectID) (int64, error)
+}
+
+// ObjectInfoHandler returns a handler for the object-info command answering
+// size queries from store. Like git, it writes the "size" attribute header
+// only when the size is requested, and reports a missing object with an
+// empty size ("<oid> ") rather than an error. Other errors of store abort
+// the command.
+func ObjectInfoHandler(store ObjectStore) Handler {
+	return HandlerFunc(func(w io.Writer, cmd *Command) error {
+		size := false
+		var oids []pkt.ObjectID
+		for _, arg := range cmd.Arguments {
+			switch {
+			case arg == "size":
+				size = true
+			case strings.HasPrefix(arg, "oid "):
+				oid := strings.TrimPrefix(arg, "oid ")
+				if _, err := pkt.ValidateWants([]string{oid}, nil, nil); err != nil {
+					return fmt.Errorf("object-info: expected an object ID, not %q", oid)
+				}
+				oids = append(oids, pkt.ObjectID(oid))
+			default:
+				return fmt.Errorf("object-info: unexpected argument %q", arg)
+			}
+		}
+
+		if size {
+			if _, err := w.Write(pkt.StringPacket("size").EncodeToPktLine()); err != nil {
+				return err
+			}
+		}
+		for _, oid := range oids {
+			line := string(oid)
+			if size {
+				sz, err := store.ObjectSize(cmd.Context(), oid)
+				switch {
+				case errors.Is(err, ErrObjectNotFound):
+					line += " "
+				case err != nil:
+					return err
+				default:
+					line += " " + strconv.FormatInt(sz, 10)
+				}
+			}
+			if _, err := w.Write(pkt.StringPacket(line).EncodeToPktLine()); err != nil {
+				return err
+			}
+		}
+		_, err := w.Write(pkt.FlushPacket{}.EncodeToPktLine())
+		return err
+	})
+}
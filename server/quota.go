@@ -0,0 +1,268 @@
+// Modified by Giacomo Tartari
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/cycloidio/pkt-line"
+)
+
+// Quota limits the resources used by a session. Zero fields are unlimited.
+// The ref, want, have and round limits apply to the protocol v2 commands
+// with Middleware, and to the protocol v0/v1 sessions of UploadPack with
+// SessionMiddleware.
+type Quota struct {
+	// MaxAdvertisedRefs limits the refs sent by ls-refs or advertised by
+	// UploadPack.
+	MaxAdvertisedRefs int
+	// MaxWants limits the want and want-ref arguments of fetch commands, or
+	// the want lines of UploadPack.
+	MaxWants int
+	// MaxNegotiationRounds limits the fetch commands of a session, or the
+	// rounds of haves, ended by a flush or done, of UploadPack.
+	MaxNegotiationRounds int
+	// MaxHaves limits the have arguments of the fetch commands of a session,
+	// or the have lines of UploadPack.
+	MaxHaves int
+	// MaxPackBytesIn limits the bytes read from the client, which for
+	// git-receive-pack are dominated by the pack.
+	MaxPackBytesIn int64
+	// MaxPackBytesOut limits the bytes written to the client, which for
+	// git-upload-pack are dominated by the pack.
+	MaxPackBytesOut int64
+}
+
+// QuotaExceededError is returned when a session exceeds its Quota.
+type QuotaExceededError struct {
+	// Limit is the name of the exceeded Quota field.
+	Limit string
+	Max   int64
+}
+
+func (e *QuotaExceededError) Error() string {
+	return fmt.Sprintf("quota exceeded: %s (max %d)", e.Limit, e.Max)
+}
+
+type quotaUsageKey struct{}
+
+// quotaUsage is the usage of a session, shared by its commands.
+type quotaUsage struct {
+	// q is the Quota of SessionMiddleware, enforced by UploadPack.
+	q      Quota
+	mu     sync.Mutex
+	wants  int
+	haves  int
+	rounds int
+	refs   int
+}
+
+// SessionMiddleware returns a middleware that limits the bytes exchanged by
+// a session and tracks the usage of its commands for Middleware. When the
+// output limit is exceeded, writes fail with a QuotaExceededError, except
+// for a lone error packet so that the error can still be reported. The
+// other limits are enforced by UploadPack, which has no commands.
+func (q Quota) SessionMiddleware() SessionMiddleware {
+	return func(next SessionHandler) SessionHandler {
+		return SessionHandlerFunc(func(s *Session) error {
+			s = s.WithContext(context.WithValue(s.Context(), quotaUsageKey{}, &quotaUsage{q: q}))
+			if q.MaxPackBytesIn > 0 {
+				s.Reader = &quotaReader{rd: s.Reader, max: q.MaxPackBytesIn}
+			}
+			if q.MaxPackBytesOut > 0 {
+				s.Writer = &quotaWriter{w: s.Writer, max: q.MaxPackBytesOut}
+			}
+			return next.ServeSession(s)
+		})
+	}
+}
+
+// Middleware returns a middleware that enforces the ref, want, have and
+// round limits. The usage is accumulated over the session when SessionMiddleware is
+// installed too, otherwise per command.
+func (q Quota) Middleware() Middleware {
+	return func(next Handler) Handler {
+		return HandlerFunc(func(w io.Writer, cmd *Command) error {
+			u, ok := cmd.Context().Value(quotaUsageKey{}).(*quotaUsage)
+			if !ok {
+				u = &quotaUsage{}
+			}
+			switch cmd.Name {
+			case "fetch":
+				wants, haves := 0, 0
+				for _, arg := range cmd.Arguments {
+					if strings.HasPrefix(arg, "want ") || strings.HasPrefix(arg, "want-ref ") {
+						wants++
+					}
+					if strings.HasPrefix(arg, "have ") {
+						haves++
+					}
+				}
+				if err := u.fetch(q, 1, wants, haves); err != nil {
+					return err
+				}
+			case "ls-refs":
+				if q.MaxAdvertisedRefs > 0 {
+					w = &refCountingWriter{w: w, u: u, q: q}
+				}
+			}
+			return next.ServeCommand(w, cmd)
+		})
+	}
+}
+
+// fetch adds rounds, wants and haves to the usage and checks them against
+// the limits of q.
+func (u *quotaUsage) fetch(q Quota, rounds, wants, haves int) error {
+	u.mu.Lock()
+	u.rounds += rounds
+	u.wants += wants
+	u.haves += haves
+	rounds, wants, haves = u.rounds, u.wants, u.haves
+	u.mu.Unlock()
+	if q.MaxNegotiationRounds > 0 && rounds > q.MaxNegotiationRounds {
+		return &QuotaExceededError{"MaxNegotiationRounds", int64(q.MaxNegotiationRounds)}
+	}
+	if q.MaxWants > 0 && wants > q.MaxWants {
+		return &QuotaExceededError{"MaxWants", int64(q.MaxWants)}
+	}
+	if q.MaxHaves > 0 && haves > q.MaxHaves {
+		return &QuotaExceededError{"MaxHaves", int64(q.MaxHaves)}
+	}
+	return nil
+}
+
+// advertise adds refs to the usage and checks them against the limits of q.
+func (u *quotaUsage) advertise(q Quota, refs int) error {
+	u.mu.Lock()
+	u.refs += refs
+	refs = u.refs
+	u.mu.Unlock()
+	if q.MaxAdvertisedRefs > 0 && refs > q.MaxAdvertisedRefs {
+		return &QuotaExceededError{"MaxAdvertisedRefs", int64(q.MaxAdvertisedRefs)}
+	}
+	return nil
+}
+
+// sessionUsage returns the usage of the session of ctx, with the Quota of
+// SessionMiddleware, or an unlimited one without it.
+func sessionUsage(ctx context.Context) *quotaUsage {
+	if u, ok := ctx.Value(quotaUsageKey{}).(*quotaUsage); ok {
+		return u
+	}
+	return &quotaUsage{}
+}
+
+type quotaReader struct {
+	rd  io.Reader
+	n   int64
+	max int64
+}
+
+func (r *quotaReader) Read(p []byte) (int, error) {
+	if rem := r.max - r.n; int64(len(p)) > rem {
+		// Read one byte more than allowed to tell an input of exactly
+		// max bytes from a larger one.
+		p = p[:rem+1]
+	}
+	n, err := r.rd.Read(p)
+	r.n += int64(n)
+	if r.n > r.max {
+		return n, &QuotaExceededError{"MaxPackBytesIn", r.max}
+	}
+	return n, err
+}
+
+type quotaWriter struct {
+	mu  sync.Mutex
+	w   io.Writer
+	n   int64
+	max int64
+}
+
+func (w *quotaWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.n+int64(len(p)) > w.max && !isErrorPacket(p) {
+		return 0, &QuotaExceededError{"MaxPackBytesOut", w.max}
+	}
+	n, err := w.w.Write(p)
+	w.n += int64(n)
+	return n, err
+}
+
+func isErrorPacket(p []byte) bool {
+	if len(p) < 8 || !bytes.Equal(p[4:8], []byte("ERR ")) {
+		return false
+	}
+	sz, ok := pkt.ParseLength(p[:4])
+	return ok && sz == len(p)
+}
+
+// refCountingWriter counts the data packets written by an ls-refs handler.
+// Packets may span several writes.
+type refCountingWriter struct {
+	w io.Writer
+	u *quotaUsage
+	q Quota
+	// hdr buffers a partially written length header, rem is the number of
+	// payload bytes left in the current packet.
+	hdr []byte
+	rem int
+}
+
+func (w *refCountingWriter) Write(p []byte) (int, error) {
+	refs := 0
+	for i := 0; i < len(p); {
+		if w.rem > 0 {
+			n := min(w.rem, len(p)-i)
+			w.rem -= n
+			i += n
+			continue
+		}
+		n := min(4-len(w.hdr), len(p)-i)
+		w.hdr = append(w.hdr, p[i:i+n]...)
+		i += n
+		if len(w.hdr) < 4 {
+			break
+		}
+		sz, ok := pkt.ParseLength(w.hdr)
+		if !ok {
+			return 0, fmt.Errorf("cannot parse the packet length: %q", w.hdr)
+		}
+		w.hdr = w.hdr[:0]
+		if sz > 4 {
+			refs++
+			w.rem = int(sz) - 4
+		}
+	}
+	if err := w.u.advertise(w.q, refs); err != nil {
+		return 0, err
+	}
+	return w.w.Write(p)
+}
+
+// NegotiationQuota returns the Quota enforcing the negotiation limits l on
+// protocol v2 fetch commands and on the negotiation of UploadPack. Other
+// protocol v1 servers can use a pkt.NegotiationTracker instead.
+func NegotiationQuota(l pkt.NegotiationLimits) Quota {
+	return Quota{MaxNegotiationRounds: l.MaxRounds, MaxHaves: l.MaxHaves}
+}
@@ -0,0 +1,145 @@
+// Modified by Giacomo Tartari
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/cycloidio/pkt-line"
+)
+
+func TestQuota_uploadPack(t *testing.T) {
+	a, b := strings.Repeat("a", 40), strings.Repeat("b", 40)
+	up := &UploadPack{Repo: principalRepo{
+		{Name: "refs/heads/a", ObjectID: pkt.ObjectID(a)},
+		{Name: "refs/heads/b", ObjectID: pkt.ObjectID(b)},
+	}}
+	tests := []struct {
+		name  string
+		quota Quota
+		req   string
+		limit string
+	}{
+		{
+			name:  "MaxAdvertisedRefs",
+			quota: Quota{MaxAdvertisedRefs: 1},
+			req:   "0000",
+			limit: "MaxAdvertisedRefs",
+		},
+		{
+			name:  "MaxWants",
+			quota: Quota{MaxWants: 1},
+			req:   pktLines("want "+a+"\n", "want "+b+"\n", "0000", "done\n"),
+			limit: "MaxWants",
+		},
+		{
+			name:  "MaxHaves",
+			quota: Quota{MaxHaves: 1},
+			req:   pktLines("want "+a+"\n", "0000", "have "+a+"\n", "have "+b+"\n", "0000", "done\n"),
+			limit: "MaxHaves",
+		},
+		{
+			name:  "MaxNegotiationRounds",
+			quota: Quota{MaxNegotiationRounds: 1},
+			req:   pktLines("want "+a+"\n", "0000", "have "+a+"\n", "0000", "have "+b+"\n", "0000", "done\n"),
+			limit: "MaxNegotiationRounds",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			s := NewSession(context.Background(), "git-upload-pack", strings.NewReader(tt.req), &out)
+			err := ChainSession(up, tt.quota.SessionMiddleware()).ServeSession(s)
+			var qe *QuotaExceededError
+			if !errors.As(err, &qe) || qe.Limit != tt.limit {
+				t.Fatalf("got error %v, want a %s QuotaExceededError", err, tt.limit)
+			}
+			if want := pkt.ErrorPacket(err.Error()).EncodeToPktLine(); !bytes.HasSuffix(out.Bytes(), want) {
+				t.Errorf("got %q, want it to end with %q", out.Bytes(), want)
+			}
+		})
+	}
+}
+
+func TestQuota_v2(t *testing.T) {
+	a, b := strings.Repeat("a", 40), strings.Repeat("b", 40)
+	tests := []struct {
+		name  string
+		quota Quota
+		req   string
+		limit string
+	}{
+		{
+			name:  "MaxAdvertisedRefs",
+			quota: Quota{MaxAdvertisedRefs: 1},
+			req:   pktLines("command=ls-refs\n", "0001", "0000"),
+			limit: "MaxAdvertisedRefs",
+		},
+		{
+			name:  "MaxWants",
+			quota: Quota{MaxWants: 1},
+			req:   pktLines("command=fetch\n", "0001", "want "+a+"\n", "want "+b+"\n", "done\n", "0000"),
+			limit: "MaxWants",
+		},
+		{
+			name:  "MaxHaves",
+			quota: Quota{MaxHaves: 1},
+			req:   pktLines("command=fetch\n", "0001", "want "+a+"\n", "have "+a+"\n", "have "+b+"\n", "0000"),
+			limit: "MaxHaves",
+		},
+		{
+			name:  "MaxNegotiationRounds",
+			quota: Quota{MaxNegotiationRounds: 1},
+			req: pktLines(
+				"command=fetch\n", "0001", "want "+a+"\n", "have "+a+"\n", "0000",
+				"command=fetch\n", "0001", "want "+a+"\n", "have "+b+"\n", "0000",
+			),
+			limit: "MaxNegotiationRounds",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mux := NewCommandMux()
+			mux.Use(tt.quota.Middleware())
+			mux.HandleFunc("ls-refs", func(w io.Writer, cmd *Command) error {
+				_, err := w.Write([]byte(pktLines(a+" refs/heads/a\n", b+" refs/heads/b\n", "0000")))
+				return err
+			})
+			mux.HandleFunc("fetch", func(w io.Writer, cmd *Command) error {
+				_, err := w.Write([]byte(pktLines("acknowledgments\n", "NAK\n", "0000")))
+				return err
+			})
+			v2 := &V2Server{Mux: mux, Stateless: true}
+
+			var out bytes.Buffer
+			s := NewSession(context.Background(), "git-upload-pack", strings.NewReader(tt.req), &out)
+			s.Protocol = 2
+			err := ChainSession(v2, tt.quota.SessionMiddleware()).ServeSession(s)
+			var qe *QuotaExceededError
+			if !errors.As(err, &qe) || qe.Limit != tt.limit {
+				t.Fatalf("got error %v, want a %s QuotaExceededError", err, tt.limit)
+			}
+			if want := pkt.ErrorPacket(err.Error()).EncodeToPktLine(); !bytes.HasSuffix(out.Bytes(), want) {
+				t.Errorf("got %q, want it to end with %q", out.Bytes(), want)
+			}
+		})
+	}
+}
@@ -0,0 +1,136 @@
+// Modified by Giacomo Tartari
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"slices"
+
+	"github.com/cycloidio/pkt-line"
+)
+
+// CommitGraph gives the parents of the commits of a repository.
+type CommitGraph interface {
+	Parents(ctx context.Context, oid pkt.ObjectID) ([]pkt.ObjectID, error)
+}
+
+// DeepenRequest is a deepen request of a client.
+type DeepenRequest struct {
+	Wants []pkt.ObjectID
+	// Shallows are the shallow commits of the client.
+	Shallows []pkt.ObjectID
+	// Depth is the requested depth.
+	Depth int
+	// Relative measures Depth from the shallow commits of the client
+	// (deepen-relative) instead of the wants.
+	Relative bool
+}
+
+// ShallowUpdate is the result of ComputeShallow.
+type ShallowUpdate struct {
+	// Shallow are the new shallow commits of the client.
+	Shallow []pkt.ObjectID
+	// Unshallow are the shallow commits of the client that are not shallow
+	// anymore.
+	Unshallow []pkt.ObjectID
+	// Boundary are all the shallow commits of the client after the fetch,
+	// to be given to the pack generator.
+	Boundary []pkt.ObjectID
+}
+
+// ComputeShallow computes the shallow boundary of a deepen request. The
+// commits at Depth from the wants, or Depth beyond the shallow commits of
+// the client when Relative is set, become shallow if they have parents, and
+// the shallow commits of the client that end up inside the history become
+// unshallow.
+func ComputeShallow(ctx context.Context, g CommitGraph, req *DeepenRequest) (*ShallowUpdate, error) {
+	clientShallow := map[pkt.ObjectID]bool{}
+	for _, oid := range req.Shallows {
+		clientShallow[oid] = true
+	}
+
+	starts, depth := req.Wants, req.Depth
+	if req.Relative {
+		// The shallow commits of the client are at depth 1.
+		starts, depth = req.Shallows, req.Depth+1
+	}
+
+	// Walk breadth first, so that every commit is reached at its minimal
+	// depth.
+	reached := map[pkt.ObjectID]bool{}
+	boundary := map[pkt.ObjectID]bool{}
+	var queue []pkt.ObjectID
+	for _, oid := range starts {
+		if !reached[oid] {
+			reached[oid] = true
+			queue = append(queue, oid)
+		}
+	}
+	for d := 1; len(queue) > 0; d++ {
+		var next []pkt.ObjectID
+		for _, oid := range queue {
+			parents, err := g.Parents(ctx, oid)
+			if err != nil {
+				return nil, err
+			}
+			if len(parents) == 0 {
+				continue
+			}
+			if d >= depth {
+				boundary[oid] = true
+				continue
+			}
+			for _, p := range parents {
+				if !reached[p] {
+					reached[p] = true
+					next = append(next, p)
+				}
+			}
+		}
+		queue = next
+	}
+
+	u := &ShallowUpdate{}
+	for _, oid := range req.Shallows {
+		if reached[oid] && !boundary[oid] {
+			u.Unshallow = append(u.Unshallow, oid)
+		} else {
+			u.Boundary = append(u.Boundary, oid)
+		}
+	}
+	for oid := range boundary {
+		if !clientShallow[oid] {
+			u.Shallow = append(u.Shallow, oid)
+			u.Boundary = append(u.Boundary, oid)
+		}
+	}
+	slices.Sort(u.Shallow)
+	slices.Sort(u.Boundary)
+	return u, nil
+}
+
+// Chunks returns the shallow, unshallow and end of shallows chunks sending
+// the update to the client.
+func (u *ShallowUpdate) Chunks() []*pkt.UploadResponseChunk {
+	var chunks []*pkt.UploadResponseChunk
+	for _, oid := range u.Shallow {
+		chunks = append(chunks, pkt.NewShallowChunk(string(oid)))
+	}
+	for _, oid := range u.Unshallow {
+		chunks = append(chunks, pkt.NewUnshallowChunk(string(oid)))
+	}
+	return append(chunks, pkt.NewEndOfShallowsChunk())
+}
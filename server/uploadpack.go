@@ -0,0 +1,495 @@
+// Modified by Giacomo Tartari
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cycloidio/pkt-line"
+)
+
+// UploadPackConfig configures UploadPack. The fields mirror the
+// uploadpack.* settings of git, see SetOption.
+type UploadPackConfig struct {
+	// AllowFilter enables partial clones (uploadpack.allowFilter).
+	AllowFilter bool
+	// AllowRefInWant enables the ref-in-want feature of protocol v2 fetch
+	// (uploadpack.allowRefInWant).
+	AllowRefInWant bool
+	// KeepAlive is the interval of the keepalive packets sent while the pack
+	// is generated, when side-band is used (uploadpack.keepAlive). Zero
+	// disables them.
+	KeepAlive time.Duration
+	// PackObjectsHook is a shell command run in place of git pack-objects,
+	// with the pack-objects command line as arguments
+	// (uploadpack.packObjectsHook).
+	PackObjectsHook string
+	// HideRefs are the prefixes of the refs that are not advertised
+	// (uploadpack.hideRefs and transfer.hideRefs). See IsHidden.
+	HideRefs []string
+	// Wants is the policy for the wants of unadvertised objects
+	// (uploadpack.allow*SHA1InWant).
+	Wants WantConfig
+}
+
+// DefaultKeepAlive is the default of UploadPackConfig.KeepAlive in git.
+const DefaultKeepAlive = 5 * time.Second
+
+// SetOption sets the field matching a git configuration entry, so that
+// existing configurations can be ported. The key is case insensitive, e.g.
+// "uploadpack.allowFilter"; hideRefs entries accumulate.
+func (c *UploadPackConfig) SetOption(key, value string) error {
+	var err error
+	switch strings.ToLower(key) {
+	case "uploadpack.allowfilter":
+		c.AllowFilter, err = parseBool(value)
+	case "uploadpack.allowrefinwant":
+		c.AllowRefInWant, err = parseBool(value)
+	case "uploadpack.keepalive":
+		var secs int
+		if secs, err = strconv.Atoi(value); err == nil {
+			c.KeepAlive = time.Duration(secs) * time.Second
+		}
+	case "uploadpack.packobjectshook":
+		c.PackObjectsHook = value
+	case "uploadpack.hiderefs", "transfer.hiderefs":
+		c.HideRefs = append(c.HideRefs, value)
+	case "uploadpack.allowtipsha1inwant":
+		err = c.setWantPolicy(value, AllowTipSHA1InWant)
+	case "uploadpack.allowreachablesha1inwant":
+		err = c.setWantPolicy(value, AllowReachableSHA1InWant)
+	case "uploadpack.allowanysha1inwant":
+		err = c.setWantPolicy(value, AllowAnySHA1InWant)
+	default:
+		return fmt.Errorf("unknown option %q", key)
+	}
+	if err != nil {
+		return fmt.Errorf("bad value %q for %s: %v", value, key, err)
+	}
+	return nil
+}
+
+// setWantPolicy raises the want policy to p when value is true. Like in git,
+// the most permissive setting wins.
+func (c *UploadPackConfig) setWantPolicy(value string, p WantPolicy) error {
+	b, err := parseBool(value)
+	if b && c.Wants.Policy < p {
+		c.Wants.Policy = p
+	}
+	return err
+}
+
+func parseBool(s string) (bool, error) {
+	switch strings.ToLower(s) {
+	case "", "true", "yes", "on", "1":
+		return true, nil
+	case "false", "no", "off", "0":
+		return false, nil
+	}
+	return false, errors.New("not a boolean")
+}
+
+// IsHidden reports whether the ref is hidden by HideRefs. As in git, a
+// pattern matches the refs it is a path prefix of, a leading "!" negates it
+// and the last matching pattern wins.
+func (c *UploadPackConfig) IsHidden(ref string) bool {
+	for i := len(c.HideRefs) - 1; i >= 0; i-- {
+		p := c.HideRefs[i]
+		neg := strings.HasPrefix(p, "!")
+		p = strings.TrimPrefix(strings.TrimPrefix(p, "!"), "^")
+		if strings.HasPrefix(ref, p) && (len(ref) == len(p) || ref[len(p)] == '/' || strings.HasSuffix(p, "/")) {
+			return !neg
+		}
+	}
+	return false
+}
+
+// VisibleRefs returns the refs that are not hidden.
+func (c *UploadPackConfig) VisibleRefs(refs pkt.Refs) pkt.Refs {
+	return refs.Filter(func(r pkt.Ref) bool { return !c.IsHidden(r.Name) })
+}
+
+// FetchFeatures returns the features of the protocol v2 fetch command
+// enabled by the configuration, for v2.CapabilityAdvertisement.AddCommand.
+func (c *UploadPackConfig) FetchFeatures() []string {
+	var fs []string
+	if c.AllowFilter {
+		fs = append(fs, "filter")
+	}
+	if c.AllowRefInWant {
+		fs = append(fs, "ref-in-want")
+	}
+	return fs
+}
+
+// Repository is the repository served by UploadPack.
+type Repository interface {
+	// Refs returns the refs of the repository, hidden ones included.
+	Refs(ctx context.Context) (pkt.Refs, error)
+	// HasObject reports whether the repository has the object.
+	HasObject(ctx context.Context, oid pkt.ObjectID) (bool, error)
+}
+
+// PackRequest describes the pack to send to a client.
+type PackRequest struct {
+	Wants []pkt.ObjectID
+	// Haves are the objects the client has in common with the server.
+	Haves []pkt.ObjectID
+	// Shallows are the shallow commits of the client.
+	Shallows []pkt.ObjectID
+	Filter   string
+	// Thin allows deltas against objects the client has, as negotiated with
+	// the thin-pack capability in protocol v1 and the absence of the
+	// no-thin argument in protocol v2.
+	Thin bool
+	// IncludeTag asks for the annotated tags pointing at objects of the
+	// pack, as requested with the include-tag capability.
+	IncludeTag bool
+	// Tags are annotated tags to add to the pack, found by a TagFollower.
+	Tags []pkt.ObjectID
+	// Capabilities are the capabilities requested by the client.
+	Capabilities []string
+	// Progress receives the progress messages for the client.
+	Progress io.Writer
+}
+
+// HasCapability reports whether the client requested the capability.
+func (r *PackRequest) HasCapability(name string) bool {
+	return pkt.Capabilities(r.Capabilities).Has(name)
+}
+
+// PackGenerator generates the packs sent by UploadPack.
+type PackGenerator interface {
+	GeneratePack(ctx context.Context, req *PackRequest, w io.Writer) error
+}
+
+// TagFollower is implemented by the PackGenerators that need to be told the
+// annotated tags to include for include-tag. UploadPack calls FollowTags
+// before GeneratePack and stores the result in the Tags of the request.
+type TagFollower interface {
+	// FollowTags returns the annotated tags pointing, directly or through
+	// other tags, at the objects sent for req.
+	FollowTags(ctx context.Context, req *PackRequest) ([]pkt.ObjectID, error)
+}
+
+// GitPackGenerator generates packs with git pack-objects, or with the
+// PackObjectsHook of Config. It follows the tags itself with --include-tag.
+type GitPackGenerator struct {
+	// Dir is the git directory of the repository.
+	Dir    string
+	Config *UploadPackConfig
+}
+
+// Args returns the command line generating the pack for req.
+func (g *GitPackGenerator) Args(req *PackRequest) []string {
+	args := []string{"git", "pack-objects", "--revs", "--stdout"}
+	if req.HasCapability("ofs-delta") {
+		args = append(args, "--delta-base-offset")
+	}
+	if req.Thin {
+		args = append(args, "--thin")
+	}
+	if req.IncludeTag {
+		args = append(args, "--include-tag")
+	}
+	if req.Progress != io.Discard {
+		args = append(args, "--progress")
+	}
+	if req.Filter != "" {
+		args = append(args, "--filter="+req.Filter)
+	}
+	if g.Config != nil && g.Config.PackObjectsHook != "" {
+		args = append([]string{"sh", "-c", g.Config.PackObjectsHook + ` "$@"`, g.Config.PackObjectsHook}, args...)
+	}
+	return args
+}
+
+// GeneratePack implements PackGenerator.
+func (g *GitPackGenerator) GeneratePack(ctx context.Context, req *PackRequest, w io.Writer) error {
+	var in strings.Builder
+	for _, oid := range append(req.Wants, req.Tags...) {
+		in.WriteString(string(oid) + "\n")
+	}
+	in.WriteString("--not\n")
+	for _, oid := range req.Haves {
+		in.WriteString(string(oid) + "\n")
+	}
+	for _, oid := range req.Shallows {
+		in.WriteString("--shallow " + string(oid) + "\n")
+	}
+
+	args := g.Args(req)
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Dir = g.Dir
+	cmd.Env = append(cmd.Environ(), "GIT_DIR="+g.Dir)
+	cmd.Stdin = strings.NewReader(in.String())
+	cmd.Stdout = w
+	cmd.Stderr = req.Progress
+	return cmd.Run()
+}
+
+// UploadPack is a skeleton of a protocol v0/v1 git-upload-pack server. It
+// advertises the refs, checks the wants, negotiates without multi_ack and
+// sends the pack made by Packer, side-band encoded if requested. Shallow
+// fetches by depth are supported when Repo implements CommitGraph. The
+// limits of the Quota of SessionMiddleware are enforced, and a refused
+// request is reported to the client as an error packet.
+type UploadPack struct {
+	Config UploadPackConfig
+	Repo   Repository
+	Packer PackGenerator
+}
+
+// Capabilities returns the advertised capabilities.
+func (u *UploadPack) Capabilities() []string {
+	caps := []string{"side-band", "side-band-64k", "ofs-delta", "thin-pack", "no-progress", "include-tag"}
+	if _, ok := u.Repo.(CommitGraph); ok {
+		caps = append(caps, "shallow", "deepen-relative")
+	}
+	if u.Config.AllowFilter {
+		caps = append(caps, "filter")
+	}
+	return append(caps, u.Config.Wants.Capabilities()...)
+}
+
+// ServeSession implements SessionHandler.
+func (u *UploadPack) ServeSession(s *Session) error {
+	ctx := s.Context()
+	refs, err := u.Repo.Refs(ctx)
+	if err != nil {
+		return err
+	}
+	refs = u.Config.VisibleRefs(refs)
+	usage := sessionUsage(ctx)
+	if err := usage.advertise(usage.q, len(refs)); err != nil {
+		WriteError(s.Writer, err)
+		return err
+	}
+	adv := &pkt.Advertisement{Refs: refs, Capabilities: u.Capabilities()}
+	if _, err := adv.WriteTo(s.Writer); err != nil {
+		return err
+	}
+
+	// A client only listing the refs sends a flush or nothing.
+	rd := bufio.NewReader(s.Reader)
+	if p, err := rd.Peek(4); err == io.EOF || err == nil && string(p) == "0000" {
+		return nil
+	}
+
+	req, err := u.readRequest(ctx, pkt.NewUploadRequest(rd, pkt.WithContext(ctx)), s.Writer, refs)
+	if err != nil {
+		WriteError(s.Writer, err)
+		return err
+	}
+	return u.sendPack(ctx, s.Writer, req)
+}
+
+// readRequest reads the wants and negotiates the common objects.
+func (u *UploadPack) readRequest(ctx context.Context, r *pkt.UploadRequest, w io.Writer, refs pkt.Refs) (*PackRequest, error) {
+	req := &PackRequest{}
+	usage := sessionUsage(ctx)
+	var wants []string
+	wantsDone := false
+	depth := 0
+	for r.Scan() {
+		c := r.Chunk()
+		switch {
+		case c.WantObjectID != "":
+			if len(wants) == 0 {
+				req.Capabilities = c.Capabilities
+				req.Thin = c.PackMayBeThin()
+				req.IncludeTag = c.HasCapability("include-tag")
+			}
+			wants = append(wants, c.WantObjectID)
+		case c.ShallowObjectID != "":
+			req.Shallows = append(req.Shallows, pkt.ObjectID(c.ShallowObjectID))
+		case c.DeepenDepth != 0:
+			if _, ok := u.Repo.(CommitGraph); !ok {
+				return nil, errors.New("upload-pack: shallow fetches are not supported")
+			}
+			depth = c.DeepenDepth
+		case c.DeepenSince != 0 || c.DeepenNotRef != "":
+			return nil, errors.New("upload-pack: deepen-since and deepen-not are not supported")
+		case c.FilterSpec != "":
+			if !u.Config.AllowFilter {
+				return nil, errors.New("upload-pack: filtering not allowed")
+			}
+			if _, err := c.Filter(); err != nil {
+				return nil, err
+			}
+			req.Filter = c.FilterSpec
+		case c.EndOneRound && !wantsDone:
+			wantsDone = true
+			if err := usage.fetch(usage.q, 0, len(wants), 0); err != nil {
+				return nil, err
+			}
+			if err := u.Config.Wants.CheckWants(ctx, wants, refs.ObjectIDs()); err != nil {
+				return nil, err
+			}
+			for _, oid := range wants {
+				req.Wants = append(req.Wants, pkt.ObjectID(oid))
+			}
+			if depth > 0 {
+				if err := u.deepen(ctx, w, req, depth); err != nil {
+					return nil, err
+				}
+			}
+		case c.HaveObjectID != "":
+			if err := usage.fetch(usage.q, 0, 0, 1); err != nil {
+				return nil, err
+			}
+			oid := pkt.ObjectID(c.HaveObjectID)
+			ok, err := u.Repo.HasObject(ctx, oid)
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				if len(req.Haves) == 0 {
+					// Without multi_ack, only the first common object is
+					// acknowledged.
+					if _, err := w.Write(pkt.NewAckChunk(string(oid), "").EncodeToPktLine()); err != nil {
+						return nil, err
+					}
+				}
+				req.Haves = append(req.Haves, oid)
+			}
+		case c.EndOneRound, c.NoMoreNegotiation:
+			if err := usage.fetch(usage.q, 1, 0, 0); err != nil {
+				return nil, err
+			}
+			if len(req.Haves) == 0 {
+				if _, err := w.Write(pkt.NewNakChunk().EncodeToPktLine()); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+	return req, r.Err()
+}
+
+// deepen sends the shallow update of a deepen request and sets the shallow
+// boundary of req.
+func (u *UploadPack) deepen(ctx context.Context, w io.Writer, req *PackRequest, depth int) error {
+	su, err := ComputeShallow(ctx, u.Repo.(CommitGraph), &DeepenRequest{
+		Wants:    req.Wants,
+		Shallows: req.Shallows,
+		Depth:    depth,
+		Relative: req.HasCapability("deepen-relative"),
+	})
+	if err != nil {
+		return err
+	}
+	for _, c := range su.Chunks() {
+		b, err := pkt.Encode(c)
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write(b); err != nil {
+			return err
+		}
+	}
+	req.Shallows = su.Boundary
+	return nil
+}
+
+// sendPack writes the pack of req to w.
+func (u *UploadPack) sendPack(ctx context.Context, w io.Writer, req *PackRequest) error {
+	if tf, ok := u.Packer.(TagFollower); ok && req.IncludeTag {
+		tags, err := tf.FollowTags(ctx, req)
+		if err != nil {
+			return err
+		}
+		req.Tags = tags
+	}
+
+	req.Progress = io.Discard
+	large := req.HasCapability("side-band-64k")
+	if !large && !req.HasCapability("side-band") {
+		return u.Packer.GeneratePack(ctx, req, w)
+	}
+
+	aw := &activityWriter{w: w}
+	m := pkt.NewSideBandMuxer(aw, large)
+	if !req.HasCapability("no-progress") {
+		req.Progress = m.Progress()
+	}
+	stop := keepAlive(m, aw, u.Config.KeepAlive)
+	err := u.Packer.GeneratePack(ctx, req, m.Main())
+	stop()
+	if err != nil {
+		return err
+	}
+	return m.Flush()
+}
+
+// activityWriter records the time of the last write.
+type activityWriter struct {
+	mu   sync.Mutex
+	w    io.Writer
+	last time.Time
+}
+
+func (w *activityWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	w.last = time.Now()
+	w.mu.Unlock()
+	return w.w.Write(p)
+}
+
+func (w *activityWriter) idle(d time.Duration) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return time.Since(w.last) >= d
+}
+
+// keepAlive sends a keepalive packet with m when nothing was written to aw
+// for d, until the returned function is called.
+func keepAlive(m *pkt.SideBandMuxer, aw *activityWriter, d time.Duration) (stop func()) {
+	if d <= 0 {
+		return func() {}
+	}
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		t := time.NewTicker(d / 2)
+		defer t.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-t.C:
+				if aw.idle(d) {
+					m.KeepAlive()
+				}
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		wg.Wait()
+	}
+}
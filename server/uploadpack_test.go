@@ -0,0 +1,284 @@
+// Modified by Giacomo Tartari
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cycloidio/pkt-line"
+	"github.com/google/go-cmp/cmp"
+)
+
+// pktLines encodes lines as pkt-lines, except "0000" and "0001" which are
+// written as is.
+func pktLines(lines ...string) string {
+	var b strings.Builder
+	for _, l := range lines {
+		if l == "0000" || l == "0001" {
+			b.WriteString(l)
+			continue
+		}
+		b.Write(pkt.BytesPacket(l).EncodeToPktLine())
+	}
+	return b.String()
+}
+
+func TestUploadPackConfig_SetOption(t *testing.T) {
+	var c UploadPackConfig
+	for _, kv := range [][2]string{
+		{"uploadpack.allowFilter", "true"},
+		{"UPLOADPACK.ALLOWREFINWANT", "yes"},
+		{"uploadpack.keepAlive", "10"},
+		{"uploadpack.packObjectsHook", "cache"},
+		{"uploadpack.hideRefs", "refs/pull"},
+		{"transfer.hideRefs", "!refs/pull/1"},
+		{"uploadpack.allowReachableSHA1InWant", "on"},
+		// The most permissive want policy wins.
+		{"uploadpack.allowTipSHA1InWant", "true"},
+	} {
+		if err := c.SetOption(kv[0], kv[1]); err != nil {
+			t.Fatalf("SetOption(%q, %q): %v", kv[0], kv[1], err)
+		}
+	}
+	want := UploadPackConfig{
+		AllowFilter:     true,
+		AllowRefInWant:  true,
+		KeepAlive:       10 * time.Second,
+		PackObjectsHook: "cache",
+		HideRefs:        []string{"refs/pull", "!refs/pull/1"},
+		Wants:           WantConfig{Policy: AllowReachableSHA1InWant},
+	}
+	if diff := cmp.Diff(want, c); diff != "" {
+		t.Errorf("config mismatch (-want +got):\n%s", diff)
+	}
+
+	for _, kv := range [][2]string{
+		{"uploadpack.unknown", "true"},
+		{"uploadpack.allowFilter", "maybe"},
+		{"uploadpack.keepAlive", "5s"},
+	} {
+		if err := c.SetOption(kv[0], kv[1]); err == nil {
+			t.Errorf("SetOption(%q, %q) succeeded", kv[0], kv[1])
+		}
+	}
+}
+
+func TestUploadPackConfig_IsHidden(t *testing.T) {
+	c := UploadPackConfig{HideRefs: []string{"refs/pull", "!refs/pull/1", "refs/tmp/"}}
+	tests := []struct {
+		ref    string
+		hidden bool
+	}{
+		{"refs/heads/main", false},
+		{"refs/pull", true},
+		{"refs/pull/2/head", true},
+		{"refs/pull/1/head", false},
+		{"refs/pull/10/head", true},
+		{"refs/pulls", false},
+		{"refs/tmp/x", true},
+	}
+	for _, tt := range tests {
+		if got := c.IsHidden(tt.ref); got != tt.hidden {
+			t.Errorf("IsHidden(%q) = %v, want %v", tt.ref, got, tt.hidden)
+		}
+	}
+}
+
+func TestGitPackGenerator_Args(t *testing.T) {
+	tests := []struct {
+		name string
+		g    GitPackGenerator
+		req  PackRequest
+		want []string
+	}{
+		{
+			name: "minimal",
+			req:  PackRequest{Progress: io.Discard},
+			want: []string{"git", "pack-objects", "--revs", "--stdout"},
+		},
+		{
+			name: "all",
+			req: PackRequest{
+				Capabilities: []string{"ofs-delta"},
+				Thin:         true,
+				IncludeTag:   true,
+				Filter:       "blob:none",
+				Progress:     &bytes.Buffer{},
+			},
+			want: []string{"git", "pack-objects", "--revs", "--stdout", "--delta-base-offset", "--thin", "--include-tag", "--progress", "--filter=blob:none"},
+		},
+		{
+			name: "hook",
+			g:    GitPackGenerator{Config: &UploadPackConfig{PackObjectsHook: "cache"}},
+			req:  PackRequest{Progress: io.Discard},
+			want: []string{"sh", "-c", `cache "$@"`, "cache", "git", "pack-objects", "--revs", "--stdout"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if diff := cmp.Diff(tt.want, tt.g.Args(&tt.req)); diff != "" {
+				t.Errorf("Args() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+// memRepo is a Repository with the objects of its refs and extra ones.
+type memRepo struct {
+	refs    pkt.Refs
+	objects []pkt.ObjectID
+}
+
+func (r *memRepo) Refs(ctx context.Context) (pkt.Refs, error) {
+	return r.refs, nil
+}
+
+func (r *memRepo) HasObject(ctx context.Context, oid pkt.ObjectID) (bool, error) {
+	for _, id := range r.objects {
+		if id == oid {
+			return true, nil
+		}
+	}
+	for _, ref := range r.refs {
+		if ref.ObjectID == oid {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// fakePacker records the requests and writes a fake pack.
+type fakePacker struct {
+	reqs []*PackRequest
+}
+
+func (p *fakePacker) GeneratePack(ctx context.Context, req *PackRequest, w io.Writer) error {
+	p.reqs = append(p.reqs, req)
+	_, err := io.WriteString(w, "PACKdata")
+	return err
+}
+
+func TestUploadPack(t *testing.T) {
+	a, b, c, d := strings.Repeat("a", 40), strings.Repeat("b", 40), strings.Repeat("c", 40), strings.Repeat("d", 40)
+	repo := &memRepo{
+		refs: pkt.Refs{
+			{Name: "refs/heads/main", ObjectID: pkt.ObjectID(a)},
+			{Name: "refs/pull/1/head", ObjectID: pkt.ObjectID(b)},
+		},
+		objects: []pkt.ObjectID{pkt.ObjectID(c)},
+	}
+	tests := []struct {
+		name   string
+		config UploadPackConfig
+		req    string
+		// resp is the response following the advertisement.
+		resp string
+		// wants and haves are those of the pack request, if any.
+		wants []pkt.ObjectID
+		haves []pkt.ObjectID
+		err   bool
+	}{
+		{
+			name: "ls-remote",
+			req:  "0000",
+		},
+		{
+			name:  "clone",
+			req:   pktLines("want "+a+"\n", "0000", "done\n"),
+			resp:  pktLines("NAK\n") + "PACKdata",
+			wants: []pkt.ObjectID{pkt.ObjectID(a)},
+		},
+		{
+			// Without multi_ack, only the first common object is
+			// acknowledged, and no NAK follows.
+			name:  "fetch",
+			req:   pktLines("want "+a+" ofs-delta\n", "0000", "have "+d+"\n", "have "+c+"\n", "0000", "have "+b+"\n", "done\n"),
+			resp:  pktLines("ACK "+c+"\n") + "PACKdata",
+			wants: []pkt.ObjectID{pkt.ObjectID(a)},
+			haves: []pkt.ObjectID{pkt.ObjectID(c), pkt.ObjectID(b)},
+		},
+		{
+			name:  "side-band-64k",
+			req:   pktLines("want "+a+" side-band-64k no-progress\n", "0000", "done\n"),
+			resp:  pktLines("NAK\n", "\x01PACKdata", "0000"),
+			wants: []pkt.ObjectID{pkt.ObjectID(a)},
+		},
+		{
+			name:   "hidden ref",
+			config: UploadPackConfig{HideRefs: []string{"refs/pull"}},
+			req:    pktLines("want "+b+"\n", "0000", "done\n"),
+			resp:   pktLines("ERR cannot fetch " + b + ": not our ref"),
+			err:    true,
+		},
+		{
+			name:   "tip of a hidden ref",
+			config: UploadPackConfig{HideRefs: []string{"refs/pull"}, Wants: WantConfig{Policy: AllowAnySHA1InWant}},
+			req:    pktLines("want "+b+"\n", "0000", "done\n"),
+			resp:   pktLines("NAK\n") + "PACKdata",
+			wants:  []pkt.ObjectID{pkt.ObjectID(b)},
+		},
+		{
+			name: "filter not allowed",
+			req:  pktLines("want "+a+" filter\n", "filter blob:none\n", "0000", "done\n"),
+			resp: pktLines("ERR upload-pack: filtering not allowed"),
+			err:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			packer := &fakePacker{}
+			up := &UploadPack{Config: tt.config, Repo: repo, Packer: packer}
+			var adv bytes.Buffer
+			a := &pkt.Advertisement{Refs: tt.config.VisibleRefs(repo.refs), Capabilities: up.Capabilities()}
+			if _, err := a.WriteTo(&adv); err != nil {
+				t.Fatal(err)
+			}
+
+			var out bytes.Buffer
+			err := up.ServeSession(NewSession(context.Background(), "git-upload-pack", strings.NewReader(tt.req), &out))
+			if (err != nil) != tt.err {
+				t.Fatalf("got error %v, want error %v", err, tt.err)
+			}
+			got, ok := strings.CutPrefix(out.String(), adv.String())
+			if !ok {
+				t.Fatalf("got %q, want the advertisement %q first", out.String(), adv.String())
+			}
+			if got != tt.resp {
+				t.Errorf("got response %q, want %q", got, tt.resp)
+			}
+			if tt.wants == nil {
+				if len(packer.reqs) != 0 {
+					t.Errorf("unexpected pack request %+v", packer.reqs[0])
+				}
+				return
+			}
+			if len(packer.reqs) != 1 {
+				t.Fatalf("got %d pack requests, want 1", len(packer.reqs))
+			}
+			if diff := cmp.Diff(tt.wants, packer.reqs[0].Wants); diff != "" {
+				t.Errorf("wants mismatch (-want +got):\n%s", diff)
+			}
+			if diff := cmp.Diff(tt.haves, packer.reqs[0].Haves); diff != "" {
+				t.Errorf("haves mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,213 @@
+// Modified by Giacomo Tartari
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"errors"
+	"io"
+	"strings"
+
+	"github.com/cycloidio/pkt-line"
+	pktv2 "github.com/cycloidio/pkt-line/v2"
+)
+
+// V2Server serves protocol v2 sessions: it advertises the capabilities and
+// the commands of Mux, reads the commands of the client and dispatches them
+// to their handler, with a ResponseWriter. The session ends at the flush
+// packet sent by the client instead of a command, or at the end of the
+// input. An error of a handler is reported to the client as an error packet
+// and ends the session.
+type V2Server struct {
+	// Mux dispatches the commands.
+	Mux *CommandMux
+	// Capabilities are advertised before the commands, e.g.
+	// "agent=example/1.0" or "object-format=sha1".
+	Capabilities []string
+	// Features are the features advertised as the values of the commands,
+	// e.g. {"fetch": {"shallow", "filter"}}.
+	Features map[string][]string
+	// Stateless disables the advertisement, sent separately by a smart
+	// HTTP server in response to the GET of info/refs.
+	Stateless bool
+}
+
+// Advertisement returns the capability advertisement of the server.
+func (s *V2Server) Advertisement() (*pktv2.CapabilityAdvertisement, error) {
+	a := &pktv2.CapabilityAdvertisement{}
+	for _, c := range s.Capabilities {
+		name, value, _ := strings.Cut(c, "=")
+		if err := a.AddCapability(name, value); err != nil {
+			return nil, err
+		}
+	}
+	for _, name := range s.Mux.Commands() {
+		if err := a.AddCommand(name, s.Features[name]...); err != nil {
+			return nil, err
+		}
+	}
+	return a, nil
+}
+
+// ServeSession implements SessionHandler.
+func (s *V2Server) ServeSession(sess *Session) error {
+	if !s.Stateless {
+		a, err := s.Advertisement()
+		if err != nil {
+			return err
+		}
+		if _, err := a.WriteTo(sess.Writer); err != nil {
+			return err
+		}
+		if err := flush(sess.Writer); err != nil {
+			return err
+		}
+	}
+
+	r := pktv2.NewRequest(sess.Reader, pkt.WithContext(sess.Context()))
+	var cmd *Command
+	for r.Scan() {
+		c := r.Chunk()
+		switch {
+		case c.Command != "":
+			cmd = &Command{Name: c.Command, Session: sess}
+		case c.Capability != "":
+			cmd.Capabilities = append(cmd.Capabilities, c.Capability)
+		case len(c.Argument) != 0:
+			cmd.Arguments = append(cmd.Arguments, strings.TrimSuffix(string(c.Argument), "\n"))
+		case c.EndArgument:
+			if err := s.serveCommand(sess.Writer, cmd); err != nil {
+				return err
+			}
+		}
+	}
+	return r.Err()
+}
+
+// serveCommand dispatches cmd and ends its response.
+func (s *V2Server) serveCommand(w io.Writer, cmd *Command) error {
+	rw := NewResponseWriter(w)
+	if err := s.Mux.ServeCommand(rw, cmd); err != nil {
+		WriteError(w, err)
+		return err
+	}
+	return rw.End()
+}
+
+// ResponseWriter writes the response to a protocol v2 command, made of
+// sections, e.g. the acknowledgments and the packfile of a fetch: the delim
+// packets between the sections and the flush packet ending the response are
+// written as needed. A handler writing raw pkt-lines with Write frames the
+// response itself, and End then writes nothing.
+type ResponseWriter struct {
+	w       io.Writer
+	pw      *pkt.PacketWriter
+	section string
+	written bool
+	raw     bool
+	ended   bool
+}
+
+// NewResponseWriter returns a ResponseWriter writing to w.
+func NewResponseWriter(w io.Writer) *ResponseWriter {
+	return &ResponseWriter{w: w, pw: pkt.NewPacketWriter(w)}
+}
+
+// ErrResponseEnded is returned by the methods of a ResponseWriter after End.
+var ErrResponseEnded = errors.New("the response has ended")
+
+// Write writes pkt-line encoded data as is.
+func (rw *ResponseWriter) Write(p []byte) (int, error) {
+	if rw.ended {
+		return 0, ErrResponseEnded
+	}
+	rw.raw = true
+	return rw.w.Write(p)
+}
+
+// Section starts a section, e.g. pktv2.SectionPackfile, writing the delim
+// packet ending the previous one.
+func (rw *ResponseWriter) Section(name string) error {
+	if rw.section != "" {
+		if err := rw.pw.Delim(); err != nil {
+			return err
+		}
+	}
+	rw.section = name
+	return rw.WriteLine(name)
+}
+
+// WriteLine writes a text line in the current section.
+func (rw *ResponseWriter) WriteLine(s string) error {
+	p, err := pkt.TextPacket(s)
+	if err != nil {
+		return err
+	}
+	return rw.WritePacket(p)
+}
+
+// WritePacket writes p in the current section, e.g. a side-band packet of
+// the packfile section.
+func (rw *ResponseWriter) WritePacket(p pkt.Packet) error {
+	if rw.ended {
+		return ErrResponseEnded
+	}
+	rw.written = true
+	return rw.pw.WritePacket(p)
+}
+
+// End writes the flush packet ending the response, unless the response was
+// written with Write, and flushes the underlying writer. It is called by
+// V2Server after the handler returns.
+func (rw *ResponseWriter) End() error {
+	if rw.ended {
+		return nil
+	}
+	rw.ended = true
+	if rw.raw && !rw.written {
+		return flush(rw.w)
+	}
+	return rw.pw.Flush()
+}
+
+// ResponseHandlerFunc is an adapter to allow the use of ordinary functions
+// writing with a ResponseWriter as Handler. Outside of a V2Server, or behind
+// a middleware wrapping the writer, the function gets its own
+// ResponseWriter, ended when it returns.
+type ResponseHandlerFunc func(rw *ResponseWriter, cmd *Command) error
+
+// ServeCommand calls f with the ResponseWriter of w.
+func (f ResponseHandlerFunc) ServeCommand(w io.Writer, cmd *Command) error {
+	if rw, ok := w.(*ResponseWriter); ok {
+		return f(rw, cmd)
+	}
+	rw := NewResponseWriter(w)
+	if err := f(rw, cmd); err != nil {
+		return err
+	}
+	return rw.End()
+}
+
+// flush flushes w if it has a Flush method, like bufio.Writer or
+// http.ResponseWriter.
+func flush(w io.Writer) error {
+	switch f := w.(type) {
+	case interface{ Flush() error }:
+		return f.Flush()
+	case interface{ Flush() }:
+		f.Flush()
+	}
+	return nil
+}
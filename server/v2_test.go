@@ -0,0 +1,212 @@
+// Modified by Giacomo Tartari
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/cycloidio/pkt-line"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+// testV2Mux returns a mux of a "lines" command, writing its arguments in a
+// section, a "raw" command framing its response itself, and a "fail"
+// command. The commands served are recorded in cmds.
+func testV2Mux(cmds *[]*Command) *CommandMux {
+	mux := NewCommandMux()
+	mux.Handle("lines", ResponseHandlerFunc(func(rw *ResponseWriter, cmd *Command) error {
+		*cmds = append(*cmds, cmd)
+		if err := rw.Section("args"); err != nil {
+			return err
+		}
+		for _, a := range cmd.Arguments {
+			if err := rw.WriteLine(a); err != nil {
+				return err
+			}
+		}
+		return nil
+	}))
+	mux.HandleFunc("raw", func(w io.Writer, cmd *Command) error {
+		*cmds = append(*cmds, cmd)
+		_, err := io.WriteString(w, pktLines("raw\n", "0000"))
+		return err
+	})
+	mux.HandleFunc("fail", func(w io.Writer, cmd *Command) error {
+		*cmds = append(*cmds, cmd)
+		return errors.New("failed")
+	})
+	return mux
+}
+
+func TestV2Server(t *testing.T) {
+	adv := pktLines("version 2\n", "agent=test/1.0\n", "object-format=sha1\n", "fail\n", "lines=x y\n", "raw\n", "0000")
+	tests := []struct {
+		name      string
+		stateless bool
+		in        string
+		out       string
+		cmds      []*Command
+		err       string
+	}{
+		{
+			name: "commands",
+			in: pktLines("command=lines\n", "agent=git/2.40\n", "0001", "a\n", "b\n", "0000",
+				"command=raw\n", "0001", "0000", "0000"),
+			out: adv + pktLines("args\n", "a\n", "b\n", "0000", "raw\n", "0000"),
+			cmds: []*Command{
+				{Name: "lines", Capabilities: []string{"agent=git/2.40"}, Arguments: []string{"a", "b"}},
+				{Name: "raw"},
+			},
+		},
+		{
+			name:      "stateless",
+			stateless: true,
+			in:        pktLines("command=lines\n", "0001", "a\n", "0000"),
+			out:       pktLines("args\n", "a\n", "0000"),
+			cmds:      []*Command{{Name: "lines", Arguments: []string{"a"}}},
+		},
+		{
+			name: "no command",
+			in:   pktLines("0000"),
+			out:  adv,
+		},
+		{
+			name: "unknown command",
+			in:   pktLines("command=push\n", "0001", "0000", "command=lines\n", "0001", "0000"),
+			out:  adv + pktLines("ERR unknown command \"push\""),
+			err:  `unknown command "push"`,
+		},
+		{
+			name: "failed command",
+			in:   pktLines("command=fail\n", "0001", "0000", "command=lines\n", "0001", "0000"),
+			out:  adv + pktLines("ERR failed"),
+			cmds: []*Command{{Name: "fail"}},
+			err:  "failed",
+		},
+		{
+			name: "bad request",
+			in:   pktLines("lines\n", "0000"),
+			out:  adv,
+			err:  "packet 0 at offset 0",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var cmds []*Command
+			s := &V2Server{
+				Mux:          testV2Mux(&cmds),
+				Capabilities: []string{"agent=test/1.0", "object-format=sha1"},
+				Features:     map[string][]string{"lines": {"x", "y"}},
+				Stateless:    tt.stateless,
+			}
+			var out bytes.Buffer
+			sess := NewSession(context.Background(), "git-upload-pack", strings.NewReader(tt.in), &out)
+			err := s.ServeSession(sess)
+			if tt.err == "" && err != nil || tt.err != "" && (err == nil || !strings.HasPrefix(err.Error(), tt.err)) {
+				t.Errorf("got error %v, want %q", err, tt.err)
+			}
+			if out.String() != tt.out {
+				t.Errorf("got output %q, want %q", out.String(), tt.out)
+			}
+			if diff := cmp.Diff(tt.cmds, cmds, cmpopts.IgnoreFields(Command{}, "Session")); diff != "" {
+				t.Errorf("commands mismatch (-want +got):\n%s", diff)
+			}
+			for _, c := range cmds {
+				if c.Session != sess {
+					t.Errorf("command %s has session %p, want %p", c.Name, c.Session, sess)
+				}
+			}
+		})
+	}
+}
+
+func TestResponseWriter(t *testing.T) {
+	t.Run("sections", func(t *testing.T) {
+		var out bytes.Buffer
+		rw := NewResponseWriter(&out)
+		rw.Section("acknowledgments")
+		rw.WriteLine("NAK")
+		rw.Section("packfile")
+		rw.WritePacket(pkt.SideBandMainPacket("PACK"))
+		if err := rw.End(); err != nil {
+			t.Fatal(err)
+		}
+		want := pktLines("acknowledgments\n", "NAK\n") + "0001" + pktLines("packfile\n", "\x01PACK", "0000")
+		if out.String() != want {
+			t.Errorf("got %q, want %q", out.String(), want)
+		}
+		// The response ends once.
+		if err := rw.End(); err != nil {
+			t.Errorf("second End() = %v", err)
+		}
+		if err := rw.WriteLine("x"); err != ErrResponseEnded {
+			t.Errorf("WriteLine() = %v after End, want %v", err, ErrResponseEnded)
+		}
+		if _, err := rw.Write([]byte("0000")); err != ErrResponseEnded {
+			t.Errorf("Write() = %v after End, want %v", err, ErrResponseEnded)
+		}
+		if out.String() != want {
+			t.Errorf("got %q after End, want %q", out.String(), want)
+		}
+	})
+	t.Run("empty", func(t *testing.T) {
+		var out bytes.Buffer
+		if err := NewResponseWriter(&out).End(); err != nil {
+			t.Fatal(err)
+		}
+		if out.String() != "0000" {
+			t.Errorf("got %q, want a flush", out.String())
+		}
+	})
+	t.Run("raw", func(t *testing.T) {
+		var out bytes.Buffer
+		rw := NewResponseWriter(&out)
+		rw.Write([]byte(pktLines("a\n", "0000")))
+		if err := rw.End(); err != nil {
+			t.Fatal(err)
+		}
+		if want := pktLines("a\n", "0000"); out.String() != want {
+			t.Errorf("got %q, want %q", out.String(), want)
+		}
+	})
+	t.Run("bad line", func(t *testing.T) {
+		var out bytes.Buffer
+		if err := NewResponseWriter(&out).WriteLine("a\nb"); err == nil {
+			t.Error("got no error for a line with LF")
+		}
+	})
+}
+
+func TestResponseHandlerFunc(t *testing.T) {
+	// Outside of a V2Server, the handler gets its own writer, ended when
+	// it returns.
+	h := ResponseHandlerFunc(func(rw *ResponseWriter, cmd *Command) error {
+		return rw.WriteLine(cmd.Name)
+	})
+	var out bytes.Buffer
+	if err := h.ServeCommand(&out, &Command{Name: "ls-refs"}); err != nil {
+		t.Fatal(err)
+	}
+	if want := pktLines("ls-refs\n", "0000"); out.String() != want {
+		t.Errorf("got %q, want %q", out.String(), want)
+	}
+}
@@ -0,0 +1,129 @@
+// Modified by Giacomo Tartari
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+
+	"github.com/cycloidio/pkt-line"
+)
+
+// WantPolicy tells which unadvertised objects a client may want, like the
+// uploadpack.allow*SHA1InWant settings of git.
+type WantPolicy int
+
+const (
+	// AllowAdvertised only allows the advertised objects.
+	AllowAdvertised WantPolicy = iota
+	// AllowTipSHA1InWant also allows the tips of the refs that are not
+	// advertised, e.g. hidden refs.
+	AllowTipSHA1InWant
+	// AllowReachableSHA1InWant allows the objects reachable from a ref.
+	AllowReachableSHA1InWant
+	// AllowAnySHA1InWant allows any object.
+	AllowAnySHA1InWant
+)
+
+// ReachabilityFunc reports whether oid satisfies a reachability condition.
+type ReachabilityFunc func(ctx context.Context, oid pkt.ObjectID) (bool, error)
+
+// WantConfig checks the wants of fetches against a WantPolicy.
+type WantConfig struct {
+	Policy WantPolicy
+	// IsTip reports whether oid is the tip of a ref, hidden or not. It is
+	// required by AllowTipSHA1InWant and protocol v2.
+	IsTip ReachabilityFunc
+	// IsReachable reports whether oid is reachable from a ref. It is required
+	// by AllowReachableSHA1InWant.
+	IsReachable ReachabilityFunc
+}
+
+// Capabilities returns the capabilities to advertise for the policy. Like
+// git, AllowAnySHA1InWant advertises both allow-tip-sha1-in-want and
+// allow-reachable-sha1-in-want, which is what clients understand.
+func (c *WantConfig) Capabilities() []string {
+	switch c.Policy {
+	case AllowTipSHA1InWant:
+		return []string{"allow-tip-sha1-in-want"}
+	case AllowReachableSHA1InWant:
+		return []string{"allow-reachable-sha1-in-want"}
+	case AllowAnySHA1InWant:
+		return []string{"allow-tip-sha1-in-want", "allow-reachable-sha1-in-want"}
+	}
+	return nil
+}
+
+// CheckWants returns the wants that the policy refuses as
+// pkt.UnfetchableObjectErrors joined with errors.Join, or the first error of
+// the callbacks. The advertised objects are always allowed; a nil
+// advertised, as in protocol v2 where the refs are listed by ls-refs, makes
+// IsTip the check for them.
+func (c *WantConfig) CheckWants(ctx context.Context, wants, advertised []string) error {
+	adv := make(map[string]bool, len(advertised))
+	for _, oid := range advertised {
+		adv[oid] = true
+	}
+	isTip := c.Policy >= AllowTipSHA1InWant || advertised == nil
+
+	var errs []error
+	for _, oid := range wants {
+		if c.Policy == AllowAnySHA1InWant || adv[oid] {
+			continue
+		}
+		ok, err := c.check(ctx, isTip, c.IsTip, oid)
+		if err == nil && !ok {
+			ok, err = c.check(ctx, c.Policy >= AllowReachableSHA1InWant, c.IsReachable, oid)
+		}
+		if err != nil {
+			return err
+		}
+		if !ok {
+			errs = append(errs, &pkt.UnfetchableObjectError{ObjectID: oid, Reason: "not our ref"})
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (c *WantConfig) check(ctx context.Context, enabled bool, fn ReachabilityFunc, oid string) (bool, error) {
+	if !enabled || fn == nil {
+		return false, nil
+	}
+	return fn(ctx, pkt.ObjectID(oid))
+}
+
+// Middleware returns a middleware checking the want arguments of protocol v2
+// fetch commands with CheckWants.
+func (c *WantConfig) Middleware() Middleware {
+	return func(next Handler) Handler {
+		return HandlerFunc(func(w io.Writer, cmd *Command) error {
+			if cmd.Name == "fetch" {
+				var wants []string
+				for _, arg := range cmd.Arguments {
+					if oid, ok := strings.CutPrefix(arg, "want "); ok {
+						wants = append(wants, oid)
+					}
+				}
+				if err := c.CheckWants(cmd.Context(), wants, nil); err != nil {
+					return err
+				}
+			}
+			return next.ServeCommand(w, cmd)
+		})
+	}
+}
@@ -15,10 +15,6 @@
 
 package pkt
 
-import (
-	"fmt"
-)
-
 // BytePayloadPacket is the interface of Packets that the payload is []byte.
 type BytePayloadPacket interface {
 	Packet
@@ -28,13 +24,15 @@ type BytePayloadPacket interface {
 // SideBandMainPacket is a sideband packet for the main stream (0x01).
 type SideBandMainPacket []byte
 
-// EncodeToPktLine serializes the packet.
+// EncodeToPktLine serializes the packet. It panics with a
+// PacketTooLargeError if the packet does not fit, see EncodeToPktLineErr.
 func (p SideBandMainPacket) EncodeToPktLine() []byte {
-	sz := len(p)
-	if sz > 0xFFFF-5 {
-		panic("content too large")
-	}
-	return append([]byte(fmt.Sprintf("%04x%c", sz+5, 1)), p...)
+	return p.AppendPktLine(make([]byte, 0, len(p)+5))
+}
+
+// AppendPktLine appends the serialized packet to dst.
+func (p SideBandMainPacket) AppendPktLine(dst []byte) []byte {
+	return appendPayload(dst, 1, p)
 }
 
 // Bytes returns the payload.
@@ -45,13 +43,15 @@ func (p SideBandMainPacket) Bytes() []byte {
 // SideBandReportPacket is a sideband packet for the report stream (0x02).
 type SideBandReportPacket []byte
 
-// EncodeToPktLine serializes the packet.
+// EncodeToPktLine serializes the packet. It panics with a
+// PacketTooLargeError if the packet does not fit, see EncodeToPktLineErr.
 func (p SideBandReportPacket) EncodeToPktLine() []byte {
-	sz := len(p)
-	if sz > 0xFFFF-5 {
-		panic("content too large")
-	}
-	return append([]byte(fmt.Sprintf("%04x%c", sz+5, 2)), p...)
+	return p.AppendPktLine(make([]byte, 0, len(p)+5))
+}
+
+// AppendPktLine appends the serialized packet to dst.
+func (p SideBandReportPacket) AppendPktLine(dst []byte) []byte {
+	return appendPayload(dst, 2, p)
 }
 
 // Bytes returns the payload.
@@ -62,13 +62,15 @@ func (p SideBandReportPacket) Bytes() []byte {
 // SideBandErrorPacket is a sideband packet for the error stream (0x03).
 type SideBandErrorPacket []byte
 
-// EncodeToPktLine serializes the packet.
+// EncodeToPktLine serializes the packet. It panics with a
+// PacketTooLargeError if the packet does not fit, see EncodeToPktLineErr.
 func (p SideBandErrorPacket) EncodeToPktLine() []byte {
-	sz := len(p)
-	if sz > 0xFFFF-5 {
-		panic("content too large")
-	}
-	return append([]byte(fmt.Sprintf("%04x%c", sz+5, 3)), p...)
+	return p.AppendPktLine(make([]byte, 0, len(p)+5))
+}
+
+// AppendPktLine appends the serialized packet to dst.
+func (p SideBandErrorPacket) AppendPktLine(dst []byte) []byte {
+	return appendPayload(dst, 3, p)
 }
 
 // Bytes returns the payload.
@@ -79,6 +81,9 @@ func (p SideBandErrorPacket) Bytes() []byte {
 // ParseSideBandPacket parses the BytesPacket as a sideband packet. Returns nil
 // if the packet is not a sideband packet.
 func ParseSideBandPacket(bp BytesPacket) BytePayloadPacket {
+	if len(bp) == 0 {
+		return nil
+	}
 	switch bp[0] {
 	case 1:
 		return SideBandMainPacket(bp[1:])
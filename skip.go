@@ -0,0 +1,89 @@
+// Modified by Giacomo Tartari
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkt
+
+import (
+	"bytes"
+	"io"
+)
+
+// Discard skips the next n packets without allocating them, e.g. the
+// capabilities of a request the server ignores. After Discard, Packet
+// returns nil unless the last packet skipped is a special packet or was
+// peeked. It returns the number of packets skipped and, if it is less than
+// n, the error ending the scan, io.EOF at the end of the input. The skipped
+// data packets are counted by the Metrics but not given to the Trace.
+func (s *PacketScanner) Discard(n int) (int, error) {
+	for i := 0; i < n; i++ {
+		if !s.skip() {
+			if s.err != nil {
+				return i, s.err
+			}
+			return i, io.EOF
+		}
+	}
+	return n, nil
+}
+
+// SkipUntilFlush skips the packets up to the next flush packet, included,
+// without allocating them. Packet then returns the flush packet. It returns
+// io.ErrUnexpectedEOF if the input ends before a flush packet.
+func (s *PacketScanner) SkipUntilFlush() error {
+	for s.skip() {
+		if _, ok := s.curr.(FlushPacket); ok {
+			return nil
+		}
+	}
+	if s.err != nil {
+		return s.err
+	}
+	return io.ErrUnexpectedEOF
+}
+
+// skip discards the next packet if it is a data packet, and scans it
+// otherwise.
+func (s *PacketScanner) skip() bool {
+	if s.err != nil || s.packFileMode || s.peek.peeked || (s.ctx != nil && s.ctx.Err() != nil) {
+		return s.Scan()
+	}
+	hdr, err := s.rd.Peek(4)
+	if err != nil {
+		return s.Scan()
+	}
+	sz, ok := ParseLength(hdr)
+	if !ok || sz <= 4 || sz > s.maxSize {
+		return s.Scan()
+	}
+	// The error packets end the scan.
+	if b, _ := s.rd.Peek(min(sz, 8)); bytes.HasPrefix(b[4:], []byte("ERR ")) {
+		return s.Scan()
+	}
+	copy(s.hdr[:], hdr)
+	if _, err := s.rd.Discard(sz); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		s.err = err
+		s.done = true
+		s.observeEnd()
+		return false
+	}
+	s.advance(sz)
+	s.curr = nil
+	s.payload = nil
+	s.metrics.PacketRead(DataPacketKind, sz)
+	return true
+}
@@ -0,0 +1,154 @@
+// Modified by Giacomo Tartari
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkt
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestPacketScanner_Discard(t *testing.T) {
+	in := pktLines("a\n") + "0001" + pktLines("b\n") + "0002" + pktLines("c\n")
+	tests := []struct {
+		name string
+		n    int
+		// got and packet are the packets skipped and the current packet,
+		// and next the packet scanned afterwards.
+		got    int
+		packet string
+		next   string
+		err    error
+	}{
+		{name: "data", n: 1, got: 1, packet: "<nil>", next: "0001"},
+		{name: "delim", n: 2, got: 2, packet: "0001", next: "b\n"},
+		{name: "response-end", n: 4, got: 4, packet: "0002", next: "c\n"},
+		{name: "all", n: 5, got: 5, packet: "<nil>"},
+		{name: "EOF", n: 6, got: 5, err: io.EOF},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewPacketScanner(strings.NewReader(in))
+			got, err := s.Discard(tt.n)
+			if got != tt.got || err != tt.err {
+				t.Fatalf("Discard(%d) = %d, %v, want %d, %v", tt.n, got, err, tt.got, tt.err)
+			}
+			if err != nil {
+				return
+			}
+			if p := packetString(s.Packet()); p != tt.packet {
+				t.Errorf("got packet %q, want %q", p, tt.packet)
+			}
+			if tt.next == "" {
+				if s.Scan() {
+					t.Errorf("got packet %q after the end", packetString(s.Packet()))
+				}
+				return
+			}
+			if !s.Scan() {
+				t.Fatal(s.Err())
+			}
+			if p := packetString(s.Packet()); p != tt.next {
+				t.Errorf("got next packet %q, want %q", p, tt.next)
+			}
+		})
+	}
+}
+
+func TestPacketScanner_SkipUntilFlush(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		next string
+		err  error
+	}{
+		{
+			name: "across delim and response-end",
+			in:   pktLines("a\n") + "0001" + pktLines("b\n") + "0002" + "0000" + pktLines("c\n"),
+			next: "c\n",
+		},
+		{
+			name: "at the flush",
+			in:   "0000" + pktLines("c\n"),
+			next: "c\n",
+		},
+		{
+			name: "EOF before the flush",
+			in:   pktLines("a\n") + "0001",
+			err:  io.ErrUnexpectedEOF,
+		},
+		{
+			name: "truncated packet",
+			in:   pktLines("a\n") + "000ab",
+			err:  io.ErrUnexpectedEOF,
+		},
+		{
+			name: "error packet",
+			in:   pktLines("a\n", "ERR denied", "0000"),
+			err:  ErrorPacket("denied"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewPacketScanner(strings.NewReader(tt.in))
+			if err := s.SkipUntilFlush(); !errors.Is(err, tt.err) {
+				t.Fatalf("SkipUntilFlush() = %v, want %v", err, tt.err)
+			}
+			if tt.err != nil {
+				return
+			}
+			if _, ok := s.Packet().(FlushPacket); !ok {
+				t.Errorf("got packet %q, want a flush", packetString(s.Packet()))
+			}
+			if !s.Scan() || packetString(s.Packet()) != tt.next {
+				t.Errorf("got next packet %q, %v, want %q", packetString(s.Packet()), s.Err(), tt.next)
+			}
+		})
+	}
+}
+
+func TestPacketScanner_skipPeeked(t *testing.T) {
+	in := pktLines("a\n", "b\n", "c\n", "0000", "d\n")
+	t.Run("SkipUntilFlush", func(t *testing.T) {
+		s := NewPacketScanner(strings.NewReader(in))
+		s.Scan()
+		if p, err := s.Peek(); err != nil || packetString(p) != "b\n" {
+			t.Fatalf("Peek() = %q, %v", packetString(p), err)
+		}
+		if err := s.SkipUntilFlush(); err != nil {
+			t.Fatal(err)
+		}
+		if !s.Scan() || packetString(s.Packet()) != "d\n" {
+			t.Errorf("got next packet %q, %v, want %q", packetString(s.Packet()), s.Err(), "d\n")
+		}
+	})
+	t.Run("Discard", func(t *testing.T) {
+		s := NewPacketScanner(strings.NewReader(in))
+		s.Scan()
+		s.Peek()
+		// The peeked packet is the first one skipped.
+		if n, err := s.Discard(1); n != 1 || err != nil {
+			t.Fatalf("Discard(1) = %d, %v", n, err)
+		}
+		if packetString(s.Packet()) != "b\n" {
+			t.Errorf("got packet %q, want %q", packetString(s.Packet()), "b\n")
+		}
+		if !s.Scan() || packetString(s.Packet()) != "c\n" {
+			t.Errorf("got next packet %q, %v, want %q", packetString(s.Packet()), s.Err(), "c\n")
+		}
+	})
+}
@@ -0,0 +1,124 @@
+// Modified by Giacomo Tartari
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ssh
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/cycloidio/pkt-line/server"
+)
+
+// ParseCommand parses the remote command of an SSH session running a
+// service, e.g. "git-upload-pack '/srv/repo.git'" as written by Command,
+// and returns the service and the path. The path may be unquoted, and the
+// service written "git upload-pack", as older clients do.
+func ParseCommand(cmd string) (service, path string, err error) {
+	service, arg, ok := strings.Cut(cmd, " ")
+	if service == "git" {
+		service, arg, ok = strings.Cut(arg, " ")
+		service = "git-" + service
+	}
+	if !ok || !strings.HasPrefix(service, "git-") || arg == "" {
+		return "", "", fmt.Errorf("unexpected command %q", cmd)
+	}
+	if !strings.HasPrefix(arg, "'") {
+		return service, arg, nil
+	}
+	path, ok = dequote(arg)
+	if !ok {
+		return "", "", fmt.Errorf("badly quoted path in command %q", cmd)
+	}
+	return service, path, nil
+}
+
+// dequote undoes the quoting of Command: a path between single quotes,
+// where a quote or an exclamation mark closes the quotes, is escaped with a
+// backslash, and opens them again.
+func dequote(s string) (string, bool) {
+	var b strings.Builder
+	for {
+		if !strings.HasPrefix(s, "'") {
+			return "", false
+		}
+		end := strings.IndexByte(s[1:], '\'')
+		if end < 0 {
+			return "", false
+		}
+		b.WriteString(s[1 : end+1])
+		s = s[end+2:]
+		if s == "" {
+			return b.String(), true
+		}
+		if len(s) < 3 || s[0] != '\\' || s[1] != '\'' && s[1] != '!' {
+			return "", false
+		}
+		b.WriteByte(s[1])
+		s = s[2:]
+	}
+}
+
+// NewServerSession returns the server session of the service requested by
+// the remote command cmd of an SSH session, reading the requests from rd and
+// writing the responses to w, e.g. the channel of golang.org/x/crypto/ssh.
+// The protocol is read from the GIT_PROTOCOL variable of env, as set by the
+// client. The principal authenticated by the SSH server, e.g. with
+// PublicKeyPrincipal, is attached to the session if not nil. The path of
+// the repository is returned too.
+func NewServerSession(ctx context.Context, cmd string, env []string, p *server.Principal, rd io.Reader, w io.Writer) (*server.Session, string, error) {
+	service, path, err := ParseCommand(cmd)
+	if err != nil {
+		return nil, "", err
+	}
+	if p != nil {
+		ctx = server.WithPrincipal(ctx, p)
+	}
+	s := server.NewSession(ctx, service, rd, w)
+	for _, kv := range env {
+		if v, ok := strings.CutPrefix(kv, "GIT_PROTOCOL="); ok {
+			s.Protocol = protocolOf(v)
+		}
+	}
+	return s, path, nil
+}
+
+// protocolOf returns the highest version of the colon separated parameters
+// of GIT_PROTOCOL, e.g. 2 for "version=2", or 0.
+func protocolOf(params string) int {
+	v := 0
+	for _, p := range strings.Split(params, ":") {
+		if s, ok := strings.CutPrefix(p, "version="); ok {
+			if n, _ := strconv.Atoi(s); n > v {
+				v = n
+			}
+		}
+	}
+	return v
+}
+
+// PublicKeyPrincipal returns the principal of user authenticated by the SSH
+// server with the public key of the fingerprint, e.g. the "SHA256:..." of
+// ssh.FingerprintSHA256, kept in the "key" attribute.
+func PublicKeyPrincipal(user, fingerprint string) *server.Principal {
+	return &server.Principal{
+		Name:       user,
+		Method:     server.AuthSSH,
+		Attributes: map[string]string{"key": fingerprint},
+	}
+}
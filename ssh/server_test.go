@@ -0,0 +1,121 @@
+// Modified by Giacomo Tartari
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ssh
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/cycloidio/pkt-line"
+	"github.com/cycloidio/pkt-line/server"
+)
+
+func TestParseCommand(t *testing.T) {
+	tests := []struct {
+		cmd     string
+		service string
+		path    string
+		err     bool
+	}{
+		{cmd: "git-upload-pack '/srv/repo.git'", service: "git-upload-pack", path: "/srv/repo.git"},
+		{cmd: "git-receive-pack 'repo.git'", service: "git-receive-pack", path: "repo.git"},
+		{cmd: "git upload-pack '/srv/repo.git'", service: "git-upload-pack", path: "/srv/repo.git"},
+		{cmd: "git-upload-pack /srv/repo.git", service: "git-upload-pack", path: "/srv/repo.git"},
+		{cmd: Command("git-upload-pack", "it's here!"), service: "git-upload-pack", path: "it's here!"},
+		{cmd: Command("git-upload-pack", "a b"), service: "git-upload-pack", path: "a b"},
+		{cmd: "git-upload-pack", err: true},
+		{cmd: "rm -rf /", err: true},
+		{cmd: "git-upload-pack '/srv/repo.git", err: true},
+		{cmd: "git-upload-pack '/srv/'x'repo.git'", err: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.cmd, func(t *testing.T) {
+			service, path, err := ParseCommand(tt.cmd)
+			if tt.err {
+				if err == nil {
+					t.Fatalf("got %q %q, want an error", service, path)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if service != tt.service || path != tt.path {
+				t.Errorf("got %q %q, want %q %q", service, path, tt.service, tt.path)
+			}
+		})
+	}
+}
+
+// principalRepo hides the refs under refs/private/ from the principals
+// other than alice.
+type principalRepo pkt.Refs
+
+func (r principalRepo) Refs(ctx context.Context) (pkt.Refs, error) {
+	if p, ok := server.PrincipalFromContext(ctx); ok && p.Name == "alice" {
+		return pkt.Refs(r), nil
+	}
+	return pkt.Refs(r).WithPrefix("refs/heads/"), nil
+}
+
+func (r principalRepo) HasObject(ctx context.Context, oid pkt.ObjectID) (bool, error) {
+	return false, nil
+}
+
+func TestNewServerSession(t *testing.T) {
+	oid := pkt.ObjectID(strings.Repeat("a", 40))
+	up := &server.UploadPack{Repo: principalRepo{
+		{Name: "refs/heads/main", ObjectID: oid},
+		{Name: "refs/private/alice", ObjectID: oid},
+	}}
+	tests := []struct {
+		name      string
+		principal *server.Principal
+		env       []string
+		protocol  int
+		private   bool
+	}{
+		{name: "anonymous"},
+		{name: "bob", principal: PublicKeyPrincipal("bob", "SHA256:bob"), env: []string{"GIT_PROTOCOL=version=1"}, protocol: 1},
+		{name: "alice", principal: PublicKeyPrincipal("alice", "SHA256:alice"), env: []string{"LANG=C", "GIT_PROTOCOL=version=2:version=1"}, protocol: 2, private: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			s, path, err := NewServerSession(context.Background(), Command("git-upload-pack", "/srv/repo.git"), tt.env, tt.principal, strings.NewReader("0000"), &out)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if s.Service != "git-upload-pack" || path != "/srv/repo.git" {
+				t.Errorf("got %q %q", s.Service, path)
+			}
+			if s.Protocol != tt.protocol {
+				t.Errorf("got protocol %d, want %d", s.Protocol, tt.protocol)
+			}
+			if p := s.Principal(); p != tt.principal {
+				t.Errorf("got principal %v, want %v", p, tt.principal)
+			}
+			if err := up.ServeSession(s); err != nil {
+				t.Fatal(err)
+			}
+			if got := strings.Contains(out.String(), "refs/private/alice"); got != tt.private {
+				t.Errorf("private ref advertised: got %v, want %v", got, tt.private)
+			}
+		})
+	}
+}
@@ -0,0 +1,191 @@
+// Modified by Giacomo Tartari
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package ssh runs the Git services over SSH: the remote command
+// "git-upload-pack '<path>'" of an SSH session, whose standard input and
+// output carry the packets. It works with the sessions of
+// golang.org/x/crypto/ssh, through the Session interface, and with the ssh
+// command, as git does.
+package ssh
+
+import (
+	"context"
+	"io"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/cycloidio/pkt-line"
+)
+
+const (
+	// UploadPack is the service of fetches.
+	UploadPack = "git-upload-pack"
+	// ReceivePack is the service of pushes.
+	ReceivePack = "git-receive-pack"
+)
+
+// Command returns the remote command running service on the repository at
+// path, quoted as git does, e.g. "git-upload-pack '/srv/repo.git'".
+func Command(service, path string) string {
+	var b strings.Builder
+	b.WriteString(service)
+	b.WriteString(" '")
+	for _, r := range path {
+		switch r {
+		case '\'', '!':
+			b.WriteString("'\\")
+			b.WriteRune(r)
+			b.WriteString("'")
+		default:
+			b.WriteRune(r)
+		}
+	}
+	b.WriteString("'")
+	return b.String()
+}
+
+// ProtocolEnv returns the value of the GIT_PROTOCOL environment variable
+// requesting protocol, e.g. "version=2", or "" for protocol 0.
+func ProtocolEnv(protocol int) string {
+	if protocol <= 0 {
+		return ""
+	}
+	return "version=" + strconv.Itoa(protocol)
+}
+
+// Session is the subset of the methods of *ssh.Session of
+// golang.org/x/crypto/ssh used to run a service, so that this package does
+// not depend on it.
+type Session interface {
+	Setenv(name, value string) error
+	StdinPipe() (io.WriteCloser, error)
+	StdoutPipe() (io.Reader, error)
+	Start(cmd string) error
+	Wait() error
+	Close() error
+}
+
+// Conn is a service running over SSH. The packets of the server are read
+// with Scanner, or with a parser reading Stdout, but not both, and the
+// packets of the client are written with Writer.
+type Conn struct {
+	Scanner *pkt.PacketScanner
+	Writer  *pkt.PacketWriter
+
+	stdin  io.WriteCloser
+	stdout io.Reader
+	wait   func() error
+	close  func() error
+}
+
+// Start runs service on the repository at path in the session s. A protocol
+// of 1 or 2 is requested with the GIT_PROTOCOL environment variable; as with
+// git, a server not accepting the variable answers with protocol 0. The
+// options configure Scanner and Writer.
+func Start(s Session, service, path string, protocol int, opts ...pkt.Option) (*Conn, error) {
+	if env := ProtocolEnv(protocol); env != "" {
+		// sshd refuses the variables missing from AcceptEnv.
+		_ = s.Setenv("GIT_PROTOCOL", env)
+	}
+	stdin, err := s.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := s.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := s.Start(Command(service, path)); err != nil {
+		return nil, err
+	}
+	return newConn(stdin, stdout, s.Wait, s.Close, opts), nil
+}
+
+// Cmd returns the ssh command running service on the repository at path of
+// dest, e.g. "git@example.com", as git does. A protocol of 1 or 2 is sent
+// with SendEnv. Options of ssh, e.g. "-p", can be inserted in Args before it
+// is started with StartCmd.
+func Cmd(ctx context.Context, dest, service, path string, protocol int) *exec.Cmd {
+	args := []string{"-o", "SendEnv=GIT_PROTOCOL", dest, Command(service, path)}
+	cmd := exec.CommandContext(ctx, "ssh", args...)
+	if env := ProtocolEnv(protocol); env != "" {
+		cmd.Env = append(cmd.Environ(), "GIT_PROTOCOL="+env)
+	}
+	return cmd
+}
+
+// StartCmd starts cmd, e.g. returned by Cmd, and returns the Conn on its
+// standard input and output. The options configure Scanner and Writer.
+func StartCmd(cmd *exec.Cmd, opts ...pkt.Option) (*Conn, error) {
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	kill := func() error {
+		if cmd.Process == nil {
+			return nil
+		}
+		return cmd.Process.Kill()
+	}
+	return newConn(stdin, stdout, cmd.Wait, kill, opts), nil
+}
+
+func newConn(stdin io.WriteCloser, stdout io.Reader, wait, close func() error, opts []pkt.Option) *Conn {
+	return &Conn{
+		Scanner: pkt.NewPacketScanner(stdout, opts...),
+		Writer:  pkt.NewPacketWriter(stdin, opts...),
+		stdin:   stdin,
+		stdout:  stdout,
+		wait:    wait,
+		close:   close,
+	}
+}
+
+// Stdout returns the standard output of the service, for the parsers of
+// the pkt package.
+func (c *Conn) Stdout() io.Reader {
+	return c.stdout
+}
+
+// Stdin returns the standard input of the service.
+func (c *Conn) Stdin() io.Writer {
+	return c.stdin
+}
+
+// CloseWrite closes the standard input of the service, which ends the
+// session of a server waiting for more requests.
+func (c *Conn) CloseWrite() error {
+	return c.stdin.Close()
+}
+
+// Wait closes the standard input and waits for the service to exit.
+func (c *Conn) Wait() error {
+	c.stdin.Close()
+	return c.wait()
+}
+
+// Close stops the service.
+func (c *Conn) Close() error {
+	c.stdin.Close()
+	return c.close()
+}
@@ -0,0 +1,130 @@
+// Modified by Giacomo Tartari
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkt
+
+import (
+	"fmt"
+	"strings"
+)
+
+// WithStrictMode makes the parsers validate the fields they would otherwise
+// pass through as is, e.g. to fuzz them or to front an untrusted peer. The
+// object IDs must be lowercase hex of the length of the object format, or of
+// SHA-1 or SHA-256 without WithObjectFormat; the ref names must obey
+// git-check-ref-format; and the statuses must be one of the values allowed by
+// the protocol, e.g. "continue", "common" or "ready" for an ACK. A field
+// failing these checks, including one with trailing garbage, is reported as a
+// FieldError wrapped in a ParseError. It disables WithLazyFields, since the
+// fields must be parsed to be checked.
+func WithStrictMode() Option {
+	return func(c *Config) {
+		c.StrictMode = true
+	}
+}
+
+// FieldError is returned by the parsers in strict mode for a field that does
+// not conform to the protocol. It wraps a SyntaxError, so it is also reported
+// as a ParseError.
+type FieldError struct {
+	// Field is the name of the field, e.g. "ref name".
+	Field string
+	// Value is the offending value.
+	Value string
+	Err   error
+}
+
+func (e *FieldError) Error() string {
+	return fmt.Sprintf("invalid %s %q: %v", e.Field, e.Value, e.Err)
+}
+
+// Unwrap returns the SyntaxError.
+func (e *FieldError) Unwrap() error {
+	return e.Err
+}
+
+// ValidateRefName returns a SyntaxError if name is not a valid ref name
+// according to git-check-ref-format. The only one-level name allowed is HEAD.
+func ValidateRefName(name string) error {
+	if name == "HEAD" {
+		return nil
+	}
+	switch {
+	case name == "":
+		return SyntaxError("empty ref name")
+	case name == "@":
+		return SyntaxError("ref name is @")
+	case !strings.Contains(name, "/"):
+		return SyntaxError("one-level ref name")
+	case strings.HasSuffix(name, "."):
+		return SyntaxError("ref name ends with .")
+	case strings.Contains(name, ".."):
+		return SyntaxError("ref name contains ..")
+	case strings.Contains(name, "@{"):
+		return SyntaxError("ref name contains @{")
+	}
+	for _, c := range []byte(name) {
+		if c < ' ' || c == 0x7f || strings.IndexByte(" ~^:?*[\\", c) >= 0 {
+			return SyntaxError(fmt.Sprintf("ref name contains %q", c))
+		}
+	}
+	for _, comp := range strings.Split(name, "/") {
+		switch {
+		case comp == "":
+			return SyntaxError("ref name has an empty component")
+		case strings.HasPrefix(comp, "."):
+			return SyntaxError("ref name component starts with .")
+		case strings.HasSuffix(comp, ".lock"):
+			return SyntaxError("ref name component ends with .lock")
+		}
+	}
+	return nil
+}
+
+// CheckRefName validates name with ValidateRefName in strict mode, and
+// returns nil otherwise. It is exported for the protocol subpackages.
+func (c *Config) CheckRefName(name string) error {
+	if !c.StrictMode {
+		return nil
+	}
+	if err := ValidateRefName(name); err != nil {
+		return &FieldError{Field: "ref name", Value: name, Err: err}
+	}
+	return nil
+}
+
+// CheckStatus validates in strict mode that the field named field is one of
+// allowed, and returns nil otherwise. It is exported for the protocol
+// subpackages.
+func (c *Config) CheckStatus(field, value string, allowed ...string) error {
+	if !c.StrictMode {
+		return nil
+	}
+	for _, a := range allowed {
+		if value == a {
+			return nil
+		}
+	}
+	return &FieldError{
+		Field: field,
+		Value: value,
+		Err:   SyntaxError("expected one of " + strings.Join(allowed, ", ")),
+	}
+}
+
+// lazy reports whether the parsers defer the parsing of chunk fields.
+func (c *Config) lazy() bool {
+	return c.LazyFields && !c.StrictMode
+}
@@ -0,0 +1,125 @@
+// Modified by Giacomo Tartari
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkt
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestValidateRefName(t *testing.T) {
+	for _, name := range []string{"HEAD", "refs/heads/main", "refs/tags/v1.0", "refs/heads/a-b_c", "refs/heads/x/y.z", "refs/heads/@x"} {
+		if err := ValidateRefName(name); err != nil {
+			t.Errorf("ValidateRefName(%q) = %v", name, err)
+		}
+	}
+	for _, name := range []string{
+		"", "@", "main", "refs/heads/", "refs//main", "refs/heads/main.", "refs/heads/a..b",
+		"refs/heads/a@{1}", "refs/heads/.hidden", "refs/heads/x.lock", "refs/heads/a b",
+		"refs/heads/a~1", "refs/heads/a^", "refs/heads/a:b", "refs/heads/a?", "refs/heads/a*",
+		"refs/heads/a[", "refs/heads/a\\b", "refs/heads/a\x7f", "refs/heads/a\tb",
+	} {
+		var se SyntaxError
+		if err := ValidateRefName(name); !errors.As(err, &se) {
+			t.Errorf("ValidateRefName(%q) = %v, want a SyntaxError", name, err)
+		}
+	}
+}
+
+// scanAll scans the chunks of a parser and returns its error.
+func scanAll(p interface {
+	Scan() bool
+	Err() error
+}) error {
+	for p.Scan() {
+	}
+	return p.Err()
+}
+
+func TestStrictMode(t *testing.T) {
+	zero := strings.Repeat("0", 40)
+	tests := []struct {
+		name  string
+		parse func(r io.Reader, opts ...Option) error
+		in    string
+		field string
+	}{
+		{
+			name:  "advertised ref name",
+			parse: func(r io.Reader, opts ...Option) error { return scanAll(NewInfoRefsResponse(r, opts...)) },
+			in:    pktLines(oid+" refs/heads/a..b\x00agent=x\n", "0000"),
+			field: "ref name",
+		},
+		{
+			name:  "advertised object ID",
+			parse: func(r io.Reader, opts ...Option) error { return scanAll(NewInfoRefsResponse(r, opts...)) },
+			in:    pktLines(strings.ToUpper(oid)+" refs/heads/main\x00agent=x\n", "0000"),
+			field: "object ID",
+		},
+		{
+			name:  "want",
+			parse: func(r io.Reader, opts ...Option) error { return scanAll(NewUploadRequest(r, opts...)) },
+			in:    pktLines("want "+oid[:39]+"\n", "0000", "done\n"),
+			field: "object ID",
+		},
+		{
+			name:  "ACK status",
+			parse: func(r io.Reader, opts ...Option) error { return scanAll(NewUploadResponse(r, opts...)) },
+			in:    pktLines("ACK "+oid+" maybe\n") + "PACK",
+			field: "ACK status",
+		},
+		{
+			name:  "command ref name",
+			parse: func(r io.Reader, opts ...Option) error { return scanAll(NewReceiveRequest(r, opts...)) },
+			in:    pktLines(zero+" "+oid+" refs/heads/x.lock\x00report-status\n", "0000"),
+			field: "ref name",
+		},
+		{
+			name:  "status ref name",
+			parse: func(r io.Reader, opts ...Option) error { return scanAll(NewReceiveResponse(r, opts...)) },
+			in:    pktLines("unpack ok\n", "ok refs/heads/a b\n", "0000"),
+			field: "ref name",
+		},
+		{
+			name:  "ref option",
+			parse: func(r io.Reader, opts ...Option) error { return scanAll(NewReceiveResponse(r, opts...)) },
+			in:    pktLines("unpack ok\n", "ok refs/heads/main\n", "option color blue\n", "0000"),
+			field: "ref option",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.parse(strings.NewReader(tt.in)); err != nil {
+				t.Fatalf("without strict mode: %v", err)
+			}
+			// The lazy fields are parsed in strict mode.
+			for _, opts := range [][]Option{{WithStrictMode()}, {WithStrictMode(), WithLazyFields()}} {
+				err := tt.parse(strings.NewReader(tt.in), opts...)
+				var fe *FieldError
+				if !errors.As(err, &fe) || fe.Field != tt.field {
+					t.Fatalf("got error %v, want a FieldError for the %s", err, tt.field)
+				}
+				var pe *ParseError
+				var se SyntaxError
+				if !errors.As(err, &pe) || !errors.As(err, &se) {
+					t.Errorf("got error %v, want a ParseError wrapping a SyntaxError", err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,172 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package end2end
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/cycloidio/pkt-line"
+	"github.com/cycloidio/pkt-line/server"
+)
+
+// serverRepository serves a local repository to server.UploadPack.
+type serverRepository struct {
+	gitRepo
+}
+
+func (r serverRepository) Refs(ctx context.Context) (pkt.Refs, error) {
+	out, err := r.run("for-each-ref", "--format=%(objectname) %(refname) %(*objectname)")
+	if err != nil {
+		return nil, err
+	}
+	var refs pkt.Refs
+	for _, line := range strings.Split(strings.TrimSpace(out), "\n") {
+		ss := strings.Split(line, " ")
+		refs = append(refs, pkt.Ref{Name: ss[1], ObjectID: pkt.ObjectID(ss[0]), Peeled: pkt.ObjectID(ss[2])})
+	}
+	return refs, nil
+}
+
+func (r serverRepository) HasObject(ctx context.Context, oid pkt.ObjectID) (bool, error) {
+	_, err := r.run("cat-file", "-e", string(oid))
+	return err == nil, nil
+}
+
+// tagFollowingPacker follows the tags with a callback instead of letting git
+// pack-objects do it.
+type tagFollowingPacker struct {
+	*server.GitPackGenerator
+	tags []pkt.ObjectID
+}
+
+func (p *tagFollowingPacker) FollowTags(ctx context.Context, req *server.PackRequest) ([]pkt.ObjectID, error) {
+	return p.tags, nil
+}
+
+// GeneratePack only packs the tags given by FollowTags.
+func (p *tagFollowingPacker) GeneratePack(ctx context.Context, req *server.PackRequest, w io.Writer) error {
+	r := *req
+	r.IncludeTag = false
+	return p.GitPackGenerator.GeneratePack(ctx, &r, w)
+}
+
+// fetchPack runs a fetch of want against the upload-pack server u and
+// returns the received pack.
+func fetchPack(t *testing.T, u *server.UploadPack, want string, caps ...string) []byte {
+	var in bytes.Buffer
+	in.Write(pkt.NewWantChunk(want, append(caps, "side-band-64k")...).EncodeToPktLine())
+	in.Write(pkt.NewEndOfRoundChunk().EncodeToPktLine())
+	in.Write(pkt.NewDoneChunk().EncodeToPktLine())
+	var out bytes.Buffer
+	if err := u.ServeSession(server.NewSession(context.Background(), "git-upload-pack", &in, &out)); err != nil {
+		t.Fatal(err)
+	}
+
+	// Skip the ref advertisement.
+	adv := pkt.NewPacketScanner(bytes.NewReader(out.Bytes()))
+	n := 0
+	for adv.Scan() {
+		n += len(adv.Packet().EncodeToPktLine())
+		if _, ok := adv.Packet().(pkt.FlushPacket); ok {
+			break
+		}
+	}
+
+	var pack []byte
+	resp := pkt.NewUploadResponse(bytes.NewReader(out.Bytes()[n:]))
+	for resp.Scan() {
+		if c := resp.Chunk(); len(c.PackStream) > 0 {
+			if p, ok := pkt.ParseSideBandPacket(c.PackStream).(pkt.SideBandMainPacket); ok {
+				pack = append(pack, p...)
+			}
+		}
+	}
+	if err := resp.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return pack
+}
+
+// packObjectType stores pack in a new repository and returns the type of oid in
+// it, or "" if it is missing.
+func packObjectType(t *testing.T, pack []byte, oid string) string {
+	r := createLocalGitRepo()
+	defer r.close()
+	cmd := exec.Command(gitBinary, "index-pack", "--stdin")
+	cmd.Dir = string(r)
+	cmd.Stdin = bytes.NewReader(pack)
+	if bs, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("cannot index the pack: %v\n%s", err, bs)
+	}
+	typ, err := r.run("cat-file", "-t", oid)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(typ)
+}
+
+func setupTaggedRepo(t *testing.T) (r gitRepo, commit, tag string) {
+	r = createLocalGitRepo()
+	if _, err := r.run("commit", "--allow-empty", "--message=init"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.run("tag", "--annotate", "--message=v1", "v1"); err != nil {
+		t.Fatal(err)
+	}
+	commit, _ = r.run("rev-parse", "main")
+	tag, _ = r.run("rev-parse", "v1")
+	return r, strings.TrimSpace(commit), strings.TrimSpace(tag)
+}
+
+func TestUploadPack_includeTag(t *testing.T) {
+	r, commit, tag := setupTaggedRepo(t)
+	defer r.close()
+	u := &server.UploadPack{
+		Repo:   serverRepository{r},
+		Packer: &server.GitPackGenerator{Dir: filepath.Join(string(r), ".git")},
+	}
+
+	if got := packObjectType(t, fetchPack(t, u, commit, "include-tag"), tag); got != "tag" {
+		t.Errorf("with include-tag, the tag %s has type %q in the pack, want tag", tag, got)
+	}
+	if got := packObjectType(t, fetchPack(t, u, commit), tag); got != "" {
+		t.Errorf("without include-tag, the tag %s has type %q in the pack, want none", tag, got)
+	}
+}
+
+func TestUploadPack_includeTagFollower(t *testing.T) {
+	r, commit, tag := setupTaggedRepo(t)
+	defer r.close()
+	u := &server.UploadPack{
+		Repo: serverRepository{r},
+		Packer: &tagFollowingPacker{
+			GitPackGenerator: &server.GitPackGenerator{Dir: filepath.Join(string(r), ".git")},
+			tags:             []pkt.ObjectID{pkt.ObjectID(tag)},
+		},
+	}
+
+	if got := packObjectType(t, fetchPack(t, u, commit, "include-tag"), tag); got != "tag" {
+		t.Errorf("with include-tag, the tag %s has type %q in the pack, want tag", tag, got)
+	}
+	if got := packObjectType(t, fetchPack(t, u, commit), tag); got != "" {
+		t.Errorf("without include-tag, the tag %s has type %q in the pack, want none", tag, got)
+	}
+}
@@ -385,7 +385,11 @@ func httpURLForReceivePack(base string) (string, error) {
 }
 
 func writePacket(w io.Writer, p pkt.Packet) error {
-	_, err := w.Write(p.EncodeToPktLine())
+	bs, err := pkt.Encode(p)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(bs)
 	return err
 }
 
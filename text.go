@@ -0,0 +1,50 @@
+// Modified by Giacomo Tartari
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkt
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// TextPacket returns the packet of a text line, s followed by a LF, as most
+// of the protocol lines are. s must not contain LF or NUL, and must fit in a
+// packet, or a PacketTooLargeError is returned.
+func TextPacket(s string) (BytesPacket, error) {
+	if strings.ContainsAny(s, "\n\x00") {
+		return nil, &InvalidChunkError{Chunk: "TextPacket", Reason: fmt.Sprintf("text contains LF or NUL: %q", s)}
+	}
+	if len(s)+1 > maxPayloadSize {
+		return nil, &PacketTooLargeError{Length: len(s) + 5, Max: maxPacketSize}
+	}
+	return BytesPacket(s + "\n"), nil
+}
+
+// WriteLine writes s as a text line, see TextPacket.
+func (w *PacketWriter) WriteLine(s string) error {
+	p, err := TextPacket(s)
+	if err != nil {
+		return err
+	}
+	return w.write(p)
+}
+
+// TrimmedText returns the payload of a text line without its trailing LF,
+// which the protocol makes optional for the receiver.
+func (b BytesPacket) TrimmedText() string {
+	return string(bytes.TrimSuffix(b, []byte("\n")))
+}
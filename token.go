@@ -18,9 +18,10 @@ package pkt
 import (
 	"bufio"
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"io"
-	"strconv"
 )
 
 // SyntaxError is an error returned when the parser cannot parse the input.
@@ -41,6 +42,11 @@ func (FlushPacket) EncodeToPktLine() []byte {
 	return []byte("0000")
 }
 
+// AppendPktLine appends the serialized packet to dst.
+func (FlushPacket) AppendPktLine(dst []byte) []byte {
+	return append(dst, "0000"...)
+}
+
 // DelimPacket is the delim packet ("0001").
 type DelimPacket struct{}
 
@@ -49,43 +55,75 @@ func (DelimPacket) EncodeToPktLine() []byte {
 	return []byte("0001")
 }
 
+// AppendPktLine appends the serialized packet to dst.
+func (DelimPacket) AppendPktLine(dst []byte) []byte {
+	return append(dst, "0001"...)
+}
+
+// ResponseEndPacket is the response-end packet ("0002"), which ends a
+// protocol v2 response over a stateless connection.
+type ResponseEndPacket struct{}
+
+// EncodeToPktLine serializes the packet.
+func (ResponseEndPacket) EncodeToPktLine() []byte {
+	return []byte("0002")
+}
+
+// AppendPktLine appends the serialized packet to dst.
+func (ResponseEndPacket) AppendPktLine(dst []byte) []byte {
+	return append(dst, "0002"...)
+}
+
 // BytesPacket is a packet with a content.
 type BytesPacket []byte
 
-// EncodeToPktLine serializes the packet.
+// EncodeToPktLine serializes the packet. It panics with a
+// PacketTooLargeError if the packet does not fit, see EncodeToPktLineErr.
 func (b BytesPacket) EncodeToPktLine() []byte {
-	sz := len(b)
-	if sz > 0xFFFF-4 {
-		panic("content too large")
-	}
-	return append([]byte(fmt.Sprintf("%04x", sz+4)), b...)
+	return b.AppendPktLine(make([]byte, 0, len(b)+4))
+}
+
+// AppendPktLine appends the serialized packet to dst.
+func (b BytesPacket) AppendPktLine(dst []byte) []byte {
+	return appendPayload(dst, 0, b)
 }
 
 // BytesPacket is a packet with a content.
 type StringPacket string
 
-// EncodeToPktLine serializes the packet.
+// EncodeToPktLine serializes the packet. It panics with a
+// PacketTooLargeError if the packet does not fit, see EncodeToPktLineErr.
 func (b StringPacket) EncodeToPktLine() []byte {
-	sz := len(b)
-	if sz > 0xFFFF-4 {
-		panic("content too large")
+	return b.AppendPktLine(make([]byte, 0, len(b)+4))
+}
+
+// AppendPktLine appends the serialized packet to dst.
+func (b StringPacket) AppendPktLine(dst []byte) []byte {
+	if err := checkSize(len(b) + 4); err != nil {
+		panic(err)
 	}
-	return append([]byte(fmt.Sprintf("%04x", sz+4)), b...)
+	return append(appendHeader(dst, len(b)+4), b...)
 }
 
-// ErrorPacket is a packet that indicates an error.
+// ErrorPacket is a packet that indicates an error. The PacketScanner
+// returns it as error, or as packet with WithErrorPackets.
 type ErrorPacket string
 
 func (e ErrorPacket) Error() string { return "error: " + string(e) }
 
-// EncodeToPktLine serializes the packet.
+// EncodeToPktLine serializes the packet. It panics with a
+// PacketTooLargeError if the packet does not fit, see EncodeToPktLineErr.
 func (e ErrorPacket) EncodeToPktLine() []byte {
-	bs := []byte("ERR " + e)
-	sz := len(bs)
-	if sz > 0xFFFF {
-		panic("content too large")
+	return e.AppendPktLine(make([]byte, 0, len(e)+8))
+}
+
+// AppendPktLine appends the serialized packet to dst.
+func (e ErrorPacket) AppendPktLine(dst []byte) []byte {
+	if err := checkSize(len(e) + 8); err != nil {
+		panic(err)
 	}
-	return append([]byte(fmt.Sprintf("%04X", sz+4)), bs...)
+	dst = append(appendHeader(dst, len(e)+8), "ERR "...)
+	return append(dst, e...)
 }
 
 // PackFileIndicatorPacket is the indicator of the beginning of the pack file
@@ -97,6 +135,11 @@ func (PackFileIndicatorPacket) EncodeToPktLine() []byte {
 	return []byte("PACK")
 }
 
+// AppendPktLine appends the serialized packet to dst.
+func (PackFileIndicatorPacket) AppendPktLine(dst []byte) []byte {
+	return append(dst, "PACK"...)
+}
+
 // PackFilePacket is a chunk of the pack file.
 type PackFilePacket []byte
 
@@ -105,20 +148,143 @@ func (p PackFilePacket) EncodeToPktLine() []byte {
 	return []byte(p)
 }
 
+// AppendPktLine appends the pack data to dst.
+func (p PackFilePacket) AppendPktLine(dst []byte) []byte {
+	return append(dst, p...)
+}
+
+// RawPacket is a packet in its original encoding, length header included,
+// as returned by PacketScanner.RawBytes. EncodeToPktLine returns it
+// unchanged, so that a proxy forwards the packets byte-identically even when
+// the peer encodes them differently, e.g. with an uppercase length.
+type RawPacket []byte
+
+// EncodeToPktLine serializes the packet.
+func (p RawPacket) EncodeToPktLine() []byte {
+	return []byte(p)
+}
+
+// AppendPktLine appends the packet to dst.
+func (p RawPacket) AppendPktLine(dst []byte) []byte {
+	return append(dst, p...)
+}
+
 // PacketScanner provides an interface for reading packet line data. The usage
 // is same as bufio.Scanner.
+//
+// The payload of the BytesPacket and PackFilePacket returned by Packet is
+// owned by the caller, unless the scanner was created with WithReuseBuffer,
+// in which case it points to an internal buffer that is overwritten by the
+// next call to Scan.
 type PacketScanner struct {
 	err          error
 	curr         Packet
 	packFileMode bool
-	scanner      *bufio.Scanner
+	rd           *bufio.Reader
+	buf          []byte
+	bufSize      int
+	maxSize      int
+	reuse        bool
+	errPackets   bool
+
+	// index is the number of packets returned, pos the number of bytes
+	// consumed, and offset the position of the current packet.
+	index  int
+	pos    int64
+	offset int64
+	done   bool
+
+	// hdr and payload are the raw bytes of the current packet.
+	hdr     [4]byte
+	payload []byte
+
+	ctx context.Context
+	// ctxr is the reader enforcing ctx, if any.
+	ctxr    *contextReader
+	trace   Trace
+	metrics Metrics
+	// packSize is the size of the pack file read without side-band, and
+	// ended is set once the end of the stream is reported to metrics.
+	packSize int64
+	ended    bool
+
+	peek peekState
+}
+
+// scannerBufferSize is the default size of the chunks the pack file is
+// returned in.
+const scannerBufferSize = 64 * 1024
+
+// scannerReadSize bounds the read buffer of the scanner. Larger reads, e.g.
+// of the pack file, bypass it.
+const scannerReadSize = 4 * 1024
+
+// maxPacketSize is the largest length a packet header can encode.
+const maxPacketSize = 0xFFFF
+
+// ErrPacketTooLarge is matched by the PacketTooLargeError returned by
+// PacketScanner, with errors.Is.
+var ErrPacketTooLarge = errors.New("packet too large")
+
+// PacketTooLargeError is returned by PacketScanner for a packet longer than
+// its maximum size, see WithMaxPacketSize, and by the encoders for a payload
+// that does not fit in a packet.
+type PacketTooLargeError struct {
+	// Length is the length of the packet, header included.
+	Length int
+	Max    int
+}
+
+func (e *PacketTooLargeError) Error() string {
+	return fmt.Sprintf("packet too large: %d bytes (max %d)", e.Length, e.Max)
 }
 
-// NewPacketScanner returns a new PacketScanner to read from r.
-func NewPacketScanner(r io.Reader) *PacketScanner {
-	s := &PacketScanner{scanner: bufio.NewScanner(r)}
-	s.scanner.Split(s.packetSplitFunc)
-	return s
+// Is reports whether target is ErrPacketTooLarge.
+func (e *PacketTooLargeError) Is(target error) bool {
+	return target == ErrPacketTooLarge
+}
+
+// ErrNotPackFileMode is returned by the pack data methods of PacketScanner
+// before the pack file starts.
+var ErrNotPackFileMode = errors.New("the pack file has not started")
+
+// NewPacketScanner returns a new PacketScanner to read from r. The
+// options of the scanner are WithBufferSize, WithMaxPacketSize,
+// WithReuseBuffer, WithContext, WithTrace and WithMetrics.
+func NewPacketScanner(r io.Reader, opts ...Option) *PacketScanner {
+	cfg := NewConfig(opts...)
+	bufSize := cfg.BufferSize
+	if bufSize <= 0 {
+		bufSize = scannerBufferSize
+	}
+	maxSize := cfg.MaxPacketSize
+	if maxSize <= 0 || maxSize > maxPacketSize {
+		maxSize = maxPacketSize
+	}
+	var ctxr *contextReader
+	if cfg.Context != nil {
+		ctxr = newContextReader(cfg.Context, r)
+		r = ctxr
+	}
+	return &PacketScanner{
+		rd:         bufio.NewReaderSize(r, min(bufSize, scannerReadSize)),
+		bufSize:    bufSize,
+		maxSize:    maxSize,
+		reuse:      cfg.ReuseBuffer,
+		ctx:        cfg.Context,
+		ctxr:       ctxr,
+		errPackets: cfg.ErrorPackets,
+		trace:      cfg.Trace,
+		metrics:    cfg.Metrics,
+	}
+}
+
+// NewPacketScannerSize returns a new PacketScanner to read from r with a
+// buffer of bufSize bytes, rejecting the packets longer than maxSize with a
+// PacketTooLargeError. It is a shorthand for the WithBufferSize and
+// WithMaxPacketSize options.
+func NewPacketScannerSize(r io.Reader, bufSize, maxSize int) *PacketScanner {
+	return NewPacketScanner(r, WithBufferSize(bufSize), WithMaxPacketSize(maxSize))
 }
 
 // Err returns the first non-EOF error that was encountered by the
@@ -137,68 +303,277 @@ func (s *PacketScanner) Packet() Packet {
 // returns false, the Err method will return any error that occurred during
 // scanning, except that if it was io.EOF, Err will return nil.
 func (s *PacketScanner) Scan() bool {
+	ok, peeked := s.unpeek()
+	if !peeked {
+		ok = s.scan()
+	}
+	if !ok {
+		s.observeEnd()
+		return false
+	}
+	size := int(s.pos - s.offset)
+	s.metrics.PacketRead(KindOf(s.curr), size)
+	if s.packFileMode {
+		s.packSize += int64(size)
+	}
+	if s.trace != nil {
+		s.trace.OnPacketRead(s.curr, size)
+	}
+	return true
+}
+
+// observeEnd reports the error and the pack file size, if any, to the
+// metrics, once.
+func (s *PacketScanner) observeEnd() {
+	if s.ended {
+		return
+	}
+	s.ended = true
+	if s.err != nil {
+		s.metrics.ParseError(ErrorKind(s.err))
+	}
+	if s.packFileMode {
+		s.metrics.PackStream(s.packSize)
+	}
+}
+
+func (s *PacketScanner) scan() bool {
 	if s.err != nil {
 		return false
 	}
-	if !s.scanner.Scan() {
-		s.err = s.scanner.Err()
+	if s.ctx != nil && s.ctx.Err() != nil {
+		s.err = s.ctx.Err()
 		return false
 	}
-
-	bs := s.scanner.Bytes()
 	if s.packFileMode {
-		if len(bs) == 0 {
-			// EOF
-			return false
+		buf := s.buffer(s.bufSize)
+		n, err := s.rd.Read(buf)
+		if n > 0 {
+			s.advance(n)
+			if s.reuse {
+				s.curr = PackFilePacket(buf[:n])
+			} else {
+				s.curr = PackFilePacket(bytes.Clone(buf[:n]))
+			}
+			return true
+		}
+		if err != io.EOF {
+			s.err = err
 		}
-		s.curr = PackFilePacket(bs)
+		s.done = true
+		return false
+	}
+
+	hdr, err := s.rd.Peek(4)
+	if err != nil {
+		if err == io.EOF && len(hdr) != 0 {
+			err = io.ErrUnexpectedEOF
+		}
+		if err != io.EOF {
+			s.err = err
+		}
+		s.done = true
+		return false
+	}
+	copy(s.hdr[:], hdr)
+	s.payload = nil
+	if bytes.Equal(hdr, []byte("PACK")) {
+		s.rd.Discard(4)
+		s.advance(4)
+		s.packFileMode = true
+		s.curr = PackFileIndicatorPacket{}
 		return true
 	}
-	if bytes.Equal(bs, []byte("0000")) {
+	sz, ok := ParseLength(hdr)
+	if !ok {
+		s.err = s.headerError(hdr, SyntaxError(fmt.Sprintf("invalid packet length: %q", hdr)))
+		return false
+	}
+	switch sz {
+	case 0:
+		s.rd.Discard(4)
+		s.advance(4)
 		s.curr = FlushPacket{}
 		return true
-	}
-	if bytes.Equal(bs, []byte("0001")) {
+	case 1:
+		s.rd.Discard(4)
+		s.advance(4)
 		s.curr = DelimPacket{}
 		return true
-	}
-	if bytes.Equal(bs, []byte("PACK")) {
-		s.packFileMode = true
-		s.curr = PackFileIndicatorPacket{}
+	case 2:
+		s.rd.Discard(4)
+		s.advance(4)
+		s.curr = ResponseEndPacket{}
 		return true
+	case 3:
+		s.err = s.headerError(hdr, SyntaxError("unknown special packet: "+string(hdr)))
+		return false
+	}
+	if int(sz) > s.maxSize {
+		s.err = &PacketTooLargeError{Length: int(sz), Max: s.maxSize}
+		return false
+	}
+	s.rd.Discard(4)
+	var bs []byte
+	if s.reuse {
+		bs = s.buffer(int(sz) - 4)
+	} else {
+		bs = make([]byte, sz-4)
 	}
-	if len(bs) == 4 {
-		s.err = SyntaxError("unknown special packet: " + string(bs))
+	if _, err := io.ReadFull(s.rd, bs); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		s.err = err
+		s.done = true
 		return false
 	}
-	if bytes.Equal(bs[4:8], []byte("ERR ")) {
-		s.err = ErrorPacket(string(bs[8:]))
+	s.advance(int(sz))
+	s.payload = bs
+	if bytes.HasPrefix(bs, []byte("ERR ")) {
+		if s.errPackets {
+			s.curr = ErrorPacket(string(bs[4:]))
+			return true
+		}
+		s.err = ErrorPacket(string(bs[4:]))
 		return false
 	}
-	s.curr = BytesPacket(bs[4:])
+	s.curr = BytesPacket(bs)
 	return true
 }
 
-func (s *PacketScanner) packetSplitFunc(data []byte, atEOF bool) (int, []byte, error) {
-	if s.packFileMode {
-		return len(data), data, nil
+// buffer returns n bytes of the buffer the packets and the pack file are
+// read into, growing it as needed, so that a scanner only allocates the size
+// of the data it reads.
+func (s *PacketScanner) buffer(n int) []byte {
+	if cap(s.buf) < n {
+		s.buf = make([]byte, min(max(n, 2*cap(s.buf)), max(s.bufSize, s.maxSize-4)))
 	}
-	if len(data) < 4 {
-		return 0, nil, nil
+	return s.buf[:n]
+}
+
+// RawBytes returns the most recent packet generated by a call to Scan as
+// read, length header included. Unlike the EncodeToPktLine method of the
+// packet, it preserves the original encoding. The returned slice is owned by
+// the caller.
+func (s *PacketScanner) RawBytes() []byte {
+	return rawBytes(s.curr, s.hdr, s.payload)
+}
+
+// rawBytes returns the original encoding of the packet p read with the
+// header hdr and the payload payload.
+func rawBytes(p Packet, hdr [4]byte, payload []byte) []byte {
+	switch p := p.(type) {
+	case nil:
+		return nil
+	case PackFilePacket:
+		return bytes.Clone(p)
+	case PackFileIndicatorPacket:
+		return []byte("PACK")
+	}
+	return append(hdr[:len(hdr):len(hdr)], payload...)
+}
+
+// advance records that a packet of n bytes was read.
+func (s *PacketScanner) advance(n int) {
+	s.offset = s.pos
+	s.pos += int64(n)
+	s.index++
+}
+
+// headerError returns a ParseError for the invalid header of the next
+// packet.
+func (s *PacketScanner) headerError(hdr []byte, err error) error {
+	return &ParseError{
+		Index:  s.index,
+		Offset: s.pos,
+		Packet: bytes.Clone(hdr),
+		Err:    err,
 	}
-	if bytes.HasPrefix(data, []byte("PACK")) {
-		return 4, data[:4], nil
+}
+
+// NewParseError returns a ParseError locating err at the current packet, or
+// at the end of the stream if Scan returned false, with the parser state. It
+// returns err as is if it is not a SyntaxError or is already a ParseError.
+// It is exported for the protocol subpackages.
+func (s *PacketScanner) NewParseError(err error, state any) error {
+	var se SyntaxError
+	var pe *ParseError
+	if !errors.As(err, &se) || errors.As(err, &pe) {
+		return err
+	}
+	s.metrics.ParseError(ErrorKind(err))
+	e := &ParseError{
+		Index:  s.index,
+		Offset: s.pos,
+		State:  state,
+		Err:    err,
+	}
+	if !s.done && s.curr != nil {
+		e.Index, e.Offset = s.index-1, s.offset
+		e.Packet = s.curr.EncodeToPktLine()
 	}
-	sz, err := strconv.ParseUint(string(data[:4]), 16, 32)
+	return e
+}
+
+// ReadPackData reads the pack file into p, without going through the
+// internal buffer when it is empty and p is large. It must be called after
+// Scan returned a PackFileIndicatorPacket, in place of Scan. It returns io.EOF
+// at the end of the pack file.
+func (s *PacketScanner) ReadPackData(p []byte) (int, error) {
+	if !s.packFileMode {
+		return 0, ErrNotPackFileMode
+	}
+	if s.err != nil {
+		return 0, s.err
+	}
+	n, err := s.rd.Read(p)
+	s.packSize += int64(n)
 	if err != nil {
-		return 0, nil, err
+		if err != io.EOF {
+			s.err = err
+		}
+		s.observeEnd()
 	}
-	if sz == 0 || sz == 1 {
-		// Special packet.
-		return 4, data[:4], nil
+	return n, err
+}
+
+// PackReader returns the rest of the pack file as an io.Reader, reading with
+// ReadPackData and implementing io.WriterTo with WritePackTo. It must be
+// called after Scan returned a PackFileIndicatorPacket, in place of Scan.
+func (s *PacketScanner) PackReader() io.Reader {
+	return packReader{s}
+}
+
+type packReader struct {
+	s *PacketScanner
+}
+
+func (r packReader) Read(p []byte) (int, error) {
+	return r.s.ReadPackData(p)
+}
+
+func (r packReader) WriteTo(w io.Writer) (int64, error) {
+	return r.s.WritePackTo(w)
+}
+
+// WritePackTo writes the rest of the pack file to w. After the buffered data,
+// the copy is delegated to the underlying reader's WriteTo or w's ReadFrom,
+// which for network connections and files lets the kernel splice the data.
+// It must be called after Scan returned a PackFileIndicatorPacket, in place
+// of Scan.
+func (s *PacketScanner) WritePackTo(w io.Writer) (int64, error) {
+	if !s.packFileMode {
+		return 0, ErrNotPackFileMode
 	}
-	if len(data) < int(sz) {
-		return 0, nil, nil
+	if s.err != nil {
+		return 0, s.err
+	}
+	n, err := s.rd.WriteTo(w)
+	s.packSize += n
+	if err != nil {
+		s.err = err
 	}
-	return int(sz), data[:int(sz)], nil
+	s.observeEnd()
+	return n, err
 }
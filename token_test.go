@@ -0,0 +1,133 @@
+// Modified by Giacomo Tartari
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkt
+
+import (
+	"bytes"
+	"fmt"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParseLength(t *testing.T) {
+	for i := 0; i <= 0xFFFF; i++ {
+		for _, hdr := range []string{fmt.Sprintf("%04x", i), fmt.Sprintf("%04X", i)} {
+			if n, ok := ParseLength([]byte(hdr)); !ok || n != i {
+				t.Fatalf("ParseLength(%q) = %d, %v, want %d", hdr, n, ok, i)
+			}
+		}
+	}
+	for _, hdr := range []string{"", "000", "00000", "000g", "+001", "-001", "0x01", " 001"} {
+		if _, ok := ParseLength([]byte(hdr)); ok {
+			t.Errorf("ParseLength(%q) succeeded", hdr)
+		}
+	}
+}
+
+func TestPacketScanner_reuseBuffer(t *testing.T) {
+	var in bytes.Buffer
+	var want []string
+	for _, n := range []int{1, 100, 10, 5000, maxPacketSize - 4} {
+		p := strings.Repeat(string(rune('a'+len(want))), n)
+		in.Write(BytesPacket(p).EncodeToPktLine())
+		want = append(want, p)
+	}
+	in.WriteString("0000")
+	want = append(want, "")
+
+	s := NewPacketScanner(&in, WithReuseBuffer(true))
+	var got []string
+	for s.Scan() {
+		// The peeked packet must not overwrite the current one.
+		s.Peek()
+		p, _ := s.Packet().(BytesPacket)
+		got = append(got, string(p))
+	}
+	if err := s.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("packets mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestPacketScanner_packChunks(t *testing.T) {
+	pack := strings.Repeat("0123456789", 10)
+	for _, size := range []int{8, 64, 1000} {
+		s := NewPacketScanner(strings.NewReader("0008abc\nPACK"+pack), WithBufferSize(size))
+		var got strings.Builder
+		for s.Scan() {
+			p, ok := s.Packet().(PackFilePacket)
+			if !ok {
+				continue
+			}
+			if len(p) > size {
+				t.Errorf("buffer size %d: got a chunk of %d bytes", size, len(p))
+			}
+			got.Write(p)
+		}
+		if err := s.Err(); err != nil {
+			t.Fatal(err)
+		}
+		if got.String() != pack {
+			t.Errorf("buffer size %d: got pack %q, want %q", size, got.String(), pack)
+		}
+	}
+}
+
+func TestPacketScanner_allocations(t *testing.T) {
+	const n = 100
+	for _, opts := range [][]Option{nil, {WithReuseBuffer(true)}} {
+		var before, after runtime.MemStats
+		runtime.ReadMemStats(&before)
+		for i := 0; i < n; i++ {
+			s := NewPacketScanner(strings.NewReader("0008abc\n0000"), opts...)
+			for s.Scan() {
+			}
+		}
+		runtime.ReadMemStats(&after)
+		// A scanner of small packets must not allocate the buffers of the
+		// largest packets and pack file chunks.
+		if got := (after.TotalAlloc - before.TotalAlloc) / n; got > 8<<10 {
+			t.Errorf("%d options: a scanner of 2 packets allocated %d bytes", len(opts), got)
+		}
+	}
+}
+
+var benchHeaders = [][]byte{[]byte("0000"), []byte("0032"), []byte("fff0"), []byte("1a2B")}
+
+func BenchmarkParseLength(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, ok := ParseLength(benchHeaders[i%len(benchHeaders)]); !ok {
+			b.Fatal("invalid header")
+		}
+	}
+}
+
+// BenchmarkParseUint is the strconv.ParseUint decoding ParseLength replaces.
+func BenchmarkParseUint(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := strconv.ParseUint(string(benchHeaders[i%len(benchHeaders)]), 16, 32); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,49 @@
+// Modified by Giacomo Tartari
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkt
+
+// Trace receives the events of the scanners, writers and parsers it is
+// installed on with WithTrace, e.g. to log the traffic of a proxy. Its
+// methods are called synchronously, so they must not block.
+type Trace interface {
+	// OnPacketRead is called for each packet returned by a PacketScanner,
+	// with its size in the stream, header included. The packet is only
+	// valid until the next packet is read.
+	OnPacketRead(p Packet, size int)
+	// OnPacketWritten is called for each packet written by a
+	// PacketWriter, with its encoded size.
+	OnPacketWritten(p Packet, size int)
+	// OnStateTransition is called when a parser moves from state from to
+	// state to, e.g. from UploadRequestBegin to UploadRequestScanWants.
+	// The type of the states identifies the parser.
+	OnStateTransition(from, to any)
+}
+
+// WithTrace installs t on the scanner, the writer or the parser.
+func WithTrace(t Trace) Option {
+	return func(c *Config) {
+		c.Trace = t
+	}
+}
+
+// TraceStateTransition reports the transition of a parser from state from
+// to state to to the Trace of the scanner, if any and if the states differ.
+// It is exported for the protocol subpackages.
+func (s *PacketScanner) TraceStateTransition(from, to any) {
+	if s.trace != nil && from != to {
+		s.trace.OnStateTransition(from, to)
+	}
+}
@@ -0,0 +1,183 @@
+// Modified by Giacomo Tartari
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkt
+
+import (
+	"bytes"
+	"io"
+)
+
+// Transformer rewrites a packet into zero or more packets. It is the building
+// block of the filters, rewriters and recorders inserted between a
+// PacketScanner and a writer by Transform.
+//
+// The payload of the packets given to a Transformer is only valid until the
+// next packet is scanned, so a Transformer keeping packets must copy them.
+type Transformer func(Packet) ([]Packet, error)
+
+// Compose returns a Transformer passing the packets through ts in order.
+// Each packet returned by a Transformer is given to the next one.
+func Compose(ts ...Transformer) Transformer {
+	return func(p Packet) ([]Packet, error) {
+		pkts := []Packet{p}
+		for _, t := range ts {
+			var next []Packet
+			for _, p := range pkts {
+				out, err := t(p)
+				if err != nil {
+					return nil, err
+				}
+				next = append(next, out...)
+			}
+			pkts = next
+		}
+		return pkts, nil
+	}
+}
+
+// Filter returns a Transformer dropping the packets for which keep returns
+// false.
+func Filter(keep func(Packet) bool) Transformer {
+	return func(p Packet) ([]Packet, error) {
+		if !keep(p) {
+			return nil, nil
+		}
+		return []Packet{p}, nil
+	}
+}
+
+// Map returns a Transformer replacing every packet with the one returned by
+// fn.
+func Map(fn func(Packet) Packet) Transformer {
+	return func(p Packet) ([]Packet, error) {
+		return []Packet{fn(p)}, nil
+	}
+}
+
+// Tap returns a Transformer calling fn with every packet and passing it
+// through unchanged.
+func Tap(fn func(Packet)) Transformer {
+	return func(p Packet) ([]Packet, error) {
+		fn(p)
+		return []Packet{p}, nil
+	}
+}
+
+// Transform writes to dst the packets read from src, passed through t. A nil
+// t copies the packets unchanged, in their original encoding (see RawBytes).
+// The flush packets flush dst if it has a Flush method, as PacketWriter.Flush
+// does, so that the peer receives a section as soon as it ends. It returns the
+// first error of src, t or dst, or nil at the end of src.
+func Transform(dst io.Writer, src *PacketScanner, t Transformer) error {
+	pw := NewPacketWriter(dst)
+	for src.Scan() {
+		if t == nil {
+			if err := forward(pw, RawPacket(src.RawBytes())); err != nil {
+				return err
+			}
+			continue
+		}
+		pkts, err := t(src.Packet())
+		if err != nil {
+			return err
+		}
+		for _, p := range pkts {
+			if err := forward(pw, p); err != nil {
+				return err
+			}
+		}
+	}
+	return src.Err()
+}
+
+// ChunkScanner is the interface of the parsers of this package, e.g.
+// UploadRequest or ReceiveResponse, whose chunks are of type C.
+type ChunkScanner[C any] interface {
+	Scan() bool
+	Chunk() C
+	Err() error
+}
+
+// ChunkTransformer rewrites a chunk into zero or more chunks. It is the
+// Transformer of TransformChunks.
+type ChunkTransformer[C Packet] func(C) ([]C, error)
+
+// TransformChunks is Transform for the chunks of a parser: it writes to dst
+// the chunks read from src, passed through t. Working on chunks rather than
+// packets, a proxy can rewrite the commands of a push or the refs of an
+// advertisement without parsing the lines itself. A nil t copies the chunks.
+// The chunks are validated before they are written, and the flush packets
+// flush dst as with Transform. It returns the first error of src, t or dst,
+// or nil at the end of src.
+//
+// As with Transformer, the chunks given to t may point to data that is
+// overwritten by the next call to Scan.
+func TransformChunks[C Packet](dst io.Writer, src ChunkScanner[C], t ChunkTransformer[C]) error {
+	pw := NewPacketWriter(dst)
+	for src.Scan() {
+		chunks := []C{src.Chunk()}
+		if t != nil {
+			var err error
+			if chunks, err = t(chunks[0]); err != nil {
+				return err
+			}
+		}
+		for _, c := range chunks {
+			if v, ok := any(c).(interface{ Validate() error }); ok {
+				if err := v.Validate(); err != nil {
+					return err
+				}
+			}
+			if err := forward(pw, RawPacket(c.EncodeToPktLine())); err != nil {
+				return err
+			}
+		}
+	}
+	return src.Err()
+}
+
+// ComposeChunks returns a ChunkTransformer passing the chunks through ts in
+// order, like Compose.
+func ComposeChunks[C Packet](ts ...ChunkTransformer[C]) ChunkTransformer[C] {
+	return func(c C) ([]C, error) {
+		chunks := []C{c}
+		for _, t := range ts {
+			var next []C
+			for _, c := range chunks {
+				out, err := t(c)
+				if err != nil {
+					return nil, err
+				}
+				next = append(next, out...)
+			}
+			chunks = next
+		}
+		return chunks, nil
+	}
+}
+
+// forward writes p to w, flushing w at a flush packet.
+func forward(w *PacketWriter, p Packet) error {
+	switch p := p.(type) {
+	case FlushPacket:
+		return w.Flush()
+	case RawPacket:
+		if bytes.Equal(p, []byte("0000")) {
+			return w.Flush()
+		}
+	}
+	return w.WritePacket(p)
+}
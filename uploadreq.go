@@ -20,6 +20,7 @@ import (
 	"io"
 	"strconv"
 	"strings"
+	"time"
 )
 
 type UploadRequestState int
@@ -50,10 +51,116 @@ type UploadRequestChunk struct {
 	HaveObjectID      string
 	EndOneRound       bool
 	NoMoreNegotiation bool
+
+	// raw is the payload of the packet the chunk was read from, when its
+	// fields are parsed lazily.
+	raw []byte
+}
+
+// NewWantChunk returns a chunk for a "want" line. Capabilities must only be
+// given for the first want.
+func NewWantChunk(oid string, caps ...string) *UploadRequestChunk {
+	return &UploadRequestChunk{WantObjectID: oid, Capabilities: caps}
+}
+
+// HasCapability reports whether the chunk carries the capability, e.g.
+// "deepen-relative". Only the first want carries capabilities.
+func (c *UploadRequestChunk) HasCapability(name string) bool {
+	return Capabilities(c.Capabilities).Has(name)
+}
+
+// PackMayBeThin reports whether the pack answering a request whose first
+// want is c may be thin, i.e. the client sent the thin-pack capability and
+// the pack may contain deltas against objects that the client has but that
+// are not in the pack. Such a pack must be completed, e.g. with
+// git index-pack --fix-thin, before being stored.
+func (c *UploadRequestChunk) PackMayBeThin() bool {
+	return c.HasCapability("thin-pack")
+}
+
+// NewClientShallowChunk returns a chunk for a "shallow" line telling the
+// server about a shallow commit of the client.
+func NewClientShallowChunk(oid string) *UploadRequestChunk {
+	return &UploadRequestChunk{ShallowObjectID: oid}
+}
+
+// NewDeepenChunk returns a chunk for a "deepen" line. The depth is counted
+// from the wants, or from the shallow commits of the client if the
+// deepen-relative capability is sent with the first want.
+func NewDeepenChunk(depth int) *UploadRequestChunk {
+	return &UploadRequestChunk{DeepenDepth: depth}
+}
+
+// NewDeepenSinceChunk returns a chunk for a "deepen-since" line.
+func NewDeepenSinceChunk(t time.Time) *UploadRequestChunk {
+	return &UploadRequestChunk{DeepenSince: uint64(t.Unix())}
+}
+
+// NewDeepenNotChunk returns a chunk for a "deepen-not" line.
+func NewDeepenNotChunk(ref string) *UploadRequestChunk {
+	return &UploadRequestChunk{DeepenNotRef: ref}
+}
+
+// NewFilterChunk returns a chunk for a "filter" line.
+func NewFilterChunk(spec string) *UploadRequestChunk {
+	return &UploadRequestChunk{FilterSpec: spec}
+}
+
+// NewHaveChunk returns a chunk for a "have" line.
+func NewHaveChunk(oid string) *UploadRequestChunk {
+	return &UploadRequestChunk{HaveObjectID: oid}
+}
+
+// NewEndOfRoundChunk returns a chunk for the flush packet ending the wants
+// or a round of haves.
+func NewEndOfRoundChunk() *UploadRequestChunk {
+	return &UploadRequestChunk{EndOneRound: true}
+}
+
+// NewDoneChunk returns a chunk for the "done" line.
+func NewDoneChunk() *UploadRequestChunk {
+	return &UploadRequestChunk{NoMoreNegotiation: true}
+}
+
+// Validate checks that the chunk can be encoded.
+func (c *UploadRequestChunk) Validate() error {
+	// A chunk read with WithLazyFields encodes the packet it was read from.
+	if c.raw != nil {
+		return nil
+	}
+	c.Resolve()
+	v := NewChunkValidator("UploadRequestChunk")
+	v.Kind("WantObjectID", c.WantObjectID != "")
+	v.Kind("ShallowObjectID", c.ShallowObjectID != "")
+	v.Kind("DeepenDepth", c.DeepenDepth != 0)
+	v.Kind("DeepenSince", c.DeepenSince != 0)
+	v.Kind("DeepenNotRef", c.DeepenNotRef != "")
+	v.Kind("FilterSpec", c.FilterSpec != "")
+	v.Kind("HaveObjectID", c.HaveObjectID != "")
+	v.Kind("EndOneRound", c.EndOneRound)
+	v.Kind("NoMoreNegotiation", c.NoMoreNegotiation)
+	if len(c.Capabilities) != 0 && c.WantObjectID == "" {
+		v.Fail("Capabilities are set without WantObjectID")
+	}
+	if c.DeepenDepth < 0 {
+		v.Fail(fmt.Sprintf("negative DeepenDepth: %d", c.DeepenDepth))
+	}
+	for _, cp := range c.Capabilities {
+		v.Line("Capabilities", cp)
+	}
+	v.Line("WantObjectID", c.WantObjectID)
+	v.Line("ShallowObjectID", c.ShallowObjectID)
+	v.Line("DeepenNotRef", c.DeepenNotRef)
+	v.Line("FilterSpec", c.FilterSpec)
+	v.Line("HaveObjectID", c.HaveObjectID)
+	return v.Err()
 }
 
 // EncodeToPktLine serializes the chunk.
 func (c *UploadRequestChunk) EncodeToPktLine() []byte {
+	if c.raw != nil {
+		return BytesPacket(c.raw).EncodeToPktLine()
+	}
 	if len(c.Capabilities) > 0 && c.WantObjectID != "" {
 		return BytesPacket([]byte(fmt.Sprintf("want %s %s\n", c.WantObjectID, strings.Join(c.Capabilities, " ")))).EncodeToPktLine()
 	}
@@ -91,15 +198,20 @@ func (c *UploadRequestChunk) EncodeToPktLine() []byte {
 // git-upload-pack request.
 type UploadRequest struct {
 	scanner *PacketScanner
+	cfg     *Config
 	state   UploadRequestState
 	err     error
 	curr    *UploadRequestChunk
+	// rounds is the number of rounds of haves ended by a flush, and haves
+	// is set when the current round has haves.
+	rounds int
+	haves  bool
 }
 
 // NewUploadRequest returns a new UploadRequest to
 // read from rd.
-func NewUploadRequest(rd io.Reader) *UploadRequest {
-	return &UploadRequest{scanner: NewPacketScanner(rd)}
+func NewUploadRequest(rd io.Reader, opts ...Option) *UploadRequest {
+	return &UploadRequest{scanner: NewPacketScanner(rd, opts...), cfg: NewConfig(opts...)}
 }
 
 // Err returns the first non-EOF error that was encountered by the
@@ -113,11 +225,38 @@ func (r *UploadRequest) Chunk() *UploadRequestChunk {
 	return r.curr
 }
 
+// Reset prepares r to read the next document of its input, e.g. the
+// response following an advertisement in a capture of a stateless HTTP
+// exchange, reusing its buffers. See PacketScanner.Reset.
+func (r *UploadRequest) Reset() {
+	r.scanner.Reset()
+	*r = UploadRequest{scanner: r.scanner, cfg: r.cfg}
+}
+
+// Rest returns the unread part of the input, e.g. to continue with the next
+// exchange of a stateful connection once Scan returned false at the end of
+// the upload request. r must not be used afterwards.
+func (r *UploadRequest) Rest() io.Reader {
+	return r.scanner.Rest()
+}
+
 // Scan advances the scanner to the next packet. It returns false when the scan
 // stops, either by reaching the end of the input or an error. After scan
 // returns false, the Err method will return any error that occurred during
 // scanning, except that if it was io.EOF, Err will return nil.
+// A malformed stream is reported as a ParseError.
 func (r *UploadRequest) Scan() bool {
+	from := r.state
+	ok := r.scan()
+	r.scanner.TraceStateTransition(from, r.state)
+	if ok {
+		return true
+	}
+	r.err = r.scanner.NewParseError(r.err, r.state)
+	return false
+}
+
+func (r *UploadRequest) scan() bool {
 	if r.err != nil || r.state == UploadRequestEnd {
 		return false
 	}
@@ -136,34 +275,40 @@ func (r *UploadRequest) Scan() bool {
 			r.err = SyntaxError(fmt.Sprintf("unexpected packet: %#v", pkt))
 			return false
 		}
-		ss := strings.SplitN(string(bp), " ", 3)
+		ss := strings.SplitN(r.cfg.Arena.String(bp), " ", 3)
 		if len(ss) < 2 {
 			r.err = SyntaxError("cannot split wants: " + string(bp))
 			return false
 		}
 		caps := []string{}
 		if len(ss) == 3 {
-			if capStr := strings.TrimSuffix(ss[2], "\n"); capStr != "" {
-				// This is to avoid strings.Split("", " ") => []string{""}.
-				caps = strings.Split(capStr, " ")
-			}
+			caps = r.cfg.splitCapabilities(strings.TrimSuffix(ss[2], "\n"))
 		}
 		if ss[0] != "want" {
 			r.err = SyntaxError("the first packet is not want: " + string(bp))
+			return false
+		}
+		want := strings.TrimSuffix(ss[1], "\n")
+		if r.err = r.cfg.checkObjectID(want); r.err != nil {
+			return false
 		}
 		r.state = UploadRequestScanWants
-		r.curr = &UploadRequestChunk{
+		r.curr = r.cfg.Arena.NewUploadRequestChunk(UploadRequestChunk{
 			Capabilities: caps,
-			WantObjectID: ss[1],
-		}
+			WantObjectID: want,
+		})
 		return true
 	}
 
 	if _, ok := pkt.(FlushPacket); ok {
+		if r.haves {
+			r.rounds++
+			r.haves = false
+		}
 		r.state = UploadRequestBeginNegotiationOrDoneOrEnd
-		r.curr = &UploadRequestChunk{
+		r.curr = r.cfg.Arena.NewUploadRequestChunk(UploadRequestChunk{
 			EndOneRound: true,
-		}
+		})
 		return true
 	}
 
@@ -172,14 +317,21 @@ func (r *UploadRequest) Scan() bool {
 		r.err = SyntaxError(fmt.Sprintf("unexpected packet: %#v", pkt))
 		return false
 	}
-	s := strings.TrimSuffix(string(bp), "\n")
+	if r.cfg.lazy() {
+		if c := r.lazyChunk(bp); c != nil {
+			r.curr = c
+			return true
+		}
+	}
+	s := strings.TrimSuffix(r.cfg.Arena.String(bp), "\n")
 
 	if s == "done" {
 		if r.state == UploadRequestNegotiation || r.state == UploadRequestBeginNegotiationOrDoneOrEnd {
+			r.cfg.Metrics.NegotiationRounds(r.rounds + 1)
 			r.state = UploadRequestEnd
-			r.curr = &UploadRequestChunk{
+			r.curr = r.cfg.Arena.NewUploadRequestChunk(UploadRequestChunk{
 				NoMoreNegotiation: true,
-			}
+			})
 			return true
 		}
 		r.err = SyntaxError(fmt.Sprintf("unexpected packet: %#v", pkt))
@@ -192,21 +344,28 @@ func (r *UploadRequest) Scan() bool {
 		return false
 	}
 
+	switch ss[0] {
+	case "want", "shallow", "have":
+		if r.err = r.cfg.checkObjectID(ss[1]); r.err != nil {
+			return false
+		}
+	}
+
 	switch r.state {
 	case UploadRequestScanWants:
 		if ss[0] == "want" {
-			r.curr = &UploadRequestChunk{
+			r.curr = r.cfg.Arena.NewUploadRequestChunk(UploadRequestChunk{
 				WantObjectID: ss[1],
-			}
+			})
 			return true
 		}
 		fallthrough
 	case UploadRequestScanShallows:
 		if ss[0] == "shallow" {
 			r.state = UploadRequestScanShallows
-			r.curr = &UploadRequestChunk{
+			r.curr = r.cfg.Arena.NewUploadRequestChunk(UploadRequestChunk{
 				ShallowObjectID: ss[1],
-			}
+			})
 			return true
 		}
 		fallthrough
@@ -218,28 +377,29 @@ func (r *UploadRequest) Scan() bool {
 				return false
 			}
 			r.state = UploadRequestScanFilter
-			r.curr = &UploadRequestChunk{
+			r.curr = r.cfg.Arena.NewUploadRequestChunk(UploadRequestChunk{
 				DeepenDepth: int(depth),
-			}
+			})
 			return true
 		}
 		if ss[0] == "deepen-since" {
 			since, err := strconv.ParseUint(ss[1], 10, 64)
 			if err != nil {
-				r.err = SyntaxError("cannot parse depth")
+				r.err = SyntaxError("cannot parse deepen-since")
 				return false
 			}
-			r.state = UploadRequestScanFilter
-			r.curr = &UploadRequestChunk{
+			// deepen-since and deepen-not can be combined and repeated.
+			r.state = UploadRequestScanDepth
+			r.curr = r.cfg.Arena.NewUploadRequestChunk(UploadRequestChunk{
 				DeepenSince: since,
-			}
+			})
 			return true
 		}
 		if ss[0] == "deepen-not" {
-			r.state = UploadRequestScanFilter
-			r.curr = &UploadRequestChunk{
+			r.state = UploadRequestScanDepth
+			r.curr = r.cfg.Arena.NewUploadRequestChunk(UploadRequestChunk{
 				DeepenNotRef: ss[1],
-			}
+			})
 			return true
 		}
 		fallthrough
@@ -249,9 +409,9 @@ func (r *UploadRequest) Scan() bool {
 			return false
 		}
 		r.state = UploadRequestNegotiation
-		r.curr = &UploadRequestChunk{
+		r.curr = r.cfg.Arena.NewUploadRequestChunk(UploadRequestChunk{
 			FilterSpec: ss[1],
-		}
+		})
 		return true
 	case UploadRequestNegotiation, UploadRequestBeginNegotiationOrDoneOrEnd:
 		if ss[0] != "have" {
@@ -259,9 +419,10 @@ func (r *UploadRequest) Scan() bool {
 			return false
 		}
 		r.state = UploadRequestNegotiation
-		r.curr = &UploadRequestChunk{
+		r.haves = true
+		r.curr = r.cfg.Arena.NewUploadRequestChunk(UploadRequestChunk{
 			HaveObjectID: ss[1],
-		}
+		})
 		return true
 	}
 	panic("impossible state")
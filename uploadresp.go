@@ -41,15 +41,115 @@ type UploadResponseChunk struct {
 	UnshallowObjectID string
 	EndOfShallows     bool
 	AckObjectID       string
-	AckDetail         string
-	Nak               bool
-	PackStream        []byte
-	PackRepo          any
-	EndOfRequest      bool
+	// AckDetail is the multi_ack status of the ACK line, parsed by
+	// AckStatus.
+	AckDetail  string
+	Nak        bool
+	PackStream []byte
+	PackRepo   any
+	// ProgressMessage is a side-band progress message (band 2), which
+	// servers send during the negotiation and with the pack.
+	ProgressMessage []byte
+	// Keepalive is an empty packet, or an empty side-band packet, that
+	// servers send while the pack is being prepared.
+	Keepalive    bool
+	EndOfRequest bool
+	// Delim is a delim packet, read with DelimExpose.
+	Delim bool
+	// RemoteError is the message of an "ERR" packet, read with
+	// WithErrorPackets.
+	RemoteError string
+
+	// raw is the payload of the packet the chunk was read from, when its
+	// fields are parsed lazily.
+	raw []byte
+}
+
+// NewShallowChunk returns a chunk for a "shallow" line.
+func NewShallowChunk(oid string) *UploadResponseChunk {
+	return &UploadResponseChunk{ShallowObjectID: oid}
+}
+
+// NewUnshallowChunk returns a chunk for an "unshallow" line.
+func NewUnshallowChunk(oid string) *UploadResponseChunk {
+	return &UploadResponseChunk{UnshallowObjectID: oid}
+}
+
+// NewEndOfShallowsChunk returns a chunk for the flush packet ending the
+// shallow lines.
+func NewEndOfShallowsChunk() *UploadResponseChunk {
+	return &UploadResponseChunk{EndOfShallows: true}
+}
+
+// NewAckChunk returns a chunk for an "ACK" line. The status is the
+// multi_ack detail ("continue", "common" or "ready"), or empty. See also
+// NewAckStatusChunk.
+func NewAckChunk(oid, status string) *UploadResponseChunk {
+	return &UploadResponseChunk{AckObjectID: oid, AckDetail: status}
+}
+
+// NewNakChunk returns a chunk for a "NAK" line.
+func NewNakChunk() *UploadResponseChunk {
+	return &UploadResponseChunk{Nak: true}
+}
+
+// NewPackDataChunk returns a chunk for a packet of pack data.
+func NewPackDataChunk(data []byte) *UploadResponseChunk {
+	return &UploadResponseChunk{PackStream: data}
+}
+
+// NewProgressChunk returns a chunk for a side-band progress message.
+func NewProgressChunk(msg []byte) *UploadResponseChunk {
+	return &UploadResponseChunk{ProgressMessage: msg}
+}
+
+// NewKeepaliveChunk returns a chunk for a keepalive packet.
+func NewKeepaliveChunk() *UploadResponseChunk {
+	return &UploadResponseChunk{Keepalive: true}
+}
+
+// NewUploadResponseEndChunk returns a chunk for the flush packet ending the
+// response.
+func NewUploadResponseEndChunk() *UploadResponseChunk {
+	return &UploadResponseChunk{EndOfRequest: true}
+}
+
+// Validate checks that the chunk can be encoded.
+func (c *UploadResponseChunk) Validate() error {
+	// A chunk read with WithLazyFields encodes the packet it was read from.
+	if c.raw != nil {
+		return nil
+	}
+	c.Resolve()
+	v := NewChunkValidator("UploadResponseChunk")
+	v.Kind("ShallowObjectID", c.ShallowObjectID != "")
+	v.Kind("UnshallowObjectID", c.UnshallowObjectID != "")
+	v.Kind("EndOfShallows", c.EndOfShallows)
+	v.Kind("AckObjectID", c.AckObjectID != "")
+	v.Kind("Nak", c.Nak)
+	v.Kind("PackStream", len(c.PackStream) != 0)
+	v.Kind("ProgressMessage", len(c.ProgressMessage) != 0)
+	v.Kind("Keepalive", c.Keepalive)
+	v.Kind("EndOfRequest", c.EndOfRequest)
+	v.Kind("Delim", c.Delim)
+	v.Kind("RemoteError", c.RemoteError != "")
+	if c.AckDetail != "" && c.AckObjectID == "" {
+		v.Fail("AckDetail is set without AckObjectID")
+	}
+	v.Line("ShallowObjectID", c.ShallowObjectID)
+	v.Line("UnshallowObjectID", c.UnshallowObjectID)
+	v.Line("AckObjectID", c.AckObjectID)
+	v.Line("AckDetail", c.AckDetail)
+	v.Payload("PackStream", len(c.PackStream))
+	v.Payload("ProgressMessage", len(c.ProgressMessage)+1)
+	return v.Err()
 }
 
 // EncodeToPktLine serializes the chunk.
 func (c *UploadResponseChunk) EncodeToPktLine() []byte {
+	if c.raw != nil {
+		return BytesPacket(c.raw).EncodeToPktLine()
+	}
 	if c.ShallowObjectID != "" {
 		return BytesPacket([]byte(fmt.Sprintf("shallow %s\n", c.ShallowObjectID))).EncodeToPktLine()
 	}
@@ -71,9 +171,21 @@ func (c *UploadResponseChunk) EncodeToPktLine() []byte {
 	if len(c.PackStream) != 0 {
 		return BytesPacket(c.PackStream).EncodeToPktLine()
 	}
+	if len(c.ProgressMessage) != 0 {
+		return SideBandReportPacket(c.ProgressMessage).EncodeToPktLine()
+	}
+	if c.Keepalive {
+		return SideBandMainPacket(nil).EncodeToPktLine()
+	}
 	if c.EndOfRequest {
 		return FlushPacket{}.EncodeToPktLine()
 	}
+	if c.Delim {
+		return DelimPacket{}.EncodeToPktLine()
+	}
+	if c.RemoteError != "" {
+		return ErrorPacket(c.RemoteError).EncodeToPktLine()
+	}
 	panic("impossible chunk")
 }
 
@@ -81,15 +193,18 @@ func (c *UploadResponseChunk) EncodeToPktLine() []byte {
 // git-upload-pack response.
 type UploadResponse struct {
 	scanner *PacketScanner
+	cfg     *Config
 	state   UploadResponseState
 	err     error
 	curr    *UploadResponseChunk
+	// packSize is the size of the pack file read over side-band.
+	packSize int64
 }
 
 // NewUploadResponse returns a new ProtocolV1UploadPackResponse to
 // read from rd.
-func NewUploadResponse(rd io.Reader) *UploadResponse {
-	return &UploadResponse{scanner: NewPacketScanner(rd)}
+func NewUploadResponse(rd io.Reader, opts ...Option) *UploadResponse {
+	return &UploadResponse{scanner: NewPacketScanner(rd, opts...), cfg: NewConfig(opts...)}
 }
 
 // Err returns the first non-EOF error that was encountered by the
@@ -103,16 +218,44 @@ func (r *UploadResponse) Chunk() *UploadResponseChunk {
 	return r.curr
 }
 
+// Reset prepares r to read the next document of its input, e.g. the
+// response following an advertisement in a capture of a stateless HTTP
+// exchange, reusing its buffers. See PacketScanner.Reset.
+func (r *UploadResponse) Reset() {
+	r.scanner.Reset()
+	*r = UploadResponse{scanner: r.scanner, cfg: r.cfg}
+}
+
+// Rest returns the unread part of the input, e.g. to continue with the next
+// exchange of a stateful connection once Scan returned false at the end of
+// the upload response. r must not be used afterwards.
+func (r *UploadResponse) Rest() io.Reader {
+	return r.scanner.Rest()
+}
+
 // Scan advances the scanner to the next packet. It returns false when the scan
 // stops, either by reaching the end of the input or an error. After scan
 // returns false, the Err method will return any error that occurred during
 // scanning, except that if it was io.EOF, Err will return nil.
+// A malformed stream is reported as a ParseError.
 func (r *UploadResponse) Scan() bool {
+	from := r.state
+	ok := r.scan()
+	r.scanner.TraceStateTransition(from, r.state)
+	if ok {
+		return true
+	}
+	r.err = r.scanner.NewParseError(r.err, r.state)
+	return false
+}
+
+func (r *UploadResponse) scan() bool {
 	if r.err != nil || r.state == UploadResponseEnd {
 		return false
 	}
-	if !r.scanner.Scan() {
-		if r.scanner.Err() == nil {
+	if !r.cfg.scanPacket(r.scanner) {
+		r.err = r.scanner.Err()
+		if r.err == nil {
 			switch r.state {
 			case UploadResponseBeginAcknowledgements, UploadResponseScanPacks:
 			default:
@@ -123,19 +266,69 @@ func (r *UploadResponse) Scan() bool {
 	}
 	pkt := r.scanner.Packet()
 
+	if _, ok := pkt.(DelimPacket); ok && r.cfg.DelimPolicy == DelimExpose {
+		r.curr = r.cfg.Arena.NewUploadResponseChunk(UploadResponseChunk{Delim: true})
+		return true
+	}
+	if ep, ok := pkt.(ErrorPacket); ok {
+		// The state is kept, the server may send more packets.
+		r.curr = r.cfg.Arena.NewUploadResponseChunk(UploadResponseChunk{RemoteError: string(ep)})
+		return true
+	}
+
+	if bp, ok := pkt.(BytesPacket); ok {
+		// Progress and keepalive packets can come before the
+		// acknowledgements and with the pack. No other line is empty
+		// or starts with the progress band.
+		switch {
+		case len(bp) == 0 || len(bp) == 1 && bp[0] == 1:
+			r.curr = r.cfg.Arena.NewUploadResponseChunk(UploadResponseChunk{
+				Keepalive: true,
+			})
+			return true
+		case bp[0] == 2:
+			r.curr = r.cfg.Arena.NewUploadResponseChunk(UploadResponseChunk{
+				ProgressMessage: bp[1:],
+			})
+			return true
+		}
+	}
+
+	if bp, ok := pkt.(BytesPacket); ok && r.cfg.LenientMode && bp[0] > 3 {
+		// A text line rather than a side-band packet.
+		pkt = BytesPacket(r.cfg.NormalizeAck(strings.TrimSuffix(string(bp), "\n")) + "\n")
+	}
+
+	if bp, ok := pkt.(BytesPacket); ok && r.state == UploadResponseScanPacks && bytes.HasPrefix(bp, []byte("ACK ")) {
+		// With no-done, the server sends the final ACK after the NAK
+		// of the round it is ready in. A side-band packet cannot start
+		// with "A".
+		r.state = UploadResponseScanAcknowledgements
+	}
+
+	if bp, ok := pkt.(BytesPacket); ok && r.cfg.lazy() {
+		if c := r.lazyChunk(bp); c != nil {
+			r.curr = c
+			return true
+		}
+	}
+
 	switch r.state {
 	case UploadResponseBegin, UploadResponseScanShallows:
 		if bp, ok := pkt.(BytesPacket); ok {
 			if bytes.HasPrefix(bp, []byte("shallow ")) {
-				ss := strings.SplitN(strings.TrimSuffix(string(bp), "\n"), " ", 2)
+				ss := strings.SplitN(strings.TrimSuffix(r.cfg.Arena.String(bp), "\n"), " ", 2)
 				if len(ss) < 2 {
 					r.err = SyntaxError("cannot split shallow: " + string(bp))
 					return false
 				}
+				if r.err = r.cfg.checkObjectID(ss[1]); r.err != nil {
+					return false
+				}
 				r.state = UploadResponseScanShallows
-				r.curr = &UploadResponseChunk{
+				r.curr = r.cfg.Arena.NewUploadResponseChunk(UploadResponseChunk{
 					ShallowObjectID: ss[1],
-				}
+				})
 				return true
 			}
 		}
@@ -143,30 +336,33 @@ func (r *UploadResponse) Scan() bool {
 	case UploadResponseScanUnshallows:
 		if bp, ok := pkt.(BytesPacket); ok {
 			if bytes.HasPrefix(bp, []byte("unshallow ")) {
-				ss := strings.SplitN(strings.TrimSuffix(string(bp), "\n"), " ", 2)
+				ss := strings.SplitN(strings.TrimSuffix(r.cfg.Arena.String(bp), "\n"), " ", 2)
 				if len(ss) < 2 {
 					r.err = SyntaxError("cannot split unshallow: " + string(bp))
 					return false
 				}
+				if r.err = r.cfg.checkObjectID(ss[1]); r.err != nil {
+					return false
+				}
 				r.state = UploadResponseScanUnshallows
-				r.curr = &UploadResponseChunk{
+				r.curr = r.cfg.Arena.NewUploadResponseChunk(UploadResponseChunk{
 					UnshallowObjectID: ss[1],
-				}
+				})
 				return true
 			}
 		}
 		if _, ok := pkt.(FlushPacket); ok {
 			r.state = UploadResponseBeginAcknowledgements
-			r.curr = &UploadResponseChunk{
+			r.curr = r.cfg.Arena.NewUploadResponseChunk(UploadResponseChunk{
 				EndOfShallows: true,
-			}
+			})
 			return true
 		}
 		fallthrough
 	case UploadResponseBeginAcknowledgements, UploadResponseScanAcknowledgements:
 		if bp, ok := pkt.(BytesPacket); ok {
 			if bytes.HasPrefix(bp, []byte("ACK ")) {
-				ss := strings.SplitN(strings.TrimSuffix(string(bp), "\n"), " ", 3)
+				ss := strings.SplitN(strings.TrimSuffix(r.cfg.Arena.String(bp), "\n"), " ", 3)
 				if len(ss) < 2 {
 					r.err = SyntaxError("cannot split ACK: " + string(bp))
 					return false
@@ -174,19 +370,25 @@ func (r *UploadResponse) Scan() bool {
 				detail := ""
 				if len(ss) == 3 {
 					detail = ss[2]
+					if r.err = r.cfg.checkAckStatus(detail); r.err != nil {
+						return false
+					}
+				}
+				if r.err = r.cfg.checkObjectID(ss[1]); r.err != nil {
+					return false
 				}
 				r.state = UploadResponseScanAcknowledgements
-				r.curr = &UploadResponseChunk{
+				r.curr = r.cfg.Arena.NewUploadResponseChunk(UploadResponseChunk{
 					AckObjectID: ss[1],
 					AckDetail:   detail,
-				}
+				})
 				return true
 			}
 			if bytes.Equal(bp, []byte("NAK\n")) {
 				r.state = UploadResponseScanPacks
-				r.curr = &UploadResponseChunk{
+				r.curr = r.cfg.Arena.NewUploadResponseChunk(UploadResponseChunk{
 					Nak: true,
-				}
+				})
 				return true
 			}
 		}
@@ -198,25 +400,34 @@ func (r *UploadResponse) Scan() bool {
 	case UploadResponseScanPacks:
 		switch p := pkt.(type) {
 		case FlushPacket:
+			if r.packSize > 0 {
+				r.cfg.Metrics.PackStream(r.packSize)
+			}
 			r.state = UploadResponseEnd
-			r.curr = &UploadResponseChunk{
+			r.curr = r.cfg.Arena.NewUploadResponseChunk(UploadResponseChunk{
 				EndOfRequest: true,
-			}
+			})
 			return true
 		case BytesPacket:
+			if p[0] == 1 {
+				r.packSize += int64(len(p) - 1)
+			}
 			r.state = UploadResponseScanPacks
-			r.curr = &UploadResponseChunk{
+			r.curr = r.cfg.Arena.NewUploadResponseChunk(UploadResponseChunk{
 				PackStream: p,
-			}
+			})
 			return true
 		case PackFilePacket:
 			r.state = UploadResponseScanPacks
-			r.curr = &UploadResponseChunk{
+			r.curr = r.cfg.Arena.NewUploadResponseChunk(UploadResponseChunk{
 				PackStream: p,
-			}
+			})
 			return true
 		case PackFileIndicatorPacket:
 			r.state = UploadResponseScanPacks
+			r.curr = r.cfg.Arena.NewUploadResponseChunk(UploadResponseChunk{
+				PackStream: p.EncodeToPktLine(),
+			})
 			return true
 		default:
 			r.err = SyntaxError(fmt.Sprintf("unexpected packet: %#v", p))
@@ -225,3 +436,266 @@ func (r *UploadResponse) Scan() bool {
 	}
 	panic("impossible state")
 }
+
+// PackReader returns the rest of the pack data as an io.Reader, to be used in
+// place of Scan once the acknowledgements are read. A side-band pack is
+// demultiplexed: the reader returns the data of band 1, drops the progress
+// messages and fails with a SideBandError on a message of band 3. A pack
+// sent without side-band is streamed from the buffer of the scanner, and
+// io.Copy to a network connection or a file lets the kernel splice it.
+func (r *UploadResponse) PackReader() io.Reader {
+	pr := &uploadPackReader{r: r}
+	switch r.state {
+	case UploadResponseBeginAcknowledgements, UploadResponseScanAcknowledgements, UploadResponseScanPacks:
+	default:
+		if r.err == nil {
+			r.err = fmt.Errorf("UploadResponse: pack reader requested in state %d", r.state)
+		}
+	}
+	if r.err != nil {
+		pr.err = r.err
+	}
+	r.state = UploadResponseEnd
+	return pr
+}
+
+// uploadPackReader is the reader returned by UploadResponse.PackReader. The
+// first packet tells whether the pack uses side-band.
+type uploadPackReader struct {
+	r   *UploadResponse
+	rd  io.Reader
+	buf []byte
+	err error
+}
+
+func (p *uploadPackReader) start() {
+	s := p.r.scanner
+	if !s.Scan() {
+		p.err = s.Err()
+		if p.err == nil {
+			p.err = io.ErrUnexpectedEOF
+		}
+		return
+	}
+	switch pkt := s.Packet().(type) {
+	case PackFileIndicatorPacket:
+		p.buf = pkt.EncodeToPktLine()
+		p.rd = s.PackReader()
+	case ErrorPacket:
+		p.err = pkt
+	case FlushPacket:
+		p.err = io.EOF
+	case BytesPacket:
+		d := NewSideBandDemuxer(s)
+		d.handle(pkt)
+		p.rd = d
+	default:
+		p.err = SyntaxError(fmt.Sprintf("unexpected packet: %#v", pkt))
+	}
+}
+
+func (p *uploadPackReader) Read(b []byte) (int, error) {
+	if p.rd == nil && p.err == nil {
+		p.start()
+	}
+	if p.err != nil {
+		return 0, p.err
+	}
+	if len(p.buf) > 0 {
+		n := copy(b, p.buf)
+		p.buf = p.buf[n:]
+		return n, nil
+	}
+	return p.rd.Read(b)
+}
+
+func (p *uploadPackReader) WriteTo(w io.Writer) (int64, error) {
+	if p.rd == nil && p.err == nil {
+		p.start()
+	}
+	if p.err != nil {
+		if p.err == io.EOF {
+			return 0, nil
+		}
+		return 0, p.err
+	}
+	m, err := w.Write(p.buf)
+	p.buf = p.buf[m:]
+	if err != nil {
+		return int64(m), err
+	}
+	n, err := io.Copy(w, p.rd)
+	return int64(m) + n, err
+}
+
+// UploadResponseEvent is a typed view of an UploadResponseChunk, to be used
+// in a type switch. It is one of ShallowEvent, UnshallowEvent,
+// EndOfShallowsEvent, AckEvent, NakEvent, PackDataEvent, ProgressEvent,
+// KeepaliveEvent, EndEvent, DelimEvent and RemoteErrorEvent; none of them
+// is a ReceiveResponseEvent.
+type UploadResponseEvent interface {
+	Packet
+	// Chunk returns the equivalent chunk.
+	Chunk() *UploadResponseChunk
+	uploadResponseEvent()
+}
+
+// ShallowEvent is a "shallow" line of an upload-pack response.
+type ShallowEvent struct {
+	ObjectID string
+}
+
+// UnshallowEvent is an "unshallow" line of an upload-pack response.
+type UnshallowEvent struct {
+	ObjectID string
+}
+
+// EndOfShallowsEvent is the flush packet ending the shallow lines.
+type EndOfShallowsEvent struct{}
+
+// AckEvent is an "ACK" line of an upload-pack response. Detail is the
+// multi_ack status ("continue", "common" or "ready"), if any.
+type AckEvent struct {
+	ObjectID string
+	Detail   string
+}
+
+// NakEvent is a "NAK" line of an upload-pack response.
+type NakEvent struct{}
+
+// PackDataEvent is a chunk of the pack data, side-band encoded or not.
+type PackDataEvent struct {
+	Data []byte
+}
+
+// ProgressEvent is a side-band progress message.
+type ProgressEvent struct {
+	Message []byte
+}
+
+// KeepaliveEvent is a keepalive packet.
+type KeepaliveEvent struct{}
+
+// EndEvent is the flush packet ending an upload-pack response.
+type EndEvent struct{}
+
+// Chunk returns the equivalent chunk.
+func (e ShallowEvent) Chunk() *UploadResponseChunk {
+	return &UploadResponseChunk{ShallowObjectID: e.ObjectID}
+}
+
+// Chunk returns the equivalent chunk.
+func (e UnshallowEvent) Chunk() *UploadResponseChunk {
+	return &UploadResponseChunk{UnshallowObjectID: e.ObjectID}
+}
+
+// Chunk returns the equivalent chunk.
+func (EndOfShallowsEvent) Chunk() *UploadResponseChunk {
+	return &UploadResponseChunk{EndOfShallows: true}
+}
+
+// Chunk returns the equivalent chunk.
+func (e AckEvent) Chunk() *UploadResponseChunk {
+	return &UploadResponseChunk{AckObjectID: e.ObjectID, AckDetail: e.Detail}
+}
+
+// Chunk returns the equivalent chunk.
+func (NakEvent) Chunk() *UploadResponseChunk {
+	return &UploadResponseChunk{Nak: true}
+}
+
+// Chunk returns the equivalent chunk.
+func (e PackDataEvent) Chunk() *UploadResponseChunk {
+	return &UploadResponseChunk{PackStream: e.Data}
+}
+
+// Chunk returns the equivalent chunk.
+func (e ProgressEvent) Chunk() *UploadResponseChunk {
+	return &UploadResponseChunk{ProgressMessage: e.Message}
+}
+
+// Chunk returns the equivalent chunk.
+func (KeepaliveEvent) Chunk() *UploadResponseChunk {
+	return &UploadResponseChunk{Keepalive: true}
+}
+
+// Chunk returns the equivalent chunk.
+func (EndEvent) Chunk() *UploadResponseChunk {
+	return &UploadResponseChunk{EndOfRequest: true}
+}
+
+// EncodeToPktLine serializes the event.
+func (e ShallowEvent) EncodeToPktLine() []byte { return e.Chunk().EncodeToPktLine() }
+
+// EncodeToPktLine serializes the event.
+func (e UnshallowEvent) EncodeToPktLine() []byte { return e.Chunk().EncodeToPktLine() }
+
+// EncodeToPktLine serializes the event.
+func (e EndOfShallowsEvent) EncodeToPktLine() []byte { return e.Chunk().EncodeToPktLine() }
+
+// EncodeToPktLine serializes the event.
+func (e AckEvent) EncodeToPktLine() []byte { return e.Chunk().EncodeToPktLine() }
+
+// EncodeToPktLine serializes the event.
+func (e NakEvent) EncodeToPktLine() []byte { return e.Chunk().EncodeToPktLine() }
+
+// EncodeToPktLine serializes the event.
+func (e PackDataEvent) EncodeToPktLine() []byte { return e.Chunk().EncodeToPktLine() }
+
+// EncodeToPktLine serializes the event.
+func (e ProgressEvent) EncodeToPktLine() []byte { return e.Chunk().EncodeToPktLine() }
+
+// EncodeToPktLine serializes the event.
+func (e KeepaliveEvent) EncodeToPktLine() []byte { return e.Chunk().EncodeToPktLine() }
+
+// EncodeToPktLine serializes the event.
+func (e EndEvent) EncodeToPktLine() []byte { return e.Chunk().EncodeToPktLine() }
+
+func (ShallowEvent) uploadResponseEvent()       {}
+func (UnshallowEvent) uploadResponseEvent()     {}
+func (EndOfShallowsEvent) uploadResponseEvent() {}
+func (AckEvent) uploadResponseEvent()           {}
+func (NakEvent) uploadResponseEvent()           {}
+func (PackDataEvent) uploadResponseEvent()      {}
+func (ProgressEvent) uploadResponseEvent()      {}
+func (KeepaliveEvent) uploadResponseEvent()     {}
+func (EndEvent) uploadResponseEvent()           {}
+
+// Event returns the typed view of the chunk, or nil for an empty chunk.
+func (c *UploadResponseChunk) Event() UploadResponseEvent {
+	c.Resolve()
+	switch {
+	case c.ShallowObjectID != "":
+		return ShallowEvent{ObjectID: c.ShallowObjectID}
+	case c.UnshallowObjectID != "":
+		return UnshallowEvent{ObjectID: c.UnshallowObjectID}
+	case c.EndOfShallows:
+		return EndOfShallowsEvent{}
+	case c.AckObjectID != "":
+		return AckEvent{ObjectID: c.AckObjectID, Detail: c.AckDetail}
+	case c.Nak:
+		return NakEvent{}
+	case len(c.PackStream) != 0:
+		return PackDataEvent{Data: c.PackStream}
+	case len(c.ProgressMessage) != 0:
+		return ProgressEvent{Message: c.ProgressMessage}
+	case c.Keepalive:
+		return KeepaliveEvent{}
+	case c.EndOfRequest:
+		return EndEvent{}
+	case c.Delim:
+		return DelimEvent{}
+	case c.RemoteError != "":
+		return RemoteErrorEvent{Message: c.RemoteError}
+	}
+	return nil
+}
+
+// Event returns the typed view of the most recent chunk generated by a call
+// to Scan.
+func (r *UploadResponse) Event() UploadResponseEvent {
+	if r.curr == nil {
+		return nil
+	}
+	return r.curr.Event()
+}
@@ -0,0 +1,149 @@
+// Modified by Giacomo Tartari
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkt
+
+import (
+	"fmt"
+	"io"
+)
+
+// UploadResponseWriter writes a protocol v1 git-upload-pack response. It is
+// the counterpart of UploadResponse and enforces the same order: the
+// shallow and unshallow lines and their flush, then the ACK and NAK lines,
+// then the pack. After the first error, all the methods return it.
+type UploadResponseWriter struct {
+	w     io.Writer
+	state UploadResponseState
+	err   error
+}
+
+// NewUploadResponseWriter returns a new UploadResponseWriter writing to w.
+func NewUploadResponseWriter(w io.Writer) *UploadResponseWriter {
+	return &UploadResponseWriter{w: w}
+}
+
+// Err returns the first error that was encountered by the
+// UploadResponseWriter.
+func (w *UploadResponseWriter) Err() error {
+	return w.err
+}
+
+// Shallow writes a "shallow" line.
+func (w *UploadResponseWriter) Shallow(oid string) error {
+	if w.state != UploadResponseBegin && w.state != UploadResponseScanShallows {
+		return w.fail("Shallow")
+	}
+	w.state = UploadResponseScanShallows
+	return w.write(NewShallowChunk(oid))
+}
+
+// Unshallow writes an "unshallow" line.
+func (w *UploadResponseWriter) Unshallow(oid string) error {
+	if w.state > UploadResponseScanUnshallows {
+		return w.fail("Unshallow")
+	}
+	w.state = UploadResponseScanUnshallows
+	return w.write(NewUnshallowChunk(oid))
+}
+
+// EndShallows writes the flush packet ending the shallow lines. It must be
+// called for a request with a deepen line, even without shallow lines.
+func (w *UploadResponseWriter) EndShallows() error {
+	if w.state > UploadResponseScanUnshallows {
+		return w.fail("EndShallows")
+	}
+	w.state = UploadResponseBeginAcknowledgements
+	return w.write(NewEndOfShallowsChunk())
+}
+
+// Ack writes an "ACK" line. The status is the multi_ack detail
+// ("continue", "common" or "ready"), or empty.
+func (w *UploadResponseWriter) Ack(oid, status string) error {
+	if err := w.acknowledgement("Ack"); err != nil {
+		return err
+	}
+	return w.write(NewAckChunk(oid, status))
+}
+
+// Nak writes a "NAK" line.
+func (w *UploadResponseWriter) Nak() error {
+	if err := w.acknowledgement("Nak"); err != nil {
+		return err
+	}
+	return w.write(NewNakChunk())
+}
+
+func (w *UploadResponseWriter) acknowledgement(method string) error {
+	switch w.state {
+	case UploadResponseBegin, UploadResponseBeginAcknowledgements, UploadResponseScanAcknowledgements:
+		w.state = UploadResponseScanAcknowledgements
+		return w.err
+	}
+	return w.fail(method)
+}
+
+// WritePack copies the pack read from r, for a client that did not
+// negotiate side-band. It ends the response.
+func (w *UploadResponseWriter) WritePack(r io.Reader) (int64, error) {
+	if err := w.beginPack("WritePack"); err != nil {
+		return 0, err
+	}
+	n, err := io.Copy(w.w, r)
+	w.err = err
+	return n, err
+}
+
+// WriteSideBandPack copies the pack read from r to the main band of a
+// side-band stream, with the packet size of side-band-64k if large is
+// true, and writes the flush packet ending the response.
+func (w *UploadResponseWriter) WriteSideBandPack(r io.Reader, large bool) (int64, error) {
+	if err := w.beginPack("WriteSideBandPack"); err != nil {
+		return 0, err
+	}
+	m := NewSideBandMuxer(w.w, large)
+	n, err := m.WritePack(r)
+	if err == nil {
+		err = m.Flush()
+	}
+	w.err = err
+	return n, err
+}
+
+func (w *UploadResponseWriter) beginPack(method string) error {
+	if w.state != UploadResponseScanAcknowledgements {
+		return w.fail(method)
+	}
+	w.state = UploadResponseEnd
+	return w.err
+}
+
+func (w *UploadResponseWriter) fail(method string) error {
+	if w.err == nil {
+		w.err = fmt.Errorf("UploadResponseWriter: unexpected %s in state %d", method, w.state)
+	}
+	return w.err
+}
+
+func (w *UploadResponseWriter) write(c *UploadResponseChunk) error {
+	if w.err != nil {
+		return w.err
+	}
+	if w.err = c.Validate(); w.err != nil {
+		return w.err
+	}
+	_, w.err = w.w.Write(c.EncodeToPktLine())
+	return w.err
+}
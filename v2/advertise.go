@@ -0,0 +1,88 @@
+// Modified by Giacomo Tartari
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkt
+
+import (
+	"io"
+	"strings"
+
+	"github.com/cycloidio/pkt-line"
+)
+
+// CapabilityAdvertisement is the protocol v2 capability advertisement sent
+// by a server: "version 2" followed by one capability or command per line.
+type CapabilityAdvertisement struct {
+	// Capabilities are the advertised lines, of the form name[=value].
+	Capabilities []string
+}
+
+// AddCapability appends a capability to the advertisement. It is meant for
+// private extensions; the name and the value must be legal and the name not
+// already advertised.
+func (a *CapabilityAdvertisement) AddCapability(name, value string) error {
+	if err := pkt.ValidateCapability(name, value); err != nil {
+		return err
+	}
+	c := name
+	if value != "" {
+		c += "=" + value
+	}
+	if pkt.Capabilities(a.Capabilities).Has(name) {
+		return &pkt.InvalidCapabilityError{Capability: c, Reason: "already advertised"}
+	}
+	a.Capabilities = append(a.Capabilities, c)
+	return nil
+}
+
+// AddCommand advertises a command supporting features, which are sent as
+// the space separated value of the capability, e.g. "fetch=shallow filter".
+func (a *CapabilityAdvertisement) AddCommand(name string, features ...string) error {
+	for _, f := range features {
+		if f == "" || strings.Contains(f, " ") {
+			return &pkt.InvalidCapabilityError{
+				Capability: name + "=" + strings.Join(features, " "),
+				Reason:     "a command feature must be a non-empty word",
+			}
+		}
+	}
+	return a.AddCapability(name, strings.Join(features, " "))
+}
+
+// Chunks returns the chunks of the advertisement, ending with a flush.
+func (a *CapabilityAdvertisement) Chunks() []*pkt.InfoRefsResponseChunk {
+	chunks := []*pkt.InfoRefsResponseChunk{{ProtocolVersion: 2}}
+	for _, c := range a.Capabilities {
+		chunks = append(chunks, &pkt.InfoRefsResponseChunk{Capabilities: []string{c}})
+	}
+	return append(chunks, &pkt.InfoRefsResponseChunk{EndOfRequest: true})
+}
+
+// WriteTo writes the advertisement to w.
+func (a *CapabilityAdvertisement) WriteTo(w io.Writer) (int64, error) {
+	var n int64
+	for _, c := range a.Chunks() {
+		b, err := pkt.Encode(c)
+		if err != nil {
+			return n, err
+		}
+		m, err := w.Write(b)
+		n += int64(m)
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
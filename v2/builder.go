@@ -0,0 +1,134 @@
+// Modified by Giacomo Tartari
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkt
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/cycloidio/pkt-line"
+)
+
+// RequestBuilder writes a protocol v2 request, one command at a time:
+//
+//	b := NewRequestBuilder(w)
+//	b.Command("fetch")
+//	b.Capability("agent=git/2.40.0")
+//	b.Argument("want " + oid)
+//	b.Argument("done")
+//	b.Done()
+//
+// The delimiter between the capabilities and the arguments and the flush
+// ending the command are written as needed. After the first error, all the
+// methods return it.
+type RequestBuilder struct {
+	w     *pkt.PacketWriter
+	state RequestState
+	err   error
+}
+
+// NewRequestBuilder returns a new RequestBuilder writing to w.
+func NewRequestBuilder(w io.Writer) *RequestBuilder {
+	return &RequestBuilder{w: pkt.NewPacketWriter(w)}
+}
+
+// Err returns the first error that was encountered by the RequestBuilder.
+func (b *RequestBuilder) Err() error {
+	return b.err
+}
+
+// Command starts a command, e.g. "fetch" or "ls-refs".
+func (b *RequestBuilder) Command(name string) error {
+	if b.state != RequestBegin {
+		return b.fail("Command", name)
+	}
+	b.state = RequestScanCapabilities
+	return b.write(&RequestChunk{Command: name})
+}
+
+// Capability sends a capability of the current command, e.g.
+// "agent=git/2.40.0" or "object-format=sha256".
+func (b *RequestBuilder) Capability(c string) error {
+	if b.state != RequestScanCapabilities {
+		return b.fail("Capability", c)
+	}
+	return b.write(&RequestChunk{Capability: c})
+}
+
+// Argument sends an argument of the current command, e.g. "want <oid>" or
+// "peel". A LF is appended.
+func (b *RequestBuilder) Argument(arg string) error {
+	if err := b.beginArguments("Argument", arg); err != nil {
+		return err
+	}
+	return b.write(&RequestChunk{Argument: []byte(arg + "\n")})
+}
+
+// Done ends the current command.
+func (b *RequestBuilder) Done() error {
+	if err := b.beginArguments("Done", ""); err != nil {
+		return err
+	}
+	b.state = RequestBegin
+	return b.write(&RequestChunk{EndArgument: true})
+}
+
+// End sends the flush packet ending the request, after which the server
+// closes a stateful connection.
+func (b *RequestBuilder) End() error {
+	if b.state != RequestBegin {
+		return b.fail("End", "")
+	}
+	b.state = RequestEnd
+	return b.write(&RequestChunk{EndRequest: true})
+}
+
+// beginArguments writes the delimiter after the capabilities if needed.
+func (b *RequestBuilder) beginArguments(method, arg string) error {
+	switch b.state {
+	case RequestScanCapabilities:
+		b.state = RequestScanArguments
+		return b.write(&RequestChunk{EndCapability: true})
+	case RequestScanArguments:
+		return b.err
+	}
+	return b.fail(method, arg)
+}
+
+func (b *RequestBuilder) fail(method, arg string) error {
+	if b.err == nil {
+		b.err = fmt.Errorf("RequestBuilder: unexpected %s(%q) in state %d", method, arg, b.state)
+	}
+	return b.err
+}
+
+func (b *RequestBuilder) write(c *RequestChunk) error {
+	if b.err != nil {
+		return b.err
+	}
+	if err := c.Validate(); err != nil {
+		b.err = err
+		return err
+	}
+	if c.EndArgument || c.EndRequest {
+		// Flush the underlying writer too, the server waits for the
+		// whole command.
+		b.err = b.w.Flush()
+	} else {
+		b.err = b.w.WritePacket(c)
+	}
+	return b.err
+}
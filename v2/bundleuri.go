@@ -0,0 +1,317 @@
+// Modified by Giacomo Tartari
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkt
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/cycloidio/pkt-line"
+)
+
+type BundleURIResponseState int
+
+const (
+	BundleURIResponseBegin BundleURIResponseState = iota
+	BundleURIResponseEnd
+)
+
+// BundleURIResponseChunk is a chunk of a protocol v2 bundle-uri response, a
+// key=value line of the bundle list. Like git, the lines are encoded without
+// LF.
+type BundleURIResponseChunk struct {
+	Key           string
+	Value         string
+	EndOfResponse bool
+}
+
+// Equal reports whether c and o have the same fields.
+func (c *BundleURIResponseChunk) Equal(o *BundleURIResponseChunk) bool {
+	if c == nil || o == nil {
+		return c == o
+	}
+	return *c == *o
+}
+
+// Validate checks that the chunk can be encoded.
+func (c *BundleURIResponseChunk) Validate() error {
+	v := pkt.NewChunkValidator("BundleURIResponseChunk")
+	v.Kind("Key", c.Key != "")
+	v.Kind("EndOfResponse", c.EndOfResponse)
+	if c.Key == "" && c.Value != "" {
+		v.Fail("Value is set without Key")
+	}
+	if strings.ContainsAny(c.Key, "= ") {
+		v.Fail(fmt.Sprintf("Key contains '=' or a space: %q", c.Key))
+	}
+	v.Line("Key", c.Key)
+	v.Line("Value", c.Value)
+	v.Payload("Key and Value", len(c.Key)+1+len(c.Value))
+	return v.Err()
+}
+
+// EncodeToPktLine serializes the chunk.
+func (c *BundleURIResponseChunk) EncodeToPktLine() []byte {
+	if c.Key != "" {
+		return pkt.StringPacket(c.Key + "=" + c.Value).EncodeToPktLine()
+	}
+	if c.EndOfResponse {
+		return pkt.FlushPacket{}.EncodeToPktLine()
+	}
+	panic("impossible chunk")
+}
+
+// BundleURIResponse provides an interface for reading a protocol v2
+// bundle-uri response.
+type BundleURIResponse struct {
+	scanner *pkt.PacketScanner
+	state   BundleURIResponseState
+	err     error
+	curr    *BundleURIResponseChunk
+}
+
+// NewBundleURIResponse returns a new BundleURIResponse to read from rd.
+func NewBundleURIResponse(rd io.Reader, opts ...pkt.Option) *BundleURIResponse {
+	return &BundleURIResponse{scanner: pkt.NewPacketScanner(rd, opts...)}
+}
+
+// Err returns the first non-EOF error that was encountered by the
+// BundleURIResponse.
+func (r *BundleURIResponse) Err() error {
+	return r.err
+}
+
+// Chunk returns the most recent chunk generated by a call to Scan.
+func (r *BundleURIResponse) Chunk() *BundleURIResponseChunk {
+	return r.curr
+}
+
+// Reset prepares r to read the next document of its input, e.g. the
+// response following an advertisement in a capture of a stateless HTTP
+// exchange, reusing its buffers. See PacketScanner.Reset.
+func (r *BundleURIResponse) Reset() {
+	r.scanner.Reset()
+	*r = BundleURIResponse{scanner: r.scanner}
+}
+
+// Rest returns the unread part of the input, e.g. to continue with the next
+// exchange of a stateful connection once Scan returned false at the end of
+// the bundle-uri response. r must not be used afterwards.
+func (r *BundleURIResponse) Rest() io.Reader {
+	return r.scanner.Rest()
+}
+
+// Scan advances the scanner to the next packet. It returns false when the scan
+// stops, either by reaching the end of the input or an error. After scan
+// returns false, the Err method will return any error that occurred during
+// scanning, except that if it was io.EOF, Err will return nil.
+// A malformed stream is reported as a ParseError.
+func (r *BundleURIResponse) Scan() bool {
+	from := r.state
+	ok := r.scan()
+	r.scanner.TraceStateTransition(from, r.state)
+	if ok {
+		return true
+	}
+	r.err = r.scanner.NewParseError(r.err, r.state)
+	return false
+}
+
+func (r *BundleURIResponse) scan() bool {
+	if r.err != nil || r.state == BundleURIResponseEnd {
+		return false
+	}
+	if !r.scanner.Scan() {
+		r.err = r.scanner.Err()
+		if r.err == nil {
+			r.err = pkt.SyntaxError("early EOF")
+		}
+		return false
+	}
+
+	switch p := r.scanner.Packet().(type) {
+	case pkt.FlushPacket:
+		r.state = BundleURIResponseEnd
+		r.curr = &BundleURIResponseChunk{
+			EndOfResponse: true,
+		}
+		return true
+	case pkt.BytesPacket:
+		key, value, ok := strings.Cut(strings.TrimSuffix(string(p), "\n"), "=")
+		if !ok || key == "" || strings.Contains(key, " ") {
+			r.err = pkt.SyntaxError(fmt.Sprintf("unexpected bundle-uri line: %#v", string(p)))
+			return false
+		}
+		r.curr = &BundleURIResponseChunk{
+			Key:   key,
+			Value: value,
+		}
+		return true
+	default:
+		r.err = pkt.SyntaxError(fmt.Sprintf("unexpected packet: %#v", p))
+		return false
+	}
+}
+
+// Bundle is a bundle of a bundle list, described by the bundle.<id>.* keys.
+type Bundle struct {
+	ID  string
+	URI string
+	// CreationToken orders the bundles with the creationToken heuristic;
+	// it is zero when not sent.
+	CreationToken uint64
+	Filter        string
+}
+
+// BundleList is the bundle list sent in a bundle-uri response, telling the
+// client where to download bundles before fetching.
+type BundleList struct {
+	Version int
+	// Mode is "all" or "any".
+	Mode string
+	// Heuristic is e.g. "creationToken", or empty.
+	Heuristic string
+	Bundles   []*Bundle
+}
+
+// Bundle returns the bundle with the id, or nil.
+func (l *BundleList) Bundle(id string) *Bundle {
+	for _, b := range l.Bundles {
+		if b.ID == id {
+			return b
+		}
+	}
+	return nil
+}
+
+// Set sets the key of the list to value. Like git, the unknown keys are
+// ignored, and the keys are case-insensitive except for the bundle IDs.
+func (l *BundleList) Set(key, value string) error {
+	rest, ok := cutPrefixFold(key, "bundle.")
+	if !ok {
+		return nil
+	}
+	dot := strings.LastIndexByte(rest, '.')
+	if dot < 0 {
+		switch strings.ToLower(rest) {
+		case "version":
+			v, err := strconv.Atoi(value)
+			if err != nil {
+				return pkt.SyntaxError(fmt.Sprintf("invalid bundle.version: %q", value))
+			}
+			l.Version = v
+		case "mode":
+			l.Mode = value
+		case "heuristic":
+			l.Heuristic = value
+		}
+		return nil
+	}
+
+	id, sub := rest[:dot], strings.ToLower(rest[dot+1:])
+	if id == "" {
+		return pkt.SyntaxError(fmt.Sprintf("invalid bundle-uri key: %q", key))
+	}
+	b := l.Bundle(id)
+	if b == nil {
+		b = &Bundle{ID: id}
+		l.Bundles = append(l.Bundles, b)
+	}
+	switch sub {
+	case "uri":
+		b.URI = value
+	case "creationtoken":
+		t, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			return pkt.SyntaxError(fmt.Sprintf("invalid %s: %q", key, value))
+		}
+		b.CreationToken = t
+	case "filter":
+		b.Filter = value
+	}
+	return nil
+}
+
+// Chunks returns the chunks of the list, ending with a flush.
+func (l *BundleList) Chunks() []*BundleURIResponseChunk {
+	chunks := []*BundleURIResponseChunk{
+		{Key: "bundle.version", Value: strconv.Itoa(l.Version)},
+	}
+	if l.Mode != "" {
+		chunks = append(chunks, &BundleURIResponseChunk{Key: "bundle.mode", Value: l.Mode})
+	}
+	if l.Heuristic != "" {
+		chunks = append(chunks, &BundleURIResponseChunk{Key: "bundle.heuristic", Value: l.Heuristic})
+	}
+	for _, b := range l.Bundles {
+		prefix := "bundle." + b.ID + "."
+		chunks = append(chunks, &BundleURIResponseChunk{Key: prefix + "uri", Value: b.URI})
+		if b.CreationToken != 0 {
+			chunks = append(chunks, &BundleURIResponseChunk{
+				Key:   prefix + "creationToken",
+				Value: strconv.FormatUint(b.CreationToken, 10),
+			})
+		}
+		if b.Filter != "" {
+			chunks = append(chunks, &BundleURIResponseChunk{Key: prefix + "filter", Value: b.Filter})
+		}
+	}
+	return append(chunks, &BundleURIResponseChunk{EndOfResponse: true})
+}
+
+// WriteTo writes the list as a bundle-uri response to w.
+func (l *BundleList) WriteTo(w io.Writer) (int64, error) {
+	var n int64
+	for _, c := range l.Chunks() {
+		b, err := pkt.Encode(c)
+		if err != nil {
+			return n, err
+		}
+		m, err := w.Write(b)
+		n += int64(m)
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// ReadBundleList reads a bundle-uri response from rd.
+func ReadBundleList(rd io.Reader, opts ...pkt.Option) (*BundleList, error) {
+	l := &BundleList{}
+	r := NewBundleURIResponse(rd, opts...)
+	for r.Scan() {
+		if c := r.Chunk(); c.Key != "" {
+			if err := l.Set(c.Key, c.Value); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if err := r.Err(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// cutPrefixFold is strings.CutPrefix ignoring the case.
+func cutPrefixFold(s, prefix string) (string, bool) {
+	if len(s) < len(prefix) || !strings.EqualFold(s[:len(prefix)], prefix) {
+		return s, false
+	}
+	return s[len(prefix):], true
+}
@@ -0,0 +1,209 @@
+// Modified by Giacomo Tartari
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkt
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestBundleURIResponse(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want []*BundleURIResponseChunk
+		err  bool
+	}{
+		{
+			name: "lines",
+			in:   pktLines("bundle.version=1", "bundle.a.uri=https://cdn.example.com/a.bundle?x=y", "bundle.a.filter=", "0000"),
+			want: []*BundleURIResponseChunk{
+				{Key: "bundle.version", Value: "1"},
+				{Key: "bundle.a.uri", Value: "https://cdn.example.com/a.bundle?x=y"},
+				{Key: "bundle.a.filter"},
+				{EndOfResponse: true},
+			},
+		},
+		{
+			name: "empty",
+			in:   pktLines("0000"),
+			want: []*BundleURIResponseChunk{{EndOfResponse: true}},
+		},
+		{
+			name: "no value",
+			in:   pktLines("bundle.version", "0000"),
+			err:  true,
+		},
+		{
+			name: "no key",
+			in:   pktLines("=1", "0000"),
+			err:  true,
+		},
+		{
+			name: "space in key",
+			in:   pktLines("bundle a.uri=x", "0000"),
+			err:  true,
+		},
+		{
+			name: "delim",
+			in:   pktLines("bundle.version=1", "0001"),
+			want: []*BundleURIResponseChunk{{Key: "bundle.version", Value: "1"}},
+			err:  true,
+		},
+		{
+			name: "early EOF",
+			in:   pktLines("bundle.version=1"),
+			want: []*BundleURIResponseChunk{{Key: "bundle.version", Value: "1"}},
+			err:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewBundleURIResponse(strings.NewReader(tt.in))
+			var got []*BundleURIResponseChunk
+			for r.Scan() {
+				got = append(got, r.Chunk())
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("chunks mismatch (-want +got):\n%s", diff)
+			}
+			if err := r.Err(); tt.err != isSyntaxError(err) || !tt.err && err != nil {
+				t.Errorf("unexpected error %v", err)
+			}
+		})
+	}
+}
+
+func TestBundleList(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want *BundleList
+		err  bool
+	}{
+		{
+			name: "list",
+			in: pktLines(
+				"bundle.version=1", "bundle.mode=all", "bundle.heuristic=creationToken",
+				"bundle.base.uri=https://cdn.example.com/base.bundle", "bundle.base.creationToken=1",
+				"bundle.delta.uri=https://cdn.example.com/delta.bundle", "bundle.delta.creationToken=2",
+				"bundle.delta.filter=blob:none",
+				"0000"),
+			want: &BundleList{Version: 1, Mode: "all", Heuristic: "creationToken", Bundles: []*Bundle{
+				{ID: "base", URI: "https://cdn.example.com/base.bundle", CreationToken: 1},
+				{ID: "delta", URI: "https://cdn.example.com/delta.bundle", CreationToken: 2, Filter: "blob:none"},
+			}},
+		},
+		{
+			// The last value of a key wins, the keys are case-insensitive
+			// but the bundle IDs.
+			name: "duplicate keys",
+			in: pktLines(
+				"bundle.version=1", "Bundle.Mode=all", "bundle.mode=any",
+				"bundle.a.uri=https://a1", "bundle.a.URI=https://a2", "bundle.A.uri=https://A",
+				"0000"),
+			want: &BundleList{Version: 1, Mode: "any", Bundles: []*Bundle{
+				{ID: "a", URI: "https://a2"},
+				{ID: "A", URI: "https://A"},
+			}},
+		},
+		{
+			// Like git, the unknown keys are ignored, and the bundle IDs may
+			// contain dots.
+			name: "unknown keys",
+			in: pktLines(
+				"bundle.version=1", "bundle.mode=all", "bundle.unknown=x", "core.bare=true",
+				"bundle.a.b.uri=https://ab", "bundle.a.b.location=x",
+				"0000"),
+			want: &BundleList{Version: 1, Mode: "all", Bundles: []*Bundle{
+				{ID: "a.b", URI: "https://ab"},
+			}},
+		},
+		{
+			name: "invalid version",
+			in:   pktLines("bundle.version=one", "0000"),
+			err:  true,
+		},
+		{
+			name: "invalid creation token",
+			in:   pktLines("bundle.version=1", "bundle.a.creationToken=-1", "0000"),
+			err:  true,
+		},
+		{
+			name: "empty bundle ID",
+			in:   pktLines("bundle.version=1", "bundle..uri=x", "0000"),
+			err:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ReadBundleList(strings.NewReader(tt.in))
+			if tt.err {
+				if !isSyntaxError(err) {
+					t.Errorf("got error %v, want a SyntaxError", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("list mismatch (-want +got):\n%s", diff)
+			}
+
+			// The list written back reads the same.
+			var b bytes.Buffer
+			if _, err := got.WriteTo(&b); err != nil {
+				t.Fatal(err)
+			}
+			again, err := ReadBundleList(&b)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(got, again); diff != "" {
+				t.Errorf("list written back mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestBundleList_Chunks(t *testing.T) {
+	l := &BundleList{Version: 1, Mode: "any", Bundles: []*Bundle{
+		{ID: "a", URI: "https://a", CreationToken: 3},
+		{ID: "b", URI: "https://b", Filter: "blob:none"},
+	}}
+	want := []*BundleURIResponseChunk{
+		{Key: "bundle.version", Value: "1"},
+		{Key: "bundle.mode", Value: "any"},
+		{Key: "bundle.a.uri", Value: "https://a"},
+		{Key: "bundle.a.creationToken", Value: "3"},
+		{Key: "bundle.b.uri", Value: "https://b"},
+		{Key: "bundle.b.filter", Value: "blob:none"},
+		{EndOfResponse: true},
+	}
+	if diff := cmp.Diff(want, l.Chunks()); diff != "" {
+		t.Errorf("chunks mismatch (-want +got):\n%s", diff)
+	}
+	if b := l.Bundle("b"); b == nil || b.URI != "https://b" {
+		t.Errorf("Bundle(%q) = %+v", "b", b)
+	}
+	if b := l.Bundle("c"); b != nil {
+		t.Errorf("Bundle(%q) = %+v, want nil", "c", b)
+	}
+}
@@ -0,0 +1,179 @@
+// Modified by Giacomo Tartari
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkt
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/cycloidio/pkt-line"
+)
+
+// argumentCapabilities are the protocol v1 capabilities that are arguments
+// of the protocol v2 fetch command.
+var argumentCapabilities = []string{"thin-pack", "no-progress", "include-tag", "ofs-delta", "deepen-relative"}
+
+// commandCapabilities are the protocol v1 capabilities that are capabilities
+// of the protocol v2 commands.
+var commandCapabilities = []string{"agent", "object-format", "session-id"}
+
+// LsRefsFromAdvertisement returns the ls-refs response equivalent to a
+// protocol v1 ref advertisement, for an ls-refs command with the symrefs
+// and peel arguments and the ref-prefix arguments prefixes, if any.
+func LsRefsFromAdvertisement(a *pkt.Advertisement, prefixes ...string) []*LsRefsResponseChunk {
+	var chunks []*LsRefsResponseChunk
+	for _, r := range a.Refs {
+		if len(prefixes) != 0 && !slices.ContainsFunc(prefixes, func(p string) bool { return strings.HasPrefix(r.Name, p) }) {
+			continue
+		}
+		chunks = append(chunks, &LsRefsResponseChunk{
+			ObjectID:       string(r.ObjectID),
+			RefName:        r.Name,
+			SymrefTarget:   r.SymrefTarget,
+			PeeledObjectID: string(r.Peeled),
+		})
+	}
+	return append(chunks, &LsRefsResponseChunk{EndOfResponse: true})
+}
+
+// AdvertisementFromLsRefs returns the protocol v1 ref advertisement
+// equivalent to an ls-refs response read with the symrefs and peel
+// arguments, advertising caps. The symref capabilities are taken from the
+// symref targets of the refs rather than caps. The unborn refs, which
+// protocol v1 cannot advertise, are dropped.
+func AdvertisementFromLsRefs(chunks []*LsRefsResponseChunk, caps []string) *pkt.Advertisement {
+	a := &pkt.Advertisement{Capabilities: slices.DeleteFunc(slices.Clone(caps), func(c string) bool {
+		return strings.HasPrefix(c, "symref=")
+	})}
+	for _, c := range chunks {
+		if c.RefName != "" && !c.Unborn {
+			a.Refs = append(a.Refs, c.Ref())
+		}
+	}
+	return a
+}
+
+// FetchFromUploadRequest returns the capabilities and arguments of the
+// protocol v2 fetch command equivalent to a protocol v1 upload-pack request.
+// The capabilities of protocol v1 without equivalent, e.g. multi_ack or
+// side-band-64k which protocol v2 always uses, are dropped.
+func FetchFromUploadRequest(chunks []*pkt.UploadRequestChunk) (caps, args []string) {
+	for _, c := range chunks {
+		c.Resolve()
+		for _, cap := range c.Capabilities {
+			name, _, _ := strings.Cut(cap, "=")
+			if slices.Contains(argumentCapabilities, name) {
+				args = append(args, name)
+			} else if slices.Contains(commandCapabilities, name) {
+				caps = append(caps, cap)
+			}
+		}
+		switch {
+		case c.WantObjectID != "":
+			args = append(args, "want "+c.WantObjectID)
+		case c.ShallowObjectID != "":
+			args = append(args, "shallow "+c.ShallowObjectID)
+		case c.DeepenDepth != 0 || c.DeepenSince != 0 || c.DeepenNotRef != "":
+			var d pkt.Deepen
+			d.AddChunk(c)
+			args = append(args, d.Arguments()...)
+		case c.FilterSpec != "":
+			args = append(args, "filter "+c.FilterSpec)
+		case c.HaveObjectID != "":
+			args = append(args, "have "+c.HaveObjectID)
+		case c.NoMoreNegotiation:
+			args = append(args, "done")
+		}
+	}
+	return caps, args
+}
+
+// UploadRequestFromFetch returns the protocol v1 upload-pack request
+// equivalent to a protocol v2 fetch command, for a server supporting the
+// multi_ack_detailed, no-done and side-band-64k capabilities. The arguments
+// of protocol v2 without equivalent, e.g. want-ref, are rejected; the
+// wait-for-done, sideband-all and packfile-uris arguments, which only change
+// the response, are dropped.
+func UploadRequestFromFetch(caps, args []string) ([]*pkt.UploadRequestChunk, error) {
+	v1caps := []string{"multi_ack_detailed", "no-done", "side-band-64k"}
+	for _, c := range caps {
+		name, _, _ := strings.Cut(c, "=")
+		if slices.Contains(commandCapabilities, name) {
+			v1caps = append(v1caps, c)
+		}
+	}
+
+	var (
+		wants, shallows, haves []*pkt.UploadRequestChunk
+		deepen                 pkt.Deepen
+		filter                 string
+		done                   bool
+	)
+	for _, arg := range args {
+		if ok, err := deepen.AddArgument(arg); err != nil {
+			return nil, err
+		} else if ok {
+			continue
+		}
+		name, value, _ := strings.Cut(arg, " ")
+		switch {
+		case name == "want" && value != "":
+			wants = append(wants, pkt.NewWantChunk(value))
+		case name == "shallow" && value != "":
+			shallows = append(shallows, pkt.NewClientShallowChunk(value))
+		case name == "have" && value != "":
+			haves = append(haves, pkt.NewHaveChunk(value))
+		case name == "filter" && value != "":
+			filter = value
+		case arg == "done":
+			done = true
+		case slices.Contains(argumentCapabilities, arg):
+			v1caps = append(v1caps, arg)
+		case arg == "wait-for-done" || arg == "sideband-all" || name == "packfile-uris":
+		default:
+			return nil, fmt.Errorf("cannot convert the fetch argument %q to protocol v1", arg)
+		}
+	}
+	if len(wants) == 0 {
+		return nil, pkt.SyntaxError("no want in the fetch command")
+	}
+	if err := deepen.Validate(); err != nil {
+		return nil, err
+	}
+	if len(shallows) != 0 || !deepen.IsZero() {
+		v1caps = append(v1caps, "shallow")
+	}
+	if deepen.Relative {
+		v1caps = append(v1caps, "deepen-relative")
+	}
+	if filter != "" {
+		v1caps = append(v1caps, "filter")
+	}
+	wants[0].Capabilities = v1caps
+
+	chunks := append(wants, shallows...)
+	chunks = append(chunks, deepen.Chunks()...)
+	if filter != "" {
+		chunks = append(chunks, pkt.NewFilterChunk(filter))
+	}
+	chunks = append(chunks, pkt.NewEndOfRoundChunk())
+	chunks = append(chunks, haves...)
+	if done {
+		return append(chunks, pkt.NewDoneChunk()), nil
+	}
+	return append(chunks, pkt.NewEndOfRoundChunk()), nil
+}
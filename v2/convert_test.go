@@ -0,0 +1,213 @@
+// Modified by Giacomo Tartari
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkt
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cycloidio/pkt-line"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestLsRefsFromAdvertisement(t *testing.T) {
+	a := &pkt.Advertisement{
+		Refs: pkt.Refs{
+			{Name: "HEAD", ObjectID: pkt.ObjectID(oid1), SymrefTarget: "refs/heads/main"},
+			{Name: "refs/heads/main", ObjectID: pkt.ObjectID(oid1)},
+			{Name: "refs/tags/v1", ObjectID: pkt.ObjectID(oid2), Peeled: pkt.ObjectID(oid1)},
+		},
+		Capabilities: []string{"agent=git/2.40", "ofs-delta"},
+	}
+	tests := []struct {
+		name     string
+		prefixes []string
+		want     []*LsRefsResponseChunk
+	}{
+		{
+			name: "all refs",
+			want: []*LsRefsResponseChunk{
+				{ObjectID: oid1, RefName: "HEAD", SymrefTarget: "refs/heads/main"},
+				{ObjectID: oid1, RefName: "refs/heads/main"},
+				{ObjectID: oid2, RefName: "refs/tags/v1", PeeledObjectID: oid1},
+				{EndOfResponse: true},
+			},
+		},
+		{
+			name:     "prefixes",
+			prefixes: []string{"refs/tags/", "refs/notes/"},
+			want: []*LsRefsResponseChunk{
+				{ObjectID: oid2, RefName: "refs/tags/v1", PeeledObjectID: oid1},
+				{EndOfResponse: true},
+			},
+		},
+		{
+			name:     "no match",
+			prefixes: []string{"refs/notes/"},
+			want:     []*LsRefsResponseChunk{{EndOfResponse: true}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := LsRefsFromAdvertisement(a, tt.prefixes...)
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("LsRefsFromAdvertisement() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+
+	t.Run("round trip", func(t *testing.T) {
+		// The symref capability of the ls-refs side is replaced by the
+		// symref targets of the refs.
+		caps := []string{"agent=git/2.40", "symref=HEAD:refs/heads/other", "ofs-delta"}
+		got := AdvertisementFromLsRefs(LsRefsFromAdvertisement(a), caps)
+		if diff := cmp.Diff(a, got); diff != "" {
+			t.Errorf("round trip mismatch (-want +got):\n%s", diff)
+		}
+		if want := []string{"agent=git/2.40", "symref=HEAD:refs/heads/other", "ofs-delta"}; !cmp.Equal(want, caps) {
+			t.Errorf("caps modified to %q", caps)
+		}
+	})
+}
+
+func TestAdvertisementFromLsRefs(t *testing.T) {
+	chunks := []*LsRefsResponseChunk{
+		{Unborn: true, RefName: "HEAD", SymrefTarget: "refs/heads/main"},
+		{ObjectID: oid2, RefName: "refs/tags/v1", PeeledObjectID: oid1},
+		{EndOfResponse: true},
+	}
+	want := &pkt.Advertisement{
+		Refs: pkt.Refs{{Name: "refs/tags/v1", ObjectID: pkt.ObjectID(oid2), Peeled: pkt.ObjectID(oid1)}},
+	}
+	if diff := cmp.Diff(want, AdvertisementFromLsRefs(chunks, nil)); diff != "" {
+		t.Errorf("AdvertisementFromLsRefs() mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestFetchFromUploadRequest(t *testing.T) {
+	since := time.Unix(1700000000, 0)
+	tests := []struct {
+		name      string
+		v1        []*pkt.UploadRequestChunk
+		caps      []string
+		args      []string
+		roundTrip bool
+	}{
+		{
+			name: "shallow and deepen",
+			v1: []*pkt.UploadRequestChunk{
+				pkt.NewWantChunk(oid1, "multi_ack_detailed", "no-done", "side-band-64k", "agent=git/2.40", "thin-pack", "ofs-delta", "shallow", "deepen-relative"),
+				pkt.NewWantChunk(oid2),
+				pkt.NewClientShallowChunk(oid1),
+				pkt.NewDeepenChunk(3),
+				pkt.NewEndOfRoundChunk(),
+				pkt.NewHaveChunk(oid2),
+				pkt.NewDoneChunk(),
+			},
+			caps:      []string{"agent=git/2.40"},
+			args:      []string{"thin-pack", "ofs-delta", "deepen-relative", "want " + oid1, "want " + oid2, "shallow " + oid1, "deepen 3", "have " + oid2, "done"},
+			roundTrip: true,
+		},
+		{
+			name: "deepen-since, deepen-not and filter",
+			v1: []*pkt.UploadRequestChunk{
+				pkt.NewWantChunk(oid1, "multi_ack_detailed", "no-done", "side-band-64k", "object-format=sha1", "include-tag", "shallow", "filter"),
+				pkt.NewDeepenSinceChunk(since),
+				pkt.NewDeepenNotChunk("refs/heads/old"),
+				pkt.NewDeepenNotChunk("refs/heads/older"),
+				pkt.NewFilterChunk("blob:none"),
+				pkt.NewEndOfRoundChunk(),
+				pkt.NewEndOfRoundChunk(),
+			},
+			caps:      []string{"object-format=sha1"},
+			args:      []string{"include-tag", "want " + oid1, "deepen-since 1700000000", "deepen-not refs/heads/old", "deepen-not refs/heads/older", "filter blob:none"},
+			roundTrip: true,
+		},
+		{
+			name: "v1 only capabilities",
+			v1: []*pkt.UploadRequestChunk{
+				pkt.NewWantChunk(oid1, "multi_ack", "side-band", "no-progress", "session-id=abc"),
+				pkt.NewEndOfRoundChunk(),
+				pkt.NewDoneChunk(),
+			},
+			caps: []string{"session-id=abc"},
+			args: []string{"no-progress", "want " + oid1, "done"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			caps, args := FetchFromUploadRequest(tt.v1)
+			if diff := cmp.Diff(tt.caps, caps); diff != "" {
+				t.Errorf("capabilities mismatch (-want +got):\n%s", diff)
+			}
+			if diff := cmp.Diff(tt.args, args); diff != "" {
+				t.Errorf("arguments mismatch (-want +got):\n%s", diff)
+			}
+			if !tt.roundTrip {
+				return
+			}
+			got, err := UploadRequestFromFetch(caps, args)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(tt.v1, got); diff != "" {
+				t.Errorf("round trip mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestUploadRequestFromFetch(t *testing.T) {
+	t.Run("dropped arguments", func(t *testing.T) {
+		got, err := UploadRequestFromFetch(
+			[]string{"agent=git/2.40", "server-option=x"},
+			[]string{"want " + oid1, "wait-for-done", "sideband-all", "packfile-uris https", "no-progress"},
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := []*pkt.UploadRequestChunk{
+			pkt.NewWantChunk(oid1, "multi_ack_detailed", "no-done", "side-band-64k", "agent=git/2.40", "no-progress"),
+			pkt.NewEndOfRoundChunk(),
+			pkt.NewEndOfRoundChunk(),
+		}
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Errorf("UploadRequestFromFetch() mismatch (-want +got):\n%s", diff)
+		}
+	})
+
+	tests := []struct {
+		name string
+		args []string
+		err  string
+	}{
+		{"want-ref", []string{"want " + oid1, "want-ref refs/heads/main"}, `cannot convert the fetch argument "want-ref refs/heads/main" to protocol v1`},
+		{"no want", []string{"have " + oid1, "done"}, "no want in the fetch command"},
+		{"empty want", []string{"want "}, `cannot convert the fetch argument "want " to protocol v1`},
+		{"bad depth", []string{"want " + oid1, "deepen x"}, "cannot parse depth"},
+		{"bad since", []string{"want " + oid1, "deepen-since x"}, "cannot parse deepen-since"},
+		{"deepen and deepen-not", []string{"want " + oid1, "deepen 1", "deepen-not refs/heads/main"}, "deepen cannot be combined with deepen-since or deepen-not"},
+		{"relative without depth", []string{"want " + oid1, "deepen-relative"}, "deepen-relative needs deepen"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := UploadRequestFromFetch(nil, tt.args)
+			if err == nil || err.Error() != tt.err {
+				t.Errorf("got error %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,391 @@
+// Modified by Giacomo Tartari
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkt
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/cycloidio/pkt-line"
+)
+
+// The sections of a protocol v2 fetch response, in their order.
+const (
+	SectionAcknowledgments = "acknowledgments"
+	SectionShallowInfo     = "shallow-info"
+	SectionWantedRefs      = "wanted-refs"
+	SectionPackfileURIs    = "packfile-uris"
+	SectionPackfile        = "packfile"
+)
+
+type FetchResponseState int
+
+const (
+	FetchResponseBegin FetchResponseState = iota
+	FetchResponseScanSection
+	FetchResponseEnd
+)
+
+// FetchResponseChunk is a chunk of a protocol v2 fetch response. Section is
+// set on all the chunks but EndOfResponse, and only one of the other fields
+// is set.
+type FetchResponseChunk struct {
+	Section string
+	// SectionHeader is set for the line naming the section.
+	SectionHeader bool
+
+	// acknowledgments
+	AckObjectID string
+	Nak         bool
+	Ready       bool
+
+	// shallow-info
+	ShallowObjectID   string
+	UnshallowObjectID string
+
+	// wanted-refs
+	WantedRefObjectID string
+	WantedRefName     string
+
+	// packfile-uris
+	PackfileHash string
+	PackfileURI  string
+
+	// packfile, side-band-64k encoded
+	PackData        []byte
+	ProgressMessage []byte
+	Keepalive       bool
+
+	// EndOfSection is set for the delim packet ending a section followed
+	// by another one.
+	EndOfSection bool
+	// EndOfResponse is set for the flush packet ending the response.
+	EndOfResponse bool
+}
+
+// Equal reports whether c and o have the same fields.
+func (c *FetchResponseChunk) Equal(o *FetchResponseChunk) bool {
+	if c == nil || o == nil {
+		return c == o
+	}
+	return c.Section == o.Section &&
+		c.SectionHeader == o.SectionHeader &&
+		c.AckObjectID == o.AckObjectID &&
+		c.Nak == o.Nak &&
+		c.Ready == o.Ready &&
+		c.ShallowObjectID == o.ShallowObjectID &&
+		c.UnshallowObjectID == o.UnshallowObjectID &&
+		c.WantedRefObjectID == o.WantedRefObjectID &&
+		c.WantedRefName == o.WantedRefName &&
+		c.PackfileHash == o.PackfileHash &&
+		c.PackfileURI == o.PackfileURI &&
+		bytes.Equal(c.PackData, o.PackData) &&
+		bytes.Equal(c.ProgressMessage, o.ProgressMessage) &&
+		c.Keepalive == o.Keepalive &&
+		c.EndOfSection == o.EndOfSection &&
+		c.EndOfResponse == o.EndOfResponse
+}
+
+// Validate checks that the chunk can be encoded.
+func (c *FetchResponseChunk) Validate() error {
+	v := pkt.NewChunkValidator("FetchResponseChunk")
+	v.Kind("SectionHeader", c.SectionHeader)
+	v.Kind("AckObjectID", c.AckObjectID != "")
+	v.Kind("Nak", c.Nak)
+	v.Kind("Ready", c.Ready)
+	v.Kind("ShallowObjectID", c.ShallowObjectID != "")
+	v.Kind("UnshallowObjectID", c.UnshallowObjectID != "")
+	v.Kind("WantedRefObjectID, WantedRefName", c.WantedRefObjectID != "" || c.WantedRefName != "")
+	v.Kind("PackfileHash, PackfileURI", c.PackfileHash != "" || c.PackfileURI != "")
+	v.Kind("PackData", len(c.PackData) != 0)
+	v.Kind("ProgressMessage", len(c.ProgressMessage) != 0)
+	v.Kind("Keepalive", c.Keepalive)
+	v.Kind("EndOfSection", c.EndOfSection)
+	v.Kind("EndOfResponse", c.EndOfResponse)
+	if c.Section == "" && !c.EndOfResponse {
+		v.Fail("Section is not set")
+	}
+	if (c.WantedRefObjectID == "") != (c.WantedRefName == "") {
+		v.Fail("a wanted ref needs WantedRefObjectID and WantedRefName")
+	}
+	if (c.PackfileHash == "") != (c.PackfileURI == "") {
+		v.Fail("a packfile URI needs PackfileHash and PackfileURI")
+	}
+	v.Line("Section", c.Section)
+	v.Line("AckObjectID", c.AckObjectID)
+	v.Line("ShallowObjectID", c.ShallowObjectID)
+	v.Line("UnshallowObjectID", c.UnshallowObjectID)
+	v.Line("WantedRefObjectID", c.WantedRefObjectID)
+	v.Line("WantedRefName", c.WantedRefName)
+	v.Line("PackfileHash", c.PackfileHash)
+	v.Line("PackfileURI", c.PackfileURI)
+	v.Payload("PackData", len(c.PackData)+1)
+	v.Payload("ProgressMessage", len(c.ProgressMessage)+1)
+	return v.Err()
+}
+
+// EncodeToPktLine serializes the chunk.
+func (c *FetchResponseChunk) EncodeToPktLine() []byte {
+	var line string
+	switch {
+	case c.SectionHeader:
+		line = c.Section
+	case c.AckObjectID != "":
+		line = "ACK " + c.AckObjectID
+	case c.Nak:
+		line = "NAK"
+	case c.Ready:
+		line = "ready"
+	case c.ShallowObjectID != "":
+		line = "shallow " + c.ShallowObjectID
+	case c.UnshallowObjectID != "":
+		line = "unshallow " + c.UnshallowObjectID
+	case c.WantedRefObjectID != "":
+		line = c.WantedRefObjectID + " " + c.WantedRefName
+	case c.PackfileHash != "":
+		line = c.PackfileHash + " " + c.PackfileURI
+	case len(c.PackData) != 0:
+		return pkt.SideBandMainPacket(c.PackData).EncodeToPktLine()
+	case len(c.ProgressMessage) != 0:
+		return pkt.SideBandReportPacket(c.ProgressMessage).EncodeToPktLine()
+	case c.Keepalive:
+		return pkt.SideBandMainPacket(nil).EncodeToPktLine()
+	case c.EndOfSection:
+		return pkt.DelimPacket{}.EncodeToPktLine()
+	case c.EndOfResponse:
+		return pkt.FlushPacket{}.EncodeToPktLine()
+	default:
+		panic("impossible chunk")
+	}
+	return pkt.BytesPacket(line + "\n").EncodeToPktLine()
+}
+
+// FetchResponse provides an interface for reading a protocol v2 fetch
+// response. A message on the error band of the packfile section ends the
+// scan with a pkt.SideBandError.
+type FetchResponse struct {
+	scanner *pkt.PacketScanner
+	cfg     *pkt.Config
+	state   FetchResponseState
+	section string
+	err     error
+	curr    *FetchResponseChunk
+	// packSize is the size of the pack file of the packfile section.
+	packSize int64
+}
+
+// NewFetchResponse returns a new FetchResponse to read from rd.
+func NewFetchResponse(rd io.Reader, opts ...pkt.Option) *FetchResponse {
+	return &FetchResponse{scanner: pkt.NewPacketScanner(rd, opts...), cfg: pkt.NewConfig(opts...)}
+}
+
+// Err returns the first non-EOF error that was encountered by the
+// FetchResponse.
+func (r *FetchResponse) Err() error {
+	return r.err
+}
+
+// Chunk returns the most recent chunk generated by a call to Scan.
+func (r *FetchResponse) Chunk() *FetchResponseChunk {
+	return r.curr
+}
+
+// Reset prepares r to read the next document of its input, e.g. the
+// response following an advertisement in a capture of a stateless HTTP
+// exchange, reusing its buffers. See PacketScanner.Reset.
+func (r *FetchResponse) Reset() {
+	r.scanner.Reset()
+	*r = FetchResponse{scanner: r.scanner, cfg: r.cfg}
+}
+
+// Rest returns the unread part of the input, e.g. to continue with the next
+// exchange of a stateful connection once Scan returned false at the end of
+// the fetch response. r must not be used afterwards.
+func (r *FetchResponse) Rest() io.Reader {
+	return r.scanner.Rest()
+}
+
+// Scan advances the scanner to the next packet. It returns false when the scan
+// stops, either by reaching the end of the input or an error. After scan
+// returns false, the Err method will return any error that occurred during
+// scanning, except that if it was io.EOF, Err will return nil.
+// A malformed stream is reported as a ParseError.
+func (r *FetchResponse) Scan() bool {
+	from := r.state
+	ok := r.scan()
+	r.scanner.TraceStateTransition(from, r.state)
+	if ok {
+		return true
+	}
+	r.err = r.scanner.NewParseError(r.err, r.state)
+	return false
+}
+
+func (r *FetchResponse) scan() bool {
+	if r.err != nil || r.state == FetchResponseEnd {
+		return false
+	}
+	if !r.scanner.Scan() {
+		r.err = r.scanner.Err()
+		if r.err == nil {
+			r.err = pkt.SyntaxError("early EOF")
+		}
+		return false
+	}
+	packet := r.scanner.Packet()
+
+	switch r.state {
+	case FetchResponseBegin:
+		bp, ok := packet.(pkt.BytesPacket)
+		if !ok {
+			r.err = pkt.SyntaxError(fmt.Sprintf("unexpected packet: %#v", packet))
+			return false
+		}
+		switch s := strings.TrimSuffix(string(bp), "\n"); s {
+		case SectionAcknowledgments, SectionShallowInfo, SectionWantedRefs, SectionPackfileURIs, SectionPackfile:
+			r.section = s
+		default:
+			r.err = pkt.SyntaxError(fmt.Sprintf("unknown section: %#v", s))
+			return false
+		}
+		r.state = FetchResponseScanSection
+		r.curr = &FetchResponseChunk{Section: r.section, SectionHeader: true}
+		return true
+	case FetchResponseScanSection:
+		switch p := packet.(type) {
+		case pkt.DelimPacket:
+			r.state = FetchResponseBegin
+			r.curr = &FetchResponseChunk{Section: r.section, EndOfSection: true}
+			return true
+		case pkt.FlushPacket:
+			if r.section == SectionPackfile {
+				r.cfg.Metrics.PackStream(r.packSize)
+			}
+			r.state = FetchResponseEnd
+			r.curr = &FetchResponseChunk{EndOfResponse: true}
+			return true
+		case pkt.BytesPacket:
+			if r.section == SectionPackfile {
+				return r.scanPackfile(p)
+			}
+			line := strings.TrimSuffix(string(p), "\n")
+			if r.section == SectionAcknowledgments {
+				line = r.cfg.NormalizeAck(line)
+			}
+			c, err := parseFetchResponseLine(r.section, line)
+			if err != nil {
+				r.err = err
+				return false
+			}
+			if r.err = r.cfg.CheckObjectIDs(c.objectIDs()...); r.err != nil {
+				return false
+			}
+			if c.WantedRefName != "" {
+				if r.err = r.cfg.CheckRefName(c.WantedRefName); r.err != nil {
+					return false
+				}
+			}
+			r.curr = c
+			return true
+		default:
+			r.err = pkt.SyntaxError(fmt.Sprintf("unexpected packet: %#v", p))
+			return false
+		}
+	}
+	panic("impossible state")
+}
+
+// PackReader returns the pack data of the packfile section as an io.Reader,
+// to be used in place of Scan once the section header is read. The progress
+// messages are dropped, and a message on the error band fails the reader
+// with a pkt.SideBandError.
+func (r *FetchResponse) PackReader() io.Reader {
+	return &fetchPackReader{r: r}
+}
+
+// scanPackfile reads a side-band packet of the packfile section.
+func (r *FetchResponse) scanPackfile(p pkt.BytesPacket) bool {
+	c := &FetchResponseChunk{Section: SectionPackfile}
+	switch sp := pkt.ParseSideBandPacket(p).(type) {
+	case pkt.SideBandMainPacket:
+		c.PackData = sp
+		r.packSize += int64(len(sp))
+		c.Keepalive = len(sp) == 0
+	case pkt.SideBandReportPacket:
+		c.ProgressMessage = sp
+	case pkt.SideBandErrorPacket:
+		r.err = pkt.SideBandError(sp)
+		return false
+	default:
+		if len(p) != 0 {
+			r.err = pkt.SyntaxError(fmt.Sprintf("unknown side-band: %#v", p))
+			return false
+		}
+		c.Keepalive = true
+	}
+	r.curr = c
+	return true
+}
+
+// parseFetchResponseLine parses a line of a section other than packfile.
+func parseFetchResponseLine(section, s string) (*FetchResponseChunk, error) {
+	c := &FetchResponseChunk{Section: section}
+	switch section {
+	case SectionAcknowledgments:
+		if s == "NAK" {
+			c.Nak = true
+		} else if s == "ready" {
+			c.Ready = true
+		} else if oid, ok := strings.CutPrefix(s, "ACK "); ok {
+			c.AckObjectID = oid
+		} else {
+			return nil, pkt.SyntaxError(fmt.Sprintf("unexpected acknowledgment: %#v", s))
+		}
+	case SectionShallowInfo:
+		if oid, ok := strings.CutPrefix(s, "shallow "); ok {
+			c.ShallowObjectID = oid
+		} else if oid, ok := strings.CutPrefix(s, "unshallow "); ok {
+			c.UnshallowObjectID = oid
+		} else {
+			return nil, pkt.SyntaxError(fmt.Sprintf("unexpected shallow-info: %#v", s))
+		}
+	case SectionWantedRefs:
+		oid, ref, ok := strings.Cut(s, " ")
+		if !ok || oid == "" || ref == "" {
+			return nil, pkt.SyntaxError("cannot split wanted-ref: " + s)
+		}
+		c.WantedRefObjectID, c.WantedRefName = oid, ref
+	case SectionPackfileURIs:
+		hash, uri, ok := strings.Cut(s, " ")
+		if !ok || hash == "" || uri == "" {
+			return nil, pkt.SyntaxError("cannot split packfile-uri: " + s)
+		}
+		c.PackfileHash, c.PackfileURI = hash, uri
+	}
+	return c, nil
+}
+
+// objectIDs returns the object IDs of the chunk.
+func (c *FetchResponseChunk) objectIDs() []string {
+	for _, id := range []string{c.AckObjectID, c.ShallowObjectID, c.UnshallowObjectID, c.WantedRefObjectID} {
+		if id != "" {
+			return []string{id}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,213 @@
+// Modified by Giacomo Tartari
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkt
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/cycloidio/pkt-line"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestFetchResponse(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want []*FetchResponseChunk
+		// err checks the error of the scan.
+		err func(error) bool
+	}{
+		{
+			name: "all sections",
+			in: pktLines(
+				"acknowledgments\n", "ACK "+oid1+"\n", "ready\n", "0001",
+				"shallow-info\n", "shallow "+oid1+"\n", "unshallow "+oid2+"\n", "0001",
+				"wanted-refs\n", oid2+" refs/heads/main\n", "0001",
+				"packfile-uris\n", "sha1hash https://cdn.example.com/p.pack\n", "0001",
+				"packfile\n", "\x01PACK", "\x02counting objects\n", "\x01", "\x01data", "0000"),
+			want: []*FetchResponseChunk{
+				{Section: SectionAcknowledgments, SectionHeader: true},
+				{Section: SectionAcknowledgments, AckObjectID: oid1},
+				{Section: SectionAcknowledgments, Ready: true},
+				{Section: SectionAcknowledgments, EndOfSection: true},
+				{Section: SectionShallowInfo, SectionHeader: true},
+				{Section: SectionShallowInfo, ShallowObjectID: oid1},
+				{Section: SectionShallowInfo, UnshallowObjectID: oid2},
+				{Section: SectionShallowInfo, EndOfSection: true},
+				{Section: SectionWantedRefs, SectionHeader: true},
+				{Section: SectionWantedRefs, WantedRefObjectID: oid2, WantedRefName: "refs/heads/main"},
+				{Section: SectionWantedRefs, EndOfSection: true},
+				{Section: SectionPackfileURIs, SectionHeader: true},
+				{Section: SectionPackfileURIs, PackfileHash: "sha1hash", PackfileURI: "https://cdn.example.com/p.pack"},
+				{Section: SectionPackfileURIs, EndOfSection: true},
+				{Section: SectionPackfile, SectionHeader: true},
+				{Section: SectionPackfile, PackData: []byte("PACK")},
+				{Section: SectionPackfile, ProgressMessage: []byte("counting objects\n")},
+				{Section: SectionPackfile, Keepalive: true},
+				{Section: SectionPackfile, PackData: []byte("data")},
+				{EndOfResponse: true},
+			},
+		},
+		{
+			name: "acknowledgments only",
+			in:   pktLines("acknowledgments\n", "NAK\n", "0000"),
+			want: []*FetchResponseChunk{
+				{Section: SectionAcknowledgments, SectionHeader: true},
+				{Section: SectionAcknowledgments, Nak: true},
+				{EndOfResponse: true},
+			},
+		},
+		{
+			name: "unknown section",
+			in:   pktLines("bundle-uris\n", "0000"),
+			err:  isSyntaxError,
+		},
+		{
+			name: "flush before the section header",
+			in:   pktLines("0000"),
+			err:  isSyntaxError,
+		},
+		{
+			name: "bad acknowledgment",
+			in:   pktLines("acknowledgments\n", "ACK\n", "0000"),
+			want: []*FetchResponseChunk{{Section: SectionAcknowledgments, SectionHeader: true}},
+			err:  isSyntaxError,
+		},
+		{
+			name: "bad shallow-info",
+			in:   pktLines("shallow-info\n", "deepen 1\n", "0000"),
+			want: []*FetchResponseChunk{{Section: SectionShallowInfo, SectionHeader: true}},
+			err:  isSyntaxError,
+		},
+		{
+			name: "bad wanted-ref",
+			in:   pktLines("wanted-refs\n", oid1+"\n", "0000"),
+			want: []*FetchResponseChunk{{Section: SectionWantedRefs, SectionHeader: true}},
+			err:  isSyntaxError,
+		},
+		{
+			name: "bad packfile-uri",
+			in:   pktLines("packfile-uris\n", "sha1hash\n", "0000"),
+			want: []*FetchResponseChunk{{Section: SectionPackfileURIs, SectionHeader: true}},
+			err:  isSyntaxError,
+		},
+		{
+			name: "error band",
+			in:   pktLines("packfile\n", "\x01PACK", "\x03out of memory", "0000"),
+			want: []*FetchResponseChunk{
+				{Section: SectionPackfile, SectionHeader: true},
+				{Section: SectionPackfile, PackData: []byte("PACK")},
+			},
+			err: func(err error) bool { return err == pkt.SideBandError("out of memory") },
+		},
+		{
+			name: "unknown band",
+			in:   pktLines("packfile\n", "\x04data", "0000"),
+			want: []*FetchResponseChunk{{Section: SectionPackfile, SectionHeader: true}},
+			err:  isSyntaxError,
+		},
+		{
+			name: "early EOF",
+			in:   pktLines("acknowledgments\n", "NAK\n"),
+			want: []*FetchResponseChunk{
+				{Section: SectionAcknowledgments, SectionHeader: true},
+				{Section: SectionAcknowledgments, Nak: true},
+			},
+			err: isSyntaxError,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewFetchResponse(strings.NewReader(tt.in))
+			var got []*FetchResponseChunk
+			for r.Scan() {
+				got = append(got, r.Chunk())
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("chunks mismatch (-want +got):\n%s", diff)
+			}
+			if err := r.Err(); tt.err == nil && err != nil || tt.err != nil && !tt.err(err) {
+				t.Errorf("unexpected error %v", err)
+			}
+			if tt.err != nil {
+				return
+			}
+			// The chunks encode back to the input.
+			var enc strings.Builder
+			for _, c := range got {
+				if err := c.Validate(); err != nil {
+					t.Errorf("Validate(%+v) = %v", c, err)
+				}
+				enc.Write(c.EncodeToPktLine())
+			}
+			if enc.String() != tt.in {
+				t.Errorf("encoded %q, want %q", enc.String(), tt.in)
+			}
+		})
+	}
+}
+
+func TestFetchResponse_checks(t *testing.T) {
+	tests := []struct {
+		name  string
+		in    string
+		opts  []pkt.Option
+		field string
+	}{
+		{
+			name: "object format",
+			in:   pktLines("acknowledgments\n", "ACK "+strings.Repeat("1", 64)+"\n", "0000"),
+			opts: []pkt.Option{pkt.WithObjectFormat(pkt.SHA1)},
+		},
+		{
+			name:  "strict object ID",
+			in:    pktLines("shallow-info\n", "shallow "+oid1[:39]+"\n", "0000"),
+			opts:  []pkt.Option{pkt.WithStrictMode()},
+			field: "object ID",
+		},
+		{
+			name:  "strict wanted-ref name",
+			in:    pktLines("wanted-refs\n", oid1+" refs/heads/a..b\n", "0000"),
+			opts:  []pkt.Option{pkt.WithStrictMode()},
+			field: "ref name",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The input is valid without the checks.
+			if err := scanFetchResponse(tt.in); err != nil {
+				t.Fatal(err)
+			}
+			err := scanFetchResponse(tt.in, tt.opts...)
+			if err == nil {
+				t.Fatal("got no error")
+			}
+			var fe *pkt.FieldError
+			if tt.field != "" && (!errors.As(err, &fe) || fe.Field != tt.field) {
+				t.Errorf("got error %v, want a FieldError for %s", err, tt.field)
+			}
+		})
+	}
+}
+
+func scanFetchResponse(in string, opts ...pkt.Option) error {
+	r := NewFetchResponse(strings.NewReader(in), opts...)
+	for r.Scan() {
+	}
+	return r.Err()
+}
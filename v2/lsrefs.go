@@ -0,0 +1,277 @@
+// Modified by Giacomo Tartari
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkt
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/cycloidio/pkt-line"
+)
+
+type LsRefsResponseState int
+
+const (
+	LsRefsResponseBegin LsRefsResponseState = iota
+	LsRefsResponseScanRefs
+	LsRefsResponseEnd
+)
+
+// LsRefsResponseChunk is a chunk of a protocol v2 ls-refs response:
+//
+//	obj-id-or-unborn SP refname *(SP ref-attribute) LF
+type LsRefsResponseChunk struct {
+	ObjectID string
+	// Unborn is set for a ref without object, e.g. the HEAD of an empty
+	// repository, sent when the unborn argument is given.
+	Unborn         bool
+	RefName        string
+	SymrefTarget   string
+	PeeledObjectID string
+	EndOfResponse  bool
+}
+
+// Equal reports whether c and o have the same fields.
+func (c *LsRefsResponseChunk) Equal(o *LsRefsResponseChunk) bool {
+	if c == nil || o == nil {
+		return c == o
+	}
+	return *c == *o
+}
+
+// Validate checks that the chunk can be encoded.
+func (c *LsRefsResponseChunk) Validate() error {
+	v := pkt.NewChunkValidator("LsRefsResponseChunk")
+	v.Kind("RefName", c.RefName != "")
+	v.Kind("EndOfResponse", c.EndOfResponse)
+	if c.RefName != "" && (c.ObjectID == "") == !c.Unborn {
+		v.Fail("a ref needs exactly one of ObjectID and Unborn")
+	}
+	if c.RefName == "" && (c.ObjectID != "" || c.Unborn || c.SymrefTarget != "" || c.PeeledObjectID != "") {
+		v.Fail("ref fields are set without RefName")
+	}
+	v.Line("ObjectID", c.ObjectID)
+	v.Line("RefName", c.RefName)
+	v.Line("SymrefTarget", c.SymrefTarget)
+	v.Line("PeeledObjectID", c.PeeledObjectID)
+	return v.Err()
+}
+
+// EncodeToPktLine serializes the chunk.
+func (c *LsRefsResponseChunk) EncodeToPktLine() []byte {
+	if c.EndOfResponse {
+		return pkt.FlushPacket{}.EncodeToPktLine()
+	}
+	oid := c.ObjectID
+	if c.Unborn {
+		oid = "unborn"
+	}
+	s := oid + " " + c.RefName
+	if c.SymrefTarget != "" {
+		s += " symref-target:" + c.SymrefTarget
+	}
+	if c.PeeledObjectID != "" {
+		s += " peeled:" + c.PeeledObjectID
+	}
+	return pkt.BytesPacket(s + "\n").EncodeToPktLine()
+}
+
+// Ref returns the ref of the chunk.
+func (c *LsRefsResponseChunk) Ref() pkt.Ref {
+	return pkt.Ref{
+		Name:         c.RefName,
+		ObjectID:     pkt.ObjectID(c.ObjectID),
+		Peeled:       pkt.ObjectID(c.PeeledObjectID),
+		SymrefTarget: c.SymrefTarget,
+	}
+}
+
+// parseLsRefsLine parses a line of an ls-refs response.
+func parseLsRefsLine(line string) (*LsRefsResponseChunk, error) {
+	ss := strings.Split(strings.TrimSuffix(line, "\n"), " ")
+	if len(ss) < 2 {
+		return nil, pkt.SyntaxError("cannot split ls-refs line: " + line)
+	}
+	c := &LsRefsResponseChunk{ObjectID: ss[0], RefName: ss[1]}
+	if ss[0] == "unborn" {
+		c.ObjectID, c.Unborn = "", true
+	}
+	for _, attr := range ss[2:] {
+		if v, ok := strings.CutPrefix(attr, "symref-target:"); ok {
+			c.SymrefTarget = v
+		} else if v, ok := strings.CutPrefix(attr, "peeled:"); ok {
+			c.PeeledObjectID = v
+		} else {
+			return nil, pkt.SyntaxError("unknown ref attribute: " + attr)
+		}
+	}
+	return c, nil
+}
+
+// ParseRef parses a line of an ls-refs response. The ObjectID of an unborn
+// ref is empty.
+func ParseRef(line []byte) (pkt.Ref, error) {
+	c, err := parseLsRefsLine(string(line))
+	if err != nil {
+		return pkt.Ref{}, err
+	}
+	return c.Ref(), nil
+}
+
+// LsRefsResponse provides an interface for reading a protocol v2 ls-refs
+// response.
+type LsRefsResponse struct {
+	scanner *pkt.PacketScanner
+	cfg     *pkt.Config
+	state   LsRefsResponseState
+	err     error
+	curr    *LsRefsResponseChunk
+}
+
+// NewLsRefsResponse returns a new LsRefsResponse to read from rd.
+func NewLsRefsResponse(rd io.Reader, opts ...pkt.Option) *LsRefsResponse {
+	return &LsRefsResponse{scanner: pkt.NewPacketScanner(rd, opts...), cfg: pkt.NewConfig(opts...)}
+}
+
+// Err returns the first non-EOF error that was encountered by the
+// LsRefsResponse.
+func (r *LsRefsResponse) Err() error {
+	return r.err
+}
+
+// Chunk returns the most recent chunk generated by a call to Scan.
+func (r *LsRefsResponse) Chunk() *LsRefsResponseChunk {
+	return r.curr
+}
+
+// Reset prepares r to read the next document of its input, e.g. the
+// response following an advertisement in a capture of a stateless HTTP
+// exchange, reusing its buffers. See PacketScanner.Reset.
+func (r *LsRefsResponse) Reset() {
+	r.scanner.Reset()
+	*r = LsRefsResponse{scanner: r.scanner, cfg: r.cfg}
+}
+
+// Rest returns the unread part of the input, e.g. to continue with the next
+// exchange of a stateful connection once Scan returned false at the end of
+// the ls-refs response. r must not be used afterwards.
+func (r *LsRefsResponse) Rest() io.Reader {
+	return r.scanner.Rest()
+}
+
+// Scan advances the scanner to the next packet. It returns false when the scan
+// stops, either by reaching the end of the input or an error. After scan
+// returns false, the Err method will return any error that occurred during
+// scanning, except that if it was io.EOF, Err will return nil.
+// A malformed stream is reported as a ParseError.
+func (r *LsRefsResponse) Scan() bool {
+	from := r.state
+	ok := r.scan()
+	r.scanner.TraceStateTransition(from, r.state)
+	if ok {
+		return true
+	}
+	r.err = r.scanner.NewParseError(r.err, r.state)
+	return false
+}
+
+func (r *LsRefsResponse) scan() bool {
+	if r.err != nil || r.state == LsRefsResponseEnd {
+		return false
+	}
+	if !r.scanner.Scan() {
+		r.err = r.scanner.Err()
+		if r.err == nil {
+			r.err = pkt.SyntaxError("early EOF")
+		}
+		return false
+	}
+
+	switch p := r.scanner.Packet().(type) {
+	case pkt.FlushPacket:
+		r.state = LsRefsResponseEnd
+		r.curr = &LsRefsResponseChunk{
+			EndOfResponse: true,
+		}
+		return true
+	case pkt.BytesPacket:
+		c, err := parseLsRefsLine(string(p))
+		if err != nil {
+			r.err = err
+			return false
+		}
+		var ids []string
+		if !c.Unborn {
+			ids = append(ids, c.ObjectID)
+		}
+		if c.PeeledObjectID != "" {
+			ids = append(ids, c.PeeledObjectID)
+		}
+		if r.err = r.cfg.CheckObjectIDs(ids...); r.err != nil {
+			return false
+		}
+		if r.err = r.cfg.CheckRefName(c.RefName); r.err != nil {
+			return false
+		}
+		if c.SymrefTarget != "" {
+			if r.err = r.cfg.CheckRefName(c.SymrefTarget); r.err != nil {
+				return false
+			}
+		}
+		r.state = LsRefsResponseScanRefs
+		r.curr = c
+		return true
+	default:
+		r.err = pkt.SyntaxError(fmt.Sprintf("unexpected packet: %#v", p))
+		return false
+	}
+}
+
+// ReadRefs reads an ls-refs response from rd.
+func ReadRefs(rd io.Reader, opts ...pkt.Option) (pkt.Refs, error) {
+	var refs pkt.Refs
+	r := NewLsRefsResponse(rd, opts...)
+	for r.Scan() {
+		if c := r.Chunk(); !c.EndOfResponse {
+			refs = append(refs, c.Ref())
+		}
+	}
+	if err := r.Err(); err != nil {
+		return nil, err
+	}
+	return refs, nil
+}
+
+// FilterLsRefs returns a ChunkTransformer removing the refs hidden by f from
+// the chunks of an LsRefsResponse, to be used with pkt.TransformChunks. The
+// symref target of a visible ref is removed when the target is hidden.
+func FilterLsRefs(f *pkt.RefFilter) pkt.ChunkTransformer[*LsRefsResponseChunk] {
+	return func(c *LsRefsResponseChunk) ([]*LsRefsResponseChunk, error) {
+		if c.RefName == "" {
+			return []*LsRefsResponseChunk{c}, nil
+		}
+		if f.Hidden(c.RefName) {
+			return nil, nil
+		}
+		if c.SymrefTarget != "" && f.Hidden(c.SymrefTarget) {
+			rc := *c
+			rc.SymrefTarget = ""
+			return []*LsRefsResponseChunk{&rc}, nil
+		}
+		return []*LsRefsResponseChunk{c}, nil
+	}
+}
@@ -0,0 +1,184 @@
+// Modified by Giacomo Tartari
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkt
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/cycloidio/pkt-line"
+	"github.com/google/go-cmp/cmp"
+)
+
+// pktLines encodes lines as pkt-lines, except "0000" and "0001" which are
+// the flush and delim packets.
+func pktLines(lines ...string) string {
+	var b strings.Builder
+	for _, l := range lines {
+		if l == "0000" || l == "0001" {
+			b.WriteString(l)
+			continue
+		}
+		fmt.Fprintf(&b, "%04x%s", len(l)+4, l)
+	}
+	return b.String()
+}
+
+var (
+	oid1 = strings.Repeat("1", 40)
+	oid2 = strings.Repeat("2", 40)
+)
+
+func isSyntaxError(err error) bool {
+	var se pkt.SyntaxError
+	return errors.As(err, &se)
+}
+
+func TestLsRefsResponse(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want []*LsRefsResponseChunk
+		err  bool
+	}{
+		{
+			name: "refs",
+			in: pktLines(
+				oid1+" HEAD symref-target:refs/heads/main\n",
+				oid1+" refs/heads/main\n",
+				oid2+" refs/tags/v1 peeled:"+oid1+"\n",
+				"0000"),
+			want: []*LsRefsResponseChunk{
+				{ObjectID: oid1, RefName: "HEAD", SymrefTarget: "refs/heads/main"},
+				{ObjectID: oid1, RefName: "refs/heads/main"},
+				{ObjectID: oid2, RefName: "refs/tags/v1", PeeledObjectID: oid1},
+				{EndOfResponse: true},
+			},
+		},
+		{
+			name: "unborn",
+			in:   pktLines("unborn HEAD symref-target:refs/heads/main\n", "0000"),
+			want: []*LsRefsResponseChunk{
+				{Unborn: true, RefName: "HEAD", SymrefTarget: "refs/heads/main"},
+				{EndOfResponse: true},
+			},
+		},
+		{
+			name: "empty",
+			in:   pktLines("0000"),
+			want: []*LsRefsResponseChunk{{EndOfResponse: true}},
+		},
+		{
+			// The scan stops at the flush.
+			name: "trailing data",
+			in:   pktLines(oid1+" refs/heads/main\n", "0000") + "garbage",
+			want: []*LsRefsResponseChunk{
+				{ObjectID: oid1, RefName: "refs/heads/main"},
+				{EndOfResponse: true},
+			},
+		},
+		{
+			name: "no ref name",
+			in:   pktLines(oid1+"\n", "0000"),
+			err:  true,
+		},
+		{
+			name: "unknown attribute",
+			in:   pktLines(oid1+" refs/heads/main upstream:refs/heads/x\n", "0000"),
+			err:  true,
+		},
+		{
+			name: "delim",
+			in:   pktLines(oid1+" refs/heads/main\n", "0001"),
+			want: []*LsRefsResponseChunk{{ObjectID: oid1, RefName: "refs/heads/main"}},
+			err:  true,
+		},
+		{
+			name: "early EOF",
+			in:   pktLines(oid1 + " refs/heads/main\n"),
+			want: []*LsRefsResponseChunk{{ObjectID: oid1, RefName: "refs/heads/main"}},
+			err:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewLsRefsResponse(strings.NewReader(tt.in))
+			var got []*LsRefsResponseChunk
+			for r.Scan() {
+				got = append(got, r.Chunk())
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("chunks mismatch (-want +got):\n%s", diff)
+			}
+			err := r.Err()
+			if tt.err {
+				if !isSyntaxError(err) {
+					t.Errorf("got error %v, want a SyntaxError", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			var enc strings.Builder
+			for _, c := range got {
+				if err := c.Validate(); err != nil {
+					t.Errorf("Validate(%+v) = %v", c, err)
+				}
+				enc.Write(c.EncodeToPktLine())
+			}
+			if want := strings.TrimSuffix(tt.in, "garbage"); enc.String() != want {
+				t.Errorf("encoded %q, want %q", enc.String(), want)
+			}
+		})
+	}
+}
+
+func TestLsRefsResponse_checks(t *testing.T) {
+	for _, in := range []string{
+		pktLines(oid1[:39]+" refs/heads/main\n", "0000"),
+		pktLines(oid1+" refs/tags/v1 peeled:"+oid2[:39]+"\n", "0000"),
+		pktLines(oid1+" refs/heads/a..b\n", "0000"),
+		pktLines(oid1+" HEAD symref-target:refs/heads/a..b\n", "0000"),
+	} {
+		if _, err := ReadRefs(strings.NewReader(in)); err != nil {
+			t.Errorf("ReadRefs(%q) = %v", in, err)
+		}
+		if _, err := ReadRefs(strings.NewReader(in), pkt.WithStrictMode()); err == nil {
+			t.Errorf("ReadRefs(%q) succeeded in strict mode", in)
+		}
+	}
+}
+
+func TestReadRefs(t *testing.T) {
+	in := pktLines(
+		"unborn HEAD symref-target:refs/heads/main\n",
+		oid2+" refs/tags/v1 peeled:"+oid1+"\n",
+		"0000")
+	got, err := ReadRefs(strings.NewReader(in))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := pkt.Refs{
+		{Name: "HEAD", SymrefTarget: "refs/heads/main"},
+		{Name: "refs/tags/v1", ObjectID: pkt.ObjectID(oid2), Peeled: pkt.ObjectID(oid1)},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("refs mismatch (-want +got):\n%s", diff)
+	}
+}
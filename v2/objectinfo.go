@@ -0,0 +1,276 @@
+// Modified by Giacomo Tartari
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkt
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/cycloidio/pkt-line"
+)
+
+// ObjectInfoSize is the size attribute of the object-info command, the only
+// one git supports.
+const ObjectInfoSize = "size"
+
+// ObjectInfoArguments returns the arguments of an object-info command
+// querying attrs of oids, e.g. ObjectInfoArguments(oids, ObjectInfoSize).
+func ObjectInfoArguments(oids []string, attrs ...string) []string {
+	args := append([]string(nil), attrs...)
+	for _, oid := range oids {
+		args = append(args, "oid "+oid)
+	}
+	return args
+}
+
+// ParseObjectInfoArguments splits the arguments of an object-info command,
+// without their trailing LF, into the requested attributes and object IDs.
+func ParseObjectInfoArguments(args []string) (attrs, oids []string, err error) {
+	for _, arg := range args {
+		if oid, ok := strings.CutPrefix(arg, "oid "); ok {
+			oids = append(oids, oid)
+			continue
+		}
+		if arg == "" || strings.Contains(arg, " ") {
+			return nil, nil, pkt.SyntaxError(fmt.Sprintf("unexpected object-info argument: %#v", arg))
+		}
+		attrs = append(attrs, arg)
+	}
+	return attrs, oids, nil
+}
+
+type ObjectInfoResponseState int
+
+const (
+	ObjectInfoResponseBegin ObjectInfoResponseState = iota
+	ObjectInfoResponseScanObjects
+	ObjectInfoResponseEnd
+)
+
+// ObjectInfoResponseChunk is a chunk of an object-info response: the line
+// of the requested attributes, then a line per object with their values.
+// Like git, the lines are encoded without LF.
+type ObjectInfoResponseChunk struct {
+	Attributes []string
+	ObjectID   string
+	// Values are the values of the attributes for ObjectID. A missing
+	// object has empty values.
+	Values        []string
+	EndOfResponse bool
+}
+
+// Equal reports whether c and o have the same fields.
+func (c *ObjectInfoResponseChunk) Equal(o *ObjectInfoResponseChunk) bool {
+	if c == nil || o == nil {
+		return c == o
+	}
+	return slices.Equal(c.Attributes, o.Attributes) &&
+		c.ObjectID == o.ObjectID &&
+		slices.Equal(c.Values, o.Values) &&
+		c.EndOfResponse == o.EndOfResponse
+}
+
+// Validate checks that the chunk can be encoded.
+func (c *ObjectInfoResponseChunk) Validate() error {
+	v := pkt.NewChunkValidator("ObjectInfoResponseChunk")
+	v.Kind("Attributes", len(c.Attributes) != 0)
+	v.Kind("ObjectID", c.ObjectID != "")
+	v.Kind("EndOfResponse", c.EndOfResponse)
+	if c.ObjectID == "" && len(c.Values) != 0 {
+		v.Fail("Values are set without ObjectID")
+	}
+	v.Line("ObjectID", c.ObjectID)
+	for _, s := range append(slices.Clone(c.Attributes), c.Values...) {
+		if strings.Contains(s, " ") {
+			v.Fail(fmt.Sprintf("attribute or value contains a space: %q", s))
+		}
+		v.Line("Attributes or Values", s)
+	}
+	return v.Err()
+}
+
+// EncodeToPktLine serializes the chunk.
+func (c *ObjectInfoResponseChunk) EncodeToPktLine() []byte {
+	if len(c.Attributes) != 0 {
+		return pkt.StringPacket(strings.Join(c.Attributes, " ")).EncodeToPktLine()
+	}
+	if c.ObjectID != "" {
+		return pkt.StringPacket(strings.Join(append([]string{c.ObjectID}, c.Values...), " ")).EncodeToPktLine()
+	}
+	if c.EndOfResponse {
+		return pkt.FlushPacket{}.EncodeToPktLine()
+	}
+	panic("impossible chunk")
+}
+
+// ObjectInfoResponse provides an interface for reading a protocol v2
+// object-info response. A response without the line of the attributes, or
+// with a size that is not a number, is reported as a SyntaxError.
+type ObjectInfoResponse struct {
+	scanner *pkt.PacketScanner
+	cfg     *pkt.Config
+	state   ObjectInfoResponseState
+	attrs   []string
+	err     error
+	curr    *ObjectInfoResponseChunk
+}
+
+// NewObjectInfoResponse returns a new ObjectInfoResponse to read from rd.
+func NewObjectInfoResponse(rd io.Reader, opts ...pkt.Option) *ObjectInfoResponse {
+	return &ObjectInfoResponse{scanner: pkt.NewPacketScanner(rd, opts...), cfg: pkt.NewConfig(opts...)}
+}
+
+// Err returns the first non-EOF error that was encountered by the
+// ObjectInfoResponse.
+func (r *ObjectInfoResponse) Err() error {
+	return r.err
+}
+
+// Chunk returns the most recent chunk generated by a call to Scan.
+func (r *ObjectInfoResponse) Chunk() *ObjectInfoResponseChunk {
+	return r.curr
+}
+
+// Reset prepares r to read the next document of its input, e.g. the
+// response following an advertisement in a capture of a stateless HTTP
+// exchange, reusing its buffers. See PacketScanner.Reset.
+func (r *ObjectInfoResponse) Reset() {
+	r.scanner.Reset()
+	*r = ObjectInfoResponse{scanner: r.scanner, cfg: r.cfg}
+}
+
+// Rest returns the unread part of the input, e.g. to continue with the next
+// exchange of a stateful connection once Scan returned false at the end of
+// the object-info response. r must not be used afterwards.
+func (r *ObjectInfoResponse) Rest() io.Reader {
+	return r.scanner.Rest()
+}
+
+// Attributes returns the attributes of the response, once read.
+func (r *ObjectInfoResponse) Attributes() []string {
+	return r.attrs
+}
+
+// Scan advances the scanner to the next packet. It returns false when the scan
+// stops, either by reaching the end of the input or an error. After scan
+// returns false, the Err method will return any error that occurred during
+// scanning, except that if it was io.EOF, Err will return nil.
+// A malformed stream is reported as a ParseError.
+func (r *ObjectInfoResponse) Scan() bool {
+	from := r.state
+	ok := r.scan()
+	r.scanner.TraceStateTransition(from, r.state)
+	if ok {
+		return true
+	}
+	r.err = r.scanner.NewParseError(r.err, r.state)
+	return false
+}
+
+func (r *ObjectInfoResponse) scan() bool {
+	if r.err != nil || r.state == ObjectInfoResponseEnd {
+		return false
+	}
+	if !r.scanner.Scan() {
+		r.err = r.scanner.Err()
+		if r.err == nil {
+			r.err = pkt.SyntaxError("early EOF")
+		}
+		return false
+	}
+
+	switch p := r.scanner.Packet().(type) {
+	case pkt.FlushPacket:
+		r.state = ObjectInfoResponseEnd
+		r.curr = &ObjectInfoResponseChunk{
+			EndOfResponse: true,
+		}
+		return true
+	case pkt.BytesPacket:
+		ss := strings.Split(strings.TrimSuffix(string(p), "\n"), " ")
+		if r.state == ObjectInfoResponseBegin {
+			if pkt.ObjectFormatOf(pkt.ObjectID(ss[0])).ValidateObjectID(ss[0]) == nil {
+				r.err = pkt.SyntaxError(fmt.Sprintf("missing object-info attributes before: %#v", string(p)))
+				return false
+			}
+			r.state = ObjectInfoResponseScanObjects
+			r.attrs = ss
+			r.curr = &ObjectInfoResponseChunk{
+				Attributes: ss,
+			}
+			return true
+		}
+		if len(ss) != len(r.attrs)+1 {
+			r.err = pkt.SyntaxError(fmt.Sprintf("unexpected object-info line: %#v", string(p)))
+			return false
+		}
+		if r.err = r.cfg.CheckObjectIDs(ss[0]); r.err != nil {
+			return false
+		}
+		for i, a := range r.attrs {
+			// The size of a missing object is empty.
+			if a == ObjectInfoSize && ss[i+1] != "" {
+				if _, err := strconv.ParseUint(ss[i+1], 10, 63); err != nil {
+					r.err = pkt.SyntaxError(fmt.Sprintf("unexpected object size: %#v", ss[i+1]))
+					return false
+				}
+			}
+		}
+		r.curr = &ObjectInfoResponseChunk{
+			ObjectID: ss[0],
+			Values:   ss[1:],
+		}
+		return true
+	default:
+		r.err = pkt.SyntaxError(fmt.Sprintf("unexpected packet: %#v", p))
+		return false
+	}
+}
+
+// Size returns the size attribute of an object line, and false if the
+// object is missing or the size was not requested.
+func (r *ObjectInfoResponse) Size() (int64, bool) {
+	c := r.curr
+	if c == nil || c.ObjectID == "" {
+		return 0, false
+	}
+	for i, a := range r.attrs {
+		if a == ObjectInfoSize && i < len(c.Values) {
+			sz, err := strconv.ParseInt(c.Values[i], 10, 64)
+			return sz, err == nil
+		}
+	}
+	return 0, false
+}
+
+// ReadObjectSizes reads an object-info response to a size query from rd.
+// The missing objects are not in the map.
+func ReadObjectSizes(rd io.Reader, opts ...pkt.Option) (map[pkt.ObjectID]int64, error) {
+	sizes := map[pkt.ObjectID]int64{}
+	r := NewObjectInfoResponse(rd, opts...)
+	for r.Scan() {
+		if sz, ok := r.Size(); ok {
+			sizes[pkt.ObjectID(r.Chunk().ObjectID)] = sz
+		}
+	}
+	if err := r.Err(); err != nil {
+		return nil, err
+	}
+	return sizes, nil
+}
@@ -0,0 +1,140 @@
+// Modified by Giacomo Tartari
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkt
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/cycloidio/pkt-line"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestObjectInfoArguments(t *testing.T) {
+	oids := []string{oid1, oid2}
+	args := ObjectInfoArguments(oids, ObjectInfoSize)
+	if want := []string{"size", "oid " + oid1, "oid " + oid2}; !cmp.Equal(args, want) {
+		t.Errorf("got arguments %q, want %q", args, want)
+	}
+	attrs, gotOids, err := ParseObjectInfoArguments(args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cmp.Equal(attrs, []string{ObjectInfoSize}) || !cmp.Equal(gotOids, oids) {
+		t.Errorf("got attributes %q and object IDs %q", attrs, gotOids)
+	}
+	for _, arg := range []string{"", "size type"} {
+		if _, _, err := ParseObjectInfoArguments([]string{arg}); !isSyntaxError(err) {
+			t.Errorf("ParseObjectInfoArguments(%q) = %v, want a SyntaxError", arg, err)
+		}
+	}
+}
+
+func TestObjectInfoResponse(t *testing.T) {
+	tests := []struct {
+		name  string
+		in    string
+		want  []*ObjectInfoResponseChunk
+		sizes map[pkt.ObjectID]int64
+		err   bool
+	}{
+		{
+			name: "sizes",
+			in:   pktLines("size", oid1+" 42", oid2+" ", "0000"),
+			want: []*ObjectInfoResponseChunk{
+				{Attributes: []string{"size"}},
+				{ObjectID: oid1, Values: []string{"42"}},
+				{ObjectID: oid2, Values: []string{""}},
+				{EndOfResponse: true},
+			},
+			sizes: map[pkt.ObjectID]int64{pkt.ObjectID(oid1): 42},
+		},
+		{
+			name:  "no objects",
+			in:    pktLines("size", "0000"),
+			want:  []*ObjectInfoResponseChunk{{Attributes: []string{"size"}}, {EndOfResponse: true}},
+			sizes: map[pkt.ObjectID]int64{},
+		},
+		{
+			name: "malformed size",
+			in:   pktLines("size", oid1+" 4k", "0000"),
+			want: []*ObjectInfoResponseChunk{{Attributes: []string{"size"}}},
+			err:  true,
+		},
+		{
+			name: "negative size",
+			in:   pktLines("size", oid1+" -1", "0000"),
+			want: []*ObjectInfoResponseChunk{{Attributes: []string{"size"}}},
+			err:  true,
+		},
+		{
+			name: "missing value",
+			in:   pktLines("size", oid1, "0000"),
+			want: []*ObjectInfoResponseChunk{{Attributes: []string{"size"}}},
+			err:  true,
+		},
+		{
+			name: "missing attributes",
+			in:   pktLines(oid1+" 42", "0000"),
+			err:  true,
+		},
+		{
+			name: "delim",
+			in:   pktLines("size", "0001"),
+			want: []*ObjectInfoResponseChunk{{Attributes: []string{"size"}}},
+			err:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewObjectInfoResponse(strings.NewReader(tt.in))
+			var got []*ObjectInfoResponseChunk
+			for r.Scan() {
+				got = append(got, r.Chunk())
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("chunks mismatch (-want +got):\n%s", diff)
+			}
+			sizes, err := ReadObjectSizes(strings.NewReader(tt.in))
+			if tt.err {
+				if !isSyntaxError(r.Err()) || !isSyntaxError(err) {
+					t.Errorf("got errors %v and %v, want SyntaxErrors", r.Err(), err)
+				}
+				return
+			}
+			if err := r.Err(); err != nil {
+				t.Fatal(err)
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(tt.sizes, sizes); diff != "" {
+				t.Errorf("sizes mismatch (-want +got):\n%s", diff)
+			}
+			// The chunks encode back to the input.
+			var enc strings.Builder
+			for _, c := range got {
+				if err := c.Validate(); err != nil {
+					t.Errorf("Validate(%+v) = %v", c, err)
+				}
+				enc.Write(c.EncodeToPktLine())
+			}
+			if enc.String() != tt.in {
+				t.Errorf("encoded %q, want %q", enc.String(), tt.in)
+			}
+		})
+	}
+}
@@ -0,0 +1,255 @@
+// Modified by Giacomo Tartari
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkt
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/cycloidio/pkt-line"
+)
+
+// PackfileURI is a line of the packfile-uris section of a fetch response: a
+// pack file the client downloads, e.g. from a CDN, in addition to the inline
+// pack file. Hash is the checksum at the end of the pack file.
+type PackfileURI struct {
+	Hash string
+	URI  string
+}
+
+// PackfileURI returns the packfile URI of the current chunk, and false if it
+// is not a line of the packfile-uris section.
+func (r *FetchResponse) PackfileURI() (PackfileURI, bool) {
+	c := r.curr
+	if c == nil || c.PackfileURI == "" {
+		return PackfileURI{}, false
+	}
+	return PackfileURI{Hash: c.PackfileHash, URI: c.PackfileURI}, true
+}
+
+// Chunk returns the packfile-uris line of the fetch response.
+func (u PackfileURI) Chunk() *FetchResponseChunk {
+	return &FetchResponseChunk{
+		Section:      SectionPackfileURIs,
+		PackfileHash: u.Hash,
+		PackfileURI:  u.URI,
+	}
+}
+
+// PackfileDownloader downloads the pack files of the packfile-uris section
+// of a fetch response. An interrupted download is resumed with a Range
+// request, and the checksum of the downloaded pack file is checked against
+// the hash of the line.
+type PackfileDownloader struct {
+	// HTTP is the client sending the requests, http.DefaultClient if nil.
+	HTTP *http.Client
+	// Header is added to the requests, e.g. for authorization.
+	Header http.Header
+	// Retries is the number of times an interrupted download is resumed.
+	Retries int
+}
+
+// Open starts the download of the pack file of u. The returned reader must
+// be closed.
+func (d *PackfileDownloader) Open(ctx context.Context, u PackfileURI) (io.ReadCloser, error) {
+	if n := len(u.Hash); n != 40 && n != 64 {
+		return nil, pkt.SyntaxError(fmt.Sprintf("unexpected packfile hash: %#v", u.Hash))
+	}
+	p := &packfileDownload{d: d, ctx: ctx, u: u}
+	if err := p.get(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// ReadPackfiles scans the rest of r and calls fn with each pack file of the
+// response: first the inline pack file, with an empty PackfileURI, then the
+// pack files of the packfile-uris section, in order. The pack file given to fn
+// is only valid during the call, and what fn does not read is discarded.
+func (d *PackfileDownloader) ReadPackfiles(ctx context.Context, r *FetchResponse, fn func(PackfileURI, io.Reader) error) error {
+	var uris []PackfileURI
+	for r.Scan() {
+		c := r.Chunk()
+		if u, ok := r.PackfileURI(); ok {
+			uris = append(uris, u)
+			continue
+		}
+		if c.Section == SectionPackfile && c.SectionHeader {
+			pr := r.PackReader()
+			if err := fn(PackfileURI{}, pr); err != nil {
+				return err
+			}
+			if _, err := io.Copy(io.Discard, pr); err != nil {
+				return err
+			}
+			break
+		}
+	}
+	if err := r.Err(); err != nil {
+		return err
+	}
+	for _, u := range uris {
+		if err := d.readPackfile(ctx, u, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *PackfileDownloader) readPackfile(ctx context.Context, u PackfileURI, fn func(PackfileURI, io.Reader) error) error {
+	rc, err := d.Open(ctx, u)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	if err := fn(u, rc); err != nil {
+		return err
+	}
+	_, err = io.Copy(io.Discard, rc)
+	return err
+}
+
+// fetchPackReader reads the pack data of the packfile section of a fetch
+// response, up to the flush packet ending it.
+type fetchPackReader struct {
+	r    *FetchResponse
+	buf  []byte
+	done bool
+}
+
+func (p *fetchPackReader) Read(b []byte) (int, error) {
+	for len(p.buf) == 0 {
+		if p.done {
+			return 0, io.EOF
+		}
+		if !p.r.Scan() {
+			if err := p.r.Err(); err != nil {
+				return 0, err
+			}
+			return 0, io.ErrUnexpectedEOF
+		}
+		c := p.r.Chunk()
+		p.buf = c.PackData
+		p.done = c.EndOfResponse
+	}
+	n := copy(b, p.buf)
+	p.buf = p.buf[n:]
+	return n, nil
+}
+
+// packfileDownload is the body of a pack file download, resumed after an
+// error and checked at the end.
+type packfileDownload struct {
+	d       *PackfileDownloader
+	ctx     context.Context
+	u       PackfileURI
+	body    io.ReadCloser
+	n       int64
+	retries int
+	// tail is the end of the data read so far, the checksum once done.
+	tail []byte
+	err  error
+}
+
+// get requests the pack file from offset n.
+func (p *packfileDownload) get() error {
+	req, err := http.NewRequestWithContext(p.ctx, http.MethodGet, p.u.URI, nil)
+	if err != nil {
+		return err
+	}
+	for k, vs := range p.d.Header {
+		for _, v := range vs {
+			req.Header.Add(k, v)
+		}
+	}
+	if p.n > 0 {
+		req.Header.Set("Range", "bytes="+strconv.FormatInt(p.n, 10)+"-")
+	}
+	hc := p.d.HTTP
+	if hc == nil {
+		hc = http.DefaultClient
+	}
+	resp, err := hc.Do(req)
+	if err != nil {
+		return err
+	}
+	switch {
+	case resp.StatusCode == http.StatusOK:
+		// The server ignored the range, skip what was already read.
+		if _, err := io.CopyN(io.Discard, resp.Body, p.n); err != nil {
+			resp.Body.Close()
+			return err
+		}
+	case resp.StatusCode == http.StatusPartialContent && p.n > 0:
+	default:
+		resp.Body.Close()
+		return fmt.Errorf("packfile-uri %s: %s", p.u.URI, resp.Status)
+	}
+	p.body = resp.Body
+	return nil
+}
+
+func (p *packfileDownload) Read(b []byte) (int, error) {
+	for p.err == nil {
+		n, err := p.body.Read(b)
+		p.n += int64(n)
+		p.keepTail(b[:n])
+		if err == nil || n > 0 {
+			return n, nil
+		}
+		if err == io.EOF {
+			p.err = p.check()
+			break
+		}
+		if p.retries >= p.d.Retries || p.ctx.Err() != nil {
+			p.err = err
+			break
+		}
+		p.retries++
+		p.body.Close()
+		if gerr := p.get(); gerr != nil {
+			p.err = errors.Join(err, gerr)
+			p.body = io.NopCloser(nil)
+		}
+	}
+	return 0, p.err
+}
+
+// keepTail keeps the last bytes of the pack file, the size of the checksum.
+func (p *packfileDownload) keepTail(b []byte) {
+	size := len(p.u.Hash) / 2
+	p.tail = append(p.tail, b...)
+	if len(p.tail) > size {
+		p.tail = append(p.tail[:0], p.tail[len(p.tail)-size:]...)
+	}
+}
+
+// check compares the checksum of the pack file with the hash of the line.
+func (p *packfileDownload) check() error {
+	if got := hex.EncodeToString(p.tail); got != p.u.Hash {
+		return fmt.Errorf("packfile-uri %s: checksum %s, want %s", p.u.URI, got, p.u.Hash)
+	}
+	return io.EOF
+}
+
+func (p *packfileDownload) Close() error {
+	return p.body.Close()
+}
@@ -0,0 +1,260 @@
+// Modified by Giacomo Tartari
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkt
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/cycloidio/pkt-line"
+	"github.com/google/go-cmp/cmp"
+)
+
+// testPack returns a fake pack file of n bytes and its checksum.
+func testPack(t *testing.T, n int) ([]byte, string) {
+	data := make([]byte, n-sha1.Size)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatal(err)
+	}
+	copy(data, "PACK")
+	sum := sha1.Sum(data)
+	return append(data, sum[:]...), hex.EncodeToString(sum[:])
+}
+
+// packServer serves pack, cutting the first cuts responses in the middle
+// of the body. It honors the Range requests if resume is set.
+type packServer struct {
+	pack   []byte
+	cuts   int
+	resume bool
+	// status replaces the responses after the cuts, if set.
+	status int
+
+	mu sync.Mutex
+	// ranges are the Range headers of the requests.
+	ranges []string
+}
+
+func (s *packServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	s.ranges = append(s.ranges, r.Header.Get("Range"))
+	n := len(s.ranges)
+	s.mu.Unlock()
+	if r.Header.Get("Authorization") != "Bearer token" {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if n > s.cuts && s.status != 0 {
+		http.Error(w, "failed", s.status)
+		return
+	}
+	body := s.pack
+	if rg := r.Header.Get("Range"); rg != "" && s.resume {
+		off, _ := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(rg, "bytes="), "-"))
+		body = body[off:]
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", off, len(s.pack)-1, len(s.pack)))
+		w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+		w.WriteHeader(http.StatusPartialContent)
+	} else {
+		w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	}
+	if n <= s.cuts {
+		w.Write(body[:len(body)/2])
+		w.(http.Flusher).Flush()
+		panic(http.ErrAbortHandler)
+	}
+	w.Write(body)
+}
+
+func TestPackfileDownloader(t *testing.T) {
+	pack, hash := testPack(t, 64*1024)
+	half := strconv.Itoa(len(pack) / 2)
+	tests := []struct {
+		name    string
+		srv     *packServer
+		retries int
+		hash    string
+		ranges  []string
+		err     string
+	}{
+		{
+			name:   "complete",
+			srv:    &packServer{},
+			ranges: []string{""},
+		},
+		{
+			name:    "resumed",
+			srv:     &packServer{cuts: 1, resume: true},
+			retries: 1,
+			ranges:  []string{"", "bytes=" + half + "-"},
+		},
+		{
+			// The server ignores the range, the resumed download skips
+			// what was already read.
+			name:    "resumed from the start",
+			srv:     &packServer{cuts: 2},
+			retries: 2,
+			ranges:  []string{"", "bytes=" + half + "-", "bytes=" + half + "-"},
+		},
+		{
+			name:    "out of retries",
+			srv:     &packServer{cuts: 3, resume: true},
+			retries: 2,
+			ranges:  []string{"", "bytes=" + half + "-", "bytes=" + strconv.Itoa(len(pack)*3/4) + "-"},
+			err:     "unexpected EOF",
+		},
+		{
+			name:    "failed resume",
+			srv:     &packServer{cuts: 1, resume: true, status: http.StatusServiceUnavailable},
+			retries: 1,
+			ranges:  []string{"", "bytes=" + half + "-"},
+			err:     "unexpected EOF\npackfile-uri %s: 503 Service Unavailable",
+		},
+		{
+			name:   "checksum mismatch",
+			srv:    &packServer{},
+			hash:   strings.Repeat("0", 40),
+			ranges: []string{""},
+			err:    "packfile-uri %s: checksum " + hash + ", want " + strings.Repeat("0", 40),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.srv.pack = pack
+			ts := httptest.NewServer(tt.srv)
+			defer ts.Close()
+			d := &PackfileDownloader{
+				HTTP:    ts.Client(),
+				Header:  http.Header{"Authorization": {"Bearer token"}},
+				Retries: tt.retries,
+			}
+			u := PackfileURI{Hash: hash, URI: ts.URL + "/p.pack"}
+			if tt.hash != "" {
+				u.Hash = tt.hash
+			}
+			rc, err := d.Open(context.Background(), u)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := io.ReadAll(rc)
+			if tt.err != "" {
+				if want := strings.ReplaceAll(tt.err, "%s", u.URI); err == nil || err.Error() != want {
+					t.Errorf("got error %v, want %q", err, want)
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			} else if !bytes.Equal(got, pack) {
+				t.Errorf("got %d bytes, not the pack file of %d bytes", len(got), len(pack))
+			}
+			if err := rc.Close(); err != nil {
+				t.Errorf("Close() = %v", err)
+			}
+			if diff := cmp.Diff(tt.ranges, tt.srv.ranges); diff != "" {
+				t.Errorf("ranges mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestPackfileDownloader_Open(t *testing.T) {
+	ts := httptest.NewServer(&packServer{})
+	defer ts.Close()
+	d := &PackfileDownloader{HTTP: ts.Client()}
+	if _, err := d.Open(context.Background(), PackfileURI{Hash: "abc", URI: ts.URL}); !isSyntaxError(err) {
+		t.Errorf("got error %v for a bad hash, want a SyntaxError", err)
+	}
+	want := "packfile-uri " + ts.URL + ": 401 Unauthorized"
+	if _, err := d.Open(context.Background(), PackfileURI{Hash: oid1, URI: ts.URL}); err == nil || err.Error() != want {
+		t.Errorf("got error %v, want %q", err, want)
+	}
+}
+
+func TestPackfileDownloader_ReadPackfiles(t *testing.T) {
+	pack, hash := testPack(t, 1024)
+	ts := httptest.NewServer(&packServer{pack: pack})
+	defer ts.Close()
+	d := &PackfileDownloader{HTTP: ts.Client(), Header: http.Header{"Authorization": {"Bearer token"}}}
+	u := PackfileURI{Hash: hash, URI: ts.URL + "/p.pack"}
+	in := pktLines(
+		"packfile-uris\n", hash+" "+u.URI+"\n", "0001",
+		"packfile\n", "\x01PA", "\x02progress\n", "\x01", "\x01CK", "0000")
+
+	var uris []PackfileURI
+	var packs [][]byte
+	err := d.ReadPackfiles(context.Background(), NewFetchResponse(strings.NewReader(in)), func(u PackfileURI, r io.Reader) error {
+		b, err := io.ReadAll(r)
+		uris = append(uris, u)
+		packs = append(packs, b)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]PackfileURI{{}, u}, uris); diff != "" {
+		t.Errorf("packfile URIs mismatch (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff([][]byte{[]byte("PACK"), pack}, packs); diff != "" {
+		t.Errorf("packs mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestFetchResponse_PackReader(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+		err  error
+	}{
+		{
+			name: "progress and keepalives",
+			in:   pktLines("packfile\n", "\x02counting\n", "\x01PA", "\x01", "\x02compressing\n", "\x01CK", "\x01", "0000"),
+			want: "PACK",
+		},
+		{
+			name: "error band",
+			in:   pktLines("packfile\n", "\x01PA", "\x03disk full", "\x01CK", "0000"),
+			want: "PA",
+			err:  pkt.SideBandError("disk full"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewFetchResponse(strings.NewReader(tt.in))
+			if !r.Scan() || !r.Chunk().SectionHeader {
+				t.Fatalf("no section header: %v", r.Err())
+			}
+			got, err := io.ReadAll(r.PackReader())
+			if string(got) != tt.want {
+				t.Errorf("got pack %q, want %q", got, tt.want)
+			}
+			if !errors.Is(err, tt.err) {
+				t.Errorf("got error %v, want %v", err, tt.err)
+			}
+		})
+	}
+}
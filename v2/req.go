@@ -17,6 +17,7 @@ package pkt
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"io"
 	"strings"
@@ -43,6 +44,33 @@ type RequestChunk struct {
 	EndRequest    bool
 }
 
+// Equal reports whether c and o have the same fields.
+func (c *RequestChunk) Equal(o *RequestChunk) bool {
+	if c == nil || o == nil {
+		return c == o
+	}
+	return c.Command == o.Command &&
+		c.Capability == o.Capability &&
+		c.EndCapability == o.EndCapability &&
+		bytes.Equal(c.Argument, o.Argument) &&
+		c.EndArgument == o.EndArgument &&
+		c.EndRequest == o.EndRequest
+}
+
+// Validate checks that the chunk can be encoded.
+func (c *RequestChunk) Validate() error {
+	v := pkt.NewChunkValidator("RequestChunk")
+	v.Kind("Command", c.Command != "")
+	v.Kind("Capability", c.Capability != "")
+	v.Kind("EndCapability", c.EndCapability)
+	v.Kind("Argument", len(c.Argument) != 0)
+	v.Kind("EndArgument, EndRequest", c.EndArgument || c.EndRequest)
+	v.Line("Command", c.Command)
+	v.Line("Capability", c.Capability)
+	v.Payload("Argument", len(c.Argument))
+	return v.Err()
+}
+
 // EncodeToPktLine serializes the chunk.
 func (c *RequestChunk) EncodeToPktLine() []byte {
 	if c.Command != "" {
@@ -66,14 +94,18 @@ func (c *RequestChunk) EncodeToPktLine() []byte {
 // Request provides an interface for reading a protocol v2 request.
 type Request struct {
 	scanner *pkt.PacketScanner
+	cfg     *pkt.Config
 	state   RequestState
 	err     error
 	curr    *RequestChunk
+
+	// objectFormat is the object-format capability of the current command.
+	objectFormat pkt.ObjectFormat
 }
 
 // NewRequest returns a new ProtocolV2Request to read from rd.
-func NewRequest(rd io.Reader) *Request {
-	return &Request{scanner: pkt.NewPacketScanner(rd)}
+func NewRequest(rd io.Reader, opts ...pkt.Option) *Request {
+	return &Request{scanner: pkt.NewPacketScanner(rd, opts...), cfg: pkt.NewConfig(opts...)}
 }
 
 // Err returns the first non-EOF error that was encountered by the
@@ -90,11 +122,43 @@ func (r *Request) Chunk() *RequestChunk {
 	return r.curr
 }
 
+// Reset prepares r to read the next document of its input, e.g. the
+// response following an advertisement in a capture of a stateless HTTP
+// exchange, reusing its buffers. See PacketScanner.Reset.
+func (r *Request) Reset() {
+	r.scanner.Reset()
+	*r = Request{scanner: r.scanner, cfg: r.cfg}
+}
+
+// Rest returns the unread part of the input, e.g. to continue with the next
+// exchange of a stateful connection once Scan returned false at the end of
+// the request. r must not be used afterwards.
+func (r *Request) Rest() io.Reader {
+	return r.scanner.Rest()
+}
+
+// Chunks returns the chunks of r on a channel, see pkt.ScanChunks.
+func (r *Request) Chunks(ctx context.Context) <-chan pkt.ChunkOrError[*RequestChunk] {
+	return pkt.ScanChunks[*RequestChunk](ctx, r)
+}
+
 // Scan advances the scanner to the next packet. It returns false when the scan
 // stops, either by reaching the end of the input or an error. After scan
 // returns false, the Err method will return any error that occurred during
 // scanning, except that if it was io.EOF, Err will return nil.
+// A malformed stream is reported as a ParseError.
 func (r *Request) Scan() bool {
+	from := r.state
+	ok := r.scan()
+	r.scanner.TraceStateTransition(from, r.state)
+	if ok {
+		return true
+	}
+	r.err = r.scanner.NewParseError(r.err, r.state)
+	return false
+}
+
+func (r *Request) scan() bool {
 	if r.err != nil || r.state == RequestEnd {
 		return false
 	}
@@ -139,8 +203,12 @@ func (r *Request) Scan() bool {
 			}
 			return true
 		case pkt.BytesPacket:
+			capability := strings.TrimSuffix(string(p), "\n")
+			if v, ok := strings.CutPrefix(capability, "object-format="); ok && r.objectFormat == "" {
+				r.objectFormat = pkt.ObjectFormat(v)
+			}
 			r.curr = &RequestChunk{
-				Capability: strings.TrimSuffix(string(p), "\n"),
+				Capability: capability,
 			}
 			return true
 		default:
@@ -151,11 +219,15 @@ func (r *Request) Scan() bool {
 		switch p := packet.(type) {
 		case pkt.FlushPacket:
 			r.state = RequestBegin
+			r.objectFormat = ""
 			r.curr = &RequestChunk{
 				EndArgument: true,
 			}
 			return true
 		case pkt.BytesPacket:
+			if r.err = r.checkArgument(p); r.err != nil {
+				return false
+			}
 			r.curr = &RequestChunk{
 				Argument: p,
 			}
@@ -167,3 +239,23 @@ func (r *Request) Scan() bool {
 	}
 	panic("impossible state")
 }
+
+// checkArgument validates the object ID of a want, have or shallow argument
+// against the object format set by pkt.WithObjectFormat, or else by the
+// object-format capability of the command.
+func (r *Request) checkArgument(arg []byte) error {
+	f := r.cfg.ObjectFormat
+	if f == "" {
+		f = r.objectFormat
+	}
+	if f == "" {
+		return nil
+	}
+	s := strings.TrimSuffix(string(arg), "\n")
+	for _, prefix := range []string{"want ", "have ", "shallow "} {
+		if id, ok := strings.CutPrefix(s, prefix); ok {
+			return f.ValidateObjectID(id)
+		}
+	}
+	return nil
+}
@@ -16,6 +16,7 @@
 package pkt
 
 import (
+	"bytes"
 	"fmt"
 	"io"
 
@@ -35,6 +36,31 @@ type ResponseChunk struct {
 	Response    []byte
 	Delimiter   bool
 	EndResponse bool
+	// ResponseEnd is set for the response-end packet ending a response
+	// over a stateless connection.
+	ResponseEnd bool
+}
+
+// Equal reports whether c and o have the same fields.
+func (c *ResponseChunk) Equal(o *ResponseChunk) bool {
+	if c == nil || o == nil {
+		return c == o
+	}
+	return bytes.Equal(c.Response, o.Response) &&
+		c.Delimiter == o.Delimiter &&
+		c.EndResponse == o.EndResponse &&
+		c.ResponseEnd == o.ResponseEnd
+}
+
+// Validate checks that the chunk can be encoded.
+func (c *ResponseChunk) Validate() error {
+	v := pkt.NewChunkValidator("ResponseChunk")
+	v.Kind("Response", len(c.Response) != 0)
+	v.Kind("Delimiter", c.Delimiter)
+	v.Kind("EndResponse", c.EndResponse)
+	v.Kind("ResponseEnd", c.ResponseEnd)
+	v.Payload("Response", len(c.Response))
+	return v.Err()
 }
 
 // EncodeToPktLine serializes the chunk.
@@ -48,6 +74,9 @@ func (c *ResponseChunk) EncodeToPktLine() []byte {
 	if c.EndResponse {
 		return pkt.FlushPacket{}.EncodeToPktLine()
 	}
+	if c.ResponseEnd {
+		return pkt.ResponseEndPacket{}.EncodeToPktLine()
+	}
 	panic("impossible chunk")
 }
 
@@ -60,8 +89,8 @@ type Response struct {
 }
 
 // NewResponse returns a new ProtocolV2Response to read from rd.
-func NewResponse(rd io.Reader) *Response {
-	return &Response{scanner: pkt.NewPacketScanner(rd)}
+func NewResponse(rd io.Reader, opts ...pkt.Option) *Response {
+	return &Response{scanner: pkt.NewPacketScanner(rd, opts...)}
 }
 
 // Err returns the first non-EOF error that was encountered by the
@@ -78,11 +107,38 @@ func (r *Response) Chunk() *ResponseChunk {
 	return r.curr
 }
 
+// Reset prepares r to read the next document of its input, e.g. the
+// response following an advertisement in a capture of a stateless HTTP
+// exchange, reusing its buffers. See PacketScanner.Reset.
+func (r *Response) Reset() {
+	r.scanner.Reset()
+	*r = Response{scanner: r.scanner}
+}
+
+// Rest returns the unread part of the input, e.g. to continue with the next
+// exchange of a stateful connection once Scan returned false at the end of
+// the response. r must not be used afterwards.
+func (r *Response) Rest() io.Reader {
+	return r.scanner.Rest()
+}
+
 // Scan advances the scanner to the next packet. It returns false when the scan
 // stops, either by reaching the end of the input or an error. After scan
 // returns false, the Err method will return any error that occurred during
 // scanning, except that if it was io.EOF, Err will return nil.
+// A malformed stream is reported as a ParseError.
 func (r *Response) Scan() bool {
+	from := r.state
+	ok := r.scan()
+	r.scanner.TraceStateTransition(from, r.state)
+	if ok {
+		return true
+	}
+	r.err = r.scanner.NewParseError(r.err, r.state)
+	return false
+}
+
+func (r *Response) scan() bool {
 	if r.err != nil || r.state == ResponseEnd {
 		return false
 	}
@@ -101,6 +157,16 @@ func (r *Response) Scan() bool {
 			EndResponse: true,
 		}
 		return true
+	case pkt.ResponseEndPacket:
+		if r.state != ResponseBegin {
+			r.err = pkt.SyntaxError("response-end in the middle of a response")
+			return false
+		}
+		r.state = ResponseEnd
+		r.curr = &ResponseChunk{
+			ResponseEnd: true,
+		}
+		return true
 	case pkt.DelimPacket:
 		r.state = ResponseScanResponse
 		r.curr = &ResponseChunk{
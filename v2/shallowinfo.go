@@ -0,0 +1,54 @@
+// Modified by Giacomo Tartari
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkt
+
+import "github.com/cycloidio/pkt-line"
+
+// ShallowInfo is the shallow-info section of a fetch response, sent when the
+// fetch command has deepen arguments.
+type ShallowInfo struct {
+	// Shallow are the new shallow commits of the client.
+	Shallow []pkt.ObjectID
+	// Unshallow are the shallow commits of the client that are not shallow
+	// anymore.
+	Unshallow []pkt.ObjectID
+}
+
+// Observe records the chunk if it is a shallow or unshallow line.
+func (s *ShallowInfo) Observe(c *FetchResponseChunk) {
+	if c.ShallowObjectID != "" {
+		s.Shallow = append(s.Shallow, pkt.ObjectID(c.ShallowObjectID))
+	}
+	if c.UnshallowObjectID != "" {
+		s.Unshallow = append(s.Unshallow, pkt.ObjectID(c.UnshallowObjectID))
+	}
+}
+
+// Chunks returns the shallow-info section, from its header to the delim
+// packet ending it, or nothing if s is empty.
+func (s *ShallowInfo) Chunks() []*FetchResponseChunk {
+	if len(s.Shallow) == 0 && len(s.Unshallow) == 0 {
+		return nil
+	}
+	cs := []*FetchResponseChunk{{Section: SectionShallowInfo, SectionHeader: true}}
+	for _, oid := range s.Shallow {
+		cs = append(cs, &FetchResponseChunk{Section: SectionShallowInfo, ShallowObjectID: string(oid)})
+	}
+	for _, oid := range s.Unshallow {
+		cs = append(cs, &FetchResponseChunk{Section: SectionShallowInfo, UnshallowObjectID: string(oid)})
+	}
+	return append(cs, &FetchResponseChunk{Section: SectionShallowInfo, EndOfSection: true})
+}
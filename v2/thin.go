@@ -0,0 +1,29 @@
+// Modified by Giacomo Tartari
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkt
+
+// PackMayBeThin reports whether the pack answering a fetch command with args
+// may be thin. Thin packs are the default in protocol v2 and the client
+// disables them with the no-thin argument. A thin pack must be completed,
+// e.g. with git index-pack --fix-thin, before being stored.
+func PackMayBeThin(args []string) bool {
+	for _, a := range args {
+		if a == "no-thin" {
+			return false
+		}
+	}
+	return true
+}
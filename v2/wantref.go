@@ -0,0 +1,65 @@
+// Modified by Giacomo Tartari
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkt
+
+import (
+	"strings"
+
+	"github.com/cycloidio/pkt-line"
+)
+
+// WantRefArgument returns the want-ref argument of a fetch command asking for
+// the ref name, e.g. "refs/heads/main". The server must advertise the
+// ref-in-want fetch feature, and answers with a wanted-refs section.
+func WantRefArgument(name string) string {
+	return "want-ref " + name
+}
+
+// ParseWantRefArgument returns the ref name of a want-ref argument, without
+// its trailing LF, and false if arg is another argument.
+func ParseWantRefArgument(arg string) (string, bool) {
+	name, ok := strings.CutPrefix(arg, "want-ref ")
+	if !ok || name == "" || strings.Contains(name, " ") {
+		return "", false
+	}
+	return name, true
+}
+
+// WantedRef is a line of the wanted-refs section of a fetch response: the
+// object ID a ref asked with want-ref points to.
+type WantedRef struct {
+	Name     string
+	ObjectID pkt.ObjectID
+}
+
+// WantedRef returns the wanted ref of the current chunk, and false if it is
+// not a line of the wanted-refs section.
+func (r *FetchResponse) WantedRef() (WantedRef, bool) {
+	c := r.curr
+	if c == nil || c.WantedRefName == "" {
+		return WantedRef{}, false
+	}
+	return WantedRef{Name: c.WantedRefName, ObjectID: pkt.ObjectID(c.WantedRefObjectID)}, true
+}
+
+// Chunk returns the wanted-refs line of the fetch response.
+func (w WantedRef) Chunk() *FetchResponseChunk {
+	return &FetchResponseChunk{
+		Section:           SectionWantedRefs,
+		WantedRefObjectID: string(w.ObjectID),
+		WantedRefName:     w.Name,
+	}
+}
@@ -0,0 +1,110 @@
+// Modified by Giacomo Tartari
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkt
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Validator is implemented by the chunks that can check that they are
+// encodable. EncodeToPktLine does not call it, so that it keeps encoding
+// what it always did; Encode and the writers of this module do and return
+// the error to the caller.
+type Validator interface {
+	Validate() error
+}
+
+// InvalidChunkError is returned by Validate when a chunk cannot be encoded.
+type InvalidChunkError struct {
+	// Chunk is the name of the chunk type.
+	Chunk  string
+	Reason string
+}
+
+func (e *InvalidChunkError) Error() string {
+	return fmt.Sprintf("invalid %s: %s", e.Chunk, e.Reason)
+}
+
+// Encode validates p if it is a Validator and serializes it. A packet too
+// large to be encoded is reported as a PacketTooLargeError.
+func Encode(p Packet) ([]byte, error) {
+	if v, ok := p.(Validator); ok {
+		if err := v.Validate(); err != nil {
+			return nil, err
+		}
+	}
+	return encodeErr(p)
+}
+
+// ChunkValidator accumulates the problems of a chunk. A chunk encodes to
+// exactly one packet, so exactly one kind of field must be set.
+type ChunkValidator struct {
+	chunk string
+	kinds []string
+	err   error
+}
+
+// NewChunkValidator returns a helper for implementing Validate on a chunk
+// type named chunk. It is exported for the protocol subpackages.
+func NewChunkValidator(chunk string) *ChunkValidator {
+	return &ChunkValidator{chunk: chunk}
+}
+
+// Kind records that the fields named name are set if set is true.
+func (v *ChunkValidator) Kind(name string, set bool) {
+	if set {
+		v.kinds = append(v.kinds, name)
+	}
+}
+
+// Line checks that the string field name can be embedded in a text line.
+func (v *ChunkValidator) Line(name, s string) {
+	if strings.ContainsAny(s, "\n\x00") {
+		v.Fail(fmt.Sprintf("%s contains LF or NUL: %q", name, s))
+	}
+}
+
+// Payload checks that sz bytes fit in a single packet.
+func (v *ChunkValidator) Payload(name string, sz int) {
+	if sz > maxPayloadSize {
+		v.Fail(fmt.Sprintf("%s is too large: %d bytes", name, sz))
+	}
+}
+
+// Fail records a problem.
+func (v *ChunkValidator) Fail(reason string) {
+	if v.err == nil {
+		v.err = &InvalidChunkError{Chunk: v.chunk, Reason: reason}
+	}
+}
+
+// Err returns the first problem, if any.
+func (v *ChunkValidator) Err() error {
+	if v.err != nil {
+		return v.err
+	}
+	switch len(v.kinds) {
+	case 0:
+		return &InvalidChunkError{Chunk: v.chunk, Reason: "no field is set"}
+	case 1:
+		return nil
+	}
+	return &InvalidChunkError{Chunk: v.chunk, Reason: "conflicting fields: " + strings.Join(v.kinds, ", ")}
+}
+
+// maxPayloadSize is the largest payload of a BytesPacket.
+const maxPayloadSize = 0xFFFF - 4
@@ -0,0 +1,89 @@
+// Modified by Giacomo Tartari
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkt
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestEncode_invalidChunks(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		c    Packet
+		// want is the output of EncodeToPktLine, which does not validate.
+		want string
+	}{
+		{"conflicting fields", &UploadRequestChunk{HaveObjectID: oid, EndOneRound: true}, "0032have " + oid + "\n"},
+		{"newline in field", &UploadRequestChunk{HaveObjectID: "a\nb"}, "000dhave a\nb\n"},
+		{"conflicting response fields", &UploadResponseChunk{Nak: true, EndOfRequest: true}, "0008NAK\n"},
+		{"conflicting ref fields", &InfoRefsResponseChunk{ObjectID: oid, Ref: "HEAD", EndOfRequest: true}, "0032" + oid + " HEAD\n"},
+	} {
+		var got []byte
+		func() {
+			defer func() {
+				if r := recover(); r != nil {
+					t.Errorf("%s: EncodeToPktLine panicked: %v", tc.name, r)
+				}
+			}()
+			got = tc.c.EncodeToPktLine()
+		}()
+		if string(got) != tc.want {
+			t.Errorf("%s: EncodeToPktLine() = %q, want %q", tc.name, got, tc.want)
+		}
+
+		var ice *InvalidChunkError
+		if _, err := Encode(tc.c); !errors.As(err, &ice) {
+			t.Errorf("%s: Encode() = %v, want an InvalidChunkError", tc.name, err)
+		}
+
+		var buf bytes.Buffer
+		w := NewPacketWriter(&buf)
+		if err := w.WritePacket(tc.c); !errors.As(err, &ice) {
+			t.Errorf("%s: WritePacket() = %v, want an InvalidChunkError", tc.name, err)
+		}
+		if buf.Len() != 0 || w.Err() != nil {
+			t.Errorf("%s: WritePacket() wrote %q and broke the writer: %v", tc.name, buf.Bytes(), w.Err())
+		}
+	}
+}
+
+func TestEncode_lazyChunks(t *testing.T) {
+	in := "0032want " + oid + "\n00000032have " + oid + "\n0009done\n"
+	r := NewUploadRequest(strings.NewReader(in), WithLazyFields())
+	var buf bytes.Buffer
+	w := NewPacketWriter(&buf)
+	lazy := 0
+	for r.Scan() {
+		if r.Chunk().Raw() != nil {
+			lazy++
+		}
+		if err := w.WritePacket(r.Chunk()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := r.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if lazy == 0 {
+		t.Error("no chunk was read lazily")
+	}
+	if buf.String() != in {
+		t.Errorf("lazy chunks encoded as %q, want %q", buf.String(), in)
+	}
+}
@@ -0,0 +1,95 @@
+// Modified by Giacomo Tartari
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkt
+
+import (
+	"io"
+	"net"
+)
+
+// vectoredMinPayload is the payload size from which a vectored write pays
+// off. Smaller payloads are cheaper to copy after the header.
+const vectoredMinPayload = 512
+
+// WritePacketVectored writes p as WritePacket does, but with net.Buffers:
+// the header and the payload of a data or side-band packet are separate
+// buffers, which a network connection sends with a single writev, without
+// copying the payload. WritePacket takes this path by itself when the
+// underlying writer is a net.Conn.
+func (w *PacketWriter) WritePacketVectored(p Packet) error {
+	v := w.vectored
+	w.vectored = true
+	defer func() { w.vectored = v }()
+	return w.WritePacket(p)
+}
+
+// isVectored reports whether w sends net.Buffers with writev.
+func isVectored(w io.Writer) bool {
+	_, ok := w.(net.Conn)
+	return ok
+}
+
+// encodeVectored writes the serialized p to w, the payload of a large
+// packet in a buffer of its own, and returns the number of bytes of the
+// serialized packet.
+func encodeVectored(w io.Writer, p Packet) (int, error) {
+	var hdr [5]byte
+	head, payload, ok := splitPacket(&hdr, p)
+	if !ok || len(payload) < vectoredMinPayload {
+		return encodeTo(w, p)
+	}
+	if err := checkPacketSize(p); err != nil {
+		return 0, err
+	}
+	bufs := net.Buffers{head, payload}
+	if len(head) == 0 {
+		bufs = bufs[1:]
+	}
+	n, err := bufs.WriteTo(w)
+	return int(n), err
+}
+
+// splitPacket returns the header of p, with the side-band byte, and its
+// payload, or ok false if p is not a packet with a payload. The packets
+// already encoded, the raw packets and the pack data, have no header.
+func splitPacket(hdr *[5]byte, p Packet) (head, payload []byte, ok bool) {
+	var band byte
+	switch p := p.(type) {
+	case BytesPacket:
+		payload = p
+	case SideBandMainPacket:
+		band, payload = 1, p
+	case SideBandReportPacket:
+		band, payload = 2, p
+	case SideBandErrorPacket:
+		band, payload = 3, p
+	case RawPacket:
+		return nil, p, true
+	case PackFilePacket:
+		return nil, p, true
+	default:
+		return nil, nil, false
+	}
+	n := 4 + len(payload)
+	if band != 0 {
+		n++
+	}
+	head = appendHeader(hdr[:0], n)
+	if band != 0 {
+		head = append(head, band)
+	}
+	return head, payload, true
+}
@@ -0,0 +1,132 @@
+// Modified by Giacomo Tartari
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkt
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+// writeCounter counts the writes to a buffer.
+type writeCounter struct {
+	bytes.Buffer
+	writes int
+}
+
+func (w *writeCounter) Write(b []byte) (int, error) {
+	w.writes++
+	return w.Buffer.Write(b)
+}
+
+// payloadOf returns n bytes of payload.
+func payloadOf(n int) []byte {
+	return bytes.Repeat([]byte("0123456789"), n/10+1)[:n]
+}
+
+// scanLengths returns the payload lengths of the packets of b, and their
+// concatenated payloads.
+func scanLengths(t *testing.T, b []byte) ([]int, []byte) {
+	t.Helper()
+	var lens []int
+	var data []byte
+	s := NewPacketScanner(bytes.NewReader(b))
+	for s.Scan() {
+		p, ok := s.Packet().(BytesPacket)
+		if !ok {
+			t.Fatalf("unexpected packet %#v", s.Packet())
+		}
+		lens = append(lens, len(p))
+		data = append(data, p...)
+	}
+	if err := s.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return lens, data
+}
+
+func TestEncodeVectored(t *testing.T) {
+	packets := map[string]func(b []byte) Packet{
+		"bytes":            func(b []byte) Packet { return BytesPacket(b) },
+		"side-band main":   func(b []byte) Packet { return SideBandMainPacket(b) },
+		"side-band report": func(b []byte) Packet { return SideBandReportPacket(b) },
+		"side-band error":  func(b []byte) Packet { return SideBandErrorPacket(b) },
+		"raw":              func(b []byte) Packet { return RawPacket(BytesPacket(b).EncodeToPktLine()) },
+		"pack file":        func(b []byte) Packet { return PackFilePacket(b) },
+	}
+	for name, packet := range packets {
+		for _, n := range []int{10, vectoredMinPayload - 1, vectoredMinPayload, 65000} {
+			t.Run(fmt.Sprintf("%s/%d", name, n), func(t *testing.T) {
+				p := packet(payloadOf(n))
+				var want, got writeCounter
+				wn, err := encodeTo(&want, p)
+				if err != nil {
+					t.Fatal(err)
+				}
+				gn, err := encodeVectored(&got, p)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if gn != wn || !bytes.Equal(got.Bytes(), want.Bytes()) {
+					t.Errorf("encoded %d bytes %q..., want %d bytes %q...", gn, got.Bytes()[:8], wn, want.Bytes()[:8])
+				}
+				// The large payloads are written apart from their header,
+				// if any.
+				writes := 1
+				if n >= vectoredMinPayload && name != "raw" && name != "pack file" {
+					writes = 2
+				}
+				if got.writes != writes {
+					t.Errorf("got %d writes, want %d", got.writes, writes)
+				}
+			})
+		}
+	}
+	// Packets without payload.
+	for _, p := range []Packet{FlushPacket{}, DelimPacket{}, ErrorPacket("no"), PackFileIndicatorPacket{}} {
+		var want, got bytes.Buffer
+		encodeTo(&want, p)
+		if _, err := encodeVectored(&got, p); err != nil || !bytes.Equal(got.Bytes(), want.Bytes()) {
+			t.Errorf("encodeVectored(%#v) = %q, %v, want %q", p, got.Bytes(), err, want.Bytes())
+		}
+	}
+}
+
+func TestEncodeVectored_tooLarge(t *testing.T) {
+	for _, p := range []Packet{
+		BytesPacket(payloadOf(65532)),
+		SideBandMainPacket(payloadOf(65531)),
+		ErrorPacket(payloadOf(65528)),
+	} {
+		var b bytes.Buffer
+		if _, err := encodeVectored(&b, p); !errors.Is(err, ErrPacketTooLarge) || b.Len() != 0 {
+			t.Errorf("encodeVectored(%T) wrote %d bytes, error %v, want a PacketTooLargeError", p, b.Len(), err)
+		}
+	}
+	var b bytes.Buffer
+	w := NewPacketWriter(&b)
+	if err := w.WritePacketVectored(ErrorPacket(payloadOf(65528))); !errors.Is(err, ErrPacketTooLarge) {
+		t.Errorf("WritePacketVectored() = %v, want a PacketTooLargeError", err)
+	}
+	// The large data packets are split as with WritePacket.
+	if err := w.WritePacketVectored(BytesPacket(payloadOf(MaxPacketDataSize + 1))); err != nil {
+		t.Fatal(err)
+	}
+	if lens, _ := scanLengths(t, b.Bytes()); len(lens) != 2 {
+		t.Errorf("got packets of %d bytes, want 2 packets", lens)
+	}
+}
@@ -0,0 +1,79 @@
+// Modified by Giacomo Tartari
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkt
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// UnfetchableObjectError is returned by ValidateWants for a want that the
+// server would refuse.
+type UnfetchableObjectError struct {
+	ObjectID string
+	Reason   string
+}
+
+func (e *UnfetchableObjectError) Error() string {
+	return fmt.Sprintf("cannot fetch %s: %s", e.ObjectID, e.Reason)
+}
+
+// ValidateWants checks wants before they are sent to a server and returns
+// them deduplicated and sorted.
+//
+// A want that is not a SHA1 or SHA256 object ID is rejected. When advertised is not nil,
+// a want that is not advertised is rejected unless caps contains one of
+// allow-tip-sha1-in-want, allow-reachable-sha1-in-want or
+// allow-any-sha1-in-want; the server checks the reachability itself. Pass a
+// nil advertised for protocol v2, where wants are not limited to the
+// advertised objects.
+//
+// All the rejected wants are reported, joined with errors.Join.
+func ValidateWants(wants, advertised, caps []string) ([]string, error) {
+	unadvertisedOK := advertised == nil
+	for _, c := range caps {
+		switch c {
+		case "allow-tip-sha1-in-want", "allow-reachable-sha1-in-want", "allow-any-sha1-in-want":
+			unadvertisedOK = true
+		}
+	}
+	adv := make(map[string]bool, len(advertised))
+	for _, oid := range advertised {
+		adv[oid] = true
+	}
+
+	var errs []error
+	seen := map[string]bool{}
+	var ret []string
+	for _, oid := range wants {
+		if seen[oid] {
+			continue
+		}
+		seen[oid] = true
+		if ObjectFormatOf(ObjectID(oid)).ValidateObjectID(oid) != nil {
+			errs = append(errs, &UnfetchableObjectError{oid, "malformed object ID"})
+			continue
+		}
+		if !unadvertisedOK && !adv[oid] {
+			errs = append(errs, &UnfetchableObjectError{oid, "not advertised by the server, which does not allow unadvertised wants"})
+			continue
+		}
+		ret = append(ret, oid)
+	}
+	sort.Strings(ret)
+	return ret, errors.Join(errs...)
+}
@@ -0,0 +1,84 @@
+// Modified by Giacomo Tartari
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkt
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestValidateWants(t *testing.T) {
+	sha1 := strings.Repeat("b", 40)
+	sha256 := strings.Repeat("c", 64)
+	tests := []struct {
+		name       string
+		wants      []string
+		advertised []string
+		caps       []string
+		want       []string
+		rejected   []string
+	}{
+		{
+			name:  "v2",
+			wants: []string{sha256, sha1, sha1},
+			want:  []string{sha1, sha256},
+		},
+		{
+			name:     "malformed",
+			wants:    []string{sha1, strings.ToUpper(sha1), sha1[:39], sha1 + "b", strings.Repeat("g", 40), ""},
+			want:     []string{sha1},
+			rejected: []string{strings.ToUpper(sha1), sha1[:39], sha1 + "b", strings.Repeat("g", 40), ""},
+		},
+		{
+			name:       "unadvertised",
+			wants:      []string{sha1, sha256},
+			advertised: []string{sha1},
+			want:       []string{sha1},
+			rejected:   []string{sha256},
+		},
+		{
+			name:       "unadvertised allowed",
+			wants:      []string{sha1, sha256},
+			advertised: []string{sha1},
+			caps:       []string{"allow-reachable-sha1-in-want"},
+			want:       []string{sha1, sha256},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ValidateWants(tt.wants, tt.advertised, tt.caps)
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("ValidateWants() mismatch (-want +got):\n%s", diff)
+			}
+			var rejected []string
+			if err != nil {
+				for _, e := range err.(interface{ Unwrap() []error }).Unwrap() {
+					var ue *UnfetchableObjectError
+					if !errors.As(e, &ue) {
+						t.Fatalf("unexpected error %v", e)
+					}
+					rejected = append(rejected, ue.ObjectID)
+				}
+			}
+			if diff := cmp.Diff(tt.rejected, rejected); diff != "" {
+				t.Errorf("rejected wants mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,158 @@
+// Modified by Giacomo Tartari
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkt
+
+import "io"
+
+// MaxPacketDataSize is the largest payload that git accepts in a packet
+// (LARGE_PACKET_DATA_MAX). PacketWriter splits larger payloads.
+const MaxPacketDataSize = 65520 - 4
+
+// PacketWriter writes packets to an io.Writer. It is the counterpart of
+// PacketScanner. After the first error, all the methods return it.
+type PacketWriter struct {
+	w       io.Writer
+	err     error
+	trace   Trace
+	metrics Metrics
+	// vectored makes the writes of large packets vectored, see
+	// WritePacketVectored.
+	vectored bool
+}
+
+// NewPacketWriter returns a new PacketWriter writing to w. The options of
+// the writer are WithTrace and WithMetrics.
+func NewPacketWriter(w io.Writer, opts ...Option) *PacketWriter {
+	cfg := NewConfig(opts...)
+	return &PacketWriter{w: w, trace: cfg.Trace, metrics: cfg.Metrics, vectored: isVectored(w)}
+}
+
+// Err returns the first error that was encountered by the PacketWriter.
+func (w *PacketWriter) Err() error {
+	return w.err
+}
+
+// WritePacket writes p. The payload of a BytesPacket or a StringPacket
+// larger than MaxPacketDataSize is split into several BytesPackets, and the
+// data of a side-band packet into several packets of the same band. Another
+// packet too large to be encoded, like a long ErrorPacket, is reported as a
+// PacketTooLargeError, and a chunk that does not pass its Validate method
+// as an InvalidChunkError.
+func (w *PacketWriter) WritePacket(p Packet) error {
+	switch p := p.(type) {
+	case BytesPacket:
+		if len(p) > MaxPacketDataSize {
+			_, err := w.Write(p)
+			return err
+		}
+	case StringPacket:
+		if len(p) > MaxPacketDataSize {
+			_, err := w.Write([]byte(p))
+			return err
+		}
+	case SideBandMainPacket:
+		return writeSplit(w, p, func(b []byte) Packet { return SideBandMainPacket(b) })
+	case SideBandReportPacket:
+		return writeSplit(w, p, func(b []byte) Packet { return SideBandReportPacket(b) })
+	case SideBandErrorPacket:
+		return writeSplit(w, p, func(b []byte) Packet { return SideBandErrorPacket(b) })
+	}
+	return w.write(p)
+}
+
+// writeSplit writes the side-band data b as packets of at most
+// SideBand64kMaxData bytes made by band.
+func writeSplit(w *PacketWriter, b []byte, band func([]byte) Packet) error {
+	for {
+		sz := min(len(b), SideBand64kMaxData)
+		if err := w.write(band(b[:sz])); err != nil {
+			return err
+		}
+		if b = b[sz:]; len(b) == 0 {
+			return nil
+		}
+	}
+}
+
+// Write writes b as BytesPackets of at most MaxPacketDataSize bytes. An
+// empty b writes nothing.
+func (w *PacketWriter) Write(b []byte) (int, error) {
+	n := 0
+	for len(b) > 0 {
+		sz := min(len(b), MaxPacketDataSize)
+		if err := w.write(BytesPacket(b[:sz])); err != nil {
+			return n, err
+		}
+		n += sz
+		b = b[sz:]
+	}
+	return n, w.err
+}
+
+// Flush writes a flush packet. If the underlying writer has a Flush method,
+// like bufio.Writer or http.ResponseWriter, it is called too, so that the
+// peer receives the packets ending the current section.
+func (w *PacketWriter) Flush() error {
+	if err := w.write(FlushPacket{}); err != nil {
+		return err
+	}
+	switch f := w.w.(type) {
+	case interface{ Flush() error }:
+		w.err = f.Flush()
+	case interface{ Flush() }:
+		f.Flush()
+	}
+	return w.err
+}
+
+// Delim writes a delim packet.
+func (w *PacketWriter) Delim() error {
+	return w.write(DelimPacket{})
+}
+
+func (w *PacketWriter) write(p Packet) error {
+	if w.err != nil {
+		return w.err
+	}
+	// An invalid chunk is not written and does not break the writer either.
+	if v, ok := p.(Validator); ok {
+		if err := v.Validate(); err != nil {
+			return err
+		}
+	}
+	var (
+		n   int
+		err error
+	)
+	if w.vectored {
+		n, err = encodeVectored(w.w, p)
+	} else {
+		n, err = encodeTo(w.w, p)
+	}
+	if err != nil {
+		// A packet too large is not written and does not break the
+		// writer.
+		if _, ok := err.(*PacketTooLargeError); !ok {
+			w.err = err
+		}
+		return err
+	}
+	w.metrics.PacketWritten(KindOf(p), n)
+	if w.trace != nil {
+		w.trace.OnPacketWritten(p, n)
+	}
+	return nil
+}